@@ -0,0 +1,199 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Audit log actions for memberships managed by hand
+const (
+	grantAuditAction  = "grant"
+	extendAuditAction = "extend"
+	revokeAuditAction = "revoke"
+)
+
+// GrantAccess gives a pubkey a complimentary membership for duration, or permanently if
+// duration is 0. An active membership is renewed the same way a payment renews it.
+// OnAccessGranted fires with reason "admin".
+func (s *System) GrantAccess(ctx context.Context, pubkey string, duration time.Duration, reason string) (*PaidAccessMember, error) {
+	if duration < 0 {
+		return nil, fmt.Errorf("invalid duration: %v", duration)
+	}
+	if s.IsDenylisted(pubkey) {
+		return nil, errDeniedPubkey
+	}
+
+	events, storage := s.quotasFor(pubkey, "")
+	if err := s.paidAccessStorage.AddAccessFromSource(pubkey, "", "", SourceAdmin, 0, duration); err != nil {
+		return nil, err
+	}
+	if err := s.setQuotas(pubkey, events, storage); err != nil {
+		return nil, err
+	}
+	member, _ := s.paidAccessStorage.GetMember(pubkey)
+
+	s.recordAudit(AuditEntry{Action: grantAuditAction, Pubkey: pubkey, Actor: "admin", Reason: grantAuditReason(duration, reason)})
+	s.with(pubkeyAttr(pubkey)).logInfo("🎟️ Granted access to %s... (%s)", pubkey[:16], reason)
+
+	s.fireAccessGranted(context.WithoutCancel(ctx), AccessEvent{Pubkey: pubkey, Member: *member, Reason: "admin"})
+	return member, nil
+}
+
+// ExtendAccess adds duration to a pubkey's membership, counted from now if it has expired,
+// keeping its tier and quotas. Permanent memberships can't be extended.
+// OnAccessGranted fires with reason "admin".
+func (s *System) ExtendAccess(ctx context.Context, pubkey string, duration time.Duration, reason string) (*PaidAccessMember, error) {
+	if duration <= 0 {
+		return nil, fmt.Errorf("invalid duration: %v", duration)
+	}
+
+	member, err := s.paidAccessStorage.ExtendAccess(pubkey, duration)
+	if err != nil {
+		return nil, err
+	}
+
+	s.recordAudit(AuditEntry{Action: extendAuditAction, Pubkey: pubkey, Actor: "admin", Reason: grantAuditReason(duration, reason)})
+	s.with(pubkeyAttr(pubkey)).logInfo("🎟️ Extended access of %s... by %v (%s)", pubkey[:16], duration, reason)
+
+	s.fireAccessGranted(context.WithoutCancel(ctx), AccessEvent{Pubkey: pubkey, Member: *member, Reason: "admin"})
+	return member, nil
+}
+
+// grantAuditReason describes a grant or extension in the audit log
+func grantAuditReason(duration time.Duration, reason string) string {
+	period := "permanent"
+	if duration > 0 {
+		period = duration.String()
+	}
+	if reason == "" {
+		return period
+	}
+	return period + ": " + reason
+}
+
+// parseGrantDuration parses an access duration given to the admin endpoints: "1week",
+// "1month", "1year", "forever" or a Go duration such as "72h"
+func parseGrantDuration(value string) (time.Duration, error) {
+	switch value {
+	case "forever", "1week", "1month", "1year":
+		return accessDurationFor(value), nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("invalid duration: %s", value)
+	}
+	return duration, nil
+}
+
+// adminAccessRequest is the body of the admin grant, extend and revoke endpoints
+type adminAccessRequest struct {
+	Pubkey   string `json:"pubkey"`
+	Duration string `json:"duration"`
+	Reason   string `json:"reason"`
+}
+
+// decodeAdminAccessRequest reads an admin access request, answering the client if it is
+// invalid. The pubkey is returned as hex.
+func decodeAdminAccessRequest(w http.ResponseWriter, r *http.Request) (adminAccessRequest, bool) {
+	var req adminAccessRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return req, false
+	}
+	pubkey, err := parsePubkey(req.Pubkey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return req, false
+	}
+	req.Pubkey = pubkey
+	return req, true
+}
+
+// adminGrantAccessHandler gives a pubkey a complimentary membership
+func (s *System) adminGrantAccessHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeAdminAccessRequest(w, r)
+	if !ok {
+		return
+	}
+	duration, err := parseGrantDuration(req.Duration)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	member, err := s.GrantAccess(r.Context(), req.Pubkey, duration, req.Reason)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, errDeniedPubkey) {
+			status = http.StatusForbidden
+		}
+		s.with(pubkeyAttr(req.Pubkey)).logError("❌ Failed to grant access to %s...: %v", req.Pubkey[:16], err)
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"granted": true,
+		"member":  member,
+	})
+}
+
+// adminExtendAccessHandler adds time to a membership
+func (s *System) adminExtendAccessHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeAdminAccessRequest(w, r)
+	if !ok {
+		return
+	}
+	duration, err := parseGrantDuration(req.Duration)
+	if err != nil || duration == 0 {
+		http.Error(w, fmt.Sprintf("invalid duration: %s", req.Duration), http.StatusBadRequest)
+		return
+	}
+
+	member, err := s.ExtendAccess(r.Context(), req.Pubkey, duration, req.Reason)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errNoMembership) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"extended": true,
+		"member":   member,
+	})
+}
+
+// adminRevokeAccessHandler removes a membership
+func (s *System) adminRevokeAccessHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeAdminAccessRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.Reason == "" {
+		http.Error(w, "reason is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.RevokeAccess(r.Context(), req.Pubkey, req.Reason); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, errNoMembership) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"revoked": true,
+	})
+}
@@ -0,0 +1,83 @@
+package payments
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxAccessCacheEntries bounds the access cache, it starts over once full so events from
+// random pubkeys can't grow it without end
+const maxAccessCacheEntries = 100000
+
+// accessCache remembers recent HasAccess results so the events of busy pubkeys are checked
+// without taking the storage lock. Entries are dropped on every change of the members and
+// never outlive the moment the result would change by itself, such as an expiry.
+type accessCache struct {
+	ttl     atomic.Int64 // time.Duration, zero disables caching
+	entries atomic.Pointer[sync.Map]
+	size    atomic.Int64
+}
+
+// accessCacheEntry is a cached HasAccess result valid until the given time
+type accessCacheEntry struct {
+	allowed bool
+	until   time.Time
+}
+
+// newAccessCache creates an access cache keeping results for ttl
+func newAccessCache(ttl time.Duration) *accessCache {
+	cache := &accessCache{}
+	cache.ttl.Store(int64(ttl))
+	cache.entries.Store(&sync.Map{})
+	return cache
+}
+
+// get returns the cached result for pubkey, ok is false if there is none valid at now
+func (c *accessCache) get(pubkey string, now time.Time) (allowed, ok bool) {
+	value, exists := c.entries.Load().Load(pubkey)
+	if !exists {
+		return false, false
+	}
+	entry := value.(accessCacheEntry)
+	if !now.Before(entry.until) {
+		return false, false
+	}
+	return entry.allowed, true
+}
+
+// put caches a result computed at now that holds until changesAt, zero if it only changes
+// with the members
+func (c *accessCache) put(pubkey string, allowed bool, now, changesAt time.Time) {
+	ttl := time.Duration(c.ttl.Load())
+	if ttl <= 0 {
+		return
+	}
+	until := now.Add(ttl)
+	if !changesAt.IsZero() && changesAt.Before(until) {
+		until = changesAt
+	}
+
+	if c.size.Add(1) > maxAccessCacheEntries {
+		c.clear()
+		c.size.Add(1)
+	}
+	c.entries.Load().Store(pubkey, accessCacheEntry{allowed: allowed, until: until})
+}
+
+// forget drops the cached result for pubkey
+func (c *accessCache) forget(pubkey string) {
+	c.entries.Load().Delete(pubkey)
+}
+
+// clear drops every cached result
+func (c *accessCache) clear() {
+	c.entries.Store(&sync.Map{})
+	c.size.Store(0)
+}
+
+// setTTL changes how long results are kept and drops the cached ones
+func (c *accessCache) setTTL(ttl time.Duration) {
+	c.ttl.Store(int64(ttl))
+	c.clear()
+}
@@ -0,0 +1,368 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Access lists
+const (
+	ListAllowed = "allowed" // pubkeys that never pay
+	ListDenied  = "denied"  // pubkeys that are refused invoices and access
+)
+
+// Audit log actions for access lists
+const (
+	listAddAuditAction        = "list_add"
+	listRemoveAuditAction     = "list_remove"
+	refusedPaymentAuditAction = "payment_refused"
+)
+
+// denyRejectMessage is the rejection denylisted pubkeys get instead of an invoice
+const denyRejectMessage = "blocked: this pubkey is banned from the relay"
+
+// ListedPubkey is an entry of an access list
+type ListedPubkey struct {
+	Pubkey  string    `json:"pubkey"`
+	Note    string    `json:"note,omitempty"`     // why the pubkey is listed, e.g. "moderator"
+	Source  string    `json:"source"`             // "config" or "admin"
+	AddedAt time.Time `json:"added_at,omitempty"` // zero for configured pubkeys
+}
+
+// AccessLists persists the pubkeys operators added to access lists through the admin API.
+// Configured pubkeys are kept in memory only.
+type AccessLists struct {
+	Allowed  map[string]*ListedPubkey `json:"allowed"`
+	Denied   map[string]*ListedPubkey `json:"denied"`
+	mutex    sync.RWMutex
+	filePath string
+	config   map[string]map[string]*ListedPubkey // list -> configured pubkeys
+}
+
+// NewAccessLists creates access lists stored at filePath
+func NewAccessLists(filePath string) *AccessLists {
+	lists := &AccessLists{
+		Allowed:  make(map[string]*ListedPubkey),
+		Denied:   make(map[string]*ListedPubkey),
+		filePath: filePath,
+		config:   make(map[string]map[string]*ListedPubkey),
+	}
+
+	// Ensure directory exists
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		logWarn("⚠️ Failed to create directory for access list file: %v", err)
+	}
+
+	if err := lists.load(); err != nil {
+		logWarn("⚠️ Failed to load access lists: %v", err)
+	}
+	return lists
+}
+
+// load reads the lists from file
+func (al *AccessLists) load() error {
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+
+	data, err := os.ReadFile(al.filePath)
+	if os.IsNotExist(err) {
+		return nil // File doesn't exist, start with empty lists
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read access list file: %w", err)
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(data, al); err != nil {
+		return err
+	}
+	if al.Allowed == nil {
+		al.Allowed = make(map[string]*ListedPubkey)
+	}
+	if al.Denied == nil {
+		al.Denied = make(map[string]*ListedPubkey)
+	}
+	return nil
+}
+
+// save writes the lists to file, caller holds the mutex
+func (al *AccessLists) save() error {
+	data, err := json.MarshalIndent(al, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal access lists: %w", err)
+	}
+
+	return writeFileAtomic(al.filePath, data, 0644)
+}
+
+// entries returns the stored entries of a list, nil for unknown lists
+func (al *AccessLists) entries(list string) map[string]*ListedPubkey {
+	switch list {
+	case ListAllowed:
+		return al.Allowed
+	case ListDenied:
+		return al.Denied
+	}
+	return nil
+}
+
+// configure sets the pubkeys a list holds from configuration
+func (al *AccessLists) configure(list string, pubkeys []string) error {
+	configured := make(map[string]*ListedPubkey, len(pubkeys))
+	for _, value := range pubkeys {
+		pubkey, err := parsePubkey(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", value, err)
+		}
+		configured[pubkey] = &ListedPubkey{Pubkey: pubkey, Source: "config"}
+	}
+
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+	al.config[list] = configured
+	return nil
+}
+
+// Add puts a pubkey on a list, replacing its note if it was listed already
+func (al *AccessLists) Add(list, pubkey, note string) error {
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+
+	entries := al.entries(list)
+	if entries == nil {
+		return fmt.Errorf("unknown access list: %s", list)
+	}
+	previous, existed := entries[pubkey]
+	entries[pubkey] = &ListedPubkey{Pubkey: pubkey, Note: note, Source: "admin", AddedAt: time.Now()}
+
+	if err := al.save(); err != nil {
+		if existed {
+			entries[pubkey] = previous
+		} else {
+			delete(entries, pubkey)
+		}
+		return fmt.Errorf("failed to save access lists: %w", err)
+	}
+	return nil
+}
+
+// Remove takes a pubkey off a list. Configured pubkeys can only be removed from the configuration.
+func (al *AccessLists) Remove(list, pubkey string) error {
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+
+	entries := al.entries(list)
+	if entries == nil {
+		return fmt.Errorf("unknown access list: %s", list)
+	}
+	previous, exists := entries[pubkey]
+	if !exists {
+		if _, configured := al.config[list][pubkey]; configured {
+			return fmt.Errorf("pubkey %s is configured, remove it from the configuration", pubkey)
+		}
+		return fmt.Errorf("pubkey %s is not on the %s list", pubkey, list)
+	}
+	delete(entries, pubkey)
+
+	if err := al.save(); err != nil {
+		entries[pubkey] = previous
+		return fmt.Errorf("failed to save access lists: %w", err)
+	}
+	return nil
+}
+
+// Contains reports whether a pubkey is on a list, configured or added
+func (al *AccessLists) Contains(list, pubkey string) bool {
+	al.mutex.RLock()
+	defer al.mutex.RUnlock()
+
+	if _, configured := al.config[list][pubkey]; configured {
+		return true
+	}
+	_, added := al.entries(list)[pubkey]
+	return added
+}
+
+// List returns the entries of a list, configured ones first
+func (al *AccessLists) List(list string) []ListedPubkey {
+	al.mutex.RLock()
+	defer al.mutex.RUnlock()
+
+	listed := make([]ListedPubkey, 0)
+	for _, entry := range al.config[list] {
+		listed = append(listed, *entry)
+	}
+	for pubkey, entry := range al.entries(list) {
+		if _, configured := al.config[list][pubkey]; !configured {
+			listed = append(listed, *entry)
+		}
+	}
+	sort.SliceStable(listed, func(i, j int) bool {
+		if listed[i].Source != listed[j].Source {
+			return listed[i].Source == "config"
+		}
+		return listed[i].AddedAt.Before(listed[j].AddedAt)
+	})
+	return listed
+}
+
+// AllowPubkey lets a pubkey publish and read without paying, e.g. a moderator or bot
+func (s *System) AllowPubkey(pubkey, note string) error {
+	if err := s.accessLists.Add(ListAllowed, pubkey, note); err != nil {
+		return err
+	}
+	s.recordAudit(AuditEntry{Action: listAddAuditAction, Pubkey: pubkey, Actor: "admin", Reason: ListAllowed + ": " + note})
+	s.with(pubkeyAttr(pubkey)).logInfo("✅ Allowed pubkey %s... without payment", pubkey[:16])
+	return nil
+}
+
+// RemoveAllowedPubkey takes a pubkey added with AllowPubkey off the allowlist
+func (s *System) RemoveAllowedPubkey(pubkey string) error {
+	if err := s.accessLists.Remove(ListAllowed, pubkey); err != nil {
+		return err
+	}
+	s.recordAudit(AuditEntry{Action: listRemoveAuditAction, Pubkey: pubkey, Actor: "admin", Reason: ListAllowed})
+	s.with(pubkeyAttr(pubkey)).logInfo("🚫 Removed pubkey %s... from the allowlist", pubkey[:16])
+	return nil
+}
+
+// IsAllowlisted reports whether a pubkey bypasses payment entirely
+func (s *System) IsAllowlisted(pubkey string) bool {
+	return s.accessLists.Contains(ListAllowed, pubkey)
+}
+
+// AllowedPubkeys returns the allowlist, configured pubkeys first
+func (s *System) AllowedPubkeys() []ListedPubkey {
+	return s.accessLists.List(ListAllowed)
+}
+
+// DenyPubkey bans a pubkey: its events are rejected without an invoice, no invoice is
+// issued to it and payments that still arrive for it don't grant access. An active
+// membership is kept, but unusable while the pubkey is denied.
+func (s *System) DenyPubkey(pubkey, note string) error {
+	if err := s.accessLists.Add(ListDenied, pubkey, note); err != nil {
+		return err
+	}
+	s.recordAudit(AuditEntry{Action: listAddAuditAction, Pubkey: pubkey, Actor: "admin", Reason: ListDenied + ": " + note})
+	s.with(pubkeyAttr(pubkey)).logInfo("⛔ Denied pubkey %s...", pubkey[:16])
+	return nil
+}
+
+// RemoveDeniedPubkey takes a pubkey added with DenyPubkey off the denylist
+func (s *System) RemoveDeniedPubkey(pubkey string) error {
+	if err := s.accessLists.Remove(ListDenied, pubkey); err != nil {
+		return err
+	}
+	s.recordAudit(AuditEntry{Action: listRemoveAuditAction, Pubkey: pubkey, Actor: "admin", Reason: ListDenied})
+	s.with(pubkeyAttr(pubkey)).logInfo("✅ Removed pubkey %s... from the denylist", pubkey[:16])
+	return nil
+}
+
+// IsDenylisted reports whether a pubkey is banned from paying for access
+func (s *System) IsDenylisted(pubkey string) bool {
+	return s.accessLists.Contains(ListDenied, pubkey)
+}
+
+// DeniedPubkeys returns the denylist, configured pubkeys first
+func (s *System) DeniedPubkeys() []ListedPubkey {
+	return s.accessLists.List(ListDenied)
+}
+
+// refuseDeniedPayment records a payment that settled for a denied pubkey without granting
+// access, so the operator can refund it
+func (s *System) refuseDeniedPayment(ctx context.Context, pubkey string, verification *PaymentVerification) {
+	s.invoices.MarkPaid(verification.PaymentHash, verification.Amount, verification.PaidAt)
+	s.invoices.MarkRefused(verification.PaymentHash)
+	s.publishInvoice(verification.PaymentHash)
+
+	payment := PaymentEvent{
+		Pubkey:      pubkey,
+		PaymentHash: verification.PaymentHash,
+		Amount:      verification.Amount,
+		Provider:    s.providerNameFor(verification.PaymentHash),
+		PaidAt:      verification.PaidAt,
+	}
+	s.recordAudit(AuditEntry{Action: refusedPaymentAuditAction, Pubkey: pubkey, Actor: "system",
+		Reason: fmt.Sprintf("%d msat paid to invoice %s", verification.Amount, verification.PaymentHash)})
+	s.with(pubkeyAttr(pubkey), paymentHashAttr(verification.PaymentHash)).logInfo("⛔ Refused payment %.16s... of %d msat from denied pubkey %s...", verification.PaymentHash, verification.Amount, pubkey[:16])
+	s.fireDeniedPayment(ctx, payment)
+}
+
+// adminListHandler lists the pubkeys on an access list
+func (s *System) adminListHandler(list string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			list: s.accessLists.List(list),
+		})
+	}
+}
+
+// adminListAddHandler adds a pubkey to an access list
+func (s *System) adminListAddHandler(add func(pubkey, note string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Pubkey string `json:"pubkey"`
+			Note   string `json:"note"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		pubkey, err := parsePubkey(req.Pubkey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := add(pubkey, strings.TrimSpace(req.Note)); err != nil {
+			s.logError("❌ Failed to update access list: %v", err)
+			http.Error(w, "Failed to update access list", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"added":  true,
+			"pubkey": pubkey,
+		})
+	}
+}
+
+// adminListRemoveHandler takes a pubkey off an access list
+func (s *System) adminListRemoveHandler(remove func(pubkey string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Pubkey string `json:"pubkey"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		pubkey, err := parsePubkey(req.Pubkey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := remove(pubkey); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"removed": true,
+			"pubkey":  pubkey,
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package payments
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// nip98Kind is the kind of NIP-98 HTTP auth events
+const nip98Kind = 27235
+
+// nip98MaxSkew is how far the creation time of a NIP-98 authorization may be from now
+const nip98MaxSkew = time.Minute
+
+// maxAdminBody bounds the request body read to check a NIP-98 payload hash
+const maxAdminBody = 64 << 20
+
+// nip98Replays remembers the NIP-98 authorizations already used until they expire, so a
+// captured Authorization header can't be sent again
+type nip98Replays struct {
+	mutex sync.Mutex
+	used  map[string]time.Time // event id -> when the authorization expires
+}
+
+// use records an authorization valid until expiresAt, reporting false if it was used before
+func (n *nip98Replays) use(id string, expiresAt, now time.Time) bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.used == nil {
+		n.used = make(map[string]time.Time)
+	}
+	for usedID, usedUntil := range n.used {
+		if now.After(usedUntil) {
+			delete(n.used, usedID)
+		}
+	}
+	if _, used := n.used[id]; used {
+		return false
+	}
+	n.used[id] = expiresAt
+	return true
+}
+
+// AdminEnabled reports whether the /admin endpoints accept requests
+func (s *System) AdminEnabled() bool {
+	return s.config.AdminToken != "" || len(s.config.AdminPubkeys) > 0
+}
+
+// requireAdmin wraps admin handlers with authentication: the bearer token, or a NIP-98
+// authorization signed by one of the admin pubkeys
+func (s *System) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.AdminEnabled() {
+			http.Error(w, "Admin API disabled", http.StatusForbidden)
+			return
+		}
+
+		authorization := r.Header.Get("Authorization")
+		if encoded, isNostr := strings.CutPrefix(authorization, "Nostr "); isNostr && len(s.config.AdminPubkeys) > 0 {
+			if err := s.checkNIP98(r, encoded); err != nil {
+				w.Header().Set("WWW-Authenticate", "Nostr")
+				http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+				return
+			}
+			next(w, r)
+			return
+		}
+
+		token := strings.TrimPrefix(authorization, "Bearer ")
+		if s.config.AdminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.config.AdminToken)) != 1 {
+			if s.config.AdminToken != "" {
+				w.Header().Add("WWW-Authenticate", "Bearer")
+			}
+			if len(s.config.AdminPubkeys) > 0 {
+				w.Header().Add("WWW-Authenticate", "Nostr")
+			}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// checkNIP98 validates a base64 encoded NIP-98 authorization for r, which must be signed by
+// an admin pubkey within the last minute for this URL, method and body, and not used before
+func (s *System) checkNIP98(r *http.Request, encoded string) error {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return fmt.Errorf("invalid authorization encoding")
+	}
+	var event nostr.Event
+	if err := json.Unmarshal(data, &event); err != nil {
+		return fmt.Errorf("invalid authorization event")
+	}
+
+	if event.Kind != nip98Kind {
+		return fmt.Errorf("authorization must be kind %d", nip98Kind)
+	}
+	if ok, err := event.CheckSignature(); err != nil || !ok {
+		return fmt.Errorf("invalid authorization signature")
+	}
+	if skew := time.Since(event.CreatedAt.Time()); skew > nip98MaxSkew || skew < -nip98MaxSkew {
+		return fmt.Errorf("authorization expired, sign a new one")
+	}
+	if !slices.Contains(s.config.AdminPubkeys, event.PubKey) {
+		return fmt.Errorf("pubkey is not an admin")
+	}
+
+	if tag := event.Tags.GetFirst([]string{"u", ""}); tag == nil || tag.Value() != s.requestURL(r) {
+		return fmt.Errorf("authorization is for another URL")
+	}
+	if tag := event.Tags.GetFirst([]string{"method", ""}); tag == nil || !strings.EqualFold(tag.Value(), r.Method) {
+		return fmt.Errorf("authorization is for another method")
+	}
+
+	// The payload hash binds the authorization to the body, so requests with one need it
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxAdminBody))
+	if err != nil {
+		return fmt.Errorf("failed to read body")
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	tag := event.Tags.GetFirst([]string{"payload", ""})
+	if tag == nil && len(body) > 0 {
+		return fmt.Errorf("authorization needs a payload tag for requests with a body")
+	}
+	if tag != nil {
+		hash := sha256.Sum256(body)
+		if !strings.EqualFold(tag.Value(), hex.EncodeToString(hash[:])) {
+			return fmt.Errorf("authorization is for another body")
+		}
+	}
+
+	// Checked last, so only valid authorizations are remembered. The id is computed, the
+	// signature doesn't cover the one sent.
+	if !s.nip98Used.use(event.GetID(), event.CreatedAt.Time().Add(nip98MaxSkew), time.Now()) {
+		return fmt.Errorf("authorization was already used, sign a new one")
+	}
+	return nil
+}
+
+// requestURL is the absolute URL a request was made to, as clients sign it for NIP-98
+func (s *System) requestURL(r *http.Request) string {
+	if s.config.PublicURL != "" {
+		return strings.TrimSuffix(s.config.PublicURL, "/") + r.URL.RequestURI()
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
+
+// adminMembersHandler lists member records with how each was granted, optionally for a single source
+func (s *System) adminMembersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"members": s.paidAccessStorage.ListMembers(r.URL.Query().Get("source")),
+	})
+}
+
+// adminMemberHandler shows the membership of one pubkey
+func (s *System) adminMemberHandler(w http.ResponseWriter, r *http.Request) {
+	pubkey, err := parsePubkey(r.PathValue("pubkey"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	member, exists := s.paidAccessStorage.GetMember(pubkey)
+	if !exists {
+		http.Error(w, fmt.Sprintf("%v for pubkey: %s", errNoMembership, pubkey), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"member":       member,
+		"active":       s.HasAccess(pubkey),
+		"capabilities": s.Capabilities(pubkey),
+	})
+}
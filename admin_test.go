@@ -0,0 +1,131 @@
+package payments
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// nip98Authorization returns the Authorization header value of a NIP-98 event signed with
+// secretKey after edit changed it
+func nip98Authorization(t *testing.T, secretKey, method, url string, body string, edit func(*nostr.Event)) string {
+	t.Helper()
+	event := nostr.Event{
+		Kind:      nip98Kind,
+		CreatedAt: nostr.Now(),
+		Tags:      nostr.Tags{{"u", url}, {"method", method}},
+	}
+	if body != "" {
+		hash := sha256.Sum256([]byte(body))
+		event.Tags = append(event.Tags, nostr.Tag{"payload", hex.EncodeToString(hash[:])})
+	}
+	if edit != nil {
+		edit(&event)
+	}
+	if err := event.Sign(secretKey); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+func TestCheckNIP98(t *testing.T) {
+	adminKey := nostr.GeneratePrivateKey()
+	adminPubkey, _ := nostr.GetPublicKey(adminKey)
+	system := newTestSystem(t, Config{AdminPubkeys: []string{adminPubkey}, PublicURL: "https://relay.example.com"})
+
+	const url = "https://relay.example.com/admin/refund"
+	const body = `{"payment_hash": "abc"}`
+	for _, test := range []struct {
+		name      string
+		key       string
+		method    string
+		body      string // sent with the request
+		signed    string // body the payload tag is for, none if empty
+		edit      func(*nostr.Event)
+		wantError string
+	}{
+		{name: "valid", key: adminKey, method: "POST", body: body, signed: body},
+		{name: "valid without body", key: adminKey, method: "GET"},
+		{name: "payload tag missing", key: adminKey, method: "POST", body: body, wantError: "needs a payload tag"},
+		{name: "payload of another body", key: adminKey, method: "POST", body: body, signed: `{"payment_hash": "def"}`, wantError: "another body"},
+		{name: "payload without body", key: adminKey, method: "POST", signed: body, wantError: "another body"},
+		{name: "signed too long ago", key: adminKey, method: "GET", edit: func(event *nostr.Event) {
+			event.CreatedAt = nostr.Timestamp(time.Now().Add(-2 * nip98MaxSkew).Unix())
+		}, wantError: "expired"},
+		{name: "signed in the future", key: adminKey, method: "GET", edit: func(event *nostr.Event) {
+			event.CreatedAt = nostr.Timestamp(time.Now().Add(2 * nip98MaxSkew).Unix())
+		}, wantError: "expired"},
+		{name: "within skew", key: adminKey, method: "GET", edit: func(event *nostr.Event) {
+			event.CreatedAt = nostr.Timestamp(time.Now().Add(nip98MaxSkew / 2).Unix())
+		}},
+		{name: "other method", key: adminKey, method: "DELETE", edit: func(event *nostr.Event) {
+			event.Tags[1] = nostr.Tag{"method", "GET"}
+		}, wantError: "another method"},
+		{name: "other URL", key: adminKey, method: "GET", edit: func(event *nostr.Event) {
+			event.Tags[0] = nostr.Tag{"u", "https://relay.example.com/admin/members"}
+		}, wantError: "another URL"},
+		{name: "wrong kind", key: adminKey, method: "GET", edit: func(event *nostr.Event) {
+			event.Kind = 1
+		}, wantError: "must be kind"},
+		{name: "not an admin", key: nostr.GeneratePrivateKey(), method: "GET", wantError: "not an admin"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			authorization := nip98Authorization(t, test.key, test.method, url, test.signed, test.edit)
+			r := httptest.NewRequest(test.method, "/admin/refund", strings.NewReader(test.body))
+			err := system.checkNIP98(r, authorization)
+			if test.wantError == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantError) {
+				t.Fatalf("got error %v, want %q", err, test.wantError)
+			}
+		})
+	}
+}
+
+func TestCheckNIP98Replay(t *testing.T) {
+	adminKey := nostr.GeneratePrivateKey()
+	adminPubkey, _ := nostr.GetPublicKey(adminKey)
+	system := newTestSystem(t, Config{AdminPubkeys: []string{adminPubkey}, PublicURL: "https://relay.example.com"})
+
+	const url = "https://relay.example.com/admin/members"
+	authorization := nip98Authorization(t, adminKey, "GET", url, "", nil)
+	if err := system.checkNIP98(httptest.NewRequest("GET", "/admin/members", nil), authorization); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+
+	// The same event again, and with another id, which the signature doesn't cover
+	raw, _ := base64.StdEncoding.DecodeString(authorization)
+	var event nostr.Event
+	if err := json.Unmarshal(raw, &event); err != nil {
+		t.Fatal(err)
+	}
+	event.ID = strings.Repeat("0", 64)
+	forged, _ := json.Marshal(event)
+
+	for name, replay := range map[string]string{
+		"same event": authorization,
+		"other id":   base64.StdEncoding.EncodeToString(forged),
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := system.checkNIP98(httptest.NewRequest("GET", "/admin/members", nil), replay)
+			if err == nil || !strings.Contains(err.Error(), "already used") {
+				t.Fatalf("got error %v, want replay refused", err)
+			}
+		})
+	}
+}
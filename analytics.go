@@ -0,0 +1,107 @@
+package payments
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// CohortRow holds retention for members who first paid in the same month
+type CohortRow struct {
+	Cohort   string `json:"cohort"`   // first payment month, "2006-01"
+	Members  int    `json:"members"`  // members whose first payment fell in this month
+	Retained []int  `json:"retained"` // Retained[i] = members who paid again in month cohort+i+1
+}
+
+// CohortReport is a retention matrix computed from the payment ledger
+type CohortReport struct {
+	Months  int         `json:"months"`
+	Cohorts []CohortRow `json:"cohorts"`
+}
+
+// CohortRetention computes, for each month in which members first paid, how many of
+// them renewed in each of the following months
+func (s *System) CohortRetention(months int) *CohortReport {
+	if months <= 0 {
+		months = 12
+	}
+
+	// Collect the set of months in which each pubkey paid
+	paidMonths := make(map[string]map[int]bool)
+	for _, entry := range s.ledger.List() {
+		if paidMonths[entry.Pubkey] == nil {
+			paidMonths[entry.Pubkey] = make(map[int]bool)
+		}
+		paidMonths[entry.Pubkey][monthIndex(entry.PaidAt)] = true
+	}
+
+	rows := make(map[int]*CohortRow)
+	for _, set := range paidMonths {
+		first := -1
+		for month := range set {
+			if first == -1 || month < first {
+				first = month
+			}
+		}
+
+		row, exists := rows[first]
+		if !exists {
+			row = &CohortRow{
+				Cohort:   monthLabel(first),
+				Retained: make([]int, months),
+			}
+			rows[first] = row
+		}
+
+		row.Members++
+		for i := 1; i <= months; i++ {
+			if set[first+i] {
+				row.Retained[i-1]++
+			}
+		}
+	}
+
+	keys := make([]int, 0, len(rows))
+	for key := range rows {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+
+	report := &CohortReport{
+		Months:  months,
+		Cohorts: make([]CohortRow, 0, len(keys)),
+	}
+	for _, key := range keys {
+		report.Cohorts = append(report.Cohorts, *rows[key])
+	}
+	return report
+}
+
+// adminCohortsHandler serves the cohort retention matrix as JSON
+func (s *System) adminCohortsHandler(w http.ResponseWriter, r *http.Request) {
+	months := 12
+	if value := r.URL.Query().Get("months"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > 120 {
+			http.Error(w, "months must be between 1 and 120", http.StatusBadRequest)
+			return
+		}
+		months = parsed
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.CohortRetention(months))
+}
+
+// monthIndex maps a time to a sequential month number (UTC)
+func monthIndex(t time.Time) int {
+	t = t.UTC()
+	return t.Year()*12 + int(t.Month()) - 1
+}
+
+// monthLabel formats a month number produced by monthIndex
+func monthLabel(index int) string {
+	return time.Date(index/12, time.Month(index%12+1), 1, 0, 0, 0, 0, time.UTC).Format("2006-01")
+}
@@ -0,0 +1,202 @@
+//go:build ark
+
+package payments
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Experimental: the ark provider is only compiled with -tags ark. It settles memberships
+// off-chain through an Ark (or Spark) wallet daemon that exposes a small payment request API:
+//
+//	POST /v1/invoice        {"amount_msat": 21000, "description": "..."} -> {"id", "payment_request", "expires_at"}
+//	GET  /v1/invoice/{id}   -> {"id", "settled", "amount_msat", "settled_at"}
+//
+// The request id stands in for the payment hash everywhere else in the system.
+func init() {
+	providerFactories["ark"] = newArkFromConfig
+}
+
+// newArkFromConfig creates the experimental Ark provider
+func newArkFromConfig(config *Config, invoiceStore *InvoiceStore) (PaymentProvider, error) {
+	if config.ArkURL == "" {
+		return nil, fmt.Errorf("ARK_URL required for ark provider")
+	}
+	return NewArkProvider(config.ArkURL, config.ArkToken, invoiceStore)
+}
+
+// ArkProvider implements PaymentProvider interface for an Ark wallet daemon
+type ArkProvider struct {
+	baseURL string
+	token   string
+	// Map request id to pubkey for CheckExistingPayments
+	pubkeyMap *lruMap[string]
+	mu        sync.RWMutex
+	// Persistent storage references
+	invoiceStore *InvoiceStore
+	providerHTTP
+	logTarget
+}
+
+// NewArkProvider creates a new Ark payment provider
+func NewArkProvider(baseURL, token string, invoiceStore *InvoiceStore) (*ArkProvider, error) {
+	if baseURL == "" {
+		return nil, fmt.Errorf("ark daemon URL is required")
+	}
+
+	return &ArkProvider{
+		baseURL:      baseURL,
+		token:        token,
+		pubkeyMap:    newLRUMap[string](providerMapSize),
+		invoiceStore: invoiceStore,
+	}, nil
+}
+
+// GetProviderName returns the provider name
+func (p *ArkProvider) GetProviderName() string {
+	return "ark"
+}
+
+// Ark daemon API structures
+type ArkInvoiceRequest struct {
+	AmountMsat  int64  `json:"amount_msat"`
+	Description string `json:"description"`
+}
+
+type ArkInvoiceResponse struct {
+	ID             string `json:"id"`
+	PaymentRequest string `json:"payment_request"`
+	ExpiresAt      int64  `json:"expires_at"`
+}
+
+type ArkInvoiceStatus struct {
+	ID         string `json:"id"`
+	Settled    bool   `json:"settled"`
+	AmountMsat int64  `json:"amount_msat"`
+	SettledAt  int64  `json:"settled_at"`
+}
+
+// CreateInvoice creates an Ark payment request
+func (p *ArkProvider) CreateInvoice(ctx context.Context, amount int64, description string, pubkey string) (*Invoice, error) {
+	payload, err := json.Marshal(ArkInvoiceRequest{AmountMsat: amount, Description: description})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	body, err := p.do(ctx, OpCreateInvoice, "POST", "/v1/invoice", payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var invoiceResp ArkInvoiceResponse
+	if err := json.Unmarshal(body, &invoiceResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	p.mu.Lock()
+	p.pubkeyMap.Set(invoiceResp.ID, pubkey)
+	p.mu.Unlock()
+
+	if p.invoiceStore != nil {
+		p.invoiceStore.RecordCharge(invoiceResp.ID, invoiceResp.ID, amount)
+	}
+
+	return &Invoice{
+		PaymentRequest: invoiceResp.PaymentRequest,
+		PaymentHash:    invoiceResp.ID,
+		Amount:         amount,
+		Description:    description,
+		ExpiresAt:      time.Unix(invoiceResp.ExpiresAt, 0),
+	}, nil
+}
+
+// VerifyPayment checks if an Ark payment request has settled
+func (p *ArkProvider) VerifyPayment(ctx context.Context, paymentHash string) (*PaymentVerification, error) {
+	body, err := p.do(ctx, OpVerifyPayment, "GET", "/v1/invoice/"+url.PathEscape(paymentHash), nil)
+	if err != nil {
+		var providerErr *ProviderError
+		if errors.As(err, &providerErr) && providerErr.StatusCode == http.StatusNotFound {
+			return &PaymentVerification{Paid: false, PaymentHash: paymentHash}, nil
+		}
+		return nil, err
+	}
+
+	var status ArkInvoiceStatus
+	if err := json.Unmarshal(body, &status); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	verification := &PaymentVerification{
+		Paid:        status.Settled,
+		PaymentHash: paymentHash,
+		Amount:      status.AmountMsat,
+	}
+	if status.Settled {
+		verification.PaidAt = time.Unix(status.SettledAt, 0)
+	}
+	return verification, nil
+}
+
+// CheckExistingPayments checks for any existing payments for a pubkey and returns verification if paid
+func (p *ArkProvider) CheckExistingPayments(ctx context.Context, pubkey string) (*PaymentVerification, error) {
+	p.mu.RLock()
+	ids := pendingHashesFor(p.pubkeyMap, p.invoiceStore, p.GetProviderName(), pubkey)
+	p.mu.RUnlock()
+
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		verification, err := p.VerifyPayment(ctx, id)
+		if err == nil && verification.Paid {
+			p.logInfo("💰 Found settled ark payment: %s", id)
+			return verification, nil
+		}
+	}
+
+	return nil, nil // No paid payments found
+}
+
+// do sends a request to the Ark daemon and returns the response body
+func (p *ArkProvider) do(ctx context.Context, op, method, path string, payload []byte) ([]byte, error) {
+	var reqBody io.Reader
+	if payload != nil {
+		reqBody = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, p.baseURL+path, reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+
+	resp, err := p.httpClient().Do(req)
+	if err != nil {
+		return nil, newRequestError(p.GetProviderName(), op, fmt.Errorf("failed to make request: %w", err))
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, newRequestError(p.GetProviderName(), op, fmt.Errorf("failed to read response: %w", err))
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStatusError(p.GetProviderName(), op, resp.StatusCode, body)
+	}
+	return body, nil
+}
@@ -0,0 +1,109 @@
+package payments
+
+import (
+	"context"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// AttachOption customizes how Attach wires the payment system onto a relay
+type AttachOption func(*attachOptions)
+
+type attachOptions struct {
+	bypass    func(ctx context.Context, pubkey string) bool
+	paidReads bool
+	skipInfo  bool
+}
+
+// WithBypass lets pubkeys for which bypass returns true use the relay without paying,
+// e.g. pubkeys in the relay's Web of Trust
+func WithBypass(bypass func(ctx context.Context, pubkey string) bool) AttachOption {
+	return func(options *attachOptions) {
+		options.bypass = bypass
+	}
+}
+
+// WithPaidReads also enforces CapabilityRead and CapabilitySearch on queries, asking
+// clients to authenticate (NIP-42) unless both are free
+func WithPaidReads() AttachOption {
+	return func(options *attachOptions) {
+		options.paidReads = true
+	}
+}
+
+// WithoutRelayInfo leaves the relay's NIP-11 document untouched
+func WithoutRelayInfo() AttachOption {
+	return func(options *attachOptions) {
+		options.skipInfo = true
+	}
+}
+
+// Attach wires the payment system onto a khatru relay in one call: event rejection,
+// read restrictions if enabled, connection notices, NWC requests, the NIP-11 fees and
+// payments URL, and the HTTP endpoints including the payment page
+func Attach(relay *khatru.Relay, system *System, opts ...AttachOption) {
+	var options attachOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	relay.RejectEvent = append(relay.RejectEvent, func(ctx context.Context, event *nostr.Event) (bool, string) {
+		if options.bypass != nil && options.bypass(ctx, event.PubKey) {
+			return false, ""
+		}
+		// Authenticated members may publish events signed by others, e.g. to back them up
+		if authed := khatru.GetAuthed(ctx); authed != "" && authed != event.PubKey && system.HasAccess(authed, CapabilityRebroadcast) {
+			return false, ""
+		}
+		return system.RejectEventHandler(system.withConnectionIP(ctx), event)
+	})
+
+	if options.paidReads {
+		if !system.IsFree(CapabilityRead) || !system.IsFree(CapabilitySearch) {
+			relay.OnConnect = append(relay.OnConnect, khatru.RequestAuth)
+		}
+		relay.RejectFilter = append(relay.RejectFilter, func(ctx context.Context, filter nostr.Filter) (bool, string) {
+			pubkey := khatru.GetAuthed(ctx)
+			if pubkey != "" && options.bypass != nil && options.bypass(ctx, pubkey) {
+				return false, ""
+			}
+			return system.RejectFilterHandler(system.withConnectionIP(ctx), pubkey, filter)
+		})
+	}
+
+	// Tell clients on the connection that got an invoice once it is paid
+	system.SetConnectionLookup(func(ctx context.Context) ClientConnection {
+		if ws := khatru.GetConnection(ctx); ws != nil {
+			return ws
+		}
+		return nil
+	})
+
+	// Answer NWC requests for member balances, they are ephemeral and never stored
+	if system.NWCEnabled() {
+		relay.OnEphemeralEvent = append(relay.OnEphemeralEvent, func(ctx context.Context, event *nostr.Event) {
+			if response := system.HandleNWCRequest(ctx, event); response != nil {
+				relay.BroadcastEvent(response)
+			}
+		})
+	}
+
+	if !options.skipInfo && relay.Info != nil {
+		system.ApplyToRelayInfo(relay.Info)
+		if options.paidReads && !system.IsFree(CapabilityRead) {
+			relay.Info.Limitation.AuthRequired = true
+		}
+	}
+
+	system.RegisterHandlers(relay.Router())
+}
+
+// withConnectionIP marks ctx with the IP of the WebSocket client it belongs to, for the
+// per-IP invoice rate limit
+func (s *System) withConnectionIP(ctx context.Context) context.Context {
+	if ws := khatru.GetConnection(ctx); ws != nil && ws.Request != nil {
+		return WithClientIP(ctx, s.requestIP(ws.Request))
+	}
+	return ctx
+}
@@ -0,0 +1,119 @@
+package payments
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// AuditEntry records an administrative change to a membership
+type AuditEntry struct {
+	Action    string    `json:"action"`              // e.g. "transfer"
+	Pubkey    string    `json:"pubkey"`              // membership acted on
+	Target    string    `json:"target,omitempty"`    // second pubkey involved, e.g. the new key of a transfer
+	Actor     string    `json:"actor"`               // "admin", "self" or "system"
+	Reason    string    `json:"reason,omitempty"`    // free text given with the action
+	Reference string    `json:"reference,omitempty"` // id of the signed event authorizing the action, if any
+	At        time.Time `json:"at"`
+}
+
+// AuditLog keeps an append-only history of administrative membership changes
+type AuditLog struct {
+	Entries  []*AuditEntry `json:"entries"`
+	mutex    sync.RWMutex
+	filePath string
+}
+
+// NewAuditLog creates a new audit log
+func NewAuditLog(filePath string) *AuditLog {
+	audit := &AuditLog{
+		Entries:  make([]*AuditEntry, 0),
+		filePath: filePath,
+	}
+
+	// Ensure directory exists
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		logWarn("⚠️ Failed to create directory for audit log file: %v", err)
+	}
+
+	if err := audit.load(); err != nil {
+		logWarn("⚠️ Failed to load audit log: %v", err)
+	}
+	return audit
+}
+
+// load reads audit entries from file
+func (al *AuditLog) load() error {
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+
+	data, err := os.ReadFile(al.filePath)
+	if os.IsNotExist(err) {
+		return nil // File doesn't exist, start with empty log
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read audit log file: %w", err)
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(data, al)
+}
+
+// save writes audit entries to file
+func (al *AuditLog) save() error {
+	data, err := json.MarshalIndent(al, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit log: %w", err)
+	}
+
+	return os.WriteFile(al.filePath, data, 0644)
+}
+
+// Record appends an entry to the audit log
+func (al *AuditLog) Record(entry AuditEntry) error {
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+
+	if entry.At.IsZero() {
+		entry.At = time.Now()
+	}
+	al.Entries = append(al.Entries, &entry)
+
+	if err := al.save(); err != nil {
+		return fmt.Errorf("failed to save audit log: %w", err)
+	}
+	return nil
+}
+
+// HasReference reports whether an entry was recorded for the given authorization event
+func (al *AuditLog) HasReference(reference string) bool {
+	al.mutex.RLock()
+	defer al.mutex.RUnlock()
+
+	for _, entry := range al.Entries {
+		if entry.Reference == reference {
+			return true
+		}
+	}
+	return false
+}
+
+// List returns a copy of the entries concerning pubkey, or all entries if pubkey is empty
+func (al *AuditLog) List(pubkey string) []AuditEntry {
+	al.mutex.RLock()
+	defer al.mutex.RUnlock()
+
+	entries := make([]AuditEntry, 0)
+	for _, entry := range al.Entries {
+		if pubkey == "" || entry.Pubkey == pubkey || entry.Target == pubkey {
+			entries = append(entries, *entry)
+		}
+	}
+	return entries
+}
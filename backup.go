@@ -0,0 +1,204 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BackupVersion is the format version of backups written by this package
+const BackupVersion = 1
+
+// backupTimeFormat names backup files so they sort oldest first
+const backupTimeFormat = "20060102T150405Z"
+
+// Backup is a point-in-time copy of memberships and the invoices needed to verify
+// payments still in flight
+type Backup struct {
+	Version   int                        `json:"version"`
+	CreatedAt time.Time                  `json:"created_at"`
+	Members   *StoreData                 `json:"members"`
+	Invoices  map[string]*TrackedInvoice `json:"invoices"`
+}
+
+// BackupSink opens the destination of a scheduled backup, e.g. a remote object named name.
+// Closing the writer completes the backup.
+type BackupSink func(name string) (io.WriteCloser, error)
+
+// Backup takes a snapshot of memberships and invoices
+func (s *System) Backup() *Backup {
+	return &Backup{
+		Version:   BackupVersion,
+		CreatedAt: time.Now().UTC(),
+		Members:   s.paidAccessStorage.export(),
+		Invoices:  s.invoices.export(),
+	}
+}
+
+// WriteBackup writes a snapshot of memberships and invoices to w as JSON
+func (s *System) WriteBackup(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(s.Backup())
+}
+
+// RestoreFromBackup replaces memberships and invoices with those of a backup written by
+// WriteBackup. Changes made since the backup are lost.
+func (s *System) RestoreFromBackup(r io.Reader) error {
+	var backup Backup
+	if err := json.NewDecoder(r).Decode(&backup); err != nil {
+		return fmt.Errorf("failed to read backup: %w", err)
+	}
+	if backup.Version > BackupVersion {
+		return fmt.Errorf("backup version %d is newer than supported version %d", backup.Version, BackupVersion)
+	}
+	if backup.Members == nil || backup.Members.Members == nil {
+		return fmt.Errorf("backup holds no membership data")
+	}
+
+	if err := s.paidAccessStorage.replace(backup.Members); err != nil {
+		return fmt.Errorf("failed to restore memberships: %w", err)
+	}
+	s.invoices.replace(backup.Invoices)
+	if err := s.flush(); err != nil {
+		return fmt.Errorf("failed to save restored data: %w", err)
+	}
+
+	s.logInfo("♻️ Restored %d memberships and %d invoices from the backup of %s",
+		len(backup.Members.Members), len(backup.Invoices), backup.CreatedAt.Format(time.RFC3339))
+	return nil
+}
+
+// RestoreFromBackupFile restores memberships and invoices from a backup file
+func (s *System) RestoreFromBackupFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer file.Close()
+	return s.RestoreFromBackup(file)
+}
+
+// BackupsEnabled reports whether scheduled backups have a destination
+func (s *System) BackupsEnabled() bool {
+	return s.config.BackupDir != "" || s.config.BackupSink != nil
+}
+
+// BackupNow writes a timestamped backup to the configured sink or BackupDir and returns its
+// name. Backups in BackupDir beyond BackupKeep are deleted, oldest first.
+func (s *System) BackupNow() (string, error) {
+	if !s.BackupsEnabled() {
+		return "", fmt.Errorf("no backup destination configured")
+	}
+	name := "backup-" + time.Now().UTC().Format(backupTimeFormat) + ".json"
+
+	sink := s.config.BackupSink
+	if sink == nil {
+		sink = s.backupFile
+	}
+	w, err := sink(name)
+	if err != nil {
+		return "", fmt.Errorf("failed to open backup %s: %w", name, err)
+	}
+	if err := s.WriteBackup(w); err != nil {
+		w.Close()
+		return "", fmt.Errorf("failed to write backup %s: %w", name, err)
+	}
+	if err := w.Close(); err != nil {
+		return "", fmt.Errorf("failed to write backup %s: %w", name, err)
+	}
+
+	if s.config.BackupSink == nil {
+		s.pruneBackups()
+	}
+	s.logInfo("🗄️ Wrote backup %s", name)
+	return name, nil
+}
+
+// backupFile is the default sink, writing into BackupDir
+func (s *System) backupFile(name string) (io.WriteCloser, error) {
+	if err := os.MkdirAll(s.config.BackupDir, 0700); err != nil {
+		return nil, err
+	}
+	// Backups list members and their payments, keep them private
+	return os.OpenFile(filepath.Join(s.config.BackupDir, name), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+}
+
+// pruneBackups deletes the oldest backups in BackupDir beyond BackupKeep
+func (s *System) pruneBackups() {
+	if s.config.BackupKeep <= 0 {
+		return
+	}
+	entries, err := os.ReadDir(s.config.BackupDir)
+	if err != nil {
+		s.logWarn("⚠️ Failed to list backups: %v", err)
+		return
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasPrefix(entry.Name(), "backup-") && strings.HasSuffix(entry.Name(), ".json") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	for len(names) > s.config.BackupKeep {
+		if err := os.Remove(filepath.Join(s.config.BackupDir, names[0])); err != nil {
+			s.logWarn("⚠️ Failed to delete old backup %s: %v", names[0], err)
+		}
+		names = names[1:]
+	}
+}
+
+// runBackups writes a backup on every tick
+func (s *System) runBackups(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := s.BackupNow(); err != nil {
+			s.logError("❌ Scheduled backup failed: %v", err)
+		}
+	}
+}
+
+// adminDownloadBackupHandler downloads a fresh backup
+func (s *System) adminDownloadBackupHandler(w http.ResponseWriter, r *http.Request) {
+	name := "backup-" + time.Now().UTC().Format(backupTimeFormat) + ".json"
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	if err := s.WriteBackup(w); err != nil {
+		s.logError("❌ Failed to write backup download: %v", err)
+	}
+}
+
+// adminBackupHandler writes a backup to the configured destination now
+func (s *System) adminBackupHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.BackupsEnabled() {
+		http.Error(w, "No backup destination configured", http.StatusConflict)
+		return
+	}
+	name, err := s.BackupNow()
+	if err != nil {
+		s.logError("❌ Backup failed: %v", err)
+		http.Error(w, "Backup failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"backup": name,
+	})
+}
@@ -0,0 +1,403 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// BalanceAccount is a member's prepaid balance and the NWC connection spending it
+type BalanceAccount struct {
+	Pubkey           string    `json:"pubkey"`            // member the balance pays for
+	ConnectionPubkey string    `json:"connection_pubkey"` // key NWC requests are signed with
+	ConnectionSecret string    `json:"connection_secret"` // secret handed out in the connection string
+	Balance          int64     `json:"balance"`           // in millisatoshis
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// BalanceStore persists member balances
+type BalanceStore struct {
+	Accounts map[string]*BalanceAccount `json:"accounts"`
+	TopUps   map[string]time.Time       `json:"top_ups"` // credited payment hashes, so no top-up counts twice
+	mutex    sync.RWMutex
+	filePath string
+
+	persister *writeBehind // set by SetWriteDelay, nil writes synchronously
+
+	logTarget
+}
+
+// NewBalanceStore creates a new balance store
+func NewBalanceStore(filePath string) *BalanceStore {
+	store := &BalanceStore{
+		Accounts: make(map[string]*BalanceAccount),
+		TopUps:   make(map[string]time.Time),
+		filePath: filePath,
+	}
+
+	// Ensure directory exists
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		logWarn("⚠️ Failed to create directory for balance file: %v", err)
+	}
+
+	if err := store.load(); err != nil {
+		logWarn("⚠️ Failed to load balances: %v", err)
+	}
+	return store
+}
+
+// load reads balances from file
+func (bs *BalanceStore) load() error {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+
+	data, err := os.ReadFile(bs.filePath)
+	if os.IsNotExist(err) {
+		return nil // File doesn't exist, start with no balances
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read balance file: %w", err)
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(data, bs); err != nil {
+		return err
+	}
+	if bs.Accounts == nil {
+		bs.Accounts = make(map[string]*BalanceAccount)
+	}
+	if bs.TopUps == nil {
+		bs.TopUps = make(map[string]time.Time)
+	}
+	return nil
+}
+
+// save writes balances to file, caller holds the mutex. With a write delay set the write
+// happens in the background, see SetWriteDelay.
+func (bs *BalanceStore) save() error {
+	if bs.persister != nil {
+		bs.persister.markDirty()
+		return nil
+	}
+	return bs.write()
+}
+
+// write replaces the balance file with the current balances, caller holds the mutex
+func (bs *BalanceStore) write() error {
+	data, err := json.MarshalIndent(bs, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal balances: %w", err)
+	}
+
+	return writeFileAtomic(bs.filePath, data, 0600) // holds connection secrets
+}
+
+// SetWriteDelay batches writes: changes are written in the background at most delay after
+// they happen, so paying for events from a balance doesn't rewrite the file each time. Zero
+// writes synchronously. Call Flush before exiting so the last changes are not lost.
+func (bs *BalanceStore) SetWriteDelay(delay time.Duration) {
+	bs.Flush()
+
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	if delay <= 0 {
+		bs.persister = nil
+		return
+	}
+	bs.persister = newWriteBehind("balance file", delay, &bs.logTarget, func() error {
+		bs.mutex.Lock()
+		defer bs.mutex.Unlock()
+		return bs.write()
+	})
+}
+
+// Flush writes changes still waiting for a background write
+func (bs *BalanceStore) Flush() error {
+	bs.mutex.Lock()
+	persister := bs.persister
+	bs.mutex.Unlock()
+
+	if persister == nil {
+		return nil
+	}
+	return persister.Flush()
+}
+
+// Open returns the account of a member, creating it with a fresh NWC connection secret
+func (bs *BalanceStore) Open(pubkey string) (*BalanceAccount, error) {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+
+	if account, exists := bs.Accounts[pubkey]; exists {
+		copied := *account
+		return &copied, nil
+	}
+
+	secret := nostr.GeneratePrivateKey()
+	connectionPubkey, err := nostr.GetPublicKey(secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive connection key: %w", err)
+	}
+
+	now := time.Now()
+	account := &BalanceAccount{
+		Pubkey:           pubkey,
+		ConnectionPubkey: connectionPubkey,
+		ConnectionSecret: secret,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+	bs.Accounts[pubkey] = account
+
+	if err := bs.save(); err != nil {
+		delete(bs.Accounts, pubkey)
+		return nil, fmt.Errorf("failed to save balances: %w", err)
+	}
+
+	bs.with(pubkeyAttr(pubkey)).logInfo("👛 Opened balance account for pubkey %s...", pubkey[:16])
+	copied := *account
+	return &copied, nil
+}
+
+// Get returns a copy of a member's account
+func (bs *BalanceStore) Get(pubkey string) (*BalanceAccount, bool) {
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+
+	account, exists := bs.Accounts[pubkey]
+	if !exists {
+		return nil, false
+	}
+	copied := *account
+	return &copied, true
+}
+
+// ByConnection returns the account an NWC connection key belongs to
+func (bs *BalanceStore) ByConnection(connectionPubkey string) (*BalanceAccount, bool) {
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+
+	for _, account := range bs.Accounts {
+		if account.ConnectionPubkey == connectionPubkey {
+			copied := *account
+			return &copied, true
+		}
+	}
+	return nil, false
+}
+
+// Pubkeys returns the members that have an account
+func (bs *BalanceStore) Pubkeys() []string {
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+
+	pubkeys := make([]string, 0, len(bs.Accounts))
+	for pubkey := range bs.Accounts {
+		pubkeys = append(pubkeys, pubkey)
+	}
+	return pubkeys
+}
+
+// Credit adds a paid top-up to a member's balance. It returns false if the payment
+// was credited before.
+func (bs *BalanceStore) Credit(pubkey, paymentHash string, amount int64) (bool, error) {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+
+	account, exists := bs.Accounts[pubkey]
+	if !exists {
+		return false, fmt.Errorf("no balance account for pubkey: %s", pubkey)
+	}
+	if _, credited := bs.TopUps[paymentHash]; credited {
+		return false, nil
+	}
+
+	account.Balance += amount
+	account.UpdatedAt = time.Now()
+	bs.TopUps[paymentHash] = account.UpdatedAt
+
+	if err := bs.save(); err != nil {
+		return false, fmt.Errorf("failed to save balances: %w", err)
+	}
+	return true, nil
+}
+
+// Debit takes amount from a member's balance if it covers it, returning whether it did
+func (bs *BalanceStore) Debit(pubkey string, amount int64) (bool, error) {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+
+	account, exists := bs.Accounts[pubkey]
+	if !exists || account.Balance < amount {
+		return false, nil
+	}
+
+	account.Balance -= amount
+	account.UpdatedAt = time.Now()
+
+	if err := bs.save(); err != nil {
+		account.Balance += amount
+		return false, fmt.Errorf("failed to save balances: %w", err)
+	}
+	return true, nil
+}
+
+// Restore gives back amount taken by Debit for something that then failed
+func (bs *BalanceStore) Restore(pubkey string, amount int64) error {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+
+	account, exists := bs.Accounts[pubkey]
+	if !exists {
+		return fmt.Errorf("no balance account for pubkey: %s", pubkey)
+	}
+	account.Balance += amount
+	account.UpdatedAt = time.Now()
+
+	if err := bs.save(); err != nil {
+		return fmt.Errorf("failed to save balances: %w", err)
+	}
+	return nil
+}
+
+// Stats returns the number of accounts and the total prepaid balance
+func (bs *BalanceStore) Stats() (accounts int, total int64) {
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+
+	for _, account := range bs.Accounts {
+		total += account.Balance
+	}
+	return len(bs.Accounts), total
+}
+
+// Credited reports whether a top-up payment was credited to a balance
+func (bs *BalanceStore) Credited(paymentHash string) bool {
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+
+	_, credited := bs.TopUps[paymentHash]
+	return credited
+}
+
+// balanceDayTier is the tier of memberships renewed a day at a time with BalanceDayCharge
+const balanceDayTier = "daily"
+
+// bytesPerMB is the unit BalanceMBCharge is priced in
+const bytesPerMB = 1 << 20
+
+// BalancesEnabled reports whether members have prepaid balances, with or without NWC
+func (s *System) BalancesEnabled() bool {
+	return s.balances != nil
+}
+
+// meteredBalances reports whether balances pay per event instead of renewing memberships
+func (s *System) meteredBalances() bool {
+	return s.config.NWCEventCharge > 0 || s.config.BalanceMBCharge > 0
+}
+
+// eventCharge returns what an event costs from a balance: the per-event charge plus the
+// per-MB charge for its serialized size, rounded up to the next msat
+func (s *System) eventCharge(event *nostr.Event) int64 {
+	charge := s.config.NWCEventCharge
+	if s.config.BalanceMBCharge > 0 {
+		size := int64(len(event.String()))
+		charge += (s.config.BalanceMBCharge*size + bytesPerMB - 1) / bytesPerMB
+	}
+	return charge
+}
+
+// topUpPubkey reports whether a payment hash belongs to a balance top-up, and the pubkey
+// whose balance it credits. Credited top-ups no longer tracked report an empty pubkey.
+func (s *System) topUpPubkey(paymentHash string) (string, bool) {
+	if !s.BalancesEnabled() {
+		return "", false
+	}
+	if invoice, tracked := s.invoices.Get(paymentHash); tracked && invoice.Purpose == PurposeTopUp {
+		return invoice.Pubkey, true
+	}
+	return "", s.balances.Credited(paymentHash)
+}
+
+// balanceHandler returns a pubkey's balance and what access costs from it
+func (s *System) balanceHandler(w http.ResponseWriter, r *http.Request) {
+	pubkey, err := parsePubkey(r.PathValue("pubkey"))
+	if err != nil {
+		http.Error(w, "valid pubkey is required", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"pubkey":     pubkey,
+		"balance":    s.Balance(pubkey),
+		"has_access": s.HasAccess(pubkey),
+		"charges": map[string]int64{
+			"per_event": s.config.NWCEventCharge,
+			"per_mb":    s.config.BalanceMBCharge,
+			"per_day":   s.config.BalanceDayCharge,
+		},
+	})
+}
+
+// topUpHandler creates an invoice crediting a pubkey's balance once paid
+func (s *System) topUpHandler(w http.ResponseWriter, r *http.Request) {
+	pubkey, err := parsePubkey(r.PathValue("pubkey"))
+	if err != nil {
+		http.Error(w, "valid pubkey is required", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Amount int64 `json:"amount"` // in millisatoshis
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Amount < minTopUpAmount {
+		http.Error(w, fmt.Sprintf("amount of at least %d msat required", minTopUpAmount), http.StatusBadRequest)
+		return
+	}
+	if s.IsDenylisted(pubkey) {
+		http.Error(w, "Pubkey is banned from this relay", http.StatusForbidden)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.invoiceTimeout)
+	defer cancel()
+
+	invoice, err := s.createTopUpInvoice(ctx, pubkey, req.Amount)
+	if errors.Is(err, errRateLimited) {
+		writeRateLimited(w)
+		return
+	}
+	if err != nil {
+		s.logError("❌ Failed to create top-up invoice: %v", err)
+		http.Error(w, "Failed to create invoice", http.StatusBadGateway)
+		return
+	}
+
+	response := map[string]interface{}{
+		"pubkey":          pubkey,
+		"amount":          invoice.Amount,
+		"payment_request": invoice.PaymentRequest,
+		"payment_hash":    invoice.PaymentHash,
+	}
+	if !invoice.ExpiresAt.IsZero() {
+		response["expires_at"] = invoice.ExpiresAt.Unix()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,240 @@
+package payments
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// benchProvider issues fake invoices instantly, so benchmarks measure the system itself
+type benchProvider struct {
+	created atomic.Int64
+}
+
+func (p *benchProvider) CreateInvoice(ctx context.Context, amount int64, description string, pubkey string) (*Invoice, error) {
+	n := p.created.Add(1)
+	return &Invoice{
+		PaymentRequest: fmt.Sprintf("lnbcbench%d", n),
+		PaymentHash:    fmt.Sprintf("%064x", n),
+		Amount:         amount,
+		Description:    description,
+		ExpiresAt:      time.Now().Add(time.Hour),
+	}, nil
+}
+
+func (p *benchProvider) VerifyPayment(ctx context.Context, paymentHash string) (*PaymentVerification, error) {
+	return &PaymentVerification{PaymentHash: paymentHash}, nil
+}
+
+func (p *benchProvider) CheckExistingPayments(ctx context.Context, pubkey string) (*PaymentVerification, error) {
+	return &PaymentVerification{}, nil
+}
+
+func (p *benchProvider) GetProviderName() string {
+	return "bench"
+}
+
+var registerBenchProvider sync.Once
+
+// TestMain keeps the logs of storages created outside a System out of benchmark results
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// newBenchSystem creates a system on the bench provider keeping its files in a temporary
+// directory
+func newBenchSystem(b *testing.B) *System {
+	b.Helper()
+	return newTestSystem(b, Config{})
+}
+
+// newTestSystem creates a system configured with config on the bench provider, keeping its
+// files in a temporary directory
+func newTestSystem(tb testing.TB, config Config) *System {
+	tb.Helper()
+	registerBenchProvider.Do(func() {
+		RegisterProvider("bench", func(Config) (PaymentProvider, error) {
+			return &benchProvider{}, nil
+		})
+	})
+
+	dir := tb.TempDir()
+	config.Provider = "bench"
+	config.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	config.PaidAccessFile = filepath.Join(dir, "paid_access.json")
+	config.ChargeMappingFile = filepath.Join(dir, "charge_mappings.json")
+	config.InvoiceFile = filepath.Join(dir, "invoices.json")
+	config.LedgerFile = filepath.Join(dir, "payment_ledger.json")
+	config.AuditFile = filepath.Join(dir, "audit_log.json")
+	config.AccessListFile = filepath.Join(dir, "access_lists.json")
+	config.WebhookRetryFile = filepath.Join(dir, "webhook_retries.json")
+	config.BalanceFile = filepath.Join(dir, "balances.json")
+	config.CouponFile = filepath.Join(dir, "coupons.json")
+	system, err := New(config)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		if err := system.Close(context.Background()); err != nil {
+			tb.Error(err)
+		}
+	})
+	return system
+}
+
+// benchPubkeys returns n random hex pubkeys
+func benchPubkeys(n int) []string {
+	pubkeys := make([]string, n)
+	for i := range pubkeys {
+		key := make([]byte, 32)
+		rand.Read(key)
+		pubkeys[i] = hex.EncodeToString(key)
+	}
+	return pubkeys
+}
+
+// benchStorage creates a JSON file backed storage holding members paid members, with an
+// event quota of events each unless 0
+func benchStorage(b *testing.B, members int, events int64) (*PaidAccessStorage, []string) {
+	b.Helper()
+	storage := NewPaidAccessStorage(filepath.Join(b.TempDir(), "paid_access.json"))
+	storage.SetWriteDelay(time.Hour) // fill it without writing each member
+	pubkeys := benchPubkeys(members)
+	for _, pubkey := range pubkeys {
+		if err := storage.AddPaidAccess(pubkey, "", 21000, 30*24*time.Hour); err != nil {
+			b.Fatal(err)
+		}
+		if events > 0 {
+			if err := storage.SetQuotas(pubkey, events, 0); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	storage.SetWriteDelay(0)
+	if err := storage.Flush(); err != nil {
+		b.Fatal(err)
+	}
+	return storage, pubkeys
+}
+
+// BenchmarkRejectEventHandler measures events from unpaid pubkeys, each issued an
+// invoice, and from members, which are let through
+func BenchmarkRejectEventHandler(b *testing.B) {
+	for _, bench := range []struct {
+		name  string
+		paid  bool
+		count int
+	}{
+		{"unpaid", false, 1000},
+		{"members", true, 1000},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			system := newBenchSystem(b)
+			ctx := context.Background()
+			pubkeys := benchPubkeys(bench.count)
+			if bench.paid {
+				for _, pubkey := range pubkeys {
+					if _, err := system.GrantAccess(ctx, pubkey, 0, "bench"); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+
+			var next atomic.Int64
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					pubkey := pubkeys[int(next.Add(1))%len(pubkeys)]
+					event := &nostr.Event{PubKey: pubkey, CreatedAt: nostr.Now(), Kind: 1, Content: "bench"}
+					if reject, _ := system.RejectEventHandler(ctx, event); reject == bench.paid {
+						b.Fatalf("unexpected outcome for %s... (rejected: %v)", pubkey[:16], reject)
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkHasAccess measures access checks from many goroutines, with and without the
+// access cache and while members keep being granted
+func BenchmarkHasAccess(b *testing.B) {
+	for _, bench := range []struct {
+		name   string
+		ttl    time.Duration
+		writes bool
+	}{
+		{"cached", time.Second, false},
+		{"uncached", 0, false},
+		{"cached with writes", time.Second, true},
+		{"uncached with writes", 0, true},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			storage, pubkeys := benchStorage(b, 10000, 0)
+			storage.SetAccessCacheTTL(bench.ttl)
+			storage.SetWriteDelay(time.Second)
+
+			if bench.writes {
+				stop := make(chan struct{})
+				done := make(chan struct{})
+				go func() {
+					defer close(done)
+					for _, pubkey := range benchPubkeys(100000) {
+						select {
+						case <-stop:
+							return
+						default:
+						}
+						storage.AddPaidAccess(pubkey, "", 21000, time.Hour)
+					}
+				}()
+				b.Cleanup(func() {
+					close(stop)
+					<-done
+					storage.Flush()
+				})
+			}
+
+			var next atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if !storage.HasAccess(pubkeys[int(next.Add(1))%len(pubkeys)]) {
+						b.Fatal("member has no access")
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkStorageSave measures the synchronous write each membership change makes, as
+// the number of members grows
+func BenchmarkStorageSave(b *testing.B) {
+	for _, members := range []int{1000, 10000} {
+		b.Run(fmt.Sprintf("members=%d", members), func(b *testing.B) {
+			storage, pubkeys := benchStorage(b, members, 1<<40)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := storage.CountEvent(pubkeys[i%len(pubkeys)], 0); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
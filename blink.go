@@ -0,0 +1,369 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultBlinkURL is Blink's public GraphQL endpoint
+const defaultBlinkURL = "https://api.blink.sv/graphql"
+
+// blinkWebhookTolerance bounds the age of signed webhook deliveries, against replays
+const blinkWebhookTolerance = 5 * time.Minute
+
+// BlinkProvider implements PaymentProvider interface for Blink's (Galoy) GraphQL API
+type BlinkProvider struct {
+	apiURL        string
+	apiKey        string
+	walletID      string
+	webhookSecret []byte
+	// Map payment hash to pubkey for CheckExistingPayments
+	pubkeyMap *lruMap[string]
+	mu        sync.RWMutex
+	// Persistent storage references
+	invoiceStore *InvoiceStore
+	providerHTTP
+	logTarget
+}
+
+// NewBlinkProvider creates a new Blink payment provider. walletID is the BTC wallet receiving
+// payments, looked up from the account when empty. webhookSecret is the whsec_ signing
+// secret of a webhook endpoint configured in the Blink dashboard, empty to rely on polling.
+func NewBlinkProvider(apiURL, apiKey, walletID, webhookSecret string, invoiceStore *InvoiceStore) (*BlinkProvider, error) {
+	if apiKey == "" {
+		return nil, fmt.Errorf("Blink API key is required")
+	}
+	if apiURL == "" {
+		apiURL = defaultBlinkURL
+	}
+
+	provider := &BlinkProvider{
+		apiURL:       apiURL,
+		apiKey:       apiKey,
+		walletID:     walletID,
+		pubkeyMap:    newLRUMap[string](providerMapSize),
+		invoiceStore: invoiceStore,
+	}
+	if webhookSecret != "" {
+		secret, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(webhookSecret, "whsec_"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid Blink webhook secret: %w", err)
+		}
+		provider.webhookSecret = secret
+	}
+	return provider, nil
+}
+
+// newBlinkFromConfig creates the Blink provider
+func newBlinkFromConfig(config *Config, invoiceStore *InvoiceStore) (PaymentProvider, error) {
+	if config.BlinkAPIKey == "" {
+		return nil, fmt.Errorf("BLINK_API_KEY required for blink provider")
+	}
+	return NewBlinkProvider(config.BlinkURL, config.BlinkAPIKey, config.BlinkWalletID, config.BlinkWebhookSecret, invoiceStore)
+}
+
+// GetProviderName returns the provider name
+func (p *BlinkProvider) GetProviderName() string {
+	return "blink"
+}
+
+// Blink GraphQL structures
+type BlinkGraphQLRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables,omitempty"`
+}
+
+type BlinkGraphQLError struct {
+	Message string `json:"message"`
+}
+
+type BlinkInvoiceCreateResponse struct {
+	Data struct {
+		LnInvoiceCreate struct {
+			Invoice struct {
+				PaymentRequest string `json:"paymentRequest"`
+				PaymentHash    string `json:"paymentHash"`
+				Satoshis       int64  `json:"satoshis"`
+			} `json:"invoice"`
+			Errors []BlinkGraphQLError `json:"errors"`
+		} `json:"lnInvoiceCreate"`
+	} `json:"data"`
+}
+
+type BlinkPaymentStatusResponse struct {
+	Data struct {
+		LnInvoicePaymentStatusByHash struct {
+			Status string `json:"status"` // "PENDING", "PAID" or "EXPIRED"
+		} `json:"lnInvoicePaymentStatusByHash"`
+	} `json:"data"`
+}
+
+type BlinkWalletsResponse struct {
+	Data struct {
+		Me struct {
+			DefaultAccount struct {
+				Wallets []struct {
+					ID             string `json:"id"`
+					WalletCurrency string `json:"walletCurrency"`
+				} `json:"wallets"`
+			} `json:"defaultAccount"`
+		} `json:"me"`
+	} `json:"data"`
+}
+
+// BlinkWebhookPayload is the part of a Blink webhook delivery the provider uses
+type BlinkWebhookPayload struct {
+	EventType   string `json:"eventType"`
+	Transaction struct {
+		Status        string `json:"status"`
+		InitiationVia struct {
+			PaymentHash string `json:"paymentHash"`
+		} `json:"initiationVia"`
+	} `json:"transaction"`
+}
+
+const blinkInvoiceCreateMutation = `mutation LnInvoiceCreate($input: LnInvoiceCreateInput!) {
+  lnInvoiceCreate(input: $input) {
+    invoice { paymentRequest paymentHash satoshis }
+    errors { message }
+  }
+}`
+
+const blinkPaymentStatusQuery = `query LnInvoicePaymentStatusByHash($input: LnInvoicePaymentStatusByHashInput!) {
+  lnInvoicePaymentStatusByHash(input: $input) { status }
+}`
+
+const blinkWalletsQuery = `query Me { me { defaultAccount { wallets { id walletCurrency } } } }`
+
+// CreateInvoice creates a Lightning invoice on the BTC wallet
+func (p *BlinkProvider) CreateInvoice(ctx context.Context, amount int64, description string, pubkey string) (*Invoice, error) {
+	walletID, err := p.btcWalletID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Blink invoices are denominated in satoshis
+	amountSat := amount / 1000
+	if amountSat == 0 {
+		amountSat = 1 // minimum 1 sat
+	}
+
+	var resp BlinkInvoiceCreateResponse
+	err = p.query(ctx, OpCreateInvoice, blinkInvoiceCreateMutation, map[string]interface{}{
+		"input": map[string]interface{}{
+			"walletId":  walletID,
+			"amount":    amountSat,
+			"memo":      description,
+			"expiresIn": int64(defaultInvoiceExpiry / time.Minute),
+		},
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	result := resp.Data.LnInvoiceCreate
+	if len(result.Errors) > 0 {
+		return nil, &ProviderError{
+			Provider: p.GetProviderName(),
+			Op:       OpCreateInvoice,
+			Err:      fmt.Errorf("API error: %s", result.Errors[0].Message),
+		}
+	}
+	paymentHash := result.Invoice.PaymentHash
+
+	p.mu.Lock()
+	p.pubkeyMap.Set(paymentHash, pubkey)
+	p.mu.Unlock()
+
+	if p.invoiceStore != nil {
+		p.invoiceStore.RecordCharge(paymentHash, paymentHash, amountSat*1000)
+	}
+
+	return &Invoice{
+		PaymentRequest: result.Invoice.PaymentRequest,
+		PaymentHash:    paymentHash,
+		Amount:         amountSat * 1000,
+		Description:    description,
+		ExpiresAt:      time.Now().Add(defaultInvoiceExpiry),
+	}, nil
+}
+
+// VerifyPayment checks the payment status of an invoice
+func (p *BlinkProvider) VerifyPayment(ctx context.Context, paymentHash string) (*PaymentVerification, error) {
+	var resp BlinkPaymentStatusResponse
+	err := p.query(ctx, OpVerifyPayment, blinkPaymentStatusQuery, map[string]interface{}{
+		"input": map[string]interface{}{"paymentHash": paymentHash},
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	verification := &PaymentVerification{
+		Paid:        resp.Data.LnInvoicePaymentStatusByHash.Status == "PAID",
+		PaymentHash: paymentHash,
+	}
+	if verification.Paid {
+		verification.PaidAt = time.Now()
+		if p.invoiceStore != nil {
+			if stored, found := p.invoiceStore.Get(paymentHash); found {
+				verification.Amount = stored.Amount
+			}
+		}
+	}
+	return verification, nil
+}
+
+// CheckExistingPayments checks for any existing payments for a pubkey and returns verification if paid
+func (p *BlinkProvider) CheckExistingPayments(ctx context.Context, pubkey string) (*PaymentVerification, error) {
+	p.mu.RLock()
+	paymentHashes := pendingHashesFor(p.pubkeyMap, p.invoiceStore, p.GetProviderName(), pubkey)
+	p.mu.RUnlock()
+
+	for _, paymentHash := range paymentHashes {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		verification, err := p.VerifyPayment(ctx, paymentHash)
+		if err == nil && verification.Paid {
+			p.logInfo("💰 Found paid Blink invoice! Payment hash: %s", paymentHash)
+			return verification, nil
+		}
+	}
+
+	return nil, nil // No paid payments found
+}
+
+// HandleWebhook checks the signature of a Blink webhook delivery and returns the payment
+// hash of a received Lightning payment, empty for other events
+func (p *BlinkProvider) HandleWebhook(header http.Header, body []byte) (string, error) {
+	if len(p.webhookSecret) == 0 {
+		return "", fmt.Errorf("Blink webhook secret not configured")
+	}
+	if err := p.verifyWebhookSignature(header, body); err != nil {
+		return "", err
+	}
+
+	var payload BlinkWebhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return "", fmt.Errorf("failed to parse webhook: %w", err)
+	}
+	if payload.EventType != "receive.lightning" || payload.Transaction.Status != "success" {
+		return "", nil
+	}
+	return payload.Transaction.InitiationVia.PaymentHash, nil
+}
+
+// verifyWebhookSignature checks the Svix signature Blink signs webhook deliveries with
+func (p *BlinkProvider) verifyWebhookSignature(header http.Header, body []byte) error {
+	id := header.Get("svix-id")
+	timestamp := header.Get("svix-timestamp")
+	signatures := header.Get("svix-signature")
+	if id == "" || timestamp == "" || signatures == "" {
+		return fmt.Errorf("missing webhook signature headers")
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid webhook timestamp")
+	}
+	if age := time.Since(time.Unix(seconds, 0)); age > blinkWebhookTolerance || age < -blinkWebhookTolerance {
+		return fmt.Errorf("webhook timestamp outside tolerance")
+	}
+
+	mac := hmac.New(sha256.New, p.webhookSecret)
+	mac.Write([]byte(id + "." + timestamp + "."))
+	mac.Write(body)
+	expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	// The header lists space separated "v1,<signature>" entries, one per active secret
+	for _, signature := range strings.Fields(signatures) {
+		version, value, found := strings.Cut(signature, ",")
+		if found && version == "v1" && hmac.Equal([]byte(value), []byte(expected)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid webhook signature")
+}
+
+// btcWalletID returns the configured wallet, or looks up the account's BTC wallet once
+func (p *BlinkProvider) btcWalletID(ctx context.Context) (string, error) {
+	p.mu.RLock()
+	walletID := p.walletID
+	p.mu.RUnlock()
+	if walletID != "" {
+		return walletID, nil
+	}
+
+	var resp BlinkWalletsResponse
+	if err := p.query(ctx, OpCreateInvoice, blinkWalletsQuery, nil, &resp); err != nil {
+		return "", err
+	}
+	for _, wallet := range resp.Data.Me.DefaultAccount.Wallets {
+		if wallet.WalletCurrency == "BTC" {
+			p.mu.Lock()
+			p.walletID = wallet.ID
+			p.mu.Unlock()
+			return wallet.ID, nil
+		}
+	}
+	return "", fmt.Errorf("no BTC wallet found on the Blink account")
+}
+
+// query sends a GraphQL request to Blink and decodes the response into result
+func (p *BlinkProvider) query(ctx context.Context, op, query string, variables map[string]interface{}, result interface{}) error {
+	payload, err := json.Marshal(BlinkGraphQLRequest{Query: query, Variables: variables})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.apiURL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-KEY", p.apiKey)
+
+	resp, err := p.httpClient().Do(req)
+	if err != nil {
+		return newRequestError(p.GetProviderName(), op, fmt.Errorf("failed to make request: %w", err))
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return newRequestError(p.GetProviderName(), op, fmt.Errorf("failed to read response: %w", err))
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return newStatusError(p.GetProviderName(), op, resp.StatusCode, body)
+	}
+
+	// GraphQL reports request level failures next to the data with a 200 status
+	var envelope struct {
+		Errors []BlinkGraphQLError `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &envelope); err == nil && len(envelope.Errors) > 0 {
+		return &ProviderError{
+			Provider: p.GetProviderName(),
+			Op:       op,
+			Err:      fmt.Errorf("API error: %s", envelope.Errors[0].Message),
+		}
+	}
+
+	if err := json.Unmarshal(body, result); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultCircuitBreaker opens the circuit after 5 transient failures in a row for 30s
+const defaultCircuitBreaker = "5/30s"
+
+// circuitBreaker stops calls to a provider after failures consecutive transient failures,
+// until cooldown passed. Then a single trial call is let through: success closes the
+// circuit, failure opens it for another cooldown. A nil breaker lets every call through.
+type circuitBreaker struct {
+	failures int
+	cooldown time.Duration
+
+	mutex     sync.Mutex
+	failed    int       // consecutive transient failures
+	openUntil time.Time // zero while closed
+	trial     bool      // a trial call is running
+
+	logs *logTarget // the System's
+}
+
+// parseCircuitBreaker parses "failures/cooldown" such as "5/30s", nil for "0"
+func parseCircuitBreaker(value string) (*circuitBreaker, error) {
+	if strings.TrimSpace(value) == "0" {
+		return nil, nil
+	}
+	failuresStr, cooldownStr, found := strings.Cut(value, "/")
+	if !found {
+		return nil, fmt.Errorf("invalid circuit breaker %q, expected failures/cooldown such as 5/30s", value)
+	}
+	failures, err := strconv.Atoi(strings.TrimSpace(failuresStr))
+	if err != nil || failures <= 0 {
+		return nil, fmt.Errorf("invalid circuit breaker %q: failures must be a positive number", value)
+	}
+	cooldown, err := time.ParseDuration(strings.TrimSpace(cooldownStr))
+	if err != nil || cooldown <= 0 {
+		return nil, fmt.Errorf("invalid circuit breaker %q: invalid cooldown", value)
+	}
+	return &circuitBreaker{failures: failures, cooldown: cooldown}, nil
+}
+
+// allow reports whether a call may go to the provider, letting one trial call through
+// once the cooldown has passed
+func (b *circuitBreaker) allow(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.openUntil.IsZero() {
+		return true
+	}
+	if now.Before(b.openUntil) || b.trial {
+		return false
+	}
+	b.trial = true
+	return true
+}
+
+// record counts the outcome of a call let through by allow. Only transient failures count,
+// a rejected request says nothing about the provider's health.
+func (b *circuitBreaker) record(err error, now time.Time) {
+	if b == nil {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	trial := b.trial
+	b.trial = false
+	if errors.Is(err, context.Canceled) {
+		return // the caller gave up, the provider may be fine
+	}
+	if !IsTransient(err) {
+		if !b.openUntil.IsZero() {
+			b.logs.logInfo("🟢 Payment backend recovered, circuit closed")
+		}
+		b.failed = 0
+		b.openUntil = time.Time{}
+		return
+	}
+
+	b.failed++
+	if trial || b.failed >= b.failures {
+		if b.openUntil.IsZero() {
+			b.logs.logWarn("🔴 Payment backend failed %d times in a row, pausing calls for %v: %v", b.failed, b.cooldown, err)
+		}
+		b.openUntil = now.Add(b.cooldown)
+	}
+}
+
+// reset closes the circuit, e.g. when the provider was replaced
+func (b *circuitBreaker) reset() {
+	if b == nil {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.failed = 0
+	b.openUntil = time.Time{}
+	b.trial = false
+}
+
+// pausedUntil returns when the open circuit lets a trial call through, zero while closed
+func (b *circuitBreaker) pausedUntil() time.Time {
+	if b == nil {
+		return time.Time{}
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.openUntil
+}
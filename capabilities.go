@@ -0,0 +1,188 @@
+package payments
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Capability is something a membership allows, granted by the member's tier
+type Capability string
+
+// Capabilities a tier can grant
+const (
+	CapabilityWrite       Capability = "write"       // publish events
+	CapabilityRead        Capability = "read"        // query events, enforced with Attach's WithPaidReads
+	CapabilitySearch      Capability = "search"      // NIP-50 search queries, enforced with Attach's WithPaidReads
+	CapabilityRebroadcast Capability = "rebroadcast" // publish events signed by others while authenticated
+	CapabilityMedia       Capability = "media"       // media uploads, enforced by the relay or RequireCapability
+)
+
+// AllCapabilities is what tiers without explicit capabilities grant
+var AllCapabilities = []Capability{CapabilityWrite, CapabilityRead, CapabilitySearch, CapabilityRebroadcast, CapabilityMedia}
+
+// Reject messages for pubkeys lacking a capability
+const (
+	capabilityRejectMessage = "restricted: your membership doesn't include %s"
+	readAuthRejectMessage   = "authenticate to read from this relay"
+)
+
+// Machine-readable prefixes of queries closed with a PaymentRequest, as NIP-01 expects
+const (
+	readAuthRejectPrefix    = "auth-required: "
+	readPaymentRejectPrefix = "restricted: "
+)
+
+// parseCapabilities parses capability names separated by sep
+func parseCapabilities(value, sep string) []Capability {
+	var capabilities []Capability
+	for _, name := range strings.Split(value, sep) {
+		if name = strings.TrimSpace(name); name != "" {
+			capabilities = append(capabilities, Capability(name))
+		}
+	}
+	return capabilities
+}
+
+// validateCapabilities checks that only known capabilities are used
+func validateCapabilities(capabilities []Capability) error {
+	for _, capability := range capabilities {
+		if !slices.Contains(AllCapabilities, capability) {
+			return fmt.Errorf("unknown capability: %s", capability)
+		}
+	}
+	return nil
+}
+
+// Capabilities returns what a pubkey may do: nothing if it is denylisted, everything if it
+// is allowlisted, else the free capabilities plus those of its tier while its membership is
+// valid. Memberships whose tier is no longer configured, or that didn't come from a tier,
+// keep all capabilities.
+func (s *System) Capabilities(pubkey string) []Capability {
+	if s.IsDenylisted(pubkey) {
+		return []Capability{}
+	}
+	if s.IsAllowlisted(pubkey) {
+		return append([]Capability(nil), AllCapabilities...)
+	}
+
+	capabilities := append([]Capability(nil), s.config.FreeCapabilities...)
+	if !s.paidAccessStorage.HasAccess(pubkey) {
+		return capabilities
+	}
+
+	granted := AllCapabilities
+	if member, exists := s.paidAccessStorage.GetMember(pubkey); exists {
+		for _, tier := range s.config.Tiers {
+			if tier.Name == member.Tier && len(tier.Capabilities) > 0 {
+				granted = tier.Capabilities
+				break
+			}
+		}
+	}
+	for _, capability := range granted {
+		if !slices.Contains(capabilities, capability) {
+			capabilities = append(capabilities, capability)
+		}
+	}
+	return capabilities
+}
+
+// HasAccess checks if a pubkey has valid paid access, or, given capabilities, whether
+// it may use all of them. Free capabilities are available to everyone.
+func (s *System) HasAccess(pubkey string, capabilities ...Capability) bool {
+	if len(capabilities) == 0 {
+		if s.IsDenylisted(pubkey) {
+			return false
+		}
+		return s.IsAllowlisted(pubkey) || s.paidAccessStorage.HasAccess(pubkey)
+	}
+
+	allowed := s.Capabilities(pubkey)
+	for _, capability := range capabilities {
+		if !slices.Contains(allowed, capability) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsFree reports whether a capability is available without a membership
+func (s *System) IsFree(capability Capability) bool {
+	return slices.Contains(s.config.FreeCapabilities, capability)
+}
+
+// RequireCapability returns a rejection stage that rejects events of the given kinds,
+// or of any kind if none are given, from pubkeys lacking capability. Add it before the
+// default stages with Use, e.g. to restrict NIP-94 file metadata to media members:
+//
+//	system.Use(system.RequireCapability(payments.CapabilityMedia, 1063))
+func (s *System) RequireCapability(capability Capability, kinds ...int) RejectMiddleware {
+	return func(next RejectFunc) RejectFunc {
+		return func(ctx context.Context, event *nostr.Event) (bool, string) {
+			if len(kinds) > 0 && !slices.Contains(kinds, event.Kind) {
+				return next(ctx, event)
+			}
+			if !s.HasAccess(event.PubKey, capability) {
+				s.with(pubkeyAttr(event.PubKey)).logInfo("🚫 Rejecting kind %d event from %s...: no %s capability", event.Kind, event.PubKey[:16], capability)
+				return true, fmt.Sprintf(capabilityRejectMessage, capability)
+			}
+			return next(ctx, event)
+		}
+	}
+}
+
+// RejectFilterHandler checks a query from the authenticated pubkey, empty if the client
+// didn't authenticate: reading needs CapabilityRead and NIP-50 searches CapabilitySearch.
+// Clients that didn't authenticate, and authenticated pubkeys without a membership, are
+// closed with a PaymentRequest in JSON after the "auth-required: " or "restricted: "
+// prefix, the latter with a fresh invoice for the pubkey.
+func (s *System) RejectFilterHandler(ctx context.Context, pubkey string, filter nostr.Filter) (bool, string) {
+	needed := []Capability{CapabilityRead}
+	if filter.Search != "" {
+		needed = append(needed, CapabilitySearch)
+	}
+
+	var missing []Capability
+	for _, capability := range needed {
+		if !s.IsFree(capability) {
+			missing = append(missing, capability)
+		}
+	}
+	if len(missing) == 0 {
+		return false, ""
+	}
+	if pubkey == "" {
+		amount, _, _ := s.pricer.Price(ctx, nil, nil)
+		return true, readAuthRejectPrefix + s.encodePaymentRequest(PaymentRequest{
+			Message:    readAuthRejectMessage,
+			Amount:     amount,
+			PaymentURL: s.PaymentPageURL(""),
+		})
+	}
+
+	// Members whose tier lacks a capability need to upgrade, not pay again
+	if s.HasAccess(pubkey) {
+		for _, capability := range missing {
+			if !s.HasAccess(pubkey, capability) {
+				return true, fmt.Sprintf(capabilityRejectMessage, capability)
+			}
+		}
+		return false, ""
+	}
+	// One provider time budget for checking paid invoices and creating one
+	ctx, cancel := context.WithTimeout(ctx, s.invoiceTimeout)
+	defer cancel()
+	if s.claimPaidInvoice(ctx, pubkey) && s.HasAccess(pubkey, missing...) {
+		return false, ""
+	}
+
+	reject, message := s.requirePayment(ctx, pubkey, nil)
+	if reject && strings.HasPrefix(message, "{") {
+		message = readPaymentRejectPrefix + message
+	}
+	return reject, message
+}
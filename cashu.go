@@ -0,0 +1,267 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Cashu melt quote states (NUT-05)
+const (
+	cashuMeltPaid    = "PAID"
+	cashuMeltPending = "PENDING"
+)
+
+// cashuMintTimeout bounds each call to a mint, melting waits for a Lightning payment
+const cashuMintTimeout = 60 * time.Second
+
+// CashuEnabled reports whether Cashu tokens are accepted as payment
+func (s *System) CashuEnabled() bool {
+	return len(s.config.CashuMints) > 0
+}
+
+// CashuPayment is the outcome of paying for access with a Cashu token
+type CashuPayment struct {
+	Tier        string `json:"tier"`
+	PaymentHash string `json:"payment_hash"`
+	Amount      int64  `json:"amount"`      // msat received by the relay
+	FeeReserve  int64  `json:"fee_reserve"` // msat reserved by the mint for Lightning fees
+	Paid        bool   `json:"paid"`        // false while the mint's payment is still in flight
+}
+
+// PayWithCashu redeems a Cashu token for access: the relay creates an invoice for the tier,
+// and the token's trusted mint melts the token to pay it. Access is granted once the
+// invoice is verified as paid. An empty tier picks the best tier the token can afford.
+// The token should be close to the price, as mints don't return change without blinded
+// outputs and anything above the price and fee reserve stays with the mint.
+func (s *System) PayWithCashu(ctx context.Context, pubkey, encodedToken, tierName string) (*CashuPayment, error) {
+	if !s.CashuEnabled() {
+		return nil, fmt.Errorf("Cashu payments are not enabled")
+	}
+	token, err := decodeCashuToken(encodedToken)
+	if err != nil {
+		return nil, err
+	}
+	mint, trusted := s.trustedCashuMint(token.Mint)
+	if !trusted {
+		return nil, fmt.Errorf("mint %s is not trusted", token.Mint)
+	}
+	if token.Unit != "sat" {
+		return nil, fmt.Errorf("unsupported token unit %q, only sat is accepted", token.Unit)
+	}
+	value := token.Amount() * 1000
+
+	tier, err := s.tierForPayment(tierName, value)
+	if err != nil {
+		return nil, err
+	}
+
+	invoice, err := s.createInvoice(ctx, pubkey, tier.Amount, accessDurationFor(tier.Duration), tier.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create invoice: %w", err)
+	}
+	s.invoices.MarkSeen(invoice.PaymentHash)
+
+	client := &cashuMintClient{url: mint, client: withMinTimeout(s.httpClient, cashuMintTimeout)}
+	quote, err := client.meltQuote(ctx, invoice.PaymentRequest)
+	if err != nil {
+		return nil, err
+	}
+	if need := (quote.Amount + quote.FeeReserve) * 1000; value < need {
+		return nil, fmt.Errorf("token worth %d sats does not cover the price plus the mint's fee reserve of %d sats", value/1000, quote.FeeReserve)
+	}
+
+	state, err := client.melt(ctx, quote.Quote, token.Proofs)
+	if err != nil {
+		return nil, err
+	}
+
+	payment := &CashuPayment{
+		Tier:        tier.Name,
+		PaymentHash: invoice.PaymentHash,
+		Amount:      invoice.Amount,
+		FeeReserve:  quote.FeeReserve * 1000,
+	}
+	if state != cashuMeltPaid {
+		// The invoice is tracked, reconciliation grants access once the payment lands
+		s.with(pubkeyAttr(pubkey)).logInfo("⏳ Cashu melt for %s... is %s, waiting for the payment", pubkey[:16], state)
+		return payment, nil
+	}
+
+	verification, err := s.provider.VerifyPayment(ctx, invoice.PaymentHash)
+	if err != nil || !verification.Paid {
+		s.logWarn("⚠️ Cashu melt reported paid but the invoice is not settled yet: %v", err)
+		return payment, nil
+	}
+	if err := s.grantPaidAccess(ctx, pubkey, verification, SourceCashu); err != nil {
+		return nil, fmt.Errorf("failed to grant access: %w", err)
+	}
+	payment.Paid = true
+	s.with(pubkeyAttr(pubkey)).logInfo("🥜 Cashu payment from %s accepted, access granted for %s... (%s tier)", mint, pubkey[:16], tier.Name)
+	return payment, nil
+}
+
+// trustedCashuMint returns the configured mint URL matching a token's mint
+func (s *System) trustedCashuMint(mint string) (string, bool) {
+	mint = strings.TrimRight(strings.TrimSpace(mint), "/")
+	for _, trusted := range s.config.CashuMints {
+		if strings.EqualFold(strings.TrimRight(trusted, "/"), mint) {
+			return strings.TrimRight(trusted, "/"), true
+		}
+	}
+	return "", false
+}
+
+// cashuPayHandler grants access for a Cashu token
+func (s *System) cashuPayHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Pubkey string `json:"pubkey"`
+		Token  string `json:"token"`
+		Tier   string `json:"tier"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	pubkey, err := parsePubkey(req.Pubkey)
+	if err != nil {
+		http.Error(w, "valid pubkey is required", http.StatusBadRequest)
+		return
+	}
+	if req.Token == "" {
+		http.Error(w, "token is required", http.StatusBadRequest)
+		return
+	}
+
+	payment, err := s.PayWithCashu(r.Context(), pubkey, req.Token, req.Tier)
+	if err != nil {
+		s.logError("❌ Cashu payment failed: %v", err)
+		switch {
+		case errors.Is(err, errRateLimited):
+			writeRateLimited(w)
+		case IsTransient(err):
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, "Mint or payment provider temporarily unavailable", http.StatusServiceUnavailable)
+		case IsPermanent(err):
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		default:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	status := http.StatusOK
+	if !payment.Paid {
+		status = http.StatusAccepted
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"paid":           payment.Paid,
+		"access_granted": payment.Paid,
+		"tier":           payment.Tier,
+		"payment_hash":   payment.PaymentHash,
+		"amount":         payment.Amount,
+		"fee_reserve":    payment.FeeReserve,
+	})
+}
+
+// cashuMintClient talks to a mint's NUT-05 melt API
+type cashuMintClient struct {
+	url    string
+	client *http.Client
+}
+
+// cashuMeltQuote is a mint's offer to pay a Lightning invoice
+type cashuMeltQuote struct {
+	Quote      string `json:"quote"`
+	Amount     int64  `json:"amount"`      // sats
+	FeeReserve int64  `json:"fee_reserve"` // sats
+	State      string `json:"state"`
+}
+
+// meltQuote asks the mint what paying an invoice costs
+func (c *cashuMintClient) meltQuote(ctx context.Context, paymentRequest string) (*cashuMeltQuote, error) {
+	var quote cashuMeltQuote
+	err := c.post(ctx, OpRedeemEcash, "/v1/melt/quote/bolt11", map[string]interface{}{
+		"request": paymentRequest,
+		"unit":    "sat",
+	}, &quote)
+	if err != nil {
+		return nil, err
+	}
+	if quote.Quote == "" {
+		return nil, fmt.Errorf("mint returned no melt quote")
+	}
+	return &quote, nil
+}
+
+// melt spends proofs to pay a quoted invoice and returns the quote state
+func (c *cashuMintClient) melt(ctx context.Context, quote string, proofs []cashuProof) (string, error) {
+	var result struct {
+		State string `json:"state"`
+		Paid  bool   `json:"paid"` // mints predating quote states
+	}
+	err := c.post(ctx, OpRedeemEcash, "/v1/melt/bolt11", map[string]interface{}{
+		"quote":  quote,
+		"inputs": proofs,
+	}, &result)
+	if err != nil {
+		return "", err
+	}
+	if result.State == "" {
+		result.State = cashuMeltPending
+		if result.Paid {
+			result.State = cashuMeltPaid
+		}
+	}
+	return result.State, nil
+}
+
+// post sends a JSON request to the mint. Mint errors such as spent proofs come back as
+// status 400 with a detail message.
+func (c *cashuMintClient) post(ctx context.Context, op, path string, payload, result interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.url+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return newRequestError("cashu", op, fmt.Errorf("failed to reach mint: %w", err))
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return newRequestError("cashu", op, fmt.Errorf("failed to read mint response: %w", err))
+	}
+
+	if resp.StatusCode == http.StatusBadRequest {
+		var mintErr struct {
+			Detail string `json:"detail"`
+			Code   int    `json:"code"`
+		}
+		if json.Unmarshal(respBody, &mintErr) == nil && mintErr.Detail != "" {
+			return fmt.Errorf("mint rejected the token: %s", mintErr.Detail)
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return newStatusError("cashu", op, resp.StatusCode, respBody)
+	}
+	if err := json.Unmarshal(respBody, result); err != nil {
+		return fmt.Errorf("failed to unmarshal mint response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,296 @@
+package payments
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Cashu token serialization prefixes (NUT-00)
+const (
+	cashuTokenV3Prefix = "cashuA" // base64url JSON
+	cashuTokenV4Prefix = "cashuB" // base64url CBOR
+)
+
+// maxCBORDepth bounds nesting of decoded CBOR, a V4 token needs 4 levels
+const maxCBORDepth = 8
+
+// cashuProof is an ecash note, as sent to the mint to spend it
+type cashuProof struct {
+	Amount  int64  `json:"amount"`
+	ID      string `json:"id"`
+	Secret  string `json:"secret"`
+	C       string `json:"C"`
+	Witness string `json:"witness,omitempty"`
+}
+
+// cashuToken is a decoded token holding proofs from a single mint
+type cashuToken struct {
+	Mint   string
+	Unit   string
+	Memo   string
+	Proofs []cashuProof
+}
+
+// Amount returns the total value of the token's proofs, in the token's unit
+func (t *cashuToken) Amount() int64 {
+	var total int64
+	for _, proof := range t.Proofs {
+		total += proof.Amount
+	}
+	return total
+}
+
+// decodeCashuToken decodes a cashuA (V3) or cashuB (V4) token
+func decodeCashuToken(token string) (*cashuToken, error) {
+	token = strings.TrimPrefix(strings.TrimSpace(token), "cashu:")
+
+	var decoded *cashuToken
+	var err error
+	switch {
+	case strings.HasPrefix(token, cashuTokenV3Prefix):
+		decoded, err = decodeCashuTokenV3(strings.TrimPrefix(token, cashuTokenV3Prefix))
+	case strings.HasPrefix(token, cashuTokenV4Prefix):
+		decoded, err = decodeCashuTokenV4(strings.TrimPrefix(token, cashuTokenV4Prefix))
+	default:
+		return nil, fmt.Errorf("unsupported token format, expected cashuA or cashuB")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid Cashu token: %w", err)
+	}
+
+	if decoded.Unit == "" {
+		decoded.Unit = "sat"
+	}
+	if len(decoded.Proofs) == 0 {
+		return nil, fmt.Errorf("invalid Cashu token: no proofs")
+	}
+	for _, proof := range decoded.Proofs {
+		if proof.Amount <= 0 || proof.Secret == "" || proof.C == "" || proof.ID == "" {
+			return nil, fmt.Errorf("invalid Cashu token: malformed proof")
+		}
+	}
+	return decoded, nil
+}
+
+// decodeCashuBase64 decodes base64url with or without padding, tolerating the standard alphabet
+func decodeCashuBase64(data string) ([]byte, error) {
+	data = strings.TrimRight(data, "=")
+	data = strings.NewReplacer("+", "-", "/", "_").Replace(data)
+	return base64.RawURLEncoding.DecodeString(data)
+}
+
+// decodeCashuTokenV3 decodes the JSON serialization
+func decodeCashuTokenV3(data string) (*cashuToken, error) {
+	raw, err := decodeCashuBase64(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var v3 struct {
+		Token []struct {
+			Mint   string       `json:"mint"`
+			Proofs []cashuProof `json:"proofs"`
+		} `json:"token"`
+		Unit string `json:"unit"`
+		Memo string `json:"memo"`
+	}
+	if err := json.Unmarshal(raw, &v3); err != nil {
+		return nil, err
+	}
+
+	token := &cashuToken{Unit: v3.Unit, Memo: v3.Memo}
+	for _, entry := range v3.Token {
+		if token.Mint != "" && entry.Mint != token.Mint {
+			return nil, fmt.Errorf("tokens from several mints are not supported")
+		}
+		token.Mint = entry.Mint
+		token.Proofs = append(token.Proofs, entry.Proofs...)
+	}
+	return token, nil
+}
+
+// decodeCashuTokenV4 decodes the CBOR serialization:
+// {"m": mint, "u": unit, "d": memo, "t": [{"i": keyset id, "p": [{"a", "s", "c", "w"}]}]}
+func decodeCashuTokenV4(data string) (*cashuToken, error) {
+	raw, err := decodeCashuBase64(data)
+	if err != nil {
+		return nil, err
+	}
+	decoder := &cborDecoder{data: raw}
+	value, err := decoder.decode(0)
+	if err != nil {
+		return nil, err
+	}
+
+	root, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("token is not a map")
+	}
+	token := &cashuToken{}
+	token.Mint, _ = root["m"].(string)
+	token.Unit, _ = root["u"].(string)
+	token.Memo, _ = root["d"].(string)
+
+	entries, _ := root["t"].([]interface{})
+	for _, rawEntry := range entries {
+		entry, ok := rawEntry.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("malformed token entry")
+		}
+		keysetID, _ := entry["i"].([]byte)
+		proofs, _ := entry["p"].([]interface{})
+		for _, rawProof := range proofs {
+			fields, ok := rawProof.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("malformed proof")
+			}
+			amount, _ := fields["a"].(int64)
+			secret, _ := fields["s"].(string)
+			signature, _ := fields["c"].([]byte)
+			witness, _ := fields["w"].(string)
+			token.Proofs = append(token.Proofs, cashuProof{
+				Amount:  amount,
+				ID:      hex.EncodeToString(keysetID),
+				Secret:  secret,
+				C:       hex.EncodeToString(signature),
+				Witness: witness,
+			})
+		}
+	}
+	return token, nil
+}
+
+// cborDecoder decodes the subset of CBOR (RFC 8949) Cashu tokens use: definite length
+// integers, byte and text strings, arrays, maps with text keys and simple values
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *cborDecoder) decode(depth int) (interface{}, error) {
+	if depth > maxCBORDepth {
+		return nil, fmt.Errorf("CBOR nested too deeply")
+	}
+	if d.pos >= len(d.data) {
+		return nil, fmt.Errorf("unexpected end of CBOR data")
+	}
+	initial := d.data[d.pos]
+	d.pos++
+	major, info := initial>>5, initial&0x1f
+
+	// Simple values and floats carry no length
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22, 23:
+			return nil, nil
+		case 25, 26, 27:
+			size := 1 << (info - 24)
+			if d.pos+size > len(d.data) {
+				return nil, fmt.Errorf("unexpected end of CBOR data")
+			}
+			d.pos += size
+			return nil, nil // floats don't appear in tokens
+		}
+		return nil, fmt.Errorf("unsupported CBOR simple value %d", info)
+	}
+
+	argument, err := d.argument(info)
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case 0:
+		if argument > math.MaxInt64 {
+			return nil, fmt.Errorf("CBOR integer overflow")
+		}
+		return int64(argument), nil
+	case 1:
+		if argument > math.MaxInt64 {
+			return nil, fmt.Errorf("CBOR integer overflow")
+		}
+		return -1 - int64(argument), nil
+	case 2, 3:
+		if argument > uint64(len(d.data)-d.pos) {
+			return nil, fmt.Errorf("unexpected end of CBOR data")
+		}
+		bytes := d.data[d.pos : d.pos+int(argument)]
+		d.pos += int(argument)
+		if major == 3 {
+			return string(bytes), nil
+		}
+		return bytes, nil
+	case 4:
+		if argument > uint64(len(d.data)-d.pos) {
+			return nil, fmt.Errorf("CBOR array too long")
+		}
+		items := make([]interface{}, 0, argument)
+		for i := uint64(0); i < argument; i++ {
+			item, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case 5:
+		if argument > uint64(len(d.data)-d.pos) {
+			return nil, fmt.Errorf("CBOR map too long")
+		}
+		entries := make(map[string]interface{}, argument)
+		for i := uint64(0); i < argument; i++ {
+			key, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("unsupported CBOR map key")
+			}
+			if entries[name], err = d.decode(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return entries, nil
+	case 6:
+		// Tags only annotate the value that follows
+		return d.decode(depth + 1)
+	}
+	return nil, fmt.Errorf("unsupported CBOR major type %d", major)
+}
+
+// argument reads the length or value following an initial byte
+func (d *cborDecoder) argument(info byte) (uint64, error) {
+	if info < 24 {
+		return uint64(info), nil
+	}
+	if info > 27 {
+		return 0, fmt.Errorf("indefinite length CBOR is not supported")
+	}
+	size := 1 << (info - 24)
+	if d.pos+size > len(d.data) {
+		return 0, fmt.Errorf("unexpected end of CBOR data")
+	}
+	var value uint64
+	switch size {
+	case 1:
+		value = uint64(d.data[d.pos])
+	case 2:
+		value = uint64(binary.BigEndian.Uint16(d.data[d.pos:]))
+	case 4:
+		value = uint64(binary.BigEndian.Uint32(d.data[d.pos:]))
+	case 8:
+		value = binary.BigEndian.Uint64(d.data[d.pos:])
+	}
+	d.pos += size
+	return value, nil
+}
@@ -0,0 +1,140 @@
+package payments
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ChargeMappingStorage manages persistent storage of payment hash to charge ID mappings
+//
+// Deprecated: invoices, their charge ids included, are kept in the InvoiceStore, which
+// imports charge mapping files on first start. It remains for the deprecated provider
+// constructors taking it.
+type ChargeMappingStorage struct {
+	Mappings map[string]string `json:"mappings"`
+	mutex    sync.RWMutex
+	filePath string
+	invoices *InvoiceStore // handed to the deprecated provider constructors, nil until asked for
+}
+
+// NewChargeMappingStorage creates a new charge mapping storage
+//
+// Deprecated: use NewInvoiceStore.
+func NewChargeMappingStorage(filePath string) *ChargeMappingStorage {
+	storage := &ChargeMappingStorage{
+		Mappings: make(map[string]string),
+		filePath: filePath,
+	}
+
+	// Ensure directory exists
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		logWarn("⚠️ Failed to create directory for charge mapping file: %v", err)
+	}
+
+	storage.load()
+	return storage
+}
+
+// load reads charge mappings from file
+func (cms *ChargeMappingStorage) load() error {
+	cms.mutex.Lock()
+	defer cms.mutex.Unlock()
+
+	data, err := os.ReadFile(cms.filePath)
+	if os.IsNotExist(err) {
+		return nil // File doesn't exist, start with empty mappings
+	}
+	if err != nil {
+		logWarn("⚠️ Failed to read charge mappings file: %v", err)
+		return err
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(data, cms)
+}
+
+// save writes charge mappings to file
+func (cms *ChargeMappingStorage) save() error {
+	data, err := json.MarshalIndent(cms, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(cms.filePath, data, 0644)
+}
+
+// Store saves a payment hash to charge ID mapping
+func (cms *ChargeMappingStorage) Store(paymentHash, chargeID string) error {
+	cms.mutex.Lock()
+	defer cms.mutex.Unlock()
+
+	cms.Mappings[paymentHash] = chargeID
+	if cms.invoices != nil {
+		cms.invoices.importMappings(map[string]string{paymentHash: chargeID})
+	}
+
+	if err := cms.save(); err != nil {
+		logWarn("⚠️ Failed to save charge mapping: %v", err)
+		return err
+	}
+
+	logDebug("💾 Stored charge mapping: %.16s... → %s", paymentHash, chargeID)
+	return nil
+}
+
+// Get retrieves a charge ID by payment hash
+func (cms *ChargeMappingStorage) Get(paymentHash string) (string, bool) {
+	cms.mutex.RLock()
+	defer cms.mutex.RUnlock()
+
+	chargeID, exists := cms.Mappings[paymentHash]
+	return chargeID, exists
+}
+
+// Cleanup runs the retention pass of the invoice store holding the mappings and removes
+// the mappings of the invoices it forgot
+func (cms *ChargeMappingStorage) Cleanup() {
+	store := cms.invoiceStore()
+	store.ExpireStale(time.Now())
+
+	cms.mutex.Lock()
+	defer cms.mutex.Unlock()
+
+	removed := 0
+	for key := range cms.Mappings {
+		if _, exists := store.Get(strings.TrimPrefix(key, legacyRoutePrefix)); !exists {
+			delete(cms.Mappings, key)
+			removed++
+		}
+	}
+	if removed > 0 {
+		if err := cms.save(); err != nil {
+			logWarn("⚠️ Failed to save charge mappings: %v", err)
+		}
+	}
+	logDebug("💾 Charge mapping cleanup removed %d mappings (%d left)", removed, len(cms.Mappings))
+}
+
+// invoiceStore returns the invoice store holding the mappings, for the deprecated provider
+// constructors. It keeps its file as invoices.json next to the charge mapping file, where
+// New puts it by default, or is nil without charge mapping storage.
+func (cms *ChargeMappingStorage) invoiceStore() *InvoiceStore {
+	if cms == nil {
+		return nil
+	}
+
+	cms.mutex.Lock()
+	defer cms.mutex.Unlock()
+	if cms.invoices == nil {
+		cms.invoices = NewInvoiceStore(filepath.Join(filepath.Dir(cms.filePath), "invoices.json"))
+		cms.invoices.importMappings(cms.Mappings)
+	}
+	return cms.invoices
+}
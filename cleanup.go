@@ -0,0 +1,78 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Reconciliation limits
+const (
+	maxReconcileInvoices = 500 // provider lookups per cleanup run, newest invoices first
+	reconcileCallTimeout = 10 * time.Second
+)
+
+// CleanupReport summarizes a cleanup run
+type CleanupReport struct {
+	StartedAt         time.Time `json:"started_at"`
+	Duration          string    `json:"duration"`
+	Reconciled        int       `json:"reconciled"`         // pending invoices found paid and granted
+	Renewed           int       `json:"renewed"`            // memberships renewed from NWC balances
+	ReleasedHolds     int       `json:"released_holds"`     // holds lifted at their release date
+	ExpiredMembers    int       `json:"expired_members"`    // expired memberships removed
+	AbandonedInvoices int       `json:"abandoned_invoices"` // pending invoices past their expiry
+}
+
+// RunCleanup runs cleanup and reconciliation now instead of waiting for the schedule
+func (s *System) RunCleanup(ctx context.Context) CleanupReport {
+	return s.runCleanup(ctx)
+}
+
+// reconcileInvoices asks the provider about pending invoices and grants access for the
+// ones that were paid without being claimed, e.g. when a webhook was missed
+func (s *System) reconcileInvoices(ctx context.Context) int {
+	pending := s.invoices.Pending()
+	sort.Slice(pending, func(i, j int) bool { return pending[i].CreatedAt.After(pending[j].CreatedAt) })
+	if len(pending) > maxReconcileInvoices {
+		pending = pending[:maxReconcileInvoices]
+	}
+
+	reconciled := 0
+	for _, invoice := range pending {
+		if invoice.Pubkey == "" && invoice.Purpose != PurposeLNAddress {
+			continue
+		}
+
+		callCtx, cancel := context.WithTimeout(ctx, reconcileCallTimeout)
+		verification, err := s.provider.VerifyPayment(callCtx, invoice.PaymentHash)
+		cancel()
+		if err != nil || !verification.Paid {
+			continue
+		}
+
+		if invoice.Purpose == PurposeLNAddress {
+			s.settleLNURLPayment(ctx, invoice.PaymentHash, verification)
+		} else if invoice.Purpose == PurposeTopUp {
+			s.settleTopUp(invoice.Pubkey, verification)
+		} else if err := s.grantPaidAccess(ctx, invoice.Pubkey, verification, SourcePayment); err != nil {
+			s.with(paymentHashAttr(invoice.PaymentHash)).logError("❌ Failed to grant access for reconciled invoice %.16s...: %v", invoice.PaymentHash, err)
+			continue
+		}
+		reconciled++
+	}
+
+	if reconciled > 0 {
+		s.logInfo("🧾 Reconciled %d paid invoices that were never claimed", reconciled)
+	}
+	return reconciled
+}
+
+// adminCleanupHandler runs cleanup and reconciliation on demand
+func (s *System) adminCleanupHandler(w http.ResponseWriter, r *http.Request) {
+	report := s.RunCleanup(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
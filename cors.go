@@ -0,0 +1,81 @@
+package payments
+
+import (
+	"net/http"
+	"strings"
+)
+
+// defaultCORSHeaders are the request headers allowed from other origins unless configured
+var defaultCORSHeaders = []string{"Content-Type", "Authorization", "Idempotency-Key"}
+
+// corsExposedHeaders are the response headers browsers let pages on other origins read
+const corsExposedHeaders = "Idempotent-Replayed, Retry-After"
+
+// CORSEnabled reports whether the payment endpoints answer browsers on other origins
+func (s *System) CORSEnabled() bool {
+	return len(s.config.CORSAllowedOrigins) > 0
+}
+
+// corsOrigin returns the Access-Control-Allow-Origin value for a request from origin,
+// "" if the origin is not allowed
+func (s *System) corsOrigin(origin string) string {
+	for _, allowed := range s.config.CORSAllowedOrigins {
+		if allowed == "*" {
+			return "*"
+		}
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// setCORSHeaders allows the request's origin to read the response, reporting whether it is
+// allowed
+func (s *System) setCORSHeaders(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Add("Vary", "Origin")
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	allowed := s.corsOrigin(origin)
+	if allowed == "" {
+		return false
+	}
+	w.Header().Set("Access-Control-Allow-Origin", allowed)
+	w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+	return true
+}
+
+// cors adds CORS headers to the responses of handler when CORS is enabled
+func (s *System) cors(handler http.HandlerFunc) http.HandlerFunc {
+	if !s.CORSEnabled() {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.setCORSHeaders(w, r)
+		handler(w, r)
+	}
+}
+
+// corsPreflight answers the preflight requests browsers send before calling an endpoint
+// with method
+func (s *System) corsPreflight(method string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.setCORSHeaders(w, r) {
+			w.Header().Set("Access-Control-Allow-Methods", method+", OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(s.config.CORSAllowedHeaders, ", "))
+			w.Header().Set("Access-Control-Max-Age", "86400")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleCORS registers a payment endpoint browsers call, with CORS headers and preflight
+// requests answered when CORS is enabled. Each path takes a single method.
+func (s *System) handleCORS(mux *http.ServeMux, method, path string, handler http.HandlerFunc) {
+	mux.HandleFunc(method+" "+path, s.cors(handler))
+	if s.CORSEnabled() {
+		mux.HandleFunc("OPTIONS "+path, s.corsPreflight(method))
+	}
+}
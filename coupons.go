@@ -0,0 +1,234 @@
+package payments
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minDiscountedAmount is the lowest price a coupon can bring an invoice down to, since
+// providers can't issue invoices for nothing
+const minDiscountedAmount = 1000
+
+// Coupon is a discount code clients give when requesting invoices
+type Coupon struct {
+	Code      string    `json:"code"`                 // matched case-insensitively
+	Percent   int       `json:"percent,omitempty"`    // percent off, 1 to 100
+	Amount    int64     `json:"amount,omitempty"`     // millisatoshis off, instead of Percent
+	MaxUses   int       `json:"max_uses,omitempty"`   // paid invoices the code may discount, 0 for unlimited
+	ExpiresAt time.Time `json:"expires_at,omitempty"` // zero for codes that don't expire
+}
+
+// discount returns how much the coupon takes off amount
+func (c Coupon) discount(amount int64) int64 {
+	off := c.Amount
+	if c.Percent > 0 {
+		off = amount * int64(c.Percent) / 100
+	}
+	if amount-off < minDiscountedAmount {
+		off = amount - minDiscountedAmount
+	}
+	if off < 0 {
+		return 0
+	}
+	return off
+}
+
+// parseCoupons parses "CODE:percent%[:max_uses]" or "CODE:amount_msat[:max_uses]" entries
+// separated by commas
+func parseCoupons(value string) ([]Coupon, error) {
+	var coupons []Coupon
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) != 2 && len(parts) != 3 {
+			return nil, fmt.Errorf("invalid coupon %q (expected CODE:percent%%[:max_uses] or CODE:amount_msat[:max_uses])", entry)
+		}
+		coupon := Coupon{Code: parts[0]}
+		if percent, isPercent := strings.CutSuffix(parts[1], "%"); isPercent {
+			value, err := strconv.Atoi(percent)
+			if err != nil {
+				return nil, fmt.Errorf("invalid discount for coupon %q: %w", parts[0], err)
+			}
+			coupon.Percent = value
+		} else {
+			value, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid discount for coupon %q: %w", parts[0], err)
+			}
+			coupon.Amount = value
+		}
+		if len(parts) == 3 {
+			uses, err := strconv.Atoi(parts[2])
+			if err != nil {
+				return nil, fmt.Errorf("invalid max uses for coupon %q: %w", parts[0], err)
+			}
+			coupon.MaxUses = uses
+		}
+		coupons = append(coupons, coupon)
+	}
+	return coupons, nil
+}
+
+// validateCoupons checks coupon codes and discounts
+func validateCoupons(coupons []Coupon) error {
+	seen := make(map[string]bool)
+	for _, coupon := range coupons {
+		code := normalizeCouponCode(coupon.Code)
+		if code == "" {
+			return fmt.Errorf("coupon code is required")
+		}
+		if seen[code] {
+			return fmt.Errorf("duplicate coupon code %q", coupon.Code)
+		}
+		seen[code] = true
+		if (coupon.Percent > 0) == (coupon.Amount > 0) {
+			return fmt.Errorf("coupon %q needs either a percent or an amount off", coupon.Code)
+		}
+		if coupon.Percent < 0 || coupon.Percent > 100 || coupon.Amount < 0 {
+			return fmt.Errorf("invalid discount for coupon %q", coupon.Code)
+		}
+		if coupon.MaxUses < 0 {
+			return fmt.Errorf("invalid max uses for coupon %q", coupon.Code)
+		}
+	}
+	return nil
+}
+
+// normalizeCouponCode makes codes case-insensitive
+func normalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// CouponUsage persists how often each coupon discounted a paid invoice
+type CouponUsage struct {
+	Uses     map[string]int `json:"uses"` // normalized code -> paid invoices
+	mutex    sync.Mutex
+	filePath string
+}
+
+// NewCouponUsage creates coupon usage counters stored at filePath
+func NewCouponUsage(filePath string) *CouponUsage {
+	usage := &CouponUsage{
+		Uses:     make(map[string]int),
+		filePath: filePath,
+	}
+
+	// Ensure directory exists
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		logWarn("⚠️ Failed to create directory for coupon file: %v", err)
+	}
+
+	if err := usage.load(); err != nil {
+		logWarn("⚠️ Failed to load coupon usage: %v", err)
+	}
+	return usage
+}
+
+// load reads the counters from file
+func (cu *CouponUsage) load() error {
+	cu.mutex.Lock()
+	defer cu.mutex.Unlock()
+
+	data, err := os.ReadFile(cu.filePath)
+	if os.IsNotExist(err) {
+		return nil // File doesn't exist, no coupon was used yet
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read coupon file: %w", err)
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(data, cu); err != nil {
+		return err
+	}
+	if cu.Uses == nil {
+		cu.Uses = make(map[string]int)
+	}
+	return nil
+}
+
+// Count returns how often a coupon was used
+func (cu *CouponUsage) Count(code string) int {
+	cu.mutex.Lock()
+	defer cu.mutex.Unlock()
+	return cu.Uses[normalizeCouponCode(code)]
+}
+
+// Redeem counts one use of a coupon
+func (cu *CouponUsage) Redeem(code string) error {
+	cu.mutex.Lock()
+	defer cu.mutex.Unlock()
+
+	code = normalizeCouponCode(code)
+	cu.Uses[code]++
+
+	data, err := json.MarshalIndent(cu, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal coupon usage: %w", err)
+	}
+	return writeFileAtomic(cu.filePath, data, 0644)
+}
+
+// coupon looks up a configured coupon that can still be used
+func (s *System) coupon(code string) (Coupon, error) {
+	code = normalizeCouponCode(code)
+	for _, coupon := range s.config.Coupons {
+		if normalizeCouponCode(coupon.Code) != code {
+			continue
+		}
+		if !coupon.ExpiresAt.IsZero() && time.Now().After(coupon.ExpiresAt) {
+			return Coupon{}, fmt.Errorf("coupon %s has expired", code)
+		}
+		if coupon.MaxUses > 0 && s.couponUsage.Count(code) >= coupon.MaxUses {
+			return Coupon{}, fmt.Errorf("coupon %s has been used up", code)
+		}
+		return coupon, nil
+	}
+	return Coupon{}, fmt.Errorf("unknown coupon %s", code)
+}
+
+// redeemCoupon counts the coupon of a paid invoice, if it had one
+func (s *System) redeemCoupon(invoice TrackedInvoice) {
+	if invoice.Coupon == "" {
+		return
+	}
+	if err := s.couponUsage.Redeem(invoice.Coupon); err != nil {
+		s.logWarn("⚠️ Failed to record use of coupon %s: %v", invoice.Coupon, err)
+	}
+}
+
+// CouponStatus is a configured coupon and how often it was used
+type CouponStatus struct {
+	Coupon
+	Uses int `json:"uses"`
+}
+
+// Coupons returns the configured coupons with their usage
+func (s *System) Coupons() []CouponStatus {
+	coupons := make([]CouponStatus, 0, len(s.config.Coupons))
+	for _, coupon := range s.config.Coupons {
+		coupons = append(coupons, CouponStatus{Coupon: coupon, Uses: s.couponUsage.Count(coupon.Code)})
+	}
+	return coupons
+}
+
+// adminCouponsHandler lists the configured coupons with their usage
+func (s *System) adminCouponsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"coupons": s.Coupons(),
+	})
+}
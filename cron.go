@@ -0,0 +1,142 @@
+package payments
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronDescriptors are the shorthand schedules accepted in place of five fields
+var cronDescriptors = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+}
+
+// cronSchedule is a parsed five-field cron expression (minute hour day-of-month month day-of-week)
+type cronSchedule struct {
+	minutes, hours, days, months, weekdays []bool
+	// Standard cron runs when either day field matches if both are restricted
+	daysRestricted, weekdaysRestricted bool
+}
+
+// parseCron parses a cron expression such as "30 3 * * *" or "*/15 * * * 1-5"
+func parseCron(expr string) (*cronSchedule, error) {
+	expr = strings.TrimSpace(expr)
+	if descriptor, ok := cronDescriptors[expr]; ok {
+		expr = descriptor
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q: expected 5 fields", expr)
+	}
+
+	schedule := &cronSchedule{}
+	var err error
+	if schedule.minutes, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("invalid cron minute: %w", err)
+	}
+	if schedule.hours, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("invalid cron hour: %w", err)
+	}
+	if schedule.days, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("invalid cron day of month: %w", err)
+	}
+	if schedule.months, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("invalid cron month: %w", err)
+	}
+	if schedule.weekdays, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("invalid cron day of week: %w", err)
+	}
+	// Both 0 and 7 are Sunday
+	schedule.weekdays[0] = schedule.weekdays[0] || schedule.weekdays[7]
+	schedule.weekdays = schedule.weekdays[:7]
+	schedule.daysRestricted = !strings.HasPrefix(fields[2], "*")
+	schedule.weekdaysRestricted = !strings.HasPrefix(fields[4], "*")
+
+	if schedule.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("cron expression %q never fires", expr)
+	}
+	return schedule, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges and steps
+func parseCronField(field string, min, max int) ([]bool, error) {
+	values := make([]bool, max+1)
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step %q", stepPart)
+			}
+		}
+
+		start, end := min, max
+		if rangePart != "*" {
+			startStr, endStr, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if start, err = strconv.Atoi(startStr); err != nil {
+				return nil, fmt.Errorf("invalid value %q", startStr)
+			}
+			end = start
+			if isRange {
+				if end, err = strconv.Atoi(endStr); err != nil {
+					return nil, fmt.Errorf("invalid value %q", endStr)
+				}
+			} else if hasStep {
+				end = max
+			}
+		}
+		if start < min || end > max || start > end {
+			return nil, fmt.Errorf("%q out of range %d-%d", part, min, max)
+		}
+
+		for value := start; value <= end; value += step {
+			values[value] = true
+		}
+	}
+	return values, nil
+}
+
+// Next returns the first time after t the schedule fires
+func (c *cronSchedule) Next(t time.Time) time.Time {
+	next := t.Truncate(time.Minute).Add(time.Minute)
+	// Every schedule fires at least once within four years, February 29th included
+	limit := next.AddDate(4, 0, 1)
+	for next.Before(limit) {
+		if !c.months[next.Month()] {
+			next = time.Date(next.Year(), next.Month()+1, 1, 0, 0, 0, 0, next.Location())
+			continue
+		}
+		if !c.dayMatches(next) {
+			next = time.Date(next.Year(), next.Month(), next.Day()+1, 0, 0, 0, 0, next.Location())
+			continue
+		}
+		if !c.hours[next.Hour()] {
+			next = time.Date(next.Year(), next.Month(), next.Day(), next.Hour()+1, 0, 0, 0, next.Location())
+			continue
+		}
+		if !c.minutes[next.Minute()] {
+			next = next.Add(time.Minute)
+			continue
+		}
+		return next
+	}
+	return time.Time{}
+}
+
+// dayMatches applies cron's rule for combining day of month and day of week
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	dayMatch := c.days[t.Day()]
+	weekdayMatch := c.weekdays[t.Weekday()]
+	if c.daysRestricted && c.weekdaysRestricted {
+		return dayMatch || weekdayMatch
+	}
+	return dayMatch && weekdayMatch
+}
@@ -0,0 +1,161 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// errInvalidPubkey is returned when a pubkey is neither 64-char hex nor an npub
+var errInvalidPubkey = errors.New("invalid pubkey")
+
+// errDeniedPubkey is returned when an invoice or access is refused to a denylisted pubkey
+var errDeniedPubkey = errors.New("pubkey is banned from this relay")
+
+// errNoMembership is returned when managing the membership of a pubkey that has none
+var errNoMembership = errors.New("no membership found")
+
+// errPubkeyMismatch is returned when claiming an invoice for another pubkey than the one it
+// was issued for
+var errPubkeyMismatch = errors.New("invoice was issued for another pubkey")
+
+// errUnderpaid is returned when a payment falls short of a price that can't be prorated
+var errUnderpaid = errors.New("payment is less than the price")
+
+// errRateLimited is returned when a pubkey or client IP asks for new invoices too often
+var errRateLimited = errors.New("too many invoices requested, try again later")
+
+// errCircuitOpen is returned instead of calling a provider that keeps failing
+var errCircuitOpen = errors.New("payment backend keeps failing, calls paused")
+
+// errAlreadyRefunded is returned when refunding a payment that was refunded before
+var errAlreadyRefunded = errors.New("payment was already refunded")
+
+// errUnknownPaidAmount is returned when refunding a payment whose provider didn't report
+// the amount received
+var errUnknownPaidAmount = errors.New("amount received is unknown")
+
+// errPayoutFailed is returned by PayInvoice when the payment was tried and failed, so
+// nothing was sent, as opposed to errors leaving the outcome unknown
+var errPayoutFailed = errors.New("payment failed")
+
+// errInvalidRefundDestination is returned when a refund can't be sent where it was asked to go
+var errInvalidRefundDestination = errors.New("invalid refund destination")
+
+// errSnapshotNeeded is returned by a store asked to write single pubkeys when it must be
+// handed the whole state instead
+var errSnapshotNeeded = errors.New("store needs the whole state")
+
+// Quota errors returned by PaidAccessStorage.CountEvent
+var (
+	errEventQuota   = errors.New("event quota used up")
+	errStorageQuota = errors.New("storage quota exceeded")
+)
+
+// Provider operations reported in ProviderError
+const (
+	OpCreateInvoice = "create_invoice"
+	OpVerifyPayment = "verify_payment"
+	OpRedeemEcash   = "redeem_ecash"
+	OpBackup        = "backup"
+	OpFetchRate     = "fetch_rate"
+	OpPayInvoice    = "pay_invoice"
+)
+
+// ProviderError wraps a failure talking to a payment provider and tells whether retrying may help
+type ProviderError struct {
+	Provider   string // provider name, e.g. "ZBD"
+	Op         string // operation that failed, e.g. OpCreateInvoice
+	StatusCode int    // HTTP status returned by the provider, 0 if the request did not complete
+	Transient  bool   // timeouts, 5xx and rate limits are transient; auth and validation failures are not
+	Err        error
+}
+
+// Error implements the error interface
+func (e *ProviderError) Error() string {
+	return fmt.Sprintf("%s %s failed: %v", e.Provider, e.Op, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
+
+// Temporary reports whether the failure is expected to go away on retry
+func (e *ProviderError) Temporary() bool {
+	return e.Transient
+}
+
+// IsTransient reports whether err is a provider failure worth retrying
+func IsTransient(err error) bool {
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) {
+		return providerErr.Transient
+	}
+	return false
+}
+
+// IsPermanent reports whether err is a provider failure that will not go away on retry,
+// such as bad credentials or an invalid amount
+func IsPermanent(err error) bool {
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) {
+		return !providerErr.Transient
+	}
+	return false
+}
+
+// newRequestError classifies a failure to complete an HTTP request to a provider
+func newRequestError(provider, op string, err error) *ProviderError {
+	return &ProviderError{
+		Provider: provider,
+		Op:       op,
+		// Network failures and timeouts are transient, a cancelled caller is not worth retrying
+		Transient: !errors.Is(err, context.Canceled),
+		Err:       err,
+	}
+}
+
+// newCircuitOpenError reports a call refused by the breaker as a transient provider failure,
+// so callers treat it like the failures that opened the circuit
+func newCircuitOpenError(provider, op string) *ProviderError {
+	return &ProviderError{
+		Provider:  provider,
+		Op:        op,
+		Transient: true,
+		Err:       errCircuitOpen,
+	}
+}
+
+// newStatusError classifies an unexpected HTTP status returned by a provider
+func newStatusError(provider, op string, statusCode int, body []byte) *ProviderError {
+	return &ProviderError{
+		Provider:   provider,
+		Op:         op,
+		StatusCode: statusCode,
+		Transient:  isTransientStatus(statusCode),
+		Err:        fmt.Errorf("API error: %d - %s", statusCode, truncateBody(body)),
+	}
+}
+
+// maxErrorBody is how much of a failed response's body errors carry, enough for the
+// provider's message without filling the logs with a whole page
+const maxErrorBody = 256
+
+// truncateBody returns body as a string of at most maxErrorBody bytes
+func truncateBody(body []byte) string {
+	if len(body) <= maxErrorBody {
+		return string(body)
+	}
+	return string(body[:maxErrorBody]) + "..."
+}
+
+// isTransientStatus reports whether an HTTP status indicates a temporary condition
+func isTransientStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests:
+		return true
+	}
+	return statusCode >= 500
+}
@@ -17,6 +17,7 @@ import (
 	"strings"
 	"time"
 
+	payments "github.com/bitkarrot/khatru-payments"
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/nbd-wtf/go-nostr/nip19"
 )
@@ -45,9 +46,13 @@ type RelayInfo struct {
 }
 
 type PaymentRequest struct {
-	Message string `json:"message"`
-	Invoice string `json:"invoice"`
-	Amount  int64  `json:"amount"`
+	Version     int    `json:"version"`
+	Message     string `json:"message"`
+	Invoice     string `json:"invoice"`
+	Amount      int64  `json:"amount"`
+	PaymentHash string `json:"payment_hash"`
+	ExpiresAt   int64  `json:"expires_at"`
+	PaymentURL  string `json:"payment_url"`
 }
 
 func main() {
@@ -121,22 +126,26 @@ func checkPaymentStats() {
 	fmt.Printf("  Active Members: %d\n", relayInfo.PaymentStats.ActiveMembers)
 	fmt.Printf("  Expired Members: %d\n", relayInfo.PaymentStats.ExpiredMembers)
 
-	// Also check debug endpoint
-	fmt.Println("\n🐛 Debug Information:")
-	debugResp, err := http.Get(RelayHTTPURL + "/debug/payments")
+	// Also check the stats endpoint
+	fmt.Println("\n📈 Invoice Statistics:")
+	statsResp, err := http.Get(RelayHTTPURL + "/stats")
 	if err != nil {
-		fmt.Printf("  Failed to get debug info: %v\n", err)
+		fmt.Printf("  Failed to get stats: %v\n", err)
 		return
 	}
-	defer debugResp.Body.Close()
+	defer statsResp.Body.Close()
 
-	debugBody, err := ioutil.ReadAll(debugResp.Body)
-	if err != nil {
-		fmt.Printf("  Failed to read debug response: %v\n", err)
+	var stats payments.StatsSnapshot
+	if err := json.NewDecoder(statsResp.Body).Decode(&stats); err != nil {
+		fmt.Printf("  Failed to parse stats: %v\n", err)
 		return
 	}
 
-	fmt.Printf("  %s\n", string(debugBody))
+	fmt.Printf("  Invoices Created: %d\n", stats.Invoices.Created)
+	fmt.Printf("  Invoices Paid: %d\n", stats.Invoices.Paid)
+	fmt.Printf("  Invoices Pending: %d\n", stats.Invoices.Pending)
+	fmt.Printf("  Abandonment Rate: %.1f%%\n", stats.Invoices.AbandonmentRate*100)
+	fmt.Printf("  Total Revenue: %d msat (%d payments)\n", stats.Revenue.TotalMsat, stats.Revenue.Payments)
 }
 
 // testPaymentFlow tests the complete payment workflow
@@ -0,0 +1,199 @@
+//go:build loadtest
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	payments "github.com/bitkarrot/khatru-payments"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// mockProvider issues fake invoices after a fixed delay, standing in for a payment backend
+type mockProvider struct {
+	latency time.Duration
+	created atomic.Int64
+}
+
+func (p *mockProvider) CreateInvoice(ctx context.Context, amount int64, description string, pubkey string) (*payments.Invoice, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(p.latency):
+	}
+	n := p.created.Add(1)
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s:%d", pubkey, n)))
+	return &payments.Invoice{
+		PaymentRequest: fmt.Sprintf("lnbcmock%d", n),
+		PaymentHash:    hex.EncodeToString(hash[:]),
+		Amount:         amount,
+		Description:    description,
+		ExpiresAt:      time.Now().Add(time.Hour),
+	}, nil
+}
+
+func (p *mockProvider) VerifyPayment(ctx context.Context, paymentHash string) (*payments.PaymentVerification, error) {
+	return &payments.PaymentVerification{PaymentHash: paymentHash}, nil
+}
+
+func (p *mockProvider) CheckExistingPayments(ctx context.Context, pubkey string) (*payments.PaymentVerification, error) {
+	return &payments.PaymentVerification{}, nil
+}
+
+func (p *mockProvider) GetProviderName() string {
+	return "mock"
+}
+
+func main() {
+	rate := flag.Int("rate", 500, "events per second to send")
+	duration := flag.Duration("duration", 30*time.Second, "how long to send events")
+	workers := flag.Int("workers", 64, "events handled concurrently")
+	unpaid := flag.Int("unpaid", 10000, "distinct unpaid pubkeys sending events")
+	members := flag.Int("members", 1000, "paid members, preloaded")
+	memberShare := flag.Float64("member-share", 0.5, "share of events sent by paid members")
+	latency := flag.Duration("provider-latency", 50*time.Millisecond, "time the mock provider takes per invoice")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: go run -tags=loadtest loadtest/loadtest.go -rate 500 -duration 30s\n\n")
+		fmt.Fprintf(os.Stderr, "Sends events through RejectEventHandler with a mock provider and reports latency,\n")
+		fmt.Fprintf(os.Stderr, "throughput and memory, to catch regressions in storage and locking.\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	// Data files go to a scratch directory, logs would drown the report
+	dir, err := os.MkdirTemp("", "khatru-payments-loadtest")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Chdir(dir); err != nil {
+		log.Fatal(err)
+	}
+	log.SetOutput(io.Discard)
+
+	provider := &mockProvider{latency: *latency}
+	payments.RegisterProvider("mock", func(payments.Config) (payments.PaymentProvider, error) {
+		return provider, nil
+	})
+	system, err := payments.New(payments.Config{Provider: "mock", RejectMessage: "payment required"})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create payment system: %v\n", err)
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	memberKeys := randomPubkeys(*members)
+	for _, pubkey := range memberKeys {
+		if _, err := system.GrantAccess(ctx, pubkey, 0, "loadtest"); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to add member: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	unpaidKeys := randomPubkeys(*unpaid)
+
+	var before runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	// Workers handle the events a ticker releases at the requested rate
+	events := make(chan *nostr.Event, *workers)
+	var (
+		mutex     sync.Mutex
+		latencies []time.Duration
+		accepted  atomic.Int64
+		rejected  atomic.Int64
+		wg        sync.WaitGroup
+	)
+	for i := 0; i < *workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var own []time.Duration
+			for event := range events {
+				start := time.Now()
+				reject, _ := system.RejectEventHandler(ctx, event)
+				own = append(own, time.Since(start))
+				if reject {
+					rejected.Add(1)
+				} else {
+					accepted.Add(1)
+				}
+			}
+			mutex.Lock()
+			latencies = append(latencies, own...)
+			mutex.Unlock()
+		}()
+	}
+
+	interval := time.Second / time.Duration(max(*rate, 1))
+	ticker := time.NewTicker(interval)
+	started := time.Now()
+	dropped := 0
+	for n := 0; time.Since(started) < *duration; n++ {
+		<-ticker.C
+		pubkey := unpaidKeys[n%len(unpaidKeys)]
+		if len(memberKeys) > 0 && float64(n%1000) < *memberShare*1000 {
+			pubkey = memberKeys[n%len(memberKeys)]
+		}
+		event := &nostr.Event{PubKey: pubkey, CreatedAt: nostr.Now(), Kind: 1, Content: "load test"}
+		select {
+		case events <- event:
+		default:
+			dropped++ // every worker is busy, the relay is falling behind
+		}
+	}
+	ticker.Stop()
+	close(events)
+	wg.Wait()
+	elapsed := time.Since(started)
+
+	var after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := system.Close(shutdownCtx); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to close payment system: %v\n", err)
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	handled := len(latencies)
+	fmt.Printf("events:      %d handled in %v (%.0f/s), %d dropped with every worker busy\n", handled, elapsed.Round(time.Millisecond), float64(handled)/elapsed.Seconds(), dropped)
+	fmt.Printf("outcome:     %d accepted, %d rejected, %d invoices created\n", accepted.Load(), rejected.Load(), provider.created.Load())
+	if handled > 0 {
+		fmt.Printf("latency:     p50 %v, p95 %v, p99 %v, max %v\n",
+			percentile(latencies, 0.50), percentile(latencies, 0.95), percentile(latencies, 0.99), latencies[handled-1])
+	}
+	fmt.Printf("heap:        %.1f MiB before, %.1f MiB after\n", float64(before.HeapAlloc)/(1<<20), float64(after.HeapAlloc)/(1<<20))
+}
+
+// randomPubkeys returns n random hex pubkeys
+func randomPubkeys(n int) []string {
+	pubkeys := make([]string, n)
+	for i := range pubkeys {
+		key := make([]byte, 32)
+		rand.Read(key)
+		pubkeys[i] = hex.EncodeToString(key)
+	}
+	return pubkeys
+}
+
+// percentile returns the latency below which share of the sorted latencies fall
+func percentile(sorted []time.Duration, share float64) time.Duration {
+	index := int(float64(len(sorted)-1) * share)
+	return sorted[index].Round(time.Microsecond)
+}
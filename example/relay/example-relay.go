@@ -5,13 +5,17 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	payments "github.com/bitkarrot/khatru-payments"
 	"github.com/fiatjaf/khatru"
 	"github.com/joho/godotenv"
-	"github.com/nbd-wtf/go-nostr"
 )
 
 func main() {
@@ -35,22 +39,13 @@ func main() {
 	relay.Info.PubKey = "your-relay-pubkey"
 	relay.Info.Contact = "admin@example.com"
 
-	// Add payment-based event rejection
-	relay.RejectEvent = append(relay.RejectEvent, func(ctx context.Context, event *nostr.Event) (bool, string) {
-		// Your WoT logic here - check if user is in Web of Trust
-		isInWoT := checkWebOfTrust(event.PubKey)
+	// Wire payments onto the relay: event rejection, NIP-11 fees, payment endpoints
+	payments.Attach(relay, paymentSystem, payments.WithBypass(func(ctx context.Context, pubkey string) bool {
+		// Your WoT logic here - allow users in your Web of Trust without payment
+		return checkWebOfTrust(pubkey)
+	}))
 
-		if isInWoT {
-			return false, "" // Allow WoT users
-		}
-
-		// For non-WoT users, use payment system
-		return paymentSystem.RejectEventHandler(ctx, event)
-	})
-
-	// Register payment endpoints
 	mux := relay.Router()
-	paymentSystem.RegisterHandlers(mux)
 
 	// Add custom endpoint to show payment stats
 	mux.HandleFunc("GET /", func(w http.ResponseWriter, r *http.Request) {
@@ -67,11 +62,31 @@ func main() {
 	log.Println("💰 Payment endpoints:")
 	log.Println("   POST /verify-payment")
 	log.Println("   POST /webhook/zbd")
-	log.Println("   GET /debug/payments")
+	log.Println("   GET /stats")
+	log.Println("   GET /debug/payments (admin)")
+	log.Println("   GET /pay")
+	if paymentSystem.LNAddressEnabled() {
+		log.Printf("⚡ Pay or zap %s to join", paymentSystem.LNAddress())
+	}
+
+	// Shut down on Ctrl-C or SIGTERM so pending membership writes reach the disk
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	if err := http.ListenAndServe(":3334", relay); err != nil {
+	server := &http.Server{Addr: ":3334", Handler: relay}
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatal(err)
 	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := paymentSystem.Close(shutdownCtx); err != nil {
+		log.Printf("Failed to save payment data: %v", err)
+	}
 }
 
 // checkWebOfTrust - implement your WoT logic here
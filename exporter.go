@@ -0,0 +1,217 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// statsExporter periodically pushes stats snapshots to an external analytics endpoint
+type statsExporter struct {
+	system   *System
+	endpoint string
+	format   string // "json", "influx" or "statsd"
+	interval time.Duration
+	client   *http.Client
+}
+
+// newStatsExporter validates the export configuration
+func newStatsExporter(system *System, endpoint, format, interval string) (*statsExporter, error) {
+	if format == "" {
+		format = "json"
+	}
+	switch format {
+	case "json", "influx", "statsd":
+	default:
+		return nil, fmt.Errorf("unsupported stats export format: %s (supported: json, influx, statsd)", format)
+	}
+
+	every := time.Minute
+	if interval != "" {
+		parsed, err := time.ParseDuration(interval)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("invalid stats export interval: %s", interval)
+		}
+		every = parsed
+	}
+
+	if format == "statsd" {
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("statsd export endpoint must look like statsd://host:8125")
+		}
+	}
+
+	return &statsExporter{
+		system:   system,
+		endpoint: endpoint,
+		format:   format,
+		interval: every,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// run exports a snapshot on every tick
+func (e *statsExporter) run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := e.export(ctx); err != nil {
+			e.system.logError("❌ Failed to export stats to %s: %v", e.endpoint, err)
+		}
+	}
+}
+
+// export sends a single stats snapshot
+func (e *statsExporter) export(ctx context.Context) error {
+	stats := e.system.GetStats()
+	now := time.Now()
+
+	switch e.format {
+	case "influx":
+		return e.post(ctx, "text/plain; charset=utf-8", []byte(influxLine(stats, now)))
+	case "statsd":
+		return e.sendStatsd(stats)
+	default:
+		body, err := json.Marshal(map[string]interface{}{
+			"timestamp": now.Unix(),
+			"stats":     stats,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to marshal stats: %w", err)
+		}
+		return e.post(ctx, "application/json", body)
+	}
+}
+
+// post sends a snapshot body to the HTTP endpoint
+func (e *statsExporter) post(ctx context.Context, contentType string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("export endpoint error: %d - %s", resp.StatusCode, string(respBody))
+	}
+	return nil
+}
+
+// sendStatsd writes every numeric stat as a StatsD gauge over UDP
+func (e *statsExporter) sendStatsd(stats map[string]interface{}) error {
+	u, _ := url.Parse(e.endpoint)
+	conn, err := net.Dial("udp", u.Host)
+	if err != nil {
+		return fmt.Errorf("failed to dial statsd: %w", err)
+	}
+	defer conn.Close()
+
+	metrics := flattenStats(stats)
+	for _, key := range sortedKeys(metrics) {
+		line := fmt.Sprintf("khatru_payments.%s:%s|g", key, strconv.FormatFloat(metrics[key], 'f', -1, 64))
+		if _, err := conn.Write([]byte(line)); err != nil {
+			return fmt.Errorf("failed to write statsd metric: %w", err)
+		}
+	}
+	return nil
+}
+
+// influxLine renders numeric stats as a single InfluxDB line protocol point
+func influxLine(stats map[string]interface{}, at time.Time) string {
+	metrics := flattenStats(stats)
+
+	fields := make([]string, 0, len(metrics))
+	for _, key := range sortedKeys(metrics) {
+		fields = append(fields, fmt.Sprintf("%s=%s", influxEscape(key), strconv.FormatFloat(metrics[key], 'f', -1, 64)))
+	}
+
+	provider, _ := stats["provider"].(string)
+	return fmt.Sprintf("khatru_payments,provider=%s %s %d\n", influxEscape(provider), strings.Join(fields, ","), at.UnixNano())
+}
+
+// flattenStats turns nested stats into dotted numeric metrics, e.g. revenue_by_tier.1month.amount_msat
+func flattenStats(stats map[string]interface{}) map[string]float64 {
+	metrics := make(map[string]float64)
+
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return metrics
+	}
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return metrics
+	}
+
+	var walk func(prefix string, value interface{})
+	walk = func(prefix string, value interface{}) {
+		switch v := value.(type) {
+		case float64:
+			metrics[prefix] = v
+		case bool:
+			if v {
+				metrics[prefix] = 1
+			} else {
+				metrics[prefix] = 0
+			}
+		case map[string]interface{}:
+			for key, child := range v {
+				name := key
+				if prefix != "" {
+					name = prefix + "." + key
+				}
+				walk(name, child)
+			}
+		case []interface{}:
+			for i, child := range v {
+				label := strconv.Itoa(i)
+				if obj, ok := child.(map[string]interface{}); ok {
+					if l, ok := obj["label"].(string); ok {
+						label = l
+					}
+				}
+				walk(prefix+"."+label, child)
+			}
+		}
+	}
+	walk("", generic)
+
+	return metrics
+}
+
+// influxEscape escapes characters that are special in line protocol keys and tags
+func influxEscape(s string) string {
+	return strings.NewReplacer(",", "\\,", " ", "\\ ", "=", "\\=").Replace(s)
+}
+
+// sortedKeys returns map keys in stable order
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
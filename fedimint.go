@@ -0,0 +1,264 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// fedimintAwaitTimeout bounds how long a verification waits on await-invoice, which
+// blocks until the invoice is claimed
+const fedimintAwaitTimeout = 3 * time.Second
+
+// FedimintProvider implements PaymentProvider interface for fedimint-clientd
+type FedimintProvider struct {
+	baseURL      string
+	password     string
+	federationID string
+	gatewayID    string
+	// Map operation id to pubkey and amount for verification
+	pubkeyMap *lruMap[string]
+	amountMap *lruMap[int64]
+	mu        sync.RWMutex
+	// Persistent storage references
+	invoiceStore *InvoiceStore
+	providerHTTP
+	logTarget
+}
+
+// NewFedimintProvider creates a new Fedimint payment provider backed by fedimint-clientd
+func NewFedimintProvider(baseURL, password, federationID, gatewayID string, invoiceStore *InvoiceStore) (*FedimintProvider, error) {
+	if password == "" {
+		return nil, fmt.Errorf("fedimint-clientd password is required")
+	}
+	if baseURL == "" {
+		baseURL = "http://localhost:3333"
+	}
+
+	return &FedimintProvider{
+		baseURL:      baseURL,
+		password:     password,
+		federationID: federationID,
+		gatewayID:    gatewayID,
+		pubkeyMap:    newLRUMap[string](providerMapSize),
+		amountMap:    newLRUMap[int64](providerMapSize),
+		invoiceStore: invoiceStore,
+	}, nil
+}
+
+// newFedimintFromConfig creates the Fedimint provider
+func newFedimintFromConfig(config *Config, invoiceStore *InvoiceStore) (PaymentProvider, error) {
+	if config.FedimintPassword == "" {
+		return nil, fmt.Errorf("FEDIMINT_PASSWORD required for fedimint provider")
+	}
+	return NewFedimintProvider(config.FedimintURL, config.FedimintPassword, config.FedimintFederationID, config.FedimintGatewayID, invoiceStore)
+}
+
+// GetProviderName returns the provider name
+func (p *FedimintProvider) GetProviderName() string {
+	return "fedimint"
+}
+
+// fedimint-clientd API structures
+type FedimintInvoiceRequest struct {
+	AmountMsat   int64  `json:"amountMsat"`
+	Description  string `json:"description"`
+	ExpiryTime   int64  `json:"expiryTime,omitempty"`
+	GatewayID    string `json:"gatewayId,omitempty"`
+	FederationID string `json:"federationId,omitempty"`
+}
+
+type FedimintInvoiceResponse struct {
+	OperationID string `json:"operationId"`
+	Invoice     string `json:"invoice"`
+}
+
+type FedimintAwaitInvoiceRequest struct {
+	OperationID  string `json:"operationId"`
+	FederationID string `json:"federationId,omitempty"`
+}
+
+type FedimintNotesRequest struct {
+	Notes        string `json:"notes"`
+	FederationID string `json:"federationId,omitempty"`
+}
+
+type FedimintNotesResponse struct {
+	AmountMsat int64 `json:"amountMsat"`
+}
+
+// CreateInvoice creates a Lightning invoice through the federation's gateway
+func (p *FedimintProvider) CreateInvoice(ctx context.Context, amount int64, description string, pubkey string) (*Invoice, error) {
+	expiry := int64(defaultInvoiceExpiry / time.Second)
+	payload, err := json.Marshal(FedimintInvoiceRequest{
+		AmountMsat:   amount,
+		Description:  description,
+		ExpiryTime:   expiry,
+		GatewayID:    p.gatewayID,
+		FederationID: p.federationID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	body, err := p.post(ctx, OpCreateInvoice, "/v2/ln/invoice", payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var invoiceResp FedimintInvoiceResponse
+	if err := json.Unmarshal(body, &invoiceResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	// The operation id identifies the invoice in the federation client
+	p.mu.Lock()
+	p.pubkeyMap.Set(invoiceResp.OperationID, pubkey)
+	p.amountMap.Set(invoiceResp.OperationID, amount)
+	p.mu.Unlock()
+
+	if p.invoiceStore != nil {
+		p.invoiceStore.RecordCharge(invoiceResp.OperationID, invoiceResp.OperationID, amount)
+	}
+
+	return &Invoice{
+		PaymentRequest: invoiceResp.Invoice,
+		PaymentHash:    invoiceResp.OperationID,
+		Amount:         amount,
+		Description:    description,
+		ExpiresAt:      time.Now().Add(time.Duration(expiry) * time.Second),
+	}, nil
+}
+
+// VerifyPayment checks if an invoice was claimed by the federation client
+func (p *FedimintProvider) VerifyPayment(ctx context.Context, paymentHash string) (*PaymentVerification, error) {
+	payload, err := json.Marshal(FedimintAwaitInvoiceRequest{
+		OperationID:  paymentHash,
+		FederationID: p.federationID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	// await-invoice only returns once the invoice is claimed, so a short wait means unpaid
+	awaitCtx, cancel := context.WithTimeout(ctx, fedimintAwaitTimeout)
+	defer cancel()
+
+	_, err = p.post(awaitCtx, OpVerifyPayment, "/v2/ln/await-invoice", payload)
+	if err != nil {
+		if ctx.Err() == nil && errors.Is(awaitCtx.Err(), context.DeadlineExceeded) {
+			return &PaymentVerification{Paid: false, PaymentHash: paymentHash}, nil
+		}
+		return nil, err
+	}
+
+	return &PaymentVerification{
+		Paid:        true,
+		PaymentHash: paymentHash,
+		Amount:      p.amountFor(paymentHash),
+		PaidAt:      time.Now(),
+	}, nil
+}
+
+// amountFor returns the amount an operation was created for, 0 if unknown
+func (p *FedimintProvider) amountFor(operationID string) int64 {
+	p.mu.RLock()
+	amount, exists := p.amountMap.Get(operationID)
+	p.mu.RUnlock()
+	if exists {
+		return amount
+	}
+
+	if p.invoiceStore != nil {
+		if stored, found := p.invoiceStore.Get(operationID); found {
+			amount = stored.Amount
+		}
+	}
+	return amount
+}
+
+// CheckExistingPayments checks for any existing payments for a pubkey and returns verification if paid
+func (p *FedimintProvider) CheckExistingPayments(ctx context.Context, pubkey string) (*PaymentVerification, error) {
+	p.mu.RLock()
+	operationIDs := pendingHashesFor(p.pubkeyMap, p.invoiceStore, p.GetProviderName(), pubkey)
+	p.mu.RUnlock()
+
+	for _, operationID := range operationIDs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		verification, err := p.VerifyPayment(ctx, operationID)
+		if err == nil && verification.Paid {
+			p.logInfo("💰 Found claimed fedimint invoice! Operation: %s", operationID)
+			return verification, nil
+		}
+	}
+
+	return nil, nil // No paid payments found
+}
+
+// ValidateNotes returns the value of out-of-band ecash notes in msat without redeeming them
+func (p *FedimintProvider) ValidateNotes(ctx context.Context, notes string) (int64, error) {
+	return p.notes(ctx, "/v2/mint/validate", notes)
+}
+
+// ReissueNotes redeems ecash notes into the federation client's wallet and returns the
+// amount received in msat. Notes that were already spent are refused by the federation.
+func (p *FedimintProvider) ReissueNotes(ctx context.Context, notes string) (int64, error) {
+	return p.notes(ctx, "/v2/mint/reissue", notes)
+}
+
+// notes sends ecash notes to a fedimint-clientd mint endpoint
+func (p *FedimintProvider) notes(ctx context.Context, path, notes string) (int64, error) {
+	payload, err := json.Marshal(FedimintNotesRequest{
+		Notes:        notes,
+		FederationID: p.federationID,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	body, err := p.post(ctx, OpRedeemEcash, path, payload)
+	if err != nil {
+		return 0, err
+	}
+
+	var notesResp FedimintNotesResponse
+	if err := json.Unmarshal(body, &notesResp); err != nil {
+		return 0, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	return notesResp.AmountMsat, nil
+}
+
+// post sends a JSON request to fedimint-clientd and returns the response body
+func (p *FedimintProvider) post(ctx context.Context, op, path string, payload []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+p.password)
+
+	resp, err := p.httpClient().Do(req)
+	if err != nil {
+		return nil, newRequestError(p.GetProviderName(), op, fmt.Errorf("failed to make request: %w", err))
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, newRequestError(p.GetProviderName(), op, fmt.Errorf("failed to read response: %w", err))
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStatusError(p.GetProviderName(), op, resp.StatusCode, body)
+	}
+	return body, nil
+}
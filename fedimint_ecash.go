@@ -0,0 +1,133 @@
+package payments
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// fedimintEcashPrefix marks the ids ecash payments are recorded under in place of a payment hash
+const fedimintEcashPrefix = "fedimint-ecash:"
+
+// FedimintEcashEnabled reports whether Fedimint ecash notes are accepted as payment
+func (s *System) FedimintEcashEnabled() bool {
+	return s.fedimint != nil
+}
+
+// FedimintPayment is the outcome of paying for access with Fedimint ecash notes
+type FedimintPayment struct {
+	Tier        string `json:"tier"`
+	PaymentHash string `json:"payment_hash"` // id the payment is recorded under, "fedimint-ecash:" + sha256 of the notes
+	Amount      int64  `json:"amount"`       // msat redeemed
+}
+
+// PayWithFedimintNotes redeems out-of-band ecash notes into the relay's federation client
+// and credits them against pubkey like a Lightning payment. The notes are validated before
+// redeeming, so notes too small for the tier are left with the user. An empty tier picks
+// the most valuable tier the notes cover; anything above the price is kept by the relay.
+func (s *System) PayWithFedimintNotes(ctx context.Context, pubkey, notes, tierName string) (*FedimintPayment, error) {
+	if !s.FedimintEcashEnabled() {
+		return nil, fmt.Errorf("Fedimint ecash payments are not enabled")
+	}
+	notes = strings.TrimSpace(notes)
+
+	value, err := s.fedimint.ValidateNotes(ctx, notes)
+	if err != nil {
+		return nil, err
+	}
+	tier, err := s.tierForPayment(tierName, value)
+	if err != nil {
+		return nil, err
+	}
+
+	received, err := s.fedimint.ReissueNotes(ctx, notes)
+	if err != nil {
+		return nil, err
+	}
+	if received < tier.Amount {
+		// Validation said otherwise, the notes are redeemed now so log for the operator
+		s.with(pubkeyAttr(pubkey)).logWarn("⚠️ Fedimint notes from %s... redeemed for %d msat, below the %s price", pubkey[:16], received, tier.Name)
+	}
+
+	hash := sha256.Sum256([]byte(notes))
+	paymentID := fedimintEcashPrefix + hex.EncodeToString(hash[:])
+	duration := accessDurationFor(tier.Duration)
+	now := time.Now()
+
+	// Track the payment like an invoice so it is granted the tier and shows up in reporting
+	s.invoices.Track(&Invoice{
+		PaymentHash: paymentID,
+		Amount:      received,
+		ExpiresAt:   now.Add(defaultInvoiceExpiry),
+	}, pubkey, tier.Name, duration)
+
+	verification := &PaymentVerification{
+		Paid:        true,
+		PaymentHash: paymentID,
+		Amount:      received,
+		PaidAt:      now,
+	}
+	if err := s.grantPaidAccess(ctx, pubkey, verification, SourceEcash); err != nil {
+		return nil, fmt.Errorf("failed to grant access: %w", err)
+	}
+
+	s.with(pubkeyAttr(pubkey)).logInfo("🏛️ Fedimint ecash payment of %d msat accepted, access granted for %s... (%s tier)", received, pubkey[:16], tier.Name)
+	return &FedimintPayment{
+		Tier:        tier.Name,
+		PaymentHash: paymentID,
+		Amount:      received,
+	}, nil
+}
+
+// fedimintPayHandler grants access for Fedimint ecash notes
+func (s *System) fedimintPayHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Pubkey string `json:"pubkey"`
+		Notes  string `json:"notes"`
+		Tier   string `json:"tier"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	pubkey, err := parsePubkey(req.Pubkey)
+	if err != nil {
+		http.Error(w, "valid pubkey is required", http.StatusBadRequest)
+		return
+	}
+	if req.Notes == "" {
+		http.Error(w, "notes are required", http.StatusBadRequest)
+		return
+	}
+
+	payment, err := s.PayWithFedimintNotes(r.Context(), pubkey, req.Notes, req.Tier)
+	if err != nil {
+		s.logError("❌ Fedimint ecash payment failed: %v", err)
+		switch {
+		case IsTransient(err):
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, "Federation client temporarily unavailable", http.StatusServiceUnavailable)
+		case IsPermanent(err):
+			// fedimint-clientd refuses invalid and spent notes
+			http.Error(w, "Federation client refused the notes", http.StatusBadGateway)
+		default:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"paid":           true,
+		"access_granted": true,
+		"tier":           payment.Tier,
+		"payment_hash":   payment.PaymentHash,
+		"amount":         payment.Amount,
+	})
+}
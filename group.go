@@ -0,0 +1,151 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// GroupInvoice is an invoice that grants a tier to every pubkey of a group once paid
+type GroupInvoice struct {
+	TierInvoice
+	Payer   string   `json:"payer"`
+	Members []string `json:"members"`
+}
+
+// GroupsEnabled reports whether one payment may buy access for several pubkeys
+func (s *System) GroupsEnabled() bool {
+	return s.config.GroupMaxSize > 0
+}
+
+// groupPrice is the bundled price of a tier for size pubkeys, GroupDiscount percent off
+func (s *System) groupPrice(tier Tier, size int) int64 {
+	return tier.Amount * int64(size) * int64(100-s.config.GroupDiscount) / 100
+}
+
+// CreateGroupInvoice creates an invoice paid by payer that grants the named tier, or the
+// first tier, to every pubkey in members once paid, e.g. a community admin paying for the
+// whole community. Members are hex or npub pubkeys, duplicates are dropped.
+func (s *System) CreateGroupInvoice(ctx context.Context, payer, tierName string, members []string) (*GroupInvoice, error) {
+	tier, group, err := s.groupFor(payer, tierName, members)
+	if err != nil {
+		return nil, err
+	}
+
+	amount := s.groupPrice(tier, len(group))
+	if err := s.allowInvoice(ctx, payer); err != nil {
+		return nil, err
+	}
+	description := fmt.Sprintf("Trusted Relay Access for %d pubkeys - pubkey:%s", len(group), payer)
+	invoice, err := s.provider.CreateInvoice(ctx, amount, description, payer)
+	if err != nil {
+		return nil, err
+	}
+	s.trackGroupInvoice(invoice, payer, tier.Name, accessDurationFor(tier.Duration), group)
+
+	result := &GroupInvoice{
+		TierInvoice: TierInvoice{
+			Tier:           tier.Name,
+			Duration:       tier.Duration,
+			Amount:         invoice.Amount,
+			PaymentRequest: invoice.PaymentRequest,
+			PaymentHash:    invoice.PaymentHash,
+		},
+		Payer:   payer,
+		Members: group,
+	}
+	if !invoice.ExpiresAt.IsZero() {
+		result.ExpiresAt = invoice.ExpiresAt.Unix()
+	}
+	s.with(pubkeyAttr(payer)).logInfo("👥 Group invoice for %d pubkeys on the %s tier paid by %s...", len(group), tier.Name, payer[:16])
+	return result, nil
+}
+
+// groupFor checks a group purchase, returning the tier and the deduplicated member pubkeys
+func (s *System) groupFor(payer, tierName string, members []string) (Tier, []string, error) {
+	if !s.GroupsEnabled() {
+		return Tier{}, nil, fmt.Errorf("group plans are not enabled")
+	}
+	if s.IsDenylisted(payer) {
+		return Tier{}, nil, errDeniedPubkey
+	}
+
+	var group []string
+	for _, value := range members {
+		pubkey, err := parsePubkey(value)
+		if err != nil {
+			return Tier{}, nil, fmt.Errorf("%s: %w", value, err)
+		}
+		if s.IsDenylisted(pubkey) {
+			return Tier{}, nil, fmt.Errorf("%s: %w", pubkey, errDeniedPubkey)
+		}
+		if !slices.Contains(group, pubkey) {
+			group = append(group, pubkey)
+		}
+	}
+	if len(group) == 0 {
+		return Tier{}, nil, fmt.Errorf("a group needs at least one member")
+	}
+	if len(group) > s.config.GroupMaxSize {
+		return Tier{}, nil, fmt.Errorf("a group may have at most %d members", s.config.GroupMaxSize)
+	}
+
+	if tierName == "" {
+		return s.Tiers()[0], group, nil
+	}
+	for _, tier := range s.Tiers() {
+		if tier.Name == tierName {
+			return tier, group, nil
+		}
+	}
+	return Tier{}, nil, fmt.Errorf("unknown tier %q", tierName)
+}
+
+// groupInvoiceHandler creates an invoice granting access to a group of pubkeys
+func (s *System) groupInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Pubkey  string   `json:"pubkey"` // payer
+		Tier    string   `json:"tier"`
+		Members []string `json:"members"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	payer, err := parsePubkey(req.Pubkey)
+	if err != nil {
+		http.Error(w, "valid pubkey is required", http.StatusBadRequest)
+		return
+	}
+	if _, _, err := s.groupFor(payer, req.Tier, req.Members); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errDeniedPubkey) {
+			status = http.StatusForbidden
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.invoiceTimeout)
+	defer cancel()
+
+	invoice, err := s.CreateGroupInvoice(ctx, payer, req.Tier, req.Members)
+	if errors.Is(err, errRateLimited) {
+		writeRateLimited(w)
+		return
+	}
+	if err != nil {
+		s.with(pubkeyAttr(payer)).logError("❌ Failed to create group invoice for %s: %v", payer[:16], err)
+		http.Error(w, "Failed to create invoice", http.StatusBadGateway)
+		return
+	}
+	s.invoices.MarkSeen(invoice.PaymentHash)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"invoice": invoice,
+	})
+}
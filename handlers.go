@@ -1,12 +1,15 @@
 package payments
 
 import (
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
-	"sync/atomic"
+	"sort"
+	"strings"
+	"time"
 )
 
 // verifyPaymentHandler handles manual payment verification requests
@@ -36,12 +39,38 @@ func (s *System) verifyPaymentHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "payment_hash and pubkey are required", http.StatusBadRequest)
 		return
 	}
+	pubkey, err := parsePubkey(req.Pubkey)
+	if err != nil {
+		http.Error(w, "Invalid pubkey", http.StatusBadRequest)
+		return
+	}
+	req.Pubkey = pubkey
+
+	// The user is checking on the invoice, so they have seen it
+	s.invoices.MarkSeen(req.PaymentHash)
+
+	// Paying while access is active renews the membership
+	renewing := s.HasAccess(req.Pubkey)
 
 	// Verify payment using the configured provider
 	verification, err := s.VerifyPayment(r.Context(), req.PaymentHash, req.Pubkey)
 	if err != nil {
-		log.Printf("❌ Payment verification failed: %v", err)
-		http.Error(w, "Payment verification failed", http.StatusInternalServerError)
+		s.logError("❌ Payment verification failed: %v", err)
+		switch {
+		case errors.Is(err, errDeniedPubkey):
+			http.Error(w, "Pubkey is banned from this relay", http.StatusForbidden)
+		case errors.Is(err, errPubkeyMismatch):
+			http.Error(w, "Invoice was issued for another pubkey", http.StatusForbidden)
+		case errors.Is(err, errUnderpaid):
+			http.Error(w, "Payment is less than the price", http.StatusPaymentRequired)
+		case IsTransient(err):
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, "Payment provider temporarily unavailable", http.StatusServiceUnavailable)
+		case IsPermanent(err):
+			http.Error(w, "Payment verification failed", http.StatusBadGateway)
+		default:
+			http.Error(w, "Payment verification failed", http.StatusInternalServerError)
+		}
 		return
 	}
 
@@ -51,9 +80,94 @@ func (s *System) verifyPaymentHandler(w http.ResponseWriter, r *http.Request) {
 		"amount":       verification.Amount,
 	}
 
-	if verification.Paid {
-		log.Printf("💰 Payment verified and access granted for pubkey: %s...", req.Pubkey[:16])
+	if topUpPubkey, isTopUp := s.topUpPubkey(req.PaymentHash); isTopUp && verification.Paid {
+		if topUpPubkey != "" {
+			response["balance"] = s.Balance(topUpPubkey)
+		}
+	} else if invoice, tracked := s.invoices.Get(req.PaymentHash); tracked && invoice.Tier == donationTier {
+		// Donations to the Lightning address buy nothing
+	} else if verification.Paid {
+		s.with(pubkeyAttr(req.Pubkey)).logInfo("💰 Payment verified and access granted for pubkey: %s...", req.Pubkey[:16])
 		response["access_granted"] = true
+		response["renewed"] = renewing
+		if member, exists := s.paidAccessStorage.GetMember(req.Pubkey); exists && !member.ExpiresAt.IsZero() {
+			response["expires_at"] = member.ExpiresAt.Unix()
+		}
+		if s.NWCEnabled() {
+			if uri, err := s.NWCConnectionURI(req.Pubkey); err == nil {
+				response["nwc_connection"] = uri
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// invoiceStatusHandler reports the state of a membership invoice. Polling a pending invoice
+// verifies it with the provider, so the first poll after it settled grants access without
+// the client having to know the pubkey for /verify-payment.
+func (s *System) invoiceStatusHandler(w http.ResponseWriter, r *http.Request) {
+	paymentHash := r.PathValue("payment_hash")
+	if _, ok := s.invoiceUpdate(paymentHash); !ok {
+		http.Error(w, "Invoice not found", http.StatusNotFound)
+		return
+	}
+
+	// The user is checking on the invoice, so they have seen it
+	s.invoices.MarkSeen(paymentHash)
+	s.checkInvoice(r.Context(), paymentHash)
+
+	update, _ := s.invoiceUpdate(paymentHash)
+	invoice, _ := s.invoices.Get(paymentHash)
+	response := map[string]interface{}{
+		"payment_hash":    paymentHash,
+		"payment_request": invoice.PaymentRequest,
+		"amount":          update.Amount,
+		"status":          update.Status,
+		"paid":            update.PaidAt != 0,
+		"access_granted":  update.Status == invoiceUpdateGranted,
+	}
+	if invoice.Tier != "" {
+		response["tier"] = invoice.Tier
+	}
+	if update.ExpiresAt != 0 {
+		response["expires_at"] = update.ExpiresAt
+	}
+	if update.PaidAt != 0 {
+		response["paid_at"] = update.PaidAt
+	}
+	if update.AccessExpiresAt != 0 {
+		response["access_expires_at"] = update.AccessExpiresAt
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// accessStatusHandler tells clients whether a pubkey has access and until when, so they can
+// show when a membership expires without attempting a write
+func (s *System) accessStatusHandler(w http.ResponseWriter, r *http.Request) {
+	pubkey, err := parsePubkey(r.PathValue("pubkey"))
+	if err != nil {
+		http.Error(w, "valid pubkey is required", http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]interface{}{
+		"pubkey":     pubkey,
+		"has_access": s.HasAccess(pubkey),
+	}
+	if member, exists := s.paidAccessStorage.GetMember(pubkey); exists {
+		if member.Tier != "" {
+			response["tier"] = member.Tier
+		}
+		if !member.ExpiresAt.IsZero() {
+			response["expires_at"] = member.ExpiresAt.Unix()
+		}
+		if member.Hold.active(time.Now()) {
+			response["on_hold"] = true
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -67,41 +181,47 @@ func (s *System) zbdWebhookHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !s.zbdWebhookAuthorized(r) {
+		s.logError("❌ ZBD webhook rejected: missing or wrong secret")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("❌ Failed to read ZBD webhook body: %v", err)
+		s.logError("❌ Failed to read ZBD webhook body: %v", err)
 		http.Error(w, "Failed to read request body", http.StatusBadRequest)
 		return
 	}
 
 	// Try to handle webhook with ZBD provider
-	if zbdProvider, ok := s.provider.(*ZBDProvider); ok {
-		verification, pubkey, err := zbdProvider.HandleWebhook(body)
+	if zbdProvider, ok := s.zbdProvider(); ok {
+		verification, pubkey, err := zbdProvider.HandleWebhook(r.Context(), body)
 		if err != nil {
-			log.Printf("❌ Failed to process ZBD webhook: %v", err)
+			s.logError("❌ Failed to process ZBD webhook: %v", err)
 			http.Error(w, "Failed to process webhook", http.StatusInternalServerError)
 			return
 		}
 
 		if verification != nil && verification.Paid && pubkey != "" {
-			// Grant access
-			err = s.paidAccessStorage.AddPaidAccess(
-				pubkey,
-				verification.PaymentHash,
-				verification.Amount,
-				s.accessDuration,
-			)
-			if err != nil {
-				log.Printf("❌ Failed to add paid access: %v", err)
+			// ZBD retries deliveries it got no answer for, a payment is only granted once
+			if invoice, tracked := s.invoices.Get(verification.PaymentHash); tracked && (invoice.Status == InvoiceStatusGranted || invoice.Status == InvoiceStatusRefused) {
+				s.with(paymentHashAttr(verification.PaymentHash)).logInfo("🔁 Duplicate ZBD webhook for payment %.16s..., already handled", verification.PaymentHash)
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte("OK"))
+				return
+			}
+
+			// Grant access, failures are retried from the queue rather than by ZBD
+			if err := s.grantWebhookPayment(r.Context(), pubkey, verification); err != nil {
 				http.Error(w, "Failed to grant access", http.StatusInternalServerError)
 				return
 			}
 
-			atomic.AddUint64(&s.successfulPayments, 1)
-			log.Printf("💰 Webhook processed: access granted for pubkey: %s...", pubkey[:16])
+			s.with(pubkeyAttr(pubkey)).logInfo("💰 Webhook processed for pubkey: %s...", pubkey[:16])
 		}
 	} else {
-		log.Printf("❌ ZBD webhook received but provider is not ZBD")
+		s.logError("❌ ZBD webhook received but provider is not ZBD")
 		http.Error(w, "Invalid webhook for current provider", http.StatusBadRequest)
 		return
 	}
@@ -111,9 +231,73 @@ func (s *System) zbdWebhookHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("OK"))
 }
 
-// debugPaymentsHandler provides payment statistics
+// zbdWebhookAuthorized checks the ZBD_WEBHOOK_SECRET a delivery carries in its secret query
+// parameter or X-Webhook-Secret header. Without a configured secret every delivery passes,
+// HandleWebhook still confirms the charge with the ZBD API.
+func (s *System) zbdWebhookAuthorized(r *http.Request) bool {
+	if s.config.ZBDWebhookSecret == "" {
+		return true
+	}
+	secret := r.URL.Query().Get("secret")
+	if secret == "" {
+		secret = r.Header.Get("X-Webhook-Secret")
+	}
+	return subtle.ConstantTimeCompare([]byte(secret), []byte(s.config.ZBDWebhookSecret)) == 1
+}
+
+// blinkWebhookHandler grants access when Blink reports a membership invoice as received
+func (s *System) blinkWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		s.logError("❌ Failed to read Blink webhook body: %v", err)
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	blinkProvider, ok := s.blinkProvider()
+	if !ok {
+		s.logError("❌ Blink webhook received but no Blink provider is configured")
+		http.Error(w, "Invalid webhook for current provider", http.StatusBadRequest)
+		return
+	}
+
+	paymentHash, err := blinkProvider.HandleWebhook(r.Header, body)
+	if err != nil {
+		s.logError("❌ Failed to process Blink webhook: %v", err)
+		http.Error(w, "Invalid webhook", http.StatusBadRequest)
+		return
+	}
+
+	// Only pending membership invoices are granted, top-ups and donations are handled elsewhere
+	invoice, tracked := s.invoices.Get(paymentHash)
+	if paymentHash == "" || !tracked || !invoice.pending() || invoice.Pubkey == "" {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+		return
+	}
+
+	// Confirm with the API, the webhook only says which invoice to look at
+	verification, err := s.provider.VerifyPayment(r.Context(), paymentHash)
+	if err != nil {
+		s.with(paymentHashAttr(paymentHash)).logError("❌ Failed to verify Blink payment %.16s...: %v", paymentHash, err)
+		http.Error(w, "Failed to verify payment", http.StatusInternalServerError)
+		return
+	}
+	if verification.Paid {
+		if err := s.grantWebhookPayment(r.Context(), invoice.Pubkey, verification); err != nil {
+			http.Error(w, "Failed to grant access", http.StatusInternalServerError)
+			return
+		}
+		s.with(pubkeyAttr(invoice.Pubkey)).logInfo("💰 Blink webhook processed for pubkey: %s...", invoice.Pubkey[:16])
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+// debugPaymentsHandler renders the stats of GET /stats as text for humans
 func (s *System) debugPaymentsHandler(w http.ResponseWriter, r *http.Request) {
-	stats := s.GetStats()
+	stats := s.Snapshot()
 
 	paymentStats := fmt.Sprintf(`Payment Statistics:
 
@@ -122,6 +306,21 @@ Successful Payments: %v
 Total Paid Members: %v
 Active Paid Members: %v
 Expired Paid Members: %v
+Members by Tier: %v
+Members by Source: %v
+Remaining Membership Time: %v
+Total Revenue: %v msat (%v ledger payments)
+Revenue by Provider: %v
+Revenue by Tier: %v
+Paid Events by Kind: %v
+
+Invoices Created: %v
+Invoices Paid: %v
+Invoices Abandoned: %v (rate %.1f%%)
+Invoices Pending: %v
+Average Invoice Lifetime: %.0fs
+Abandoning Pubkeys: %v (%v paid later)
+Time to Pay: p50 %.0fs / p90 %.0fs / p99 %.0fs (%v samples)
 
 Payment Configuration:
 Lightning Address: %v
@@ -129,18 +328,112 @@ Payment Amount: %v msat (%v sats)
 Access Duration: %v
 Provider: %v
 `,
-		stats["payment_requests"],
-		stats["successful_payments"],
-		stats["total_members"],
-		stats["active_members"],
-		stats["expired_members"],
-		stats["lightning_address"],
-		stats["payment_amount_msat"],
-		stats["payment_amount_sats"],
-		stats["access_duration"],
-		stats["provider"],
+		stats.PaymentRequests,
+		stats.SuccessfulPayments,
+		stats.Members.Total,
+		stats.Members.Active,
+		stats.Members.Expired,
+		formatMemberCounts(stats.Members.ByTier),
+		formatMemberCounts(stats.Members.BySource),
+		formatHistogram(stats.Members.Remaining),
+		stats.Revenue.TotalMsat,
+		stats.Revenue.Payments,
+		formatRevenue(stats.Revenue.ByProvider),
+		formatRevenue(stats.Revenue.ByTier),
+		formatKindUsage(stats.EventsByKind),
+		stats.Invoices.Created,
+		stats.Invoices.Paid,
+		stats.Invoices.Abandoned,
+		stats.Invoices.AbandonmentRate*100,
+		stats.Invoices.Pending,
+		stats.Invoices.AvgLifetimeSeconds,
+		stats.Invoices.AbandoningPubkeys,
+		stats.Invoices.AbandoningPubkeysPaidLate,
+		stats.Invoices.TimeToPayP50Seconds,
+		stats.Invoices.TimeToPayP90Seconds,
+		stats.Invoices.TimeToPayP99Seconds,
+		stats.Invoices.TimeToPaySamples,
+		stats.LightningAddress,
+		stats.PaymentAmountMsat,
+		stats.PaymentAmountMsat/1000,
+		stats.AccessDuration,
+		stats.Provider,
 	)
 
 	w.Header().Set("Content-Type", "text/plain")
 	w.Write([]byte(paymentStats))
 }
+
+// formatRevenue renders a revenue breakdown as "name: N payments / X msat" pairs
+func formatRevenue(totals map[string]RevenueTotals) string {
+	if len(totals) == 0 {
+		return "none"
+	}
+
+	names := make([]string, 0, len(totals))
+	for name := range totals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s: %d payments / %d msat", name, totals[name].Payments, totals[name].AmountMsat))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// formatKindUsage renders per-kind usage as "kind (name): N events / M members" pairs
+func formatKindUsage(usage map[string]KindUsage) string {
+	if len(usage) == 0 {
+		return "none"
+	}
+
+	kinds := make([]KindUsage, 0, len(usage))
+	for _, entry := range usage {
+		kinds = append(kinds, entry)
+	}
+	sort.Slice(kinds, func(i, j int) bool { return kinds[i].Events > kinds[j].Events })
+
+	parts := make([]string, 0, len(kinds))
+	for _, entry := range kinds {
+		label := fmt.Sprintf("%d", entry.Kind)
+		if entry.Name != "" {
+			label = fmt.Sprintf("%d (%s)", entry.Kind, entry.Name)
+		}
+		parts = append(parts, fmt.Sprintf("%s: %d events / %d members", label, entry.Events, entry.Members))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// formatMemberCounts renders member counts per tier or source as "name: N" pairs
+func formatMemberCounts(counts map[string]int) string {
+	if len(counts) == 0 {
+		return "none"
+	}
+
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s: %d", name, counts[name]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// formatHistogram renders the remaining-time histogram as "range: N members" pairs
+func formatHistogram(buckets []RemainingTimeBucket) string {
+	if len(buckets) == 0 {
+		return "none"
+	}
+
+	parts := make([]string, 0, len(buckets))
+	for _, bucket := range buckets {
+		parts = append(parts, fmt.Sprintf("%s: %d", bucket.Label, bucket.Members))
+	}
+	return strings.Join(parts, ", ")
+}
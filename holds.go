@@ -0,0 +1,159 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Audit log actions for holds
+const (
+	holdAuditAction    = "hold"
+	releaseAuditAction = "release"
+)
+
+// holdRejectMessage is sent to members whose membership is on hold
+const holdRejectMessage = "membership on hold pending dispute resolution"
+
+// PlaceHold suspends a pubkey's membership without deleting it, e.g. while a fiat payment is
+// disputed. The hold is lifted automatically at releaseAt, or stays until ReleaseHold if
+// releaseAt is zero. OnAccessRevoked fires with reason "hold".
+func (s *System) PlaceHold(ctx context.Context, pubkey, reason string, releaseAt time.Time) (*PaidAccessMember, error) {
+	if reason == "" {
+		return nil, fmt.Errorf("reason required to place a hold")
+	}
+	if !releaseAt.IsZero() && !releaseAt.After(time.Now()) {
+		return nil, fmt.Errorf("release date must be in the future")
+	}
+
+	member, err := s.paidAccessStorage.PlaceHold(pubkey, reason, releaseAt)
+	if err != nil {
+		return nil, err
+	}
+
+	s.recordAudit(AuditEntry{Action: holdAuditAction, Pubkey: pubkey, Actor: "admin", Reason: reason})
+	s.with(pubkeyAttr(pubkey)).logInfo("⏸️ Placed membership of %s... on hold (%s)", pubkey[:16], reason)
+
+	s.fireAccessRevoked(context.WithoutCancel(ctx), AccessEvent{Pubkey: pubkey, Member: *member, Reason: "hold"})
+	return member, nil
+}
+
+// ReleaseHold lifts the hold on a pubkey's membership once a dispute is resolved in the
+// member's favour. The membership is extended by the time spent on hold and
+// OnAccessGranted fires with reason "hold_released". Revoke the membership instead
+// if the dispute was lost.
+func (s *System) ReleaseHold(ctx context.Context, pubkey, reason string) (*PaidAccessMember, error) {
+	member, err := s.paidAccessStorage.ReleaseHold(pubkey)
+	if err != nil {
+		return nil, err
+	}
+
+	s.recordAudit(AuditEntry{Action: releaseAuditAction, Pubkey: pubkey, Actor: "admin", Reason: reason})
+	s.with(pubkeyAttr(pubkey)).logInfo("▶️ Released membership of %s... from hold", pubkey[:16])
+
+	s.fireAccessGranted(context.WithoutCancel(ctx), AccessEvent{Pubkey: pubkey, Member: *member, Reason: "hold_released"})
+	return member, nil
+}
+
+// Holds returns all memberships currently on hold, oldest hold first
+func (s *System) Holds() []PaidAccessMember {
+	return s.paidAccessStorage.Holds()
+}
+
+// releaseDueHolds lifts holds past their release date and returns how many it released
+func (s *System) releaseDueHolds(ctx context.Context) int {
+	released, err := s.paidAccessStorage.ReleaseDueHolds()
+	if err != nil {
+		s.logError("❌ Error releasing held memberships: %v", err)
+	}
+	for _, member := range released {
+		s.recordAudit(AuditEntry{Action: releaseAuditAction, Pubkey: member.Pubkey, Actor: "system", Reason: "release date reached"})
+		s.fireAccessGranted(ctx, AccessEvent{Pubkey: member.Pubkey, Member: member, Reason: "hold_released"})
+	}
+	return len(released)
+}
+
+// recordAudit writes an audit entry, logging instead of failing since the change happened already
+func (s *System) recordAudit(entry AuditEntry) {
+	if err := s.audit.Record(entry); err != nil {
+		s.logWarn("⚠️ Failed to record %s in audit log: %v", entry.Action, err)
+	}
+}
+
+// adminHoldsHandler lists memberships on hold
+func (s *System) adminHoldsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"holds": s.Holds(),
+	})
+}
+
+// adminPlaceHoldHandler places a membership on hold, until release_at or for duration if given
+func (s *System) adminPlaceHoldHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Pubkey    string    `json:"pubkey"`
+		Reason    string    `json:"reason"`
+		ReleaseAt time.Time `json:"release_at"`
+		Duration  string    `json:"duration"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	pubkey, err := parsePubkey(req.Pubkey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Duration != "" {
+		duration, err := time.ParseDuration(req.Duration)
+		if err != nil || duration <= 0 {
+			http.Error(w, fmt.Sprintf("invalid duration: %s", req.Duration), http.StatusBadRequest)
+			return
+		}
+		req.ReleaseAt = time.Now().Add(duration)
+	}
+
+	member, err := s.PlaceHold(r.Context(), pubkey, req.Reason, req.ReleaseAt)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"held":   true,
+		"member": member,
+	})
+}
+
+// adminReleaseHoldHandler lifts the hold on a membership
+func (s *System) adminReleaseHoldHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Pubkey string `json:"pubkey"`
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	pubkey, err := parsePubkey(req.Pubkey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	member, err := s.ReleaseHold(r.Context(), pubkey, req.Reason)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"released": true,
+		"member":   member,
+	})
+}
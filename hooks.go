@@ -0,0 +1,154 @@
+package payments
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// PaymentEvent describes a settled payment
+type PaymentEvent struct {
+	Pubkey      string    `json:"pubkey"`
+	PaymentHash string    `json:"payment_hash"`
+	Amount      int64     `json:"amount"`
+	Provider    string    `json:"provider"`
+	Tier        string    `json:"tier,omitempty"`
+	PaidAt      time.Time `json:"paid_at"`
+}
+
+// AccessEvent describes a change to a pubkey's membership
+type AccessEvent struct {
+	Pubkey string           `json:"pubkey"`
+	Member PaidAccessMember `json:"member"`
+	Reason string           `json:"reason,omitempty"`
+}
+
+// lifecycleHooks holds callbacks registered by the embedding relay
+type lifecycleHooks struct {
+	mutex           sync.RWMutex
+	paymentReceived []func(context.Context, PaymentEvent)
+	accessGranted   []func(context.Context, AccessEvent)
+	accessExpired   []func(context.Context, AccessEvent)
+	accessRevoked   []func(context.Context, AccessEvent)
+	zapReceipt      []func(context.Context, *nostr.Event)
+	deniedPayment   []func(context.Context, PaymentEvent)
+}
+
+// OnPaymentReceived registers a callback invoked for every settled payment, before access is granted
+func (s *System) OnPaymentReceived(fn func(ctx context.Context, payment PaymentEvent)) {
+	s.hooks.mutex.Lock()
+	defer s.hooks.mutex.Unlock()
+	s.hooks.paymentReceived = append(s.hooks.paymentReceived, fn)
+}
+
+// OnAccessGranted registers a callback invoked after a pubkey was granted access
+func (s *System) OnAccessGranted(fn func(ctx context.Context, access AccessEvent)) {
+	s.hooks.mutex.Lock()
+	defer s.hooks.mutex.Unlock()
+	s.hooks.accessGranted = append(s.hooks.accessGranted, fn)
+}
+
+// OnAccessExpired registers a callback invoked when an expired membership is cleaned up
+func (s *System) OnAccessExpired(fn func(ctx context.Context, access AccessEvent)) {
+	s.hooks.mutex.Lock()
+	defer s.hooks.mutex.Unlock()
+	s.hooks.accessExpired = append(s.hooks.accessExpired, fn)
+}
+
+// OnAccessRevoked registers a callback invoked after a membership was revoked
+func (s *System) OnAccessRevoked(fn func(ctx context.Context, access AccessEvent)) {
+	s.hooks.mutex.Lock()
+	defer s.hooks.mutex.Unlock()
+	s.hooks.accessRevoked = append(s.hooks.accessRevoked, fn)
+}
+
+// OnZapReceipt registers a callback invoked with every zap receipt the relay signs, e.g. to store it locally
+func (s *System) OnZapReceipt(fn func(ctx context.Context, receipt *nostr.Event)) {
+	s.hooks.mutex.Lock()
+	defer s.hooks.mutex.Unlock()
+	s.hooks.zapReceipt = append(s.hooks.zapReceipt, fn)
+}
+
+// OnDeniedPayment registers a callback invoked when a payment settles for a denylisted pubkey
+// and access is refused, e.g. to refund it
+func (s *System) OnDeniedPayment(fn func(ctx context.Context, payment PaymentEvent)) {
+	s.hooks.mutex.Lock()
+	defer s.hooks.mutex.Unlock()
+	s.hooks.deniedPayment = append(s.hooks.deniedPayment, fn)
+}
+
+// firePaymentReceived runs the payment received callbacks
+func (s *System) firePaymentReceived(ctx context.Context, payment PaymentEvent) {
+	s.hooks.mutex.RLock()
+	callbacks := s.hooks.paymentReceived
+	s.hooks.mutex.RUnlock()
+
+	for _, fn := range callbacks {
+		s.runHook("OnPaymentReceived", func() { fn(ctx, payment) })
+	}
+}
+
+// fireAccessGranted runs the access granted callbacks
+func (s *System) fireAccessGranted(ctx context.Context, access AccessEvent) {
+	s.hooks.mutex.RLock()
+	callbacks := s.hooks.accessGranted
+	s.hooks.mutex.RUnlock()
+	s.runAccessHooks(ctx, "OnAccessGranted", callbacks, access)
+}
+
+// fireAccessExpired runs the access expired callbacks
+func (s *System) fireAccessExpired(ctx context.Context, access AccessEvent) {
+	s.hooks.mutex.RLock()
+	callbacks := s.hooks.accessExpired
+	s.hooks.mutex.RUnlock()
+	s.runAccessHooks(ctx, "OnAccessExpired", callbacks, access)
+}
+
+// fireAccessRevoked runs the access revoked callbacks
+func (s *System) fireAccessRevoked(ctx context.Context, access AccessEvent) {
+	s.hooks.mutex.RLock()
+	callbacks := s.hooks.accessRevoked
+	s.hooks.mutex.RUnlock()
+	s.runAccessHooks(ctx, "OnAccessRevoked", callbacks, access)
+}
+
+// fireZapReceipt runs the zap receipt callbacks
+func (s *System) fireZapReceipt(ctx context.Context, receipt *nostr.Event) {
+	s.hooks.mutex.RLock()
+	callbacks := s.hooks.zapReceipt
+	s.hooks.mutex.RUnlock()
+
+	for _, fn := range callbacks {
+		s.runHook("OnZapReceipt", func() { fn(ctx, receipt) })
+	}
+}
+
+// fireDeniedPayment runs the denied payment callbacks
+func (s *System) fireDeniedPayment(ctx context.Context, payment PaymentEvent) {
+	s.hooks.mutex.RLock()
+	callbacks := s.hooks.deniedPayment
+	s.hooks.mutex.RUnlock()
+
+	for _, fn := range callbacks {
+		s.runHook("OnDeniedPayment", func() { fn(ctx, payment) })
+	}
+}
+
+// runAccessHooks calls each access callback in registration order
+func (s *System) runAccessHooks(ctx context.Context, name string, callbacks []func(context.Context, AccessEvent), access AccessEvent) {
+	for _, fn := range callbacks {
+		s.runHook(name, func() { fn(ctx, access) })
+	}
+}
+
+// runHook calls a user callback, keeping a panic in it from taking down the relay
+func (s *System) runHook(name string, call func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.logError("❌ %s hook panicked: %v", name, r)
+		}
+	}()
+	call()
+}
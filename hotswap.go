@@ -0,0 +1,366 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Provider swap limits
+const (
+	providerDrainTimeout = 30 * time.Second // longest a swap waits for calls to the old provider
+	providerDrainPoll    = 50 * time.Millisecond
+	issuerRetention      = 48 * time.Hour // how long the provider that issued an invoice is remembered
+)
+
+// providerGeneration is one provider setup, counting the calls still running against it
+type providerGeneration struct {
+	provider PaymentProvider
+	config   Config // provider settings the generation was built from
+	inflight int64
+}
+
+// issuedInvoice remembers the generation that created an invoice
+type issuedInvoice struct {
+	generation *providerGeneration
+	createdAt  time.Time
+}
+
+// providerSwitch forwards to the active provider and lets it be replaced at runtime.
+// Invoices keep being verified by the generation that issued them until issuerRetention.
+type providerSwitch struct {
+	mu       sync.RWMutex
+	current  *providerGeneration
+	issuedBy map[string]issuedInvoice // payment hash -> issuing generation
+
+	// observe reports how long each provider call took, if set
+	observe func(provider, op string, elapsed time.Duration)
+
+	// retries retries transient failures of provider calls, nil calls once
+	retries *retryPolicies
+
+	// breaker pauses invoice creation and payment checks while the active provider keeps
+	// failing, nil never pauses
+	breaker *circuitBreaker
+}
+
+// newProviderSwitch creates a switch forwarding to provider
+func newProviderSwitch(provider PaymentProvider, config Config) *providerSwitch {
+	return &providerSwitch{
+		current:  &providerGeneration{provider: provider, config: config},
+		issuedBy: make(map[string]issuedInvoice),
+	}
+}
+
+// acquire returns the active generation, counting the caller as in flight until release
+func (ps *providerSwitch) acquire() *providerGeneration {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	atomic.AddInt64(&ps.current.inflight, 1)
+	return ps.current
+}
+
+// acquireIssuer returns the generation that issued a payment hash, the active one if unknown
+func (ps *providerSwitch) acquireIssuer(paymentHash string) *providerGeneration {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	generation := ps.current
+	if issued, exists := ps.issuedBy[paymentHash]; exists {
+		generation = issued.generation
+	}
+	atomic.AddInt64(&generation.inflight, 1)
+	return generation
+}
+
+// release ends a call started with acquire or acquireIssuer
+func (g *providerGeneration) release() {
+	atomic.AddInt64(&g.inflight, -1)
+}
+
+// timed reports how long a call to generation's provider took, deferred with its start time
+func (ps *providerSwitch) timed(generation *providerGeneration, op string, start time.Time) {
+	if ps.observe != nil {
+		ps.observe(generation.provider.GetProviderName(), op, time.Since(start))
+	}
+}
+
+// guarded runs a call to the active provider, retrying transient failures, unless the
+// circuit breaker paused calls to it
+func (ps *providerSwitch) guarded(ctx context.Context, generation *providerGeneration, op string, call func() error) error {
+	if !ps.breaker.allow(time.Now()) {
+		return newCircuitOpenError(generation.provider.GetProviderName(), op)
+	}
+	err := ps.retries.do(ctx, call)
+	ps.breaker.record(err, time.Now())
+	return err
+}
+
+// retryAfter returns the seconds until the circuit breaker lets a call through again
+func (ps *providerSwitch) retryAfter() int {
+	wait := time.Until(ps.breaker.pausedUntil())
+	return max(int(wait.Round(time.Second)/time.Second), 1)
+}
+
+// record remembers that generation issued an invoice
+func (ps *providerSwitch) record(invoice *Invoice, generation *providerGeneration) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.issuedBy[invoice.PaymentHash] = issuedInvoice{generation: generation, createdAt: time.Now()}
+}
+
+// issuerOf returns the provider that issued a payment hash, the active one if unknown
+func (ps *providerSwitch) issuerOf(paymentHash string) PaymentProvider {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	if issued, exists := ps.issuedBy[paymentHash]; exists {
+		return issued.generation.provider
+	}
+	return ps.current.provider
+}
+
+// Current returns the active provider
+func (ps *providerSwitch) Current() PaymentProvider {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	return ps.current.provider
+}
+
+// Config returns the provider settings of the active generation
+func (ps *providerSwitch) Config() Config {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	return ps.current.config
+}
+
+// Swap makes provider the active one and waits for calls to the previous provider to
+// finish. It reports whether they did within providerDrainTimeout.
+func (ps *providerSwitch) Swap(ctx context.Context, provider PaymentProvider, config Config) bool {
+	ps.mu.Lock()
+	previous := ps.current
+	ps.current = &providerGeneration{provider: provider, config: config}
+	ps.mu.Unlock()
+	ps.breaker.reset()
+
+	ctx, cancel := context.WithTimeout(ctx, providerDrainTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(providerDrainPoll)
+	defer ticker.Stop()
+	for atomic.LoadInt64(&previous.inflight) > 0 {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+	return true
+}
+
+// Prune forgets the issuers of invoices created before cutoff, letting retired providers go
+func (ps *providerSwitch) Prune(cutoff time.Time) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	for hash, issued := range ps.issuedBy {
+		if issued.createdAt.Before(cutoff) {
+			delete(ps.issuedBy, hash)
+		}
+	}
+}
+
+// Retired counts the remembered invoices issued by providers that are no longer active
+func (ps *providerSwitch) Retired() int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	retired := 0
+	for _, issued := range ps.issuedBy {
+		if issued.generation != ps.current {
+			retired++
+		}
+	}
+	return retired
+}
+
+// CreateInvoice creates the invoice with the active provider
+func (ps *providerSwitch) CreateInvoice(ctx context.Context, amount int64, description string, pubkey string) (*Invoice, error) {
+	generation := ps.acquire()
+	defer generation.release()
+	defer ps.timed(generation, OpCreateInvoice, time.Now())
+
+	var invoice *Invoice
+	err := ps.guarded(ctx, generation, OpCreateInvoice, func() (err error) {
+		invoice, err = generation.provider.CreateInvoice(ctx, amount, description, pubkey)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	ps.record(invoice, generation)
+	return invoice, nil
+}
+
+// CreateInvoiceWithDescriptionHash creates a description hash invoice with the active provider
+func (ps *providerSwitch) CreateInvoiceWithDescriptionHash(ctx context.Context, amount int64, descriptionHash string, pubkey string) (*Invoice, error) {
+	generation := ps.acquire()
+	defer generation.release()
+
+	provider, ok := generation.provider.(DescriptionHashProvider)
+	if !ok {
+		return nil, fmt.Errorf("%s provider cannot create description hash invoices", generation.provider.GetProviderName())
+	}
+	defer ps.timed(generation, OpCreateInvoice, time.Now())
+	var invoice *Invoice
+	err := ps.guarded(ctx, generation, OpCreateInvoice, func() (err error) {
+		invoice, err = provider.CreateInvoiceWithDescriptionHash(ctx, amount, descriptionHash, pubkey)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	ps.record(invoice, generation)
+	return invoice, nil
+}
+
+// CreateAmountlessInvoice creates an amountless invoice with the active provider
+func (ps *providerSwitch) CreateAmountlessInvoice(ctx context.Context, description string, pubkey string) (*Invoice, error) {
+	generation := ps.acquire()
+	defer generation.release()
+
+	provider, ok := generation.provider.(AmountlessInvoiceProvider)
+	if !ok {
+		return nil, fmt.Errorf("%s provider cannot create amountless invoices", generation.provider.GetProviderName())
+	}
+	defer ps.timed(generation, OpCreateInvoice, time.Now())
+	var invoice *Invoice
+	err := ps.guarded(ctx, generation, OpCreateInvoice, func() (err error) {
+		invoice, err = provider.CreateAmountlessInvoice(ctx, description, pubkey)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	ps.record(invoice, generation)
+	return invoice, nil
+}
+
+// VerifyPayment asks the provider that issued the payment hash
+func (ps *providerSwitch) VerifyPayment(ctx context.Context, paymentHash string) (*PaymentVerification, error) {
+	generation := ps.acquireIssuer(paymentHash)
+	defer generation.release()
+	defer ps.timed(generation, OpVerifyPayment, time.Now())
+
+	var verification *PaymentVerification
+	err := ps.retries.do(ctx, func() (err error) {
+		verification, err = generation.provider.VerifyPayment(ctx, paymentHash)
+		return err
+	})
+	return verification, err
+}
+
+// CheckExistingPayments asks the active provider
+func (ps *providerSwitch) CheckExistingPayments(ctx context.Context, pubkey string) (*PaymentVerification, error) {
+	generation := ps.acquire()
+	defer generation.release()
+	defer ps.timed(generation, OpVerifyPayment, time.Now())
+
+	var verification *PaymentVerification
+	err := ps.guarded(ctx, generation, OpVerifyPayment, func() (err error) {
+		verification, err = generation.provider.CheckExistingPayments(ctx, pubkey)
+		return err
+	})
+	return verification, err
+}
+
+// GetProviderName returns the name of the active provider
+func (ps *providerSwitch) GetProviderName() string {
+	return ps.Current().GetProviderName()
+}
+
+// buildProvider creates the provider, or provider router, described by config, calling
+// HTTP APIs with client and logging to logger
+func buildProvider(config *Config, invoiceStore *InvoiceStore, client *http.Client, logger *slog.Logger) (PaymentProvider, error) {
+	var provider PaymentProvider
+	var err error
+	if len(config.ProviderRoutes) == 0 {
+		provider, err = newProvider(config, invoiceStore)
+	} else {
+		if err := validateProviderRoutes(config.ProviderRoutes); err != nil {
+			return nil, err
+		}
+		provider, err = newRoutingProvider(config, config.ProviderRoutes, invoiceStore)
+	}
+	if err != nil {
+		return nil, err
+	}
+	useHTTPClient(provider, client)
+	useLogger(provider, logger)
+	return provider, nil
+}
+
+// ReconfigureProvider replaces the active provider without a restart, using the provider
+// settings of config (Provider, its credentials and ProviderRoutes). Invoices issued
+// before the swap are still verified by the provider that issued them.
+func (s *System) ReconfigureProvider(ctx context.Context, config Config) error {
+	client, err := newHTTPClient(&config)
+	if err != nil {
+		return err
+	}
+	provider, err := buildProvider(&config, s.invoices, client, s.currentLogger())
+	if err != nil {
+		return fmt.Errorf("failed to initialize %s provider: %w", config.Provider, err)
+	}
+
+	previous := s.switcher.Current().GetProviderName()
+	if !s.switcher.Swap(ctx, provider, config) {
+		s.logWarn("⚠️ Calls to the %s provider still running after the swap", previous)
+	}
+	s.logInfo("🔀 Switched payment provider from %s to %s", previous, provider.GetProviderName())
+	return nil
+}
+
+// providerStatus describes the active provider setup without credentials
+func (s *System) providerStatus() map[string]interface{} {
+	config := s.switcher.Config()
+	routes := config.ProviderRoutes
+	if routes == nil {
+		routes = []ProviderRoute{}
+	}
+	return map[string]interface{}{
+		"provider":         config.Provider,
+		"provider_name":    s.switcher.GetProviderName(),
+		"routes":           routes,
+		"retired_invoices": s.switcher.Retired(),
+	}
+}
+
+// adminProviderHandler returns the active provider setup
+func (s *System) adminProviderHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.providerStatus())
+}
+
+// adminSwapProviderHandler switches or reconfigures the provider. The body holds the
+// provider settings to change, in Config's JSON form; settings left out are kept.
+func (s *System) adminSwapProviderHandler(w http.ResponseWriter, r *http.Request) {
+	config := s.switcher.Config()
+	// Decode into copies so the running config is never modified in place
+	config.ProviderRoutes = append([]ProviderRoute(nil), config.ProviderRoutes...)
+	config.Tiers = nil
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.ReconfigureProvider(r.Context(), config); err != nil {
+		s.logError("❌ Provider swap failed: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.providerStatus())
+}
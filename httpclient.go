@@ -0,0 +1,114 @@
+package payments
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// defaultHTTPTimeout bounds provider API calls unless configured otherwise
+const defaultHTTPTimeout = 30 * time.Second
+
+// maxIdleConnsPerHost is how many connections to a provider API are kept open for reuse
+const maxIdleConnsPerHost = 16
+
+// defaultHTTPClient is shared by providers created without a Config, e.g. with
+// NewZBDProvider, so their calls reuse connections too
+var defaultHTTPClient = &http.Client{Timeout: defaultHTTPTimeout, Transport: newPooledTransport()}
+
+// newPooledTransport clones the default transport, keeping more idle connections per host
+func newPooledTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = maxIdleConnsPerHost
+	return transport
+}
+
+// newHTTPClient creates the connection-pooled client the providers of config share, with
+// its HTTP timeout, proxy and TLS settings
+func newHTTPClient(config *Config) (*http.Client, error) {
+	timeout := defaultHTTPTimeout
+	if config.HTTPTimeout != "" {
+		parsed, err := time.ParseDuration(config.HTTPTimeout)
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("invalid HTTP timeout: %s", config.HTTPTimeout)
+		}
+		timeout = parsed
+	}
+
+	transport := newPooledTransport()
+	// Without a proxy of its own, the client follows HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+	if config.HTTPProxy != "" {
+		proxyURL, err := url.Parse(config.HTTPProxy)
+		if err != nil || proxyURL.Scheme == "" || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid HTTP proxy: %s", config.HTTPProxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if config.HTTPCAFile != "" || config.HTTPInsecureSkipVerify {
+		tlsConfig := &tls.Config{InsecureSkipVerify: config.HTTPInsecureSkipVerify}
+		if config.HTTPCAFile != "" {
+			pem, err := os.ReadFile(config.HTTPCAFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read HTTP CA file: %w", err)
+			}
+			// Trust the extra certificates on top of the system's
+			certPool, err := x509.SystemCertPool()
+			if err != nil {
+				certPool = x509.NewCertPool()
+			}
+			if !certPool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in HTTP CA file %s", config.HTTPCAFile)
+			}
+			tlsConfig.RootCAs = certPool
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return &http.Client{Timeout: timeout, Transport: transport}, nil
+}
+
+// httpClientUser is implemented by providers calling HTTP APIs, so they use the client
+// built from the Config instead of the default one
+type httpClientUser interface {
+	setHTTPClient(client *http.Client)
+}
+
+// useHTTPClient hands client to provider if it calls HTTP APIs
+func useHTTPClient(provider PaymentProvider, client *http.Client) {
+	if user, ok := provider.(httpClientUser); ok {
+		user.setHTTPClient(client)
+	}
+}
+
+// withMinTimeout returns client, or a copy of it allowing calls to take at least timeout
+func withMinTimeout(client *http.Client, timeout time.Duration) *http.Client {
+	if client.Timeout == 0 || client.Timeout >= timeout {
+		return client
+	}
+	longer := *client
+	longer.Timeout = timeout
+	return &longer
+}
+
+// providerHTTP holds the HTTP client of a provider, embedding it implements httpClientUser
+type providerHTTP struct {
+	client *http.Client
+}
+
+// setHTTPClient makes the provider call its API with client
+func (p *providerHTTP) setHTTPClient(client *http.Client) {
+	p.client = client
+}
+
+// httpClient returns the client given with setHTTPClient, else the shared default
+func (p *providerHTTP) httpClient() *http.Client {
+	if p.client == nil {
+		return defaultHTTPClient
+	}
+	return p.client
+}
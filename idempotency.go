@@ -0,0 +1,231 @@
+package payments
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// idempotencyKeyHeader is the request header naming a retryable request
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// idempotentReplayHeader marks responses replayed from the cache
+const idempotentReplayHeader = "Idempotent-Replayed"
+
+// maxIdempotencyKeyLength bounds keys so clients can't grow the cache with huge ones
+const maxIdempotencyKeyLength = 255
+
+// maxIdempotencyEntries bounds the responses kept, so clients can't grow the cache with
+// fresh keys
+const maxIdempotencyEntries = 10000
+
+// maxIdempotentBodySize bounds the request bodies read to fingerprint them
+const maxIdempotentBodySize = 1 << 20
+
+// errIdempotencyCacheFull is returned by begin when every kept response is still in flight
+var errIdempotencyCacheFull = errors.New("too many requests in flight")
+
+// idempotentResponse is the recorded response to a request, done is closed once it's complete
+type idempotentResponse struct {
+	done      chan struct{}
+	bodyHash  [sha256.Size]byte // of the request, retries must send the same body
+	status    int
+	header    http.Header
+	body      []byte
+	expiresAt time.Time
+}
+
+// idempotencyCache replays responses to requests retried with the same Idempotency-Key
+type idempotencyCache struct {
+	mutex     sync.Mutex
+	window    time.Duration
+	responses map[string]*idempotentResponse // method, path and key -> response
+}
+
+// newIdempotencyCache creates a cache keeping responses for window
+func newIdempotencyCache(window time.Duration) *idempotencyCache {
+	return &idempotencyCache{
+		window:    window,
+		responses: make(map[string]*idempotentResponse),
+	}
+}
+
+// begin returns the response recorded for key, or a new one to record for a request with
+// bodyHash if the caller is first. Callers that are not first must wait on done before
+// replaying. With the cache full, the response closest to expiry is dropped.
+func (c *idempotencyCache) begin(key string, bodyHash [sha256.Size]byte) (*idempotentResponse, bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	if response, exists := c.responses[key]; exists {
+		if response.expiresAt.IsZero() || now.Before(response.expiresAt) {
+			return response, false, nil
+		}
+		delete(c.responses, key)
+	}
+	if len(c.responses) >= maxIdempotencyEntries && !c.evict(now) {
+		return nil, false, errIdempotencyCacheFull
+	}
+	response := &idempotentResponse{done: make(chan struct{}), bodyHash: bodyHash}
+	c.responses[key] = response
+	return response, true, nil
+}
+
+// evict drops an expired response, or the completed one closest to expiry, reporting
+// whether it found one. Caller holds the mutex.
+func (c *idempotencyCache) evict(now time.Time) bool {
+	oldest := ""
+	var oldestExpiry time.Time
+	for key, response := range c.responses {
+		if response.expiresAt.IsZero() {
+			continue // still in flight
+		}
+		if now.After(response.expiresAt) {
+			delete(c.responses, key)
+			return true
+		}
+		if oldest == "" || response.expiresAt.Before(oldestExpiry) {
+			oldest, oldestExpiry = key, response.expiresAt
+		}
+	}
+	if oldest == "" {
+		return false
+	}
+	delete(c.responses, oldest)
+	return true
+}
+
+// finish stores a completed response for replay, or forgets it if it has no status because
+// it was a server error, so the request can be retried
+func (c *idempotencyCache) finish(key string, response *idempotentResponse) {
+	c.mutex.Lock()
+	if response.status == 0 {
+		delete(c.responses, key)
+	} else {
+		response.expiresAt = time.Now().Add(c.window)
+	}
+	c.mutex.Unlock()
+	close(response.done)
+}
+
+// prune forgets responses whose window passed
+func (c *idempotencyCache) prune(now time.Time) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	pruned := 0
+	for key, response := range c.responses {
+		if !response.expiresAt.IsZero() && now.After(response.expiresAt) {
+			delete(c.responses, key)
+			pruned++
+		}
+	}
+	return pruned
+}
+
+// recordingWriter passes a response through while keeping a copy
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+// idempotent wraps a mutating handler so retries carrying the same Idempotency-Key get the
+// first response again instead of being processed twice. Concurrent retries wait for the
+// first request to finish, and a key reused with a different body is refused with 422.
+// Requests without the header are processed as usual.
+func (s *System) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			http.Error(w, "Idempotency-Key too long", http.StatusBadRequest)
+			return
+		}
+
+		// The body is fingerprinted, then handed to the handler again
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotentBodySize+1))
+		if err != nil {
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+		if len(body) > maxIdempotentBodySize {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		bodyHash := sha256.Sum256(body)
+
+		// Keys are scoped to the endpoint, the same key may be reused elsewhere
+		cacheKey := r.Method + " " + r.URL.Path + " " + key
+		response, first, err := s.idempotency.begin(cacheKey, bodyHash)
+		if err != nil {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too many requests in flight, try again shortly", http.StatusServiceUnavailable)
+			return
+		}
+		if !first {
+			if response.bodyHash != bodyHash {
+				http.Error(w, "Idempotency-Key was already used with a different request body", http.StatusUnprocessableEntity)
+				return
+			}
+			select {
+			case <-response.done:
+			case <-r.Context().Done():
+				return
+			}
+			if response.status == 0 {
+				// The first request failed with a server error, let this one retry
+				s.idempotent(next)(w, r)
+				return
+			}
+			s.logInfo("🔁 Replaying response for %s %s (Idempotency-Key %.16s)", r.Method, r.URL.Path, key)
+			for name, values := range response.header {
+				w.Header()[name] = values
+			}
+			w.Header().Set(idempotentReplayHeader, "true")
+			w.WriteHeader(response.status)
+			w.Write(response.body)
+			return
+		}
+
+		recorder := &recordingWriter{ResponseWriter: w}
+		defer func() {
+			status := recorder.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			// Server errors are not replayed, the retry is processed again
+			if status < http.StatusInternalServerError {
+				response.status = status
+				response.header = recorder.Header().Clone()
+				response.body = recorder.body.Bytes()
+			}
+			s.idempotency.finish(cacheKey, response)
+		}()
+		next(recorder, r)
+	}
+}
@@ -0,0 +1,220 @@
+package payments
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// invoiceCheckInterval is how often a watched invoice is verified with the provider, for
+// providers without webhooks
+const invoiceCheckInterval = 5 * time.Second
+
+// Statuses of an InvoiceUpdate. Invoices go from pending to paid to access_granted, or end
+// refused or expired.
+const (
+	invoiceUpdatePending = "pending"
+	invoiceUpdatePaid    = "paid"
+	invoiceUpdateGranted = "access_granted"
+	invoiceUpdateRefused = "refused"
+	invoiceUpdateExpired = "expired"
+)
+
+// InvoiceUpdate is the state of a membership invoice pushed to clients watching it
+type InvoiceUpdate struct {
+	PaymentHash     string `json:"payment_hash"`
+	Status          string `json:"status"`                      // "pending", "paid", "access_granted", "refused" or "expired"
+	Amount          int64  `json:"amount"`                      // millisatoshis
+	ExpiresAt       int64  `json:"expires_at,omitempty"`        // unix seconds, when the invoice stops being payable
+	PaidAt          int64  `json:"paid_at,omitempty"`           // unix seconds
+	AccessExpiresAt int64  `json:"access_expires_at,omitempty"` // unix seconds, once granted, omitted for permanent access
+}
+
+// final reports whether the invoice won't change anymore
+func (u InvoiceUpdate) final() bool {
+	switch u.Status {
+	case invoiceUpdateGranted, invoiceUpdateRefused, invoiceUpdateExpired:
+		return true
+	}
+	return false
+}
+
+// invoiceHub fans out invoice updates to the clients watching each invoice
+type invoiceHub struct {
+	subscribers map[string]map[chan InvoiceUpdate]struct{} // payment hash -> channels
+	mutex       sync.Mutex
+}
+
+// newInvoiceHub creates a hub without subscribers
+func newInvoiceHub() *invoiceHub {
+	return &invoiceHub{
+		subscribers: make(map[string]map[chan InvoiceUpdate]struct{}),
+	}
+}
+
+// Subscribe registers a new subscriber channel for an invoice
+func (h *invoiceHub) Subscribe(paymentHash string) chan InvoiceUpdate {
+	ch := make(chan InvoiceUpdate, 4)
+	h.Add(paymentHash, ch)
+	return ch
+}
+
+// Add subscribes an existing channel to an invoice, so one client can watch several
+func (h *invoiceHub) Add(paymentHash string, ch chan InvoiceUpdate) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.subscribers[paymentHash] == nil {
+		h.subscribers[paymentHash] = make(map[chan InvoiceUpdate]struct{})
+	}
+	h.subscribers[paymentHash][ch] = struct{}{}
+}
+
+// Unsubscribe removes a subscriber channel
+func (h *invoiceHub) Unsubscribe(paymentHash string, ch chan InvoiceUpdate) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	delete(h.subscribers[paymentHash], ch)
+	if len(h.subscribers[paymentHash]) == 0 {
+		delete(h.subscribers, paymentHash)
+	}
+}
+
+// Publish sends an update to the subscribers of its invoice, dropping it for clients that
+// fall behind
+func (h *invoiceHub) Publish(update InvoiceUpdate) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for ch := range h.subscribers[update.PaymentHash] {
+		select {
+		case ch <- update:
+		default:
+		}
+	}
+}
+
+// invoiceUpdate describes the current state of a tracked membership invoice
+func (s *System) invoiceUpdate(paymentHash string) (InvoiceUpdate, bool) {
+	invoice, tracked := s.invoices.Get(paymentHash)
+	if !tracked || invoice.Status == "" {
+		return InvoiceUpdate{}, false
+	}
+	if invoice.Purpose == PurposeTopUp || invoice.Tier == donationTier {
+		return InvoiceUpdate{}, false // not for a membership
+	}
+
+	update := InvoiceUpdate{
+		PaymentHash: paymentHash,
+		Status:      invoiceUpdatePending,
+		Amount:      invoice.Amount,
+	}
+	if !invoice.ExpiresAt.IsZero() {
+		update.ExpiresAt = invoice.ExpiresAt.Unix()
+	}
+	if !invoice.SettledAt.IsZero() {
+		update.PaidAt = invoice.SettledAt.Unix()
+	}
+
+	switch invoice.Status {
+	case InvoiceStatusPaid:
+		update.Status = invoiceUpdatePaid
+	case InvoiceStatusGranted:
+		update.Status = invoiceUpdateGranted
+		if member, exists := s.paidAccessStorage.GetMember(invoice.Pubkey); exists && !member.ExpiresAt.IsZero() {
+			update.AccessExpiresAt = member.ExpiresAt.Unix()
+		}
+	case InvoiceStatusRefused:
+		update.Status = invoiceUpdateRefused
+	case InvoiceStatusExpired:
+		update.Status = invoiceUpdateExpired
+	default:
+		// Cleanup marks invoices expired only every so often
+		if !invoice.ExpiresAt.IsZero() && time.Now().After(invoice.ExpiresAt) {
+			update.Status = invoiceUpdateExpired
+		}
+	}
+	return update, true
+}
+
+// publishInvoice pushes the current state of an invoice to the clients watching it
+func (s *System) publishInvoice(paymentHash string) {
+	if update, ok := s.invoiceUpdate(paymentHash); ok {
+		s.invoiceHub.Publish(update)
+	}
+}
+
+// checkInvoice asks the provider whether a pending membership invoice was paid, granting
+// access if it was. The grant publishes its own updates.
+func (s *System) checkInvoice(ctx context.Context, paymentHash string) {
+	invoice, tracked := s.invoices.Get(paymentHash)
+	if !tracked || !invoice.pending() || (invoice.Pubkey == "" && invoice.Purpose != PurposeLNAddress) {
+		return
+	}
+	if _, err := s.VerifyPayment(ctx, paymentHash, invoice.Pubkey); err != nil && ctx.Err() == nil && !IsTransient(err) {
+		s.with(paymentHashAttr(paymentHash)).logWarn("⚠️ Failed to check invoice %.16s...: %v", paymentHash, err)
+	}
+}
+
+// invoiceEventsHandler streams the state of a membership invoice as server-sent events,
+// checking with the provider until it is paid
+func (s *System) invoiceEventsHandler(w http.ResponseWriter, r *http.Request) {
+	paymentHash := r.PathValue("payment_hash")
+	if _, ok := s.invoiceUpdate(paymentHash); !ok {
+		http.Error(w, "Invoice not found", http.StatusNotFound)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	// Subscribe before reading the state, so no update falls in between
+	ch := s.invoiceHub.Subscribe(paymentHash)
+	defer s.invoiceHub.Unsubscribe(paymentHash, ch)
+
+	// The user is watching the invoice, so they have seen it
+	s.invoices.MarkSeen(paymentHash)
+
+	update, _ := s.invoiceUpdate(paymentHash)
+	writeSSE(w, update.Status, update)
+	flusher.Flush()
+	if update.final() {
+		return
+	}
+
+	check := time.NewTicker(invoiceCheckInterval)
+	defer check.Stop()
+	heartbeat := time.NewTicker(30 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case update := <-ch:
+			writeSSE(w, update.Status, update)
+			flusher.Flush()
+			if update.final() {
+				return
+			}
+		case <-check.C:
+			s.checkInvoice(r.Context(), paymentHash)
+			if update, ok := s.invoiceUpdate(paymentHash); ok && update.Status == invoiceUpdateExpired {
+				writeSSE(w, update.Status, update)
+				flusher.Flush()
+				return
+			}
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package payments
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// Invoice polling limits
+const (
+	maxPolledInvoices  = 100              // provider lookups per poll, newest invoices first
+	invoicePollGrace   = 5 * time.Minute  // invoices are still polled this long after they expire
+	invoicePollTimeout = 10 * time.Second // per provider lookup
+)
+
+// pollInvoices asks the provider about pending invoices that can still be paid and grants
+// access for the settled ones, returning how many were granted
+func (s *System) pollInvoices(ctx context.Context) int {
+	now := time.Now()
+	var pending []TrackedInvoice
+	for _, invoice := range s.invoices.Pending() {
+		if invoice.Pubkey == "" && invoice.Purpose != PurposeLNAddress {
+			continue // nothing to grant, anonymous donations excepted
+		}
+		if !invoice.ExpiresAt.IsZero() && now.Sub(invoice.ExpiresAt) > invoicePollGrace {
+			continue // left to cleanup reconciliation
+		}
+		pending = append(pending, invoice)
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].CreatedAt.After(pending[j].CreatedAt) })
+	if len(pending) > maxPolledInvoices {
+		pending = pending[:maxPolledInvoices]
+	}
+
+	granted := 0
+	for _, invoice := range pending {
+		callCtx, cancel := context.WithTimeout(ctx, invoicePollTimeout)
+		s.checkInvoice(callCtx, invoice.PaymentHash)
+		cancel()
+
+		if current, ok := s.invoices.Get(invoice.PaymentHash); ok && current.Status == InvoiceStatusGranted {
+			granted++
+		}
+	}
+
+	if granted > 0 {
+		s.logInfo("🔎 Granted access for %d invoices paid without being claimed", granted)
+	}
+	return granted
+}
+
+// runInvoicePoller polls pending invoices every interval, so access is granted even when
+// the user never verifies and no webhook reports the payment
+func (s *System) runInvoicePoller(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.pollInvoices(ctx)
+	}
+}
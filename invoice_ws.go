@@ -0,0 +1,182 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/fasthttp/websocket"
+)
+
+// Timing of /ws/payments connections
+const (
+	paymentsWSWriteWait  = 10 * time.Second
+	paymentsWSPongWait   = 60 * time.Second
+	paymentsWSPingPeriod = 30 * time.Second
+)
+
+// maxPaymentSubscriptions bounds the invoices one /ws/payments connection can watch
+const maxPaymentSubscriptions = 20
+
+// paymentsUpgrader upgrades /ws/payments requests. Payment pages may be served from
+// another origin, and invoice states are no secret to whoever knows the payment hash.
+var paymentsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// paymentsWSRequest is a message sent by a /ws/payments client
+type paymentsWSRequest struct {
+	Action      string `json:"action"` // "subscribe" or "unsubscribe"
+	PaymentHash string `json:"payment_hash"`
+}
+
+// paymentsWSError tells a /ws/payments client a request failed
+type paymentsWSError struct {
+	Error       string `json:"error"`
+	PaymentHash string `json:"payment_hash,omitempty"`
+}
+
+// paymentsWebSocketHandler lets a client subscribe to membership invoices over a WebSocket
+// and pushes an InvoiceUpdate whenever one of them changes, checking pending invoices with
+// the provider until they are paid
+func (s *System) paymentsWebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := paymentsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader answered the client already
+		s.logError("❌ Failed to upgrade payments WebSocket: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	updates := make(chan InvoiceUpdate, 16)
+	subscribed := make(map[string]bool)
+	defer func() {
+		for paymentHash := range subscribed {
+			s.invoiceHub.Unsubscribe(paymentHash, updates)
+		}
+	}()
+
+	send := func(message interface{}) bool {
+		conn.SetWriteDeadline(time.Now().Add(paymentsWSWriteWait))
+		return conn.WriteJSON(message) == nil
+	}
+	unsubscribe := func(paymentHash string) {
+		if subscribed[paymentHash] {
+			s.invoiceHub.Unsubscribe(paymentHash, updates)
+			delete(subscribed, paymentHash)
+		}
+	}
+	subscribe := func(paymentHash string) bool {
+		if !subscribed[paymentHash] && len(subscribed) >= maxPaymentSubscriptions {
+			return send(paymentsWSError{Error: "too many subscriptions", PaymentHash: paymentHash})
+		}
+		if _, ok := s.invoiceUpdate(paymentHash); !ok {
+			return send(paymentsWSError{Error: "invoice not found", PaymentHash: paymentHash})
+		}
+
+		// Subscribe before reading the state, so no update falls in between
+		if !subscribed[paymentHash] {
+			s.invoiceHub.Add(paymentHash, updates)
+			subscribed[paymentHash] = true
+		}
+		s.invoices.MarkSeen(paymentHash)
+
+		update, _ := s.invoiceUpdate(paymentHash)
+		if update.final() {
+			unsubscribe(paymentHash)
+		}
+		return send(update)
+	}
+
+	// Messages are read on their own goroutine, all writes happen below
+	requests := make(chan []byte)
+	go func() {
+		defer cancel()
+		conn.SetReadLimit(4096)
+		conn.SetReadDeadline(time.Now().Add(paymentsWSPongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(paymentsWSPongWait))
+		})
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+					s.logWarn("⚠️ Payments WebSocket closed: %v", err)
+				}
+				return
+			}
+			select {
+			case requests <- message:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// Like LNbits and BTCPay payment pages, ?payment_hash= subscribes right away
+	if paymentHash := r.URL.Query().Get("payment_hash"); paymentHash != "" {
+		if !subscribe(paymentHash) {
+			return
+		}
+	}
+
+	check := time.NewTicker(invoiceCheckInterval)
+	defer check.Stop()
+	ping := time.NewTicker(paymentsWSPingPeriod)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case message := <-requests:
+			var req paymentsWSRequest
+			if err := json.Unmarshal(message, &req); err != nil {
+				if !send(paymentsWSError{Error: "invalid JSON"}) {
+					return
+				}
+				continue
+			}
+
+			ok := true
+			switch req.Action {
+			case "subscribe":
+				ok = subscribe(req.PaymentHash)
+			case "unsubscribe":
+				unsubscribe(req.PaymentHash)
+			default:
+				ok = send(paymentsWSError{Error: "unknown action: " + req.Action, PaymentHash: req.PaymentHash})
+			}
+			if !ok {
+				return
+			}
+		case update := <-updates:
+			if update.final() {
+				unsubscribe(update.PaymentHash)
+			}
+			if !send(update) {
+				return
+			}
+		case <-check.C:
+			for paymentHash := range subscribed {
+				s.checkInvoice(ctx, paymentHash)
+				if update, ok := s.invoiceUpdate(paymentHash); ok && update.Status == invoiceUpdateExpired {
+					unsubscribe(paymentHash)
+					if !send(update) {
+						return
+					}
+				}
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(paymentsWSWriteWait)); err != nil {
+				return
+			}
+		}
+	}
+}
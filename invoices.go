@@ -0,0 +1,827 @@
+package payments
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultInvoiceExpiry is assumed when a provider does not report an invoice expiry
+const defaultInvoiceExpiry = time.Hour
+
+// defaultInvoiceRetention is how long resolved invoices and their provider references are
+// kept unless configured otherwise
+const defaultInvoiceRetention = 7 * 24 * time.Hour
+
+// minReuseValidity is how long a pending invoice must stay payable to be handed out again
+const minReuseValidity = 10 * time.Minute
+
+// maxTimeToPaySamples bounds the settlement delays kept for percentile reporting
+const maxTimeToPaySamples = 1000
+
+// abandonerWindow is how long a pubkey that let an invoice expire is remembered, so a later
+// payment counts as a conversion
+const abandonerWindow = 30 * 24 * time.Hour
+
+// maxRecentAbandoners bounds the pubkeys remembered within abandonerWindow, so invoice
+// spam from throwaway keys can't grow the invoice file
+const maxRecentAbandoners = 10000
+
+// legacyRoutePrefix marks issuing providers in charge mapping files written by older versions
+const legacyRoutePrefix = "route:"
+
+// Invoice states. Invoices move from created to seen once the user looked at them, to paid
+// once the payment settled and to granted once access was stored, or to refused if the
+// pubkey was denied by then. Unpaid invoices expire.
+const (
+	InvoiceStatusCreated = "created"
+	InvoiceStatusSeen    = "seen"
+	InvoiceStatusPaid    = "paid"
+	InvoiceStatusGranted = "granted"
+	InvoiceStatusRefused = "refused"
+	InvoiceStatusExpired = "expired"
+)
+
+// TrackedInvoice is an issued invoice, the provider's reference for it and its outcome.
+// Status is empty for invoices only kept for verification, such as NWC top-ups.
+type TrackedInvoice struct {
+	PaymentHash    string        `json:"payment_hash"`
+	PaymentRequest string        `json:"payment_request,omitempty"` // BOLT11 invoice, so it can be served again after a restart
+	Description    string        `json:"description,omitempty"`
+	ChargeID       string        `json:"charge_id,omitempty"` // the provider's id for the invoice, if it differs from the payment hash
+	Provider       string        `json:"provider,omitempty"`  // provider that issued the invoice when routing over several
+	Pubkey         string        `json:"pubkey,omitempty"`
+	Amount         int64         `json:"amount"`
+	Tier           string        `json:"tier,omitempty"`
+	Duration       time.Duration `json:"duration,omitempty"`
+	Group          []string      `json:"group,omitempty"`       // pubkeys granted access by a group payment, Pubkey is the payer
+	Coupon         string        `json:"coupon,omitempty"`      // coupon code the amount was discounted with
+	Purpose        string        `json:"purpose,omitempty"`     // what settling the invoice does, PurposeAccess when empty
+	ZapRequest     string        `json:"zap_request,omitempty"` // zap request to answer with a receipt once paid
+	Status         string        `json:"status,omitempty"`
+	CreatedAt      time.Time     `json:"created_at"`
+	ExpiresAt      time.Time     `json:"expires_at"`
+	SeenAt         time.Time     `json:"seen_at,omitempty"`
+	SettledAt      time.Time     `json:"settled_at,omitempty"`
+	GrantedAt      time.Time     `json:"granted_at,omitempty"`
+	PaidAmount     int64         `json:"paid_amount,omitempty"` // millisatoshis received, 0 if the provider didn't report it
+	Refunded       int64         `json:"refunded,omitempty"`    // millisatoshis paid back with Refund
+	RefundedAt     time.Time     `json:"refunded_at,omitempty"`
+	RefundRequest  string        `json:"refund_request,omitempty"` // invoice a refund is being paid with, until it is recorded
+}
+
+// pending reports whether the invoice is waiting for payment or for access to be granted
+func (ti *TrackedInvoice) pending() bool {
+	switch ti.Status {
+	case InvoiceStatusCreated, InvoiceStatusSeen, InvoiceStatusPaid:
+		return true
+	}
+	return false
+}
+
+// invoiceMetrics are the conversion counters, persisted so they survive restarts
+type invoiceMetrics struct {
+	Created   uint64 `json:"created"`
+	Seen      uint64 `json:"seen"`
+	Paid      uint64 `json:"paid"`
+	Abandoned uint64 `json:"abandoned"`
+	// Total time invoices stayed open before being paid or expiring
+	Lifetime time.Duration `json:"lifetime"`
+
+	// Pubkeys that let an invoice expire, and how many of them paid within abandonerWindow.
+	// Abandoners paying eventually point at friction in the payment flow rather than users
+	// who never meant to pay.
+	AbandoningPubkeys uint64 `json:"abandoning_pubkeys"`
+	AbandonersPaid    uint64 `json:"abandoners_paid"`
+	// When pubkeys last let an invoice expire, pruned after abandonerWindow
+	RecentAbandoners map[string]time.Time `json:"recent_abandoners"`
+
+	// Sets of every abandoning and paying pubkey written by older versions, turned into
+	// the counters on load
+	LegacyAbandonedPubkeys map[string]bool `json:"abandoned_pubkeys,omitempty"`
+	LegacyPaidPubkeys      map[string]bool `json:"paid_pubkeys,omitempty"`
+
+	// Most recent creation-to-settlement delays, used as a ring buffer
+	TimeToPay    []time.Duration `json:"time_to_pay"`
+	TimeToPayPos int             `json:"time_to_pay_pos"`
+}
+
+// InvoiceStore keeps issued invoices, the provider references needed to verify them and
+// their state from creation until access was granted or the invoice expired
+type InvoiceStore struct {
+	Invoices map[string]*TrackedInvoice `json:"invoices"`
+	Metrics  invoiceMetrics             `json:"metrics"`
+	mutex    sync.Mutex
+	filePath string
+	// How long resolved invoices are kept, see SetRetention
+	retention time.Duration
+	// Background writer, nil when every change is written synchronously
+	persister *writeBehind
+
+	logTarget
+}
+
+// NewInvoiceStore creates a new invoice store
+func NewInvoiceStore(filePath string) *InvoiceStore {
+	store := &InvoiceStore{
+		Invoices:  make(map[string]*TrackedInvoice),
+		filePath:  filePath,
+		retention: defaultInvoiceRetention,
+	}
+
+	// Ensure directory exists
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		logWarn("⚠️ Failed to create directory for invoice file: %v", err)
+	}
+
+	if err := store.load(); err != nil {
+		logWarn("⚠️ Failed to load invoices: %v", err)
+	}
+	store.Metrics.upgrade()
+	return store
+}
+
+// upgrade turns the pubkey sets of older versions into counters
+func (m *invoiceMetrics) upgrade() {
+	if m.RecentAbandoners == nil {
+		m.RecentAbandoners = make(map[string]time.Time)
+	}
+	if m.LegacyAbandonedPubkeys == nil {
+		return
+	}
+	for pubkey := range m.LegacyAbandonedPubkeys {
+		m.AbandoningPubkeys++
+		if m.LegacyPaidPubkeys[pubkey] {
+			m.AbandonersPaid++
+		}
+	}
+	m.LegacyAbandonedPubkeys = nil
+	m.LegacyPaidPubkeys = nil
+}
+
+// recordAbandoned counts a pubkey letting an invoice expire, once per abandonerWindow
+func (m *invoiceMetrics) recordAbandoned(pubkey string, now time.Time) {
+	if pubkey == "" {
+		return
+	}
+	_, recent := m.RecentAbandoners[pubkey]
+	if !recent {
+		m.AbandoningPubkeys++
+	}
+	if recent || len(m.RecentAbandoners) < maxRecentAbandoners {
+		m.RecentAbandoners[pubkey] = now
+	}
+}
+
+// recordPaid counts a payment by a pubkey that recently let an invoice expire
+func (m *invoiceMetrics) recordPaid(pubkey string) {
+	if _, recent := m.RecentAbandoners[pubkey]; recent {
+		m.AbandonersPaid++
+		delete(m.RecentAbandoners, pubkey)
+	}
+}
+
+// pruneAbandoners forgets pubkeys that let an invoice expire longer than abandonerWindow ago
+func (m *invoiceMetrics) pruneAbandoners(now time.Time) bool {
+	pruned := false
+	for pubkey, abandonedAt := range m.RecentAbandoners {
+		if now.Sub(abandonedAt) > abandonerWindow {
+			delete(m.RecentAbandoners, pubkey)
+			pruned = true
+		}
+	}
+	return pruned
+}
+
+// load reads invoices from file
+func (is *InvoiceStore) load() error {
+	is.mutex.Lock()
+	defer is.mutex.Unlock()
+
+	data, err := os.ReadFile(is.filePath)
+	if os.IsNotExist(err) {
+		return nil // File doesn't exist, start with no invoices
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read invoice file: %w", err)
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(data, is); err != nil {
+		return err
+	}
+	if is.Invoices == nil {
+		is.Invoices = make(map[string]*TrackedInvoice)
+	}
+	return nil
+}
+
+// save persists invoices, caller holds the mutex. With a write delay set the write
+// happens in the background, see SetWriteDelay.
+func (is *InvoiceStore) save() {
+	if is.persister != nil {
+		is.persister.markDirty()
+		return
+	}
+	if err := is.write(); err != nil {
+		is.logWarn("⚠️ Failed to save invoices: %v", err)
+	}
+}
+
+// write writes the invoice file, caller holds the mutex
+func (is *InvoiceStore) write() error {
+	data, err := json.MarshalIndent(is, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal invoices: %w", err)
+	}
+	return writeFileAtomic(is.filePath, data, 0644)
+}
+
+// SetWriteDelay batches writes: changes are written in the background at most delay after
+// they happen, so invoice creation doesn't wait for the file to be rewritten. Zero writes
+// synchronously. Call Flush before exiting so the last changes are not lost.
+func (is *InvoiceStore) SetWriteDelay(delay time.Duration) {
+	is.Flush()
+
+	is.mutex.Lock()
+	defer is.mutex.Unlock()
+	if delay <= 0 {
+		is.persister = nil
+		return
+	}
+	is.persister = newWriteBehind("invoice file", delay, &is.logTarget, func() error {
+		is.mutex.Lock()
+		defer is.mutex.Unlock()
+		return is.write()
+	})
+}
+
+// SetRetention sets how long invoices are kept after they were granted or expired. Provider
+// references go with them, so late webhooks for older invoices are no longer matched.
+func (is *InvoiceStore) SetRetention(retention time.Duration) {
+	is.mutex.Lock()
+	defer is.mutex.Unlock()
+	is.retention = retention
+}
+
+// Flush writes changes still waiting for a background write
+func (is *InvoiceStore) Flush() error {
+	is.mutex.Lock()
+	persister := is.persister
+	is.mutex.Unlock()
+
+	if persister == nil {
+		return nil
+	}
+	return persister.Flush()
+}
+
+// export copies all invoices, e.g. for backups
+func (is *InvoiceStore) export() map[string]*TrackedInvoice {
+	is.mutex.Lock()
+	defer is.mutex.Unlock()
+
+	invoices := make(map[string]*TrackedInvoice, len(is.Invoices))
+	for hash, invoice := range is.Invoices {
+		copied := *invoice
+		invoices[hash] = &copied
+	}
+	return invoices
+}
+
+// replace swaps in restored invoices and saves them, metrics are kept
+func (is *InvoiceStore) replace(invoices map[string]*TrackedInvoice) {
+	is.mutex.Lock()
+	defer is.mutex.Unlock()
+
+	is.Invoices = invoices
+	if is.Invoices == nil {
+		is.Invoices = make(map[string]*TrackedInvoice)
+	}
+	is.save()
+}
+
+// ImportChargeMappings adds the payment hash to charge id mappings of a charge mapping
+// file written by older versions, so invoices issued before the upgrade still verify.
+// It does nothing if the invoice store already has a file of its own.
+func (is *InvoiceStore) ImportChargeMappings(path string) error {
+	if _, err := os.Stat(is.filePath); err == nil {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read charge mappings file: %w", err)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	var legacy struct {
+		Mappings map[string]string `json:"mappings"`
+	}
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return fmt.Errorf("failed to parse charge mappings file: %w", err)
+	}
+	is.importMappings(legacy.Mappings)
+
+	is.logInfo("💾 Imported %d charge mappings from %s", len(legacy.Mappings), path)
+	return nil
+}
+
+// importMappings records charge ids and issuing providers from legacy charge mappings
+func (is *InvoiceStore) importMappings(mappings map[string]string) {
+	is.mutex.Lock()
+	defer is.mutex.Unlock()
+
+	for key, value := range mappings {
+		if paymentHash, ok := strings.CutPrefix(key, legacyRoutePrefix); ok {
+			is.record(paymentHash).Provider = value
+		} else {
+			is.record(key).ChargeID = value
+		}
+	}
+	is.save()
+}
+
+// record returns the invoice for a payment hash, adding an untracked one if unknown.
+// Caller holds the mutex.
+func (is *InvoiceStore) record(paymentHash string) *TrackedInvoice {
+	invoice, exists := is.Invoices[paymentHash]
+	if !exists {
+		now := time.Now()
+		invoice = &TrackedInvoice{
+			PaymentHash: paymentHash,
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(defaultInvoiceExpiry),
+		}
+		is.Invoices[paymentHash] = invoice
+	}
+	return invoice
+}
+
+// RecordCharge stores the provider's id for a newly issued invoice so it can be verified later
+func (is *InvoiceStore) RecordCharge(paymentHash, chargeID string, amount int64) {
+	is.mutex.Lock()
+	defer is.mutex.Unlock()
+
+	invoice := is.record(paymentHash)
+	invoice.ChargeID = chargeID
+	invoice.Amount = amount
+	is.save()
+
+	is.logDebug("💾 Stored charge mapping: %.16s... → %s", paymentHash, chargeID)
+}
+
+// ChargeID returns the provider's id for an invoice
+func (is *InvoiceStore) ChargeID(paymentHash string) (string, bool) {
+	is.mutex.Lock()
+	defer is.mutex.Unlock()
+
+	invoice, exists := is.Invoices[paymentHash]
+	if !exists || invoice.ChargeID == "" {
+		return "", false
+	}
+	return invoice.ChargeID, true
+}
+
+// SetProvider records which provider issued an invoice
+func (is *InvoiceStore) SetProvider(paymentHash, provider string) {
+	is.mutex.Lock()
+	defer is.mutex.Unlock()
+
+	is.record(paymentHash).Provider = provider
+	is.save()
+}
+
+// SetCoupon records the coupon a tracked invoice was discounted with
+func (is *InvoiceStore) SetCoupon(paymentHash, code string) {
+	is.mutex.Lock()
+	defer is.mutex.Unlock()
+
+	invoice, exists := is.Invoices[paymentHash]
+	if !exists {
+		return
+	}
+	invoice.Coupon = code
+	is.save()
+}
+
+// SetPurpose records what settling an invoice does, and the zap request it answers if any
+func (is *InvoiceStore) SetPurpose(paymentHash, purpose, zapRequest string) {
+	is.mutex.Lock()
+	defer is.mutex.Unlock()
+
+	invoice, exists := is.Invoices[paymentHash]
+	if !exists {
+		return
+	}
+	invoice.Purpose = purpose
+	invoice.ZapRequest = zapRequest
+	is.save()
+}
+
+// Provider returns the provider that issued an invoice
+func (is *InvoiceStore) Provider(paymentHash string) (string, bool) {
+	is.mutex.Lock()
+	defer is.mutex.Unlock()
+
+	invoice, exists := is.Invoices[paymentHash]
+	if !exists || invoice.Provider == "" {
+		return "", false
+	}
+	return invoice.Provider, true
+}
+
+// Track starts following a newly created invoice priced for the given tier and duration
+func (is *InvoiceStore) Track(invoice *Invoice, pubkey, tier string, duration time.Duration) {
+	is.TrackGroup(invoice, pubkey, tier, duration, nil)
+}
+
+// TrackGroup starts following a newly created invoice paid by pubkey that grants access to
+// every pubkey of group, or to pubkey itself when group is empty
+func (is *InvoiceStore) TrackGroup(invoice *Invoice, pubkey, tier string, duration time.Duration, group []string) {
+	now := time.Now()
+	expiresAt := invoice.ExpiresAt
+	if !expiresAt.After(now) {
+		expiresAt = now.Add(defaultInvoiceExpiry)
+	}
+
+	is.mutex.Lock()
+	defer is.mutex.Unlock()
+
+	tracked := is.record(invoice.PaymentHash)
+	tracked.PaymentRequest = invoice.PaymentRequest
+	tracked.Description = invoice.Description
+	tracked.Pubkey = pubkey
+	tracked.Amount = invoice.Amount
+	tracked.Tier = tier
+	tracked.Duration = duration
+	tracked.Group = group
+	tracked.Status = InvoiceStatusCreated
+	tracked.CreatedAt = now
+	tracked.ExpiresAt = expiresAt
+	is.Metrics.Created++
+	is.save()
+}
+
+// Get returns a copy of a tracked invoice
+func (is *InvoiceStore) Get(paymentHash string) (TrackedInvoice, bool) {
+	is.mutex.Lock()
+	defer is.mutex.Unlock()
+
+	invoice, exists := is.Invoices[paymentHash]
+	if !exists {
+		return TrackedInvoice{}, false
+	}
+	return *invoice, true
+}
+
+// PaymentHashes returns the hashes of invoices issued to pubkey that still await payment or
+// granting, so providers find invoices issued before a restart. Invoices routed to another
+// provider are left out.
+func (is *InvoiceStore) PaymentHashes(pubkey, provider string) []string {
+	is.mutex.Lock()
+	defer is.mutex.Unlock()
+
+	var hashes []string
+	for hash, tracked := range is.Invoices {
+		if tracked.Pubkey != pubkey || !tracked.pending() {
+			continue
+		}
+		if tracked.Provider != "" && !strings.EqualFold(tracked.Provider, provider) {
+			continue
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes
+}
+
+// ByChargeID finds the invoice a provider knows by chargeID
+func (is *InvoiceStore) ByChargeID(chargeID string) (TrackedInvoice, bool) {
+	is.mutex.Lock()
+	defer is.mutex.Unlock()
+
+	for _, tracked := range is.Invoices {
+		if tracked.ChargeID == chargeID {
+			return *tracked, true
+		}
+	}
+	return TrackedInvoice{}, false
+}
+
+// Reusable returns an unpaid invoice issued to pubkey for the same tier and amount that
+// stays payable for a while, so repeated requests don't pile up invoices
+func (is *InvoiceStore) Reusable(pubkey, tier string, amount int64, now time.Time) (*Invoice, bool) {
+	is.mutex.Lock()
+	defer is.mutex.Unlock()
+
+	for _, tracked := range is.Invoices {
+		if tracked.Pubkey != pubkey || tracked.Tier != tier || tracked.Amount != amount || tracked.PaymentRequest == "" || len(tracked.Group) > 0 {
+			continue
+		}
+		if tracked.Purpose != "" && tracked.Purpose != PurposeAccess {
+			continue
+		}
+		if tracked.Status != InvoiceStatusCreated && tracked.Status != InvoiceStatusSeen {
+			continue
+		}
+		if tracked.ExpiresAt.Sub(now) < minReuseValidity {
+			continue
+		}
+		return &Invoice{
+			PaymentRequest: tracked.PaymentRequest,
+			PaymentHash:    tracked.PaymentHash,
+			Amount:         tracked.Amount,
+			Description:    tracked.Description,
+			ExpiresAt:      tracked.ExpiresAt,
+		}, true
+	}
+	return nil, false
+}
+
+// Pending returns copies of the invoices not granted yet, whether unpaid or paid
+// without access having been stored
+func (is *InvoiceStore) Pending() []TrackedInvoice {
+	is.mutex.Lock()
+	defer is.mutex.Unlock()
+
+	pending := make([]TrackedInvoice, 0)
+	for _, invoice := range is.Invoices {
+		if invoice.pending() {
+			pending = append(pending, *invoice)
+		}
+	}
+	return pending
+}
+
+// List returns copies of tracked invoices, newest first, optionally only those with the
+// given status or for the given pubkey
+func (is *InvoiceStore) List(status, pubkey string) []TrackedInvoice {
+	is.mutex.Lock()
+	defer is.mutex.Unlock()
+
+	invoices := make([]TrackedInvoice, 0)
+	for _, invoice := range is.Invoices {
+		if invoice.Status == "" || (status != "" && invoice.Status != status) || (pubkey != "" && invoice.Pubkey != pubkey) {
+			continue
+		}
+		invoices = append(invoices, *invoice)
+	}
+	sort.Slice(invoices, func(i, j int) bool { return invoices[i].CreatedAt.After(invoices[j].CreatedAt) })
+	return invoices
+}
+
+// MarkSeen records that the user looked at a created invoice, e.g. on the payment page
+func (is *InvoiceStore) MarkSeen(paymentHash string) {
+	is.mutex.Lock()
+	defer is.mutex.Unlock()
+
+	invoice, exists := is.Invoices[paymentHash]
+	if !exists || invoice.Status != InvoiceStatusCreated {
+		return
+	}
+
+	invoice.Status = InvoiceStatusSeen
+	invoice.SeenAt = time.Now()
+	is.Metrics.Seen++
+	is.save()
+}
+
+// MarkPaid records that a tracked invoice settled for amount, 0 if unknown
+func (is *InvoiceStore) MarkPaid(paymentHash string, amount int64, paidAt time.Time) {
+	if paidAt.IsZero() {
+		paidAt = time.Now()
+	}
+
+	is.mutex.Lock()
+	defer is.mutex.Unlock()
+
+	invoice, exists := is.Invoices[paymentHash]
+	if !exists || invoice.Status == "" || invoice.Status == InvoiceStatusPaid || invoice.Status == InvoiceStatusGranted || invoice.Status == InvoiceStatusRefused {
+		return
+	}
+
+	// A late payment on an invoice we already counted as abandoned is still a conversion
+	if invoice.Status == InvoiceStatusExpired {
+		is.Metrics.Abandoned--
+		is.Metrics.Lifetime -= invoice.ExpiresAt.Sub(invoice.CreatedAt)
+	}
+
+	invoice.Status = InvoiceStatusPaid
+	invoice.SettledAt = paidAt
+	invoice.PaidAmount = amount
+	is.Metrics.Paid++
+	is.Metrics.Lifetime += paidAt.Sub(invoice.CreatedAt)
+	is.Metrics.recordPaid(invoice.Pubkey)
+	is.recordTimeToPay(paidAt.Sub(invoice.CreatedAt))
+	is.save()
+}
+
+// MarkGranted records that access was stored for a paid invoice
+func (is *InvoiceStore) MarkGranted(paymentHash string) {
+	is.mutex.Lock()
+	defer is.mutex.Unlock()
+
+	invoice, exists := is.Invoices[paymentHash]
+	if !exists || invoice.Status != InvoiceStatusPaid {
+		return
+	}
+
+	invoice.Status = InvoiceStatusGranted
+	invoice.GrantedAt = time.Now()
+	is.save()
+}
+
+// MarkRefused records that a paid invoice was not granted because its pubkey is denied
+func (is *InvoiceStore) MarkRefused(paymentHash string) {
+	is.mutex.Lock()
+	defer is.mutex.Unlock()
+
+	invoice, exists := is.Invoices[paymentHash]
+	if !exists || invoice.Status != InvoiceStatusPaid {
+		return
+	}
+
+	invoice.Status = InvoiceStatusRefused
+	is.save()
+}
+
+// MarkRefunded records that amount of a paid invoice was paid back
+func (is *InvoiceStore) MarkRefunded(paymentHash string, amount int64) {
+	is.mutex.Lock()
+	defer is.mutex.Unlock()
+
+	invoice, exists := is.Invoices[paymentHash]
+	if !exists {
+		return
+	}
+
+	invoice.Refunded = amount
+	invoice.RefundedAt = time.Now()
+	invoice.RefundRequest = ""
+	is.save()
+}
+
+// SetRefundRequest records the invoice a refund of paymentHash is about to be paid with,
+// empty once the attempt definitely failed. Unlike other changes it is on disk when
+// SetRefundRequest returns, so a crash mid-payment can't lose it.
+func (is *InvoiceStore) SetRefundRequest(paymentHash, paymentRequest string) error {
+	is.mutex.Lock()
+	is.record(paymentHash).RefundRequest = paymentRequest
+	persister := is.persister
+	if persister == nil {
+		defer is.mutex.Unlock()
+		return is.write()
+	}
+	persister.markDirty()
+	is.mutex.Unlock()
+	return persister.Flush()
+}
+
+// recordTimeToPay stores a settlement delay, overwriting the oldest sample when full
+func (is *InvoiceStore) recordTimeToPay(delay time.Duration) {
+	if delay < 0 {
+		delay = 0
+	}
+	if len(is.Metrics.TimeToPay) < maxTimeToPaySamples {
+		is.Metrics.TimeToPay = append(is.Metrics.TimeToPay, delay)
+		return
+	}
+	is.Metrics.TimeToPay[is.Metrics.TimeToPayPos] = delay
+	is.Metrics.TimeToPayPos = (is.Metrics.TimeToPayPos + 1) % maxTimeToPaySamples
+}
+
+// ExpireStale marks unpaid invoices past their expiry as abandoned and forgets
+// invoices that were resolved longer than the retention ago
+func (is *InvoiceStore) ExpireStale(now time.Time) int {
+	is.mutex.Lock()
+	defer is.mutex.Unlock()
+
+	expired := 0
+	changed := false
+	for hash, invoice := range is.Invoices {
+		if invoice.RefundRequest != "" {
+			continue // kept until the refund is settled one way or the other
+		}
+		switch invoice.Status {
+		case InvoiceStatusCreated, InvoiceStatusSeen:
+			if now.After(invoice.ExpiresAt) {
+				invoice.Status = InvoiceStatusExpired
+				is.Metrics.Abandoned++
+				is.Metrics.Lifetime += invoice.ExpiresAt.Sub(invoice.CreatedAt)
+				is.Metrics.recordAbandoned(invoice.Pubkey, now)
+				expired++
+				changed = true
+			}
+		case InvoiceStatusExpired, "":
+			if now.Sub(invoice.ExpiresAt) > is.retention {
+				delete(is.Invoices, hash)
+				changed = true
+			}
+		case InvoiceStatusRefused:
+			if now.Sub(invoice.SettledAt) > is.retention {
+				delete(is.Invoices, hash)
+				changed = true
+			}
+		case InvoiceStatusGranted:
+			if now.Sub(invoice.GrantedAt) > is.retention {
+				delete(is.Invoices, hash)
+				changed = true
+			}
+		}
+	}
+	if is.Metrics.pruneAbandoners(now) {
+		changed = true
+	}
+	if changed {
+		is.save()
+	}
+	return expired
+}
+
+// Stats returns invoice conversion statistics
+func (is *InvoiceStore) Stats() map[string]interface{} {
+	is.mutex.Lock()
+	defer is.mutex.Unlock()
+
+	pending := 0
+	for _, invoice := range is.Invoices {
+		if invoice.pending() {
+			pending++
+		}
+	}
+
+	metrics := is.Metrics
+	abandonmentRate := 0.0
+	avgLifetime := 0.0
+	if resolved := metrics.Paid + metrics.Abandoned; resolved > 0 {
+		abandonmentRate = float64(metrics.Abandoned) / float64(resolved)
+		avgLifetime = metrics.Lifetime.Seconds() / float64(resolved)
+	}
+
+	delays := make([]time.Duration, len(metrics.TimeToPay))
+	copy(delays, metrics.TimeToPay)
+	sort.Slice(delays, func(i, j int) bool { return delays[i] < delays[j] })
+
+	return map[string]interface{}{
+		"time_to_pay_p50_seconds":       percentile(delays, 0.50).Seconds(),
+		"time_to_pay_p90_seconds":       percentile(delays, 0.90).Seconds(),
+		"time_to_pay_p99_seconds":       percentile(delays, 0.99).Seconds(),
+		"time_to_pay_samples":           len(delays),
+		"invoices_created":              metrics.Created,
+		"invoices_seen":                 metrics.Seen,
+		"invoices_paid":                 metrics.Paid,
+		"invoices_abandoned":            metrics.Abandoned,
+		"invoices_pending":              pending,
+		"abandonment_rate":              abandonmentRate,
+		"avg_invoice_lifetime_seconds":  avgLifetime,
+		"abandoning_pubkeys":            int(metrics.AbandoningPubkeys),
+		"abandoning_pubkeys_later_paid": int(metrics.AbandonersPaid),
+	}
+}
+
+// percentile returns the nearest-rank percentile of sorted durations
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(p*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+// adminInvoicesHandler lists tracked invoices, optionally filtered by status and pubkey
+func (s *System) adminInvoicesHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	pubkey := query.Get("pubkey")
+	if pubkey != "" {
+		parsed, err := parsePubkey(pubkey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		pubkey = parsed
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"invoices": s.invoices.List(query.Get("status"), pubkey),
+	})
+}
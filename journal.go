@@ -0,0 +1,262 @@
+package payments
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// minJournalCompaction is how many journal entries are kept at least before the snapshot
+// is rewritten. Beyond it the journal is compacted once it holds more entries than members.
+const minJournalCompaction = 1000
+
+// Kinds of journal entries
+const (
+	journalMember = "member"
+	journalStream = "stream"
+	journalTrial  = "trial"
+)
+
+// journalEntry is one line of the journal: the new state of a member, stream or trial of
+// pubkey, none if it was deleted
+type journalEntry struct {
+	Generation int             `json:"gen"` // snapshot the entry applies to
+	Kind       string          `json:"kind"`
+	Pubkey     string          `json:"pubkey"`
+	Member     json.RawMessage `json:"member,omitempty"`
+	Stream     json.RawMessage `json:"stream,omitempty"`
+	Trial      *time.Time      `json:"trial,omitempty"`
+}
+
+// journalSnapshot is the snapshot file: the state plus the generation journal entries
+// must carry to apply to it
+type journalSnapshot struct {
+	*StoreData
+	Generation int `json:"generation,omitempty"`
+}
+
+// written is what the store last wrote for each pubkey, to journal only what changed
+type written struct {
+	members map[string][]byte
+	streams map[string][]byte
+	trials  map[string]time.Time
+}
+
+// remember records data as written
+func (w *written) remember(data *StoreData) error {
+	w.members = make(map[string][]byte, len(data.Members))
+	w.streams = make(map[string][]byte, len(data.Streams))
+	w.trials = make(map[string]time.Time, len(data.Trials))
+	for pubkey, member := range data.Members {
+		raw, err := json.Marshal(member)
+		if err != nil {
+			return fmt.Errorf("failed to marshal member: %w", err)
+		}
+		w.members[pubkey] = raw
+	}
+	for pubkey, drips := range data.Streams {
+		raw, err := json.Marshal(drips)
+		if err != nil {
+			return fmt.Errorf("failed to marshal stream: %w", err)
+		}
+		w.streams[pubkey] = raw
+	}
+	for pubkey, startedAt := range data.Trials {
+		w.trials[pubkey] = startedAt
+	}
+	return nil
+}
+
+// changes returns the journal entries turning what was written into data
+func (w *written) changes(data *StoreData, generation int) ([]journalEntry, error) {
+	seen := make(map[string]bool, len(data.Members))
+	var pubkeys []string
+	add := func(pubkey string) {
+		if !seen[pubkey] {
+			seen[pubkey] = true
+			pubkeys = append(pubkeys, pubkey)
+		}
+	}
+	for pubkey := range data.Members {
+		add(pubkey)
+	}
+	for pubkey := range data.Streams {
+		add(pubkey)
+	}
+	for pubkey := range data.Trials {
+		add(pubkey)
+	}
+	for pubkey := range w.members {
+		add(pubkey)
+	}
+	for pubkey := range w.streams {
+		add(pubkey)
+	}
+	for pubkey := range w.trials {
+		add(pubkey)
+	}
+	return w.changesOf(data, pubkeys, generation)
+}
+
+// changesOf returns the journal entries turning what was written for pubkeys into data,
+// pubkeys missing from data having been deleted
+func (w *written) changesOf(data *StoreData, pubkeys []string, generation int) ([]journalEntry, error) {
+	var entries []journalEntry
+	for _, pubkey := range pubkeys {
+		if member, exists := data.Members[pubkey]; exists {
+			raw, err := json.Marshal(member)
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal member: %w", err)
+			}
+			if previous, exists := w.members[pubkey]; !exists || !bytes.Equal(previous, raw) {
+				entries = append(entries, journalEntry{Generation: generation, Kind: journalMember, Pubkey: pubkey, Member: raw})
+			}
+		} else if _, exists := w.members[pubkey]; exists {
+			entries = append(entries, journalEntry{Generation: generation, Kind: journalMember, Pubkey: pubkey})
+		}
+
+		if drips, exists := data.Streams[pubkey]; exists {
+			raw, err := json.Marshal(drips)
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal stream: %w", err)
+			}
+			if previous, exists := w.streams[pubkey]; !exists || !bytes.Equal(previous, raw) {
+				entries = append(entries, journalEntry{Generation: generation, Kind: journalStream, Pubkey: pubkey, Stream: raw})
+			}
+		} else if _, exists := w.streams[pubkey]; exists {
+			entries = append(entries, journalEntry{Generation: generation, Kind: journalStream, Pubkey: pubkey})
+		}
+
+		if startedAt, exists := data.Trials[pubkey]; exists {
+			if previous, exists := w.trials[pubkey]; !exists || !previous.Equal(startedAt) {
+				entries = append(entries, journalEntry{Generation: generation, Kind: journalTrial, Pubkey: pubkey, Trial: &startedAt})
+			}
+		} else if _, exists := w.trials[pubkey]; exists {
+			entries = append(entries, journalEntry{Generation: generation, Kind: journalTrial, Pubkey: pubkey})
+		}
+	}
+	return entries, nil
+}
+
+// apply records an entry as written
+func (w *written) apply(entry journalEntry) {
+	switch entry.Kind {
+	case journalMember:
+		if entry.Member == nil {
+			delete(w.members, entry.Pubkey)
+		} else {
+			w.members[entry.Pubkey] = entry.Member
+		}
+	case journalStream:
+		if entry.Stream == nil {
+			delete(w.streams, entry.Pubkey)
+		} else {
+			w.streams[entry.Pubkey] = entry.Stream
+		}
+	case journalTrial:
+		if entry.Trial == nil {
+			delete(w.trials, entry.Pubkey)
+		} else {
+			w.trials[entry.Pubkey] = *entry.Trial
+		}
+	}
+}
+
+// replayJournal applies the entries of the journal at path written for generation to
+// data, returning how many it applied and whether the journal ends in a torn line, left
+// by a crash mid-append
+func replayJournal(logs *logTarget, path string, generation int, data *StoreData) (applied int, torn bool, err error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to open journal: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// Appends are whole lines, so only the last one can be torn
+			logs.logWarn("⚠️ Ignoring torn journal entry in %s: %v", path, err)
+			return applied, true, nil
+		}
+		if entry.Generation != generation {
+			continue // written before the snapshot, which holds it already
+		}
+		if err := applyJournalEntry(data, entry); err != nil {
+			return applied, false, err
+		}
+		applied++
+	}
+	if err := scanner.Err(); err != nil {
+		return applied, false, fmt.Errorf("failed to read journal: %w", err)
+	}
+	return applied, false, nil
+}
+
+// applyJournalEntry applies an entry to data
+func applyJournalEntry(data *StoreData, entry journalEntry) error {
+	switch entry.Kind {
+	case journalMember:
+		if entry.Member == nil {
+			delete(data.Members, entry.Pubkey)
+			return nil
+		}
+		var member PaidAccessMember
+		if err := json.Unmarshal(entry.Member, &member); err != nil {
+			return fmt.Errorf("failed to parse journaled member: %w", err)
+		}
+		data.Members[entry.Pubkey] = &member
+	case journalStream:
+		if entry.Stream == nil {
+			delete(data.Streams, entry.Pubkey)
+			return nil
+		}
+		var drips []StreamDrip
+		if err := json.Unmarshal(entry.Stream, &drips); err != nil {
+			return fmt.Errorf("failed to parse journaled stream: %w", err)
+		}
+		data.Streams[entry.Pubkey] = drips
+	case journalTrial:
+		if entry.Trial == nil {
+			delete(data.Trials, entry.Pubkey)
+			return nil
+		}
+		data.Trials[entry.Pubkey] = *entry.Trial
+	default:
+		return fmt.Errorf("unknown journal entry kind: %s", entry.Kind)
+	}
+	return nil
+}
+
+// appendJournal appends entries to the journal at path and syncs it to disk
+func appendJournal(path string, entries []journalEntry) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			return fmt.Errorf("failed to marshal journal entry: %w", err)
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open journal: %w", err)
+	}
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to append to journal: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to sync journal: %w", err)
+	}
+	return file.Close()
+}
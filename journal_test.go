@@ -0,0 +1,120 @@
+package payments
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// journalLine returns the journal line granting pubkey a membership in generation
+func journalLine(t *testing.T, generation int, pubkey string) string {
+	t.Helper()
+	member, err := json.Marshal(&PaidAccessMember{Pubkey: pubkey, Amount: 21000, ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, err := json.Marshal(journalEntry{Generation: generation, Kind: journalMember, Pubkey: pubkey, Member: member})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(line) + "\n"
+}
+
+func TestReplayJournal(t *testing.T) {
+	pubkeys := benchPubkeys(3)
+	for _, test := range []struct {
+		name    string
+		journal func(t *testing.T) string
+		applied int
+		torn    bool
+		members []string
+	}{
+		{"empty", func(t *testing.T) string { return "" }, 0, false, nil},
+		{
+			"whole lines",
+			func(t *testing.T) string { return journalLine(t, 1, pubkeys[0]) + journalLine(t, 1, pubkeys[1]) },
+			2, false, pubkeys[:2],
+		},
+		{
+			"older generation",
+			func(t *testing.T) string { return journalLine(t, 0, pubkeys[0]) + journalLine(t, 1, pubkeys[1]) },
+			1, false, pubkeys[1:2],
+		},
+		{
+			"torn last line",
+			func(t *testing.T) string {
+				torn := journalLine(t, 1, pubkeys[2])
+				return journalLine(t, 1, pubkeys[0]) + journalLine(t, 1, pubkeys[1]) + torn[:len(torn)/2]
+			},
+			2, true, pubkeys[:2],
+		},
+		{
+			"torn first line",
+			func(t *testing.T) string {
+				torn := journalLine(t, 1, pubkeys[0])
+				return torn[:len(torn)-10]
+			},
+			0, true, nil,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "paid_access.json.journal")
+			if err := os.WriteFile(path, []byte(test.journal(t)), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			data := newStoreData()
+			logs := newLogTarget(slog.New(slog.NewTextHandler(io.Discard, nil)))
+			applied, torn, err := replayJournal(logs, path, 1, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if applied != test.applied || torn != test.torn {
+				t.Errorf("applied %d entries (torn: %v), want %d (torn: %v)", applied, torn, test.applied, test.torn)
+			}
+			if len(data.Members) != len(test.members) {
+				t.Errorf("got %d members, want %d", len(data.Members), len(test.members))
+			}
+			for _, pubkey := range test.members {
+				if _, exists := data.Members[pubkey]; !exists {
+					t.Errorf("member %s... missing", pubkey[:16])
+				}
+			}
+		})
+	}
+}
+
+// TestJSONFileStoreTornJournal checks that saving after loading a torn journal doesn't
+// append to the torn line, which would lose the entry
+func TestJSONFileStoreTornJournal(t *testing.T) {
+	pubkeys := benchPubkeys(2)
+	path := filepath.Join(t.TempDir(), "paid_access.json")
+	torn := journalLine(t, 0, pubkeys[1])
+	if err := os.WriteFile(path+".journal", []byte(journalLine(t, 0, pubkeys[0])+torn[:len(torn)/2]), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewJSONFileStore(path)
+	data, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data.Members[pubkeys[1]] = &PaidAccessMember{Pubkey: pubkeys[1], Amount: 21000, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := store.Save(data); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := NewJSONFileStore(path).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pubkey := range pubkeys {
+		if _, exists := reloaded.Members[pubkey]; !exists {
+			t.Errorf("member %s... lost", pubkey[:16])
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package payments
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// LedgerEntry records a single settled payment
+type LedgerEntry struct {
+	Pubkey      string    `json:"pubkey"`
+	PaymentHash string    `json:"payment_hash"`
+	Amount      int64     `json:"amount"`
+	Provider    string    `json:"provider,omitempty"`
+	Tier        string    `json:"tier,omitempty"`
+	PaidAt      time.Time `json:"paid_at"`
+	Refunded    int64     `json:"refunded,omitempty"` // millisatoshis paid back with Refund
+	RefundedAt  time.Time `json:"refunded_at,omitempty"`
+}
+
+// RevenueTotals aggregates payment counts and amounts
+type RevenueTotals struct {
+	Payments   int   `json:"payments"`
+	AmountMsat int64 `json:"amount_msat"`
+}
+
+// PaymentLedger keeps an append-only history of settled payments
+type PaymentLedger struct {
+	Entries  []*LedgerEntry `json:"entries"`
+	mutex    sync.RWMutex
+	filePath string
+}
+
+// NewPaymentLedger creates a new payment ledger
+func NewPaymentLedger(filePath string) *PaymentLedger {
+	ledger := &PaymentLedger{
+		Entries:  make([]*LedgerEntry, 0),
+		filePath: filePath,
+	}
+
+	// Ensure directory exists
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		logWarn("⚠️ Failed to create directory for payment ledger file: %v", err)
+	}
+
+	if err := ledger.load(); err != nil {
+		logWarn("⚠️ Failed to load payment ledger: %v", err)
+	}
+	return ledger
+}
+
+// load reads ledger entries from file
+func (pl *PaymentLedger) load() error {
+	pl.mutex.Lock()
+	defer pl.mutex.Unlock()
+
+	data, err := os.ReadFile(pl.filePath)
+	if os.IsNotExist(err) {
+		return nil // File doesn't exist, start with empty ledger
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read payment ledger file: %w", err)
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(data, pl)
+}
+
+// save writes ledger entries to file
+func (pl *PaymentLedger) save() error {
+	data, err := json.MarshalIndent(pl, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal payment ledger: %w", err)
+	}
+
+	return os.WriteFile(pl.filePath, data, 0644)
+}
+
+// Record appends a settled payment to the ledger, ignoring duplicates of the same payment hash
+func (pl *PaymentLedger) Record(entry LedgerEntry) error {
+	pl.mutex.Lock()
+	defer pl.mutex.Unlock()
+
+	for _, existing := range pl.Entries {
+		if existing.PaymentHash == entry.PaymentHash && existing.Pubkey == entry.Pubkey {
+			return nil
+		}
+	}
+
+	if entry.PaidAt.IsZero() {
+		entry.PaidAt = time.Now()
+	}
+	pl.Entries = append(pl.Entries, &entry)
+
+	if err := pl.save(); err != nil {
+		return fmt.Errorf("failed to save payment ledger: %w", err)
+	}
+	return nil
+}
+
+// Get returns the ledger entry of a payment hash
+func (pl *PaymentLedger) Get(paymentHash string) (LedgerEntry, bool) {
+	pl.mutex.RLock()
+	defer pl.mutex.RUnlock()
+
+	for _, entry := range pl.Entries {
+		if entry.PaymentHash == paymentHash {
+			return *entry, true
+		}
+	}
+	return LedgerEntry{}, false
+}
+
+// MarkRefunded records that amount of a payment was paid back
+func (pl *PaymentLedger) MarkRefunded(paymentHash string, amount int64) error {
+	pl.mutex.Lock()
+	defer pl.mutex.Unlock()
+
+	for _, entry := range pl.Entries {
+		if entry.PaymentHash == paymentHash {
+			entry.Refunded = amount
+			entry.RefundedAt = time.Now()
+			if err := pl.save(); err != nil {
+				return fmt.Errorf("failed to save payment ledger: %w", err)
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// List returns a copy of all ledger entries in recording order
+func (pl *PaymentLedger) List() []LedgerEntry {
+	pl.mutex.RLock()
+	defer pl.mutex.RUnlock()
+
+	entries := make([]LedgerEntry, len(pl.Entries))
+	for i, entry := range pl.Entries {
+		entries[i] = *entry
+	}
+	return entries
+}
+
+// RevenueBy groups ledger entries by the given key and sums their payments, net of refunds
+func (pl *PaymentLedger) RevenueBy(key func(LedgerEntry) string) map[string]RevenueTotals {
+	pl.mutex.RLock()
+	defer pl.mutex.RUnlock()
+
+	totals := make(map[string]RevenueTotals)
+	for _, entry := range pl.Entries {
+		name := key(*entry)
+		if name == "" {
+			name = "unknown"
+		}
+		total := totals[name]
+		total.Payments++
+		total.AmountMsat += entry.Amount - entry.Refunded
+		totals[name] = total
+	}
+	return totals
+}
@@ -0,0 +1,373 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lndInvoiceSettled is the state of paid invoices, as returned by the REST API
+const lndInvoiceSettled = "SETTLED"
+
+// LNDProvider implements PaymentProvider interface for an LND node's REST API
+type LNDProvider struct {
+	baseURL  string
+	macaroon string
+	client   *http.Client
+	// Map payment hash to pubkey for CheckExistingPayments
+	pubkeyMap *lruMap[string]
+	mu        sync.RWMutex
+	// Persistent storage references
+	invoiceStore *InvoiceStore
+	logTarget
+}
+
+// NewLNDProvider creates a new LND payment provider. tlsCert is the node's PEM encoded
+// certificate, empty for nodes behind a publicly trusted certificate. The macaroon needs
+// the invoices:read and invoices:write permissions, e.g. LND's invoice.macaroon.
+func NewLNDProvider(baseURL, macaroon, tlsCert string, invoiceStore *InvoiceStore) (*LNDProvider, error) {
+	if baseURL == "" {
+		return nil, fmt.Errorf("LND REST URL is required")
+	}
+	if macaroon == "" {
+		return nil, fmt.Errorf("LND macaroon is required")
+	}
+	if _, err := hex.DecodeString(macaroon); err != nil {
+		return nil, fmt.Errorf("LND macaroon must be hex encoded: %w", err)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if tlsCert != "" {
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM([]byte(tlsCert)) {
+			return nil, fmt.Errorf("invalid LND TLS certificate")
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: certPool}
+	}
+
+	return &LNDProvider{
+		baseURL:      strings.TrimRight(baseURL, "/"),
+		macaroon:     macaroon,
+		client:       &http.Client{Timeout: 30 * time.Second, Transport: transport},
+		pubkeyMap:    newLRUMap[string](providerMapSize),
+		invoiceStore: invoiceStore,
+	}, nil
+}
+
+// setHTTPClient makes the provider use client, or only its timeout and proxy when the
+// node has a certificate of its own to trust
+func (p *LNDProvider) setHTTPClient(client *http.Client) {
+	own, ok := p.client.Transport.(*http.Transport)
+	shared, sharedOK := client.Transport.(*http.Transport)
+	if !ok || own.TLSClientConfig == nil || !sharedOK {
+		p.client = client
+		return
+	}
+	transport := shared.Clone()
+	transport.TLSClientConfig = own.TLSClientConfig
+	p.client = &http.Client{Timeout: client.Timeout, Transport: transport}
+}
+
+// newLNDFromConfig creates the LND provider
+func newLNDFromConfig(config *Config, invoiceStore *InvoiceStore) (PaymentProvider, error) {
+	// The URI is only parsed here, so relays on other providers never trip over it,
+	// whether it comes from New or a provider swap through the admin API
+	if err := applyLNDConnect(config); err != nil {
+		return nil, err
+	}
+	if config.LNDURL == "" {
+		return nil, fmt.Errorf("LND_URL or LND_CONNECT required for lnd provider")
+	}
+	if config.LNDMacaroon == "" {
+		return nil, fmt.Errorf("LND_MACAROON or LND_CONNECT required for lnd provider")
+	}
+	return NewLNDProvider(config.LNDURL, config.LNDMacaroon, config.LNDTLSCert, invoiceStore)
+}
+
+// GetProviderName returns the provider name
+func (p *LNDProvider) GetProviderName() string {
+	return "lnd"
+}
+
+// LND REST API structures
+type LNDInvoiceRequest struct {
+	ValueMsat       int64  `json:"value_msat,string"`
+	Memo            string `json:"memo,omitempty"`
+	DescriptionHash []byte `json:"description_hash,omitempty"`
+	Expiry          int64  `json:"expiry,string"`
+}
+
+type LNDInvoiceResponse struct {
+	RHash          []byte `json:"r_hash"`
+	PaymentRequest string `json:"payment_request"`
+	AddIndex       string `json:"add_index"`
+}
+
+type LNDInvoice struct {
+	RHash          []byte `json:"r_hash"`
+	PaymentRequest string `json:"payment_request"`
+	ValueMsat      int64  `json:"value_msat,string"`
+	AmtPaidMsat    int64  `json:"amt_paid_msat,string"`
+	State          string `json:"state"`
+	SettleDate     int64  `json:"settle_date,string"`
+}
+
+type LNDSendRequest struct {
+	PaymentRequest string `json:"payment_request"`
+}
+
+type LNDSendResponse struct {
+	PaymentError    string `json:"payment_error"`
+	PaymentPreimage []byte `json:"payment_preimage"`
+	PaymentHash     []byte `json:"payment_hash"`
+	PaymentRoute    struct {
+		TotalFeesMsat int64 `json:"total_fees_msat,string"`
+		TotalAmtMsat  int64 `json:"total_amt_msat,string"`
+	} `json:"payment_route"`
+}
+
+// CreateInvoice creates a Lightning invoice on the node
+func (p *LNDProvider) CreateInvoice(ctx context.Context, amount int64, description string, pubkey string) (*Invoice, error) {
+	invoice, err := p.createInvoice(ctx, LNDInvoiceRequest{ValueMsat: amount, Memo: description}, pubkey)
+	if err != nil {
+		return nil, err
+	}
+	invoice.Description = description
+	return invoice, nil
+}
+
+// CreateInvoiceWithDescriptionHash creates an invoice committing to a description hash, as zap invoices need
+func (p *LNDProvider) CreateInvoiceWithDescriptionHash(ctx context.Context, amount int64, descriptionHash string, pubkey string) (*Invoice, error) {
+	hash, err := hex.DecodeString(descriptionHash)
+	if err != nil {
+		return nil, fmt.Errorf("invalid description hash: %w", err)
+	}
+	return p.createInvoice(ctx, LNDInvoiceRequest{ValueMsat: amount, DescriptionHash: hash}, pubkey)
+}
+
+// CreateAmountlessInvoice creates an invoice the payer picks the amount of
+func (p *LNDProvider) CreateAmountlessInvoice(ctx context.Context, description string, pubkey string) (*Invoice, error) {
+	return p.CreateInvoice(ctx, 0, description, pubkey)
+}
+
+// createInvoice adds an invoice and remembers who it is for
+func (p *LNDProvider) createInvoice(ctx context.Context, request LNDInvoiceRequest, pubkey string) (*Invoice, error) {
+	request.Expiry = int64(defaultInvoiceExpiry / time.Second)
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	body, err := p.do(ctx, OpCreateInvoice, "POST", "/v1/invoices", payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var invoiceResp LNDInvoiceResponse
+	if err := json.Unmarshal(body, &invoiceResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	paymentHash := hex.EncodeToString(invoiceResp.RHash)
+
+	p.mu.Lock()
+	p.pubkeyMap.Set(paymentHash, pubkey)
+	p.mu.Unlock()
+
+	if p.invoiceStore != nil {
+		p.invoiceStore.RecordCharge(paymentHash, invoiceResp.AddIndex, request.ValueMsat)
+	}
+
+	return &Invoice{
+		PaymentRequest: invoiceResp.PaymentRequest,
+		PaymentHash:    paymentHash,
+		Amount:         request.ValueMsat,
+		ExpiresAt:      time.Now().Add(time.Duration(request.Expiry) * time.Second),
+	}, nil
+}
+
+// VerifyPayment looks up an invoice on the node and reports whether it settled
+func (p *LNDProvider) VerifyPayment(ctx context.Context, paymentHash string) (*PaymentVerification, error) {
+	if _, err := hex.DecodeString(paymentHash); err != nil {
+		return nil, fmt.Errorf("invalid payment hash: %w", err)
+	}
+
+	body, err := p.do(ctx, OpVerifyPayment, "GET", "/v1/invoice/"+paymentHash, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var invoice LNDInvoice
+	if err := json.Unmarshal(body, &invoice); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	verification := &PaymentVerification{
+		Paid:        invoice.State == lndInvoiceSettled,
+		PaymentHash: paymentHash,
+		Amount:      invoice.AmtPaidMsat,
+	}
+	if verification.Paid {
+		verification.PaidAt = time.Unix(invoice.SettleDate, 0)
+	}
+	return verification, nil
+}
+
+// CheckExistingPayments checks for any existing payments for a pubkey and returns verification if paid
+func (p *LNDProvider) CheckExistingPayments(ctx context.Context, pubkey string) (*PaymentVerification, error) {
+	p.mu.RLock()
+	paymentHashes := pendingHashesFor(p.pubkeyMap, p.invoiceStore, p.GetProviderName(), pubkey)
+	p.mu.RUnlock()
+
+	for _, paymentHash := range paymentHashes {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		verification, err := p.VerifyPayment(ctx, paymentHash)
+		if err == nil && verification.Paid {
+			p.logInfo("💰 Found settled LND invoice! Payment hash: %s", paymentHash)
+			return verification, nil
+		}
+	}
+
+	return nil, nil // No paid payments found
+}
+
+// PayInvoice pays a BOLT11 invoice from the node, which needs a macaroon with the
+// offchain:write permission, e.g. LND's admin.macaroon
+func (p *LNDProvider) PayInvoice(ctx context.Context, paymentRequest string) (*Payout, error) {
+	payload, err := json.Marshal(LNDSendRequest{PaymentRequest: paymentRequest})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	body, err := p.do(ctx, OpPayInvoice, "POST", "/v1/channels/transactions", payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var sendResp LNDSendResponse
+	if err := json.Unmarshal(body, &sendResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	if sendResp.PaymentError != "" {
+		return nil, fmt.Errorf("LND %w: %s", errPayoutFailed, sendResp.PaymentError)
+	}
+
+	route := sendResp.PaymentRoute
+	return &Payout{
+		PaymentHash: hex.EncodeToString(sendResp.PaymentHash),
+		Amount:      route.TotalAmtMsat - route.TotalFeesMsat,
+		Fee:         route.TotalFeesMsat,
+		Preimage:    hex.EncodeToString(sendResp.PaymentPreimage),
+	}, nil
+}
+
+// LNDPayment is a payment sent by the node, as tracked by its router
+type LNDPayment struct {
+	PaymentHash     string `json:"payment_hash"`
+	ValueMsat       int64  `json:"value_msat,string"` // fees excluded
+	FeeMsat         int64  `json:"fee_msat,string"`
+	PaymentPreimage string `json:"payment_preimage"`
+	Status          string `json:"status"` // IN_FLIGHT, SUCCEEDED or FAILED
+}
+
+// lndNotFound is the gRPC code of payments the node never sent
+const lndNotFound = 5
+
+// LookupPayout looks up a payment the node sent by its payment hash, which needs a
+// macaroon with the offchain:read permission
+func (p *LNDProvider) LookupPayout(ctx context.Context, paymentHash string) (*Payout, error) {
+	hash, err := hex.DecodeString(paymentHash)
+	if err != nil {
+		return nil, fmt.Errorf("invalid payment hash: %w", err)
+	}
+
+	// The stream ends after the update of a settled or failed payment
+	body, err := p.do(ctx, OpPayInvoice, "GET", "/v2/router/track/"+base64.URLEncoding.EncodeToString(hash)+"?no_inflight_updates=true", nil)
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) && providerErr.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var update struct {
+		Result *LNDPayment `json:"result"`
+		Error  *struct {
+			Code     int    `json:"code"`
+			GRPCCode int    `json:"grpc_code"` // older versions
+			Message  string `json:"message"`
+		} `json:"error"`
+	}
+	line, _, _ := bytes.Cut(bytes.TrimSpace(body), []byte("\n"))
+	if err := json.Unmarshal(line, &update); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	if update.Error != nil {
+		if update.Error.Code == lndNotFound || update.Error.GRPCCode == lndNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("LND payment lookup failed: %s", update.Error.Message)
+	}
+	if update.Result == nil {
+		return nil, fmt.Errorf("LND payment lookup returned nothing")
+	}
+
+	switch update.Result.Status {
+	case "SUCCEEDED":
+		return &Payout{
+			PaymentHash: paymentHash,
+			Amount:      update.Result.ValueMsat,
+			Fee:         update.Result.FeeMsat,
+			Preimage:    update.Result.PaymentPreimage,
+		}, nil
+	case "FAILED":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("LND payment %s is still in flight", paymentHash)
+}
+
+// do sends a request to the LND REST API and returns the response body
+func (p *LNDProvider) do(ctx context.Context, op, method, path string, payload []byte) ([]byte, error) {
+	var reqBody io.Reader
+	if payload != nil {
+		reqBody = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, p.baseURL+path, reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Grpc-Metadata-macaroon", p.macaroon)
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, newRequestError(p.GetProviderName(), op, fmt.Errorf("failed to make request: %w", err))
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, newRequestError(p.GetProviderName(), op, fmt.Errorf("failed to read response: %w", err))
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStatusError(p.GetProviderName(), op, resp.StatusCode, body)
+	}
+	return body, nil
+}
@@ -0,0 +1,83 @@
+package payments
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// LNDConnect holds the LND REST connection settings carried by an lndconnect URI
+type LNDConnect struct {
+	URL      string // REST base URL, e.g. "https://mynode.m.voltageapp.io:8080"
+	Macaroon string // hex encoded macaroon
+	TLSCert  string // PEM encoded TLS certificate, empty when the node uses a public CA
+}
+
+// ParseLNDConnect parses an lndconnect://host:port?cert=...&macaroon=... URI as issued
+// by Voltage and other hosted LND services. cert and macaroon are base64url encoded.
+func ParseLNDConnect(uri string) (*LNDConnect, error) {
+	parsed, err := url.Parse(strings.TrimSpace(uri))
+	if err != nil {
+		return nil, fmt.Errorf("invalid lndconnect URI: %w", err)
+	}
+	if parsed.Scheme != "lndconnect" {
+		return nil, fmt.Errorf("invalid lndconnect URI: unexpected scheme %q", parsed.Scheme)
+	}
+	if parsed.Host == "" {
+		return nil, fmt.Errorf("invalid lndconnect URI: missing host")
+	}
+
+	query := parsed.Query()
+	if query.Get("macaroon") == "" {
+		return nil, fmt.Errorf("invalid lndconnect URI: missing macaroon")
+	}
+	macaroon, err := decodeBase64URL(query.Get("macaroon"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid lndconnect macaroon: %w", err)
+	}
+
+	connect := &LNDConnect{
+		URL:      "https://" + parsed.Host,
+		Macaroon: hex.EncodeToString(macaroon),
+	}
+
+	// The cert is left out for nodes behind a publicly trusted certificate
+	if certParam := query.Get("cert"); certParam != "" {
+		cert, err := decodeBase64URL(certParam)
+		if err != nil {
+			return nil, fmt.Errorf("invalid lndconnect cert: %w", err)
+		}
+		connect.TLSCert = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}))
+	}
+
+	return connect, nil
+}
+
+// applyLNDConnect fills in the LND settings of config not given explicitly from its lndconnect URI
+func applyLNDConnect(config *Config) error {
+	if config.LNDConnectURI == "" {
+		return nil
+	}
+	connect, err := ParseLNDConnect(config.LNDConnectURI)
+	if err != nil {
+		return err
+	}
+	if config.LNDURL == "" {
+		config.LNDURL = connect.URL
+	}
+	if config.LNDMacaroon == "" {
+		config.LNDMacaroon = connect.Macaroon
+	}
+	if config.LNDTLSCert == "" {
+		config.LNDTLSCert = connect.TLSCert
+	}
+	return nil
+}
+
+// decodeBase64URL decodes base64url with or without padding
+func decodeBase64URL(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
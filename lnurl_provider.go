@@ -0,0 +1,345 @@
+package payments
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcutil/bech32"
+)
+
+// bolt11 field layout, in 5 bit words
+const (
+	bolt11TimestampWords = 7
+	bolt11SignatureWords = 104
+	bolt11PaymentHashTag = 1
+)
+
+// LNURLProvider implements PaymentProvider interface for any Lightning address or LNURL-pay
+// endpoint. Invoices are fetched from the endpoint's callback and confirmed through
+// LNURL-verify (LUD-21), so no API key is needed but the endpoint must advertise verify URLs.
+type LNURLProvider struct {
+	payURL string // the LNURL-pay endpoint the address resolves to
+	// Map payment hash to pubkey for CheckExistingPayments
+	pubkeyMap *lruMap[string]
+	mu        sync.RWMutex
+	// Persistent storage references, keeping each invoice's verify URL as its charge ID
+	invoiceStore *InvoiceStore
+	providerHTTP
+	logTarget
+}
+
+// NewLNURLProvider creates a provider paying into a Lightning address (user@domain), a
+// bech32 encoded lnurl1... string or a plain LNURL-pay URL
+func NewLNURLProvider(address string, invoiceStore *InvoiceStore) (*LNURLProvider, error) {
+	payURL, err := resolveLNURL(address)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LNURLProvider{
+		payURL:       payURL,
+		pubkeyMap:    newLRUMap[string](providerMapSize),
+		invoiceStore: invoiceStore,
+	}, nil
+}
+
+// newLNURLFromConfig creates the LNURL-pay provider
+func newLNURLFromConfig(config *Config, invoiceStore *InvoiceStore) (PaymentProvider, error) {
+	if config.LightningAddress == "" {
+		return nil, fmt.Errorf("LIGHTNING_ADDRESS required for lnurl provider")
+	}
+	return NewLNURLProvider(config.LightningAddress, invoiceStore)
+}
+
+// resolveLNURL returns the LNURL-pay URL of a Lightning address, lnurl1... string or URL
+func resolveLNURL(address string) (string, error) {
+	address = strings.TrimPrefix(strings.TrimSpace(address), "lightning:")
+	switch {
+	case strings.HasPrefix(strings.ToLower(address), "lnurl1"):
+		_, words, err := bech32.DecodeNoLimit(strings.ToLower(address))
+		if err != nil {
+			return "", fmt.Errorf("invalid LNURL: %w", err)
+		}
+		decoded, err := bech32.ConvertBits(words, 5, 8, false)
+		if err != nil {
+			return "", fmt.Errorf("invalid LNURL: %w", err)
+		}
+		address = string(decoded)
+	case strings.Contains(address, "@"):
+		name, domain, _ := strings.Cut(address, "@")
+		if name == "" || domain == "" {
+			return "", fmt.Errorf("invalid Lightning address %q", address)
+		}
+		scheme := "https"
+		if strings.HasSuffix(domain, ".onion") {
+			scheme = "http"
+		}
+		address = scheme + "://" + domain + "/.well-known/lnurlp/" + name
+	}
+
+	parsed, err := url.Parse(address)
+	if err != nil || parsed.Host == "" || (parsed.Scheme != "https" && parsed.Scheme != "http") {
+		return "", fmt.Errorf("invalid LNURL-pay address %q", address)
+	}
+	return parsed.String(), nil
+}
+
+// GetProviderName returns the provider name
+func (p *LNURLProvider) GetProviderName() string {
+	return "lnurl"
+}
+
+// LNURL-pay structures
+type LNURLPayParams struct {
+	Tag            string `json:"tag"`
+	Callback       string `json:"callback"`
+	MinSendable    int64  `json:"minSendable"`
+	MaxSendable    int64  `json:"maxSendable"`
+	CommentAllowed int    `json:"commentAllowed"`
+	Status         string `json:"status"`
+	Reason         string `json:"reason"`
+}
+
+type LNURLPayCallbackResponse struct {
+	PR     string `json:"pr"`
+	Verify string `json:"verify"`
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+type LNURLVerifyResponse struct {
+	Status   string `json:"status"`
+	Reason   string `json:"reason"`
+	Settled  bool   `json:"settled"`
+	Preimage string `json:"preimage"`
+	PR       string `json:"pr"`
+}
+
+// CreateInvoice fetches an invoice from the LNURL-pay callback, passing the description as
+// comment when the endpoint allows one
+func (p *LNURLProvider) CreateInvoice(ctx context.Context, amount int64, description string, pubkey string) (*Invoice, error) {
+	var params LNURLPayParams
+	if err := p.getJSON(ctx, OpCreateInvoice, p.payURL, &params); err != nil {
+		return nil, err
+	}
+	if params.Status == "ERROR" {
+		return nil, fmt.Errorf("LNURL-pay endpoint error: %s", params.Reason)
+	}
+	if params.Tag != "payRequest" || params.Callback == "" {
+		return nil, fmt.Errorf("%s is not an LNURL-pay endpoint", p.payURL)
+	}
+	if amount < params.MinSendable || amount > params.MaxSendable {
+		return nil, fmt.Errorf("amount %d msat outside the endpoint's range of %d-%d msat", amount, params.MinSendable, params.MaxSendable)
+	}
+
+	callback, err := url.Parse(params.Callback)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LNURL-pay callback: %w", err)
+	}
+	query := callback.Query()
+	query.Set("amount", strconv.FormatInt(amount, 10))
+	if params.CommentAllowed > 0 && description != "" {
+		comment := description
+		if len(comment) > params.CommentAllowed {
+			comment = comment[:params.CommentAllowed]
+		}
+		query.Set("comment", comment)
+	}
+	callback.RawQuery = query.Encode()
+
+	var payment LNURLPayCallbackResponse
+	if err := p.getJSON(ctx, OpCreateInvoice, callback.String(), &payment); err != nil {
+		return nil, err
+	}
+	if payment.Status == "ERROR" {
+		return nil, fmt.Errorf("LNURL-pay callback error: %s", payment.Reason)
+	}
+	// Without LNURL-verify there is no way to tell that the invoice was paid
+	if payment.Verify == "" {
+		return nil, fmt.Errorf("%s does not support LNURL-verify, payments could not be confirmed", p.payURL)
+	}
+
+	// The invoice comes from a third party, check it is for what was asked
+	paymentHash, invoiceAmount, err := decodeBolt11(payment.PR)
+	if err != nil {
+		return nil, err
+	}
+	if invoiceAmount != amount {
+		return nil, fmt.Errorf("LNURL-pay invoice is for %d msat instead of %d msat", invoiceAmount, amount)
+	}
+
+	p.mu.Lock()
+	p.pubkeyMap.Set(paymentHash, pubkey)
+	p.mu.Unlock()
+
+	if p.invoiceStore != nil {
+		p.invoiceStore.RecordCharge(paymentHash, payment.Verify, amount)
+	}
+
+	return &Invoice{
+		PaymentRequest: payment.PR,
+		PaymentHash:    paymentHash,
+		Amount:         amount,
+		Description:    description,
+		ExpiresAt:      time.Now().Add(defaultInvoiceExpiry),
+	}, nil
+}
+
+// VerifyPayment asks the invoice's LNURL-verify URL whether it settled
+func (p *LNURLProvider) VerifyPayment(ctx context.Context, paymentHash string) (*PaymentVerification, error) {
+	var verifyURL string
+	if p.invoiceStore != nil {
+		verifyURL, _ = p.invoiceStore.ChargeID(paymentHash)
+	}
+	if verifyURL == "" {
+		return nil, fmt.Errorf("no LNURL-verify URL found for payment hash: %s", paymentHash)
+	}
+
+	var status LNURLVerifyResponse
+	if err := p.getJSON(ctx, OpVerifyPayment, verifyURL, &status); err != nil {
+		return nil, err
+	}
+	if status.Status == "ERROR" {
+		return nil, &ProviderError{
+			Provider: p.GetProviderName(),
+			Op:       OpVerifyPayment,
+			Err:      fmt.Errorf("LNURL-verify error: %s", status.Reason),
+		}
+	}
+
+	verification := &PaymentVerification{
+		Paid:        status.Settled,
+		PaymentHash: paymentHash,
+	}
+	if verification.Paid {
+		// LNURL-verify reports neither amount nor time, use what was invoiced
+		verification.PaidAt = time.Now()
+		if tracked, exists := p.invoiceStore.Get(paymentHash); exists {
+			verification.Amount = tracked.Amount
+		}
+	}
+	return verification, nil
+}
+
+// CheckExistingPayments checks for any existing payments for a pubkey and returns verification if paid
+func (p *LNURLProvider) CheckExistingPayments(ctx context.Context, pubkey string) (*PaymentVerification, error) {
+	p.mu.RLock()
+	paymentHashes := pendingHashesFor(p.pubkeyMap, p.invoiceStore, p.GetProviderName(), pubkey)
+	p.mu.RUnlock()
+
+	for _, paymentHash := range paymentHashes {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		verification, err := p.VerifyPayment(ctx, paymentHash)
+		if err == nil && verification.Paid {
+			p.logInfo("💰 Found settled LNURL invoice! Payment hash: %s", paymentHash)
+			return verification, nil
+		}
+	}
+
+	return nil, nil // No paid payments found
+}
+
+// getJSON fetches an LNURL endpoint and decodes its JSON response
+func (p *LNURLProvider) getJSON(ctx context.Context, op, endpoint string, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := p.httpClient().Do(req)
+	if err != nil {
+		return newRequestError(p.GetProviderName(), op, fmt.Errorf("failed to make request: %w", err))
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return newRequestError(p.GetProviderName(), op, fmt.Errorf("failed to read response: %w", err))
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return newStatusError(p.GetProviderName(), op, resp.StatusCode, body)
+	}
+	if err := json.Unmarshal(body, result); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	return nil
+}
+
+// decodeBolt11 returns the payment hash and amount in msat of a BOLT11 invoice,
+// without checking its signature
+func decodeBolt11(invoice string) (string, int64, error) {
+	hrp, words, err := bech32.DecodeNoLimit(strings.ToLower(strings.TrimPrefix(invoice, "lightning:")))
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid invoice: %w", err)
+	}
+	if !strings.HasPrefix(hrp, "ln") || len(words) < bolt11TimestampWords+bolt11SignatureWords {
+		return "", 0, fmt.Errorf("invalid invoice")
+	}
+
+	amount, err := bolt11Amount(hrp)
+	if err != nil {
+		return "", 0, err
+	}
+
+	fields := words[bolt11TimestampWords : len(words)-bolt11SignatureWords]
+	for len(fields) >= 3 {
+		tag, length := fields[0], int(fields[1])<<5|int(fields[2])
+		if len(fields) < 3+length {
+			break
+		}
+		data := fields[3 : 3+length]
+		fields = fields[3+length:]
+		if tag != bolt11PaymentHashTag {
+			continue
+		}
+		hash, err := bech32.ConvertBits(data, 5, 8, false)
+		if err != nil || len(hash) < 32 {
+			return "", 0, fmt.Errorf("invalid invoice payment hash")
+		}
+		return hex.EncodeToString(hash[:32]), amount, nil
+	}
+	return "", 0, fmt.Errorf("invoice has no payment hash")
+}
+
+// bolt11Amount parses the amount of a BOLT11 human readable part, e.g. "lnbc2500u", in msat
+func bolt11Amount(hrp string) (int64, error) {
+	start := strings.IndexAny(hrp, "0123456789")
+	if start < 0 {
+		return 0, nil // any amount
+	}
+	digits, multiplier := hrp[start:], byte(0)
+	if last := digits[len(digits)-1]; last < '0' || last > '9' {
+		digits, multiplier = digits[:len(digits)-1], last
+	}
+	value, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid invoice amount: %w", err)
+	}
+
+	// 1 BTC is 10^11 msat
+	switch multiplier {
+	case 0:
+		return value * 100_000_000_000, nil
+	case 'm':
+		return value * 100_000_000, nil
+	case 'u':
+		return value * 100_000, nil
+	case 'n':
+		return value * 100, nil
+	case 'p':
+		return value / 10, nil
+	}
+	return 0, fmt.Errorf("invalid invoice amount multiplier %q", multiplier)
+}
@@ -0,0 +1,280 @@
+package payments
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Lightning address server limits
+const (
+	defaultLNAddressName    = "relay"
+	minLNURLSendableMsat    = 1000
+	maxLNURLSendableMsat    = 100_000_000_000 // 100M sats, larger payments still buy the best tier
+	lnurlCommentAllowed     = 200
+	donationTier            = "donation"
+	lnurlDefaultDescription = "Relay membership or donation"
+)
+
+// pendingLNURLPayment is a Lightning address invoice waiting to be paid
+type pendingLNURLPayment struct {
+	payer      string // pubkey getting access, empty for anonymous donations
+	membership bool   // false for donations
+	zapRequest *nostr.Event
+	rawRequest string
+	invoice    *Invoice
+}
+
+// LNAddressEnabled reports whether the relay serves its own Lightning address
+func (s *System) LNAddressEnabled() bool {
+	return s.config.PublicURL != "" && (s.config.LNAddressEnabled || s.ZapsEnabled())
+}
+
+// LNAddress returns the relay's Lightning address, e.g. "relay@relay.example.com", or ""
+// if the relay does not serve one
+func (s *System) LNAddress() string {
+	if !s.LNAddressEnabled() {
+		return ""
+	}
+	parsed, err := url.Parse(s.config.PublicURL)
+	if err != nil || parsed.Host == "" {
+		return ""
+	}
+	return s.config.LNAddressName + "@" + parsed.Host
+}
+
+// lnurlpHandler serves the LNURL-pay endpoint of the relay's Lightning address
+func (s *System) lnurlpHandler(w http.ResponseWriter, r *http.Request) {
+	if r.PathValue("name") != s.config.LNAddressName {
+		writeLNURLError(w, http.StatusNotFound, "unknown user")
+		return
+	}
+
+	response := map[string]interface{}{
+		"tag":            "payRequest",
+		"callback":       strings.TrimSuffix(s.config.PublicURL, "/") + "/lnurlp/" + s.config.LNAddressName + "/callback",
+		"minSendable":    minLNURLSendableMsat,
+		"maxSendable":    maxLNURLSendableMsat,
+		"metadata":       s.lnurlMetadata(),
+		"commentAllowed": lnurlCommentAllowed,
+	}
+	if s.ZapsEnabled() {
+		response["allowsNostr"] = true
+		response["nostrPubkey"] = s.relayPubkey
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// lnurlpCallbackHandler creates the invoice for a payment to the relay's Lightning address.
+// Zaps and payments whose comment names a pubkey buy membership, anything else is a donation.
+func (s *System) lnurlpCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.PathValue("name") != s.config.LNAddressName {
+		writeLNURLError(w, http.StatusNotFound, "unknown user")
+		return
+	}
+
+	query := r.URL.Query()
+	amount, err := strconv.ParseInt(query.Get("amount"), 10, 64)
+	if err != nil || amount < minLNURLSendableMsat || amount > maxLNURLSendableMsat {
+		writeLNURLError(w, http.StatusBadRequest, "invalid amount")
+		return
+	}
+	if len(query.Get("comment")) > lnurlCommentAllowed {
+		writeLNURLError(w, http.StatusBadRequest, "comment too long")
+		return
+	}
+
+	payment := &pendingLNURLPayment{}
+	if rawRequest := query.Get("nostr"); rawRequest != "" && s.ZapsEnabled() {
+		zapRequest, err := parseZapRequest(rawRequest, amount)
+		if err != nil {
+			writeLNURLError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		payment.zapRequest, payment.rawRequest = zapRequest, rawRequest
+		payment.payer = zapRequest.PubKey
+	} else if pubkey, err := parsePubkey(query.Get("comment")); err == nil {
+		payment.payer = pubkey
+	}
+
+	if payment.payer != "" && s.IsDenylisted(payment.payer) {
+		writeLNURLError(w, http.StatusForbidden, "pubkey is banned from this relay")
+		return
+	}
+
+	// Amounts below the cheapest tier are still welcome as donations, unless every amount
+	// buys its share of a day
+	tier, isMembership := s.tierForAmount(amount)
+	if !isMembership && s.ProratedPricingEnabled() {
+		tier, isMembership = s.Tiers()[0], true
+	}
+	payment.membership = isMembership && payment.payer != ""
+
+	if err := s.allowInvoice(r.Context(), payment.payer); err != nil {
+		writeLNURLError(w, http.StatusTooManyRequests, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.invoiceTimeout)
+	defer cancel()
+
+	if payment.zapRequest != nil {
+		payment.invoice, err = s.createZapInvoice(ctx, payment.payer, amount, payment.rawRequest)
+	} else {
+		payment.invoice, err = s.createLNURLInvoice(ctx, payment.payer, amount)
+	}
+	if err != nil {
+		s.logError("❌ Failed to create Lightning address invoice: %v", err)
+		writeLNURLError(w, http.StatusBadGateway, "could not create invoice")
+		return
+	}
+
+	// The invoice poller settles it like membership invoices, see settleLNURLPayment
+	if payment.membership {
+		s.trackInvoice(payment.invoice, payment.payer, tier.Name, accessDurationFor(tier.Duration))
+		s.with(pubkeyAttr(payment.payer)).logInfo("⚡ Lightning address invoice for %s... (%s tier, %d msat)", payment.payer[:16], tier.Name, amount)
+	} else {
+		s.trackInvoice(payment.invoice, payment.payer, donationTier, 0)
+		s.logInfo("⚡ Lightning address donation invoice (%d msat)", amount)
+	}
+	s.invoices.SetPurpose(payment.invoice.PaymentHash, PurposeLNAddress, payment.rawRequest)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"pr":     payment.invoice.PaymentRequest,
+		"routes": []interface{}{},
+	})
+}
+
+// createLNURLInvoice creates an invoice committing to the LNURL metadata when the provider supports it
+func (s *System) createLNURLInvoice(ctx context.Context, pubkey string, amount int64) (*Invoice, error) {
+	ctx = withInvoicePurpose(ctx, PurposeLNAddress)
+	if provider, ok := s.descriptionHashProvider(ctx, amount); ok {
+		hash := sha256.Sum256([]byte(s.lnurlMetadata()))
+		return provider.CreateInvoiceWithDescriptionHash(ctx, amount, hex.EncodeToString(hash[:]), pubkey)
+	}
+	return s.provider.CreateInvoice(ctx, amount, s.lnurlDescription(), pubkey)
+}
+
+// settleLNURLPayment grants access for a paid Lightning address invoice or records the
+// donation, then answers zaps with a receipt. It runs once per invoice, however many of the
+// poller, cleanup and /verify-payment see it paid.
+func (s *System) settleLNURLPayment(ctx context.Context, paymentHash string, verification *PaymentVerification) {
+	s.lnurlMutex.Lock()
+	defer s.lnurlMutex.Unlock()
+
+	invoice, tracked := s.invoices.Get(paymentHash)
+	if !tracked || !invoice.pending() {
+		return
+	}
+
+	if invoice.Tier == donationTier {
+		s.invoices.MarkPaid(paymentHash, verification.Amount, verification.PaidAt)
+		s.recordDonation(invoice.Pubkey, verification)
+		s.invoices.MarkGranted(paymentHash)
+	} else if err := s.grantPaidAccess(ctx, invoice.Pubkey, verification, SourcePayment); err != nil && !errors.Is(err, errDeniedPubkey) {
+		s.with(pubkeyAttr(invoice.Pubkey)).logError("❌ Failed to grant access for Lightning address payment from %s: %v", invoice.Pubkey[:16], err)
+		return // retried the next time the invoice is checked
+	}
+
+	if invoice.ZapRequest == "" {
+		return
+	}
+	var zapRequest nostr.Event
+	if err := json.Unmarshal([]byte(invoice.ZapRequest), &zapRequest); err != nil {
+		s.with(paymentHashAttr(paymentHash)).logWarn("⚠️ Failed to parse zap request of invoice %.16s...: %v", paymentHash, err)
+		return
+	}
+	paid := &Invoice{PaymentRequest: invoice.PaymentRequest, PaymentHash: paymentHash, Amount: invoice.Amount}
+	s.publishZapReceipt(context.WithoutCancel(ctx), &zapRequest, invoice.ZapRequest, paid, verification.PaidAt)
+}
+
+// recordDonation books a payment that did not buy access
+func (s *System) recordDonation(pubkey string, verification *PaymentVerification) {
+	err := s.ledger.Record(LedgerEntry{
+		Pubkey:      pubkey,
+		PaymentHash: verification.PaymentHash,
+		Amount:      verification.Amount,
+		Provider:    s.providerNameFor(verification.PaymentHash),
+		Tier:        donationTier,
+		PaidAt:      verification.PaidAt,
+	})
+	if err != nil {
+		s.logWarn("⚠️ Failed to record donation in ledger: %v", err)
+	}
+
+	s.statsHub.Publish(StatsDelta{
+		Type:       "payment",
+		Pubkey:     pubkey,
+		AmountMsat: verification.Amount,
+		Provider:   s.providerNameFor(verification.PaymentHash),
+		Tier:       donationTier,
+		At:         time.Now(),
+	})
+	s.logInfo("🎁 Received donation of %d msat", verification.Amount)
+}
+
+// lnurlMetadata returns the LUD-06 metadata of the relay's Lightning address
+func (s *System) lnurlMetadata() string {
+	metadata, _ := json.Marshal([][]string{
+		{"text/plain", s.lnurlDescription()},
+		{"text/identifier", s.LNAddress()},
+	})
+	return string(metadata)
+}
+
+// lnurlDescription describes what a payment to the relay's Lightning address buys
+func (s *System) lnurlDescription() string {
+	if s.ProratedPricingEnabled() {
+		return fmt.Sprintf("Relay membership at %d sats a day, zap or add your npub as comment.", s.config.PricePerDay/1000)
+	}
+	if min := s.minTierAmount(); min > 0 {
+		return fmt.Sprintf("Relay membership from %d sats, zap or add your npub as comment. Smaller amounts are donations.", min/1000)
+	}
+	return lnurlDefaultDescription
+}
+
+// tierForAmount returns the most valuable tier an amount pays for
+func (s *System) tierForAmount(amount int64) (Tier, bool) {
+	var best Tier
+	found := false
+	for _, tier := range s.Tiers() {
+		if tier.Amount <= amount && (!found || tier.Amount > best.Amount) {
+			best, found = tier, true
+		}
+	}
+	return best, found
+}
+
+// minTierAmount returns the price of the cheapest tier
+func (s *System) minTierAmount() int64 {
+	var min int64
+	for i, tier := range s.Tiers() {
+		if i == 0 || tier.Amount < min {
+			min = tier.Amount
+		}
+	}
+	return min
+}
+
+// writeLNURLError writes an LNURL error response
+func writeLNURLError(w http.ResponseWriter, status int, reason string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "ERROR",
+		"reason": reason,
+	})
+}
@@ -0,0 +1,174 @@
+package payments
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// logTarget is where a System, one of its stores or a provider logs to, set by New from
+// Config.Logger or Config.LogLevel and Config.LogFormat, so several Systems in a process
+// each log as configured. Embedded, it provides logDebug, logInfo, logWarn and logError.
+// Unset, or nil, logs go to slog.Default() at info level.
+type logTarget struct {
+	logger atomic.Pointer[slog.Logger]
+}
+
+// newLogTarget creates a log target logging to logger
+func newLogTarget(logger *slog.Logger) *logTarget {
+	target := &logTarget{}
+	target.setLogger(logger)
+	return target
+}
+
+// setLogger makes the owner log to logger
+func (t *logTarget) setLogger(logger *slog.Logger) {
+	t.logger.Store(logger)
+}
+
+// loggerUser is implemented by stores and providers logging to a logger of their own
+type loggerUser interface {
+	setLogger(logger *slog.Logger)
+}
+
+// useLogger hands logger to v if it logs to a logger of its own
+func useLogger(v any, logger *slog.Logger) {
+	if user, ok := v.(loggerUser); ok {
+		user.setLogger(logger)
+	}
+}
+
+// with returns a log target adding attrs to each line, so log pipelines can pick out the
+// lines of one member or payment
+func (t *logTarget) with(attrs ...slog.Attr) *logTarget {
+	args := make([]any, len(attrs))
+	for i, attr := range attrs {
+		args[i] = attr
+	}
+	return newLogTarget(t.currentLogger().With(args...))
+}
+
+// pubkeyAttr is the attribute of the pubkey a log line is about, in full where the message
+// shortens it
+func pubkeyAttr(pubkey string) slog.Attr {
+	return slog.String("pubkey", pubkey)
+}
+
+// paymentHashAttr is the attribute of the payment a log line is about
+func paymentHashAttr(paymentHash string) slog.Attr {
+	return slog.String("payment_hash", paymentHash)
+}
+
+// newLogger builds the logger described by config
+func newLogger(config Config) (*slog.Logger, error) {
+	if config.Logger != nil {
+		return config.Logger, nil
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(config.LogLevel)); err != nil {
+		return nil, fmt.Errorf("invalid log level: %s (debug, info, warn or error)", config.LogLevel)
+	}
+	options := &slog.HandlerOptions{Level: level}
+	switch strings.ToLower(config.LogFormat) {
+	case "":
+		// Through the standard log package, as the relay's own logs
+		return slog.New(&levelHandler{level: level, Handler: slog.Default().Handler()}), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, options)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, options)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format: %s (text or json)", config.LogFormat)
+	}
+}
+
+// levelHandler passes records at or above level to Handler, whatever level Handler itself
+// is set to
+type levelHandler struct {
+	level slog.Leveler
+	slog.Handler
+}
+
+func (h *levelHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{level: h.level, Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{level: h.level, Handler: h.Handler.WithGroup(name)}
+}
+
+// currentLogger returns the logger set with setLogger, slog.Default() before
+func (t *logTarget) currentLogger() *slog.Logger {
+	if t != nil {
+		if l := t.logger.Load(); l != nil {
+			return l
+		}
+	}
+	return slog.Default()
+}
+
+// logAt logs a message formatted like fmt.Sprintf at level. Disabled levels skip the
+// formatting, so debug lines cost nothing on busy relays.
+func logAt(l *slog.Logger, level slog.Level, format string, args ...any) {
+	ctx := context.Background()
+	if !l.Enabled(ctx, level) {
+		return
+	}
+	var pcs [1]uintptr
+	runtime.Callers(3, pcs[:]) // skip runtime.Callers, logAt and the level function
+	record := slog.NewRecord(time.Now(), level, fmt.Sprintf(format, args...), pcs[0])
+	_ = l.Handler().Handle(ctx, record)
+}
+
+// logDebug logs details useful when debugging a provider, such as its API responses
+func (t *logTarget) logDebug(format string, args ...any) {
+	logAt(t.currentLogger(), slog.LevelDebug, format, args...)
+}
+
+// logInfo logs what the system does, such as payments and memberships granted
+func (t *logTarget) logInfo(format string, args ...any) {
+	logAt(t.currentLogger(), slog.LevelInfo, format, args...)
+}
+
+// logWarn logs failures the system recovers from
+func (t *logTarget) logWarn(format string, args ...any) {
+	logAt(t.currentLogger(), slog.LevelWarn, format, args...)
+}
+
+// logError logs failures needing attention
+func (t *logTarget) logError(format string, args ...any) {
+	logAt(t.currentLogger(), slog.LevelError, format, args...)
+}
+
+// The package-level functions log to slog.Default(), for code running outside a System
+// such as stores loading their files before New hands them its logger
+
+// logDebug logs details useful when debugging
+func logDebug(format string, args ...any) {
+	logAt(slog.Default(), slog.LevelDebug, format, args...)
+}
+
+// logInfo logs what the package does
+func logInfo(format string, args ...any) {
+	logAt(slog.Default(), slog.LevelInfo, format, args...)
+}
+
+// logWarn logs failures the package recovers from
+func logWarn(format string, args ...any) {
+	logAt(slog.Default(), slog.LevelWarn, format, args...)
+}
+
+// logError logs failures needing attention
+func logError(format string, args ...any) {
+	logAt(slog.Default(), slog.LevelError, format, args...)
+}
@@ -0,0 +1,89 @@
+package payments
+
+import (
+	"container/list"
+	"sync"
+)
+
+// providerMapSize bounds the invoice mappings each provider keeps in memory. Older ones are
+// evicted and looked up in the invoice store instead, so invoice spam can't grow memory.
+const providerMapSize = 10000
+
+// lruMap is a map holding at most capacity entries, evicting the least recently used one
+// when full. It is safe for concurrent use.
+type lruMap[V any] struct {
+	capacity int
+
+	mutex   sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // most recently used first
+}
+
+// lruEntry is an element of lruMap.order
+type lruEntry[V any] struct {
+	key   string
+	value V
+}
+
+// newLRUMap creates an lruMap holding at most capacity entries
+func newLRUMap[V any](capacity int) *lruMap[V] {
+	return &lruMap[V]{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Get returns the value of key and marks it as recently used
+func (m *lruMap[V]) Get(key string) (V, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	element, exists := m.entries[key]
+	if !exists {
+		var zero V
+		return zero, false
+	}
+	m.order.MoveToFront(element)
+	return element.Value.(*lruEntry[V]).value, true
+}
+
+// Set stores value under key, evicting the least recently used entry when full
+func (m *lruMap[V]) Set(key string, value V) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if element, exists := m.entries[key]; exists {
+		element.Value.(*lruEntry[V]).value = value
+		m.order.MoveToFront(element)
+		return
+	}
+	m.entries[key] = m.order.PushFront(&lruEntry[V]{key: key, value: value})
+	if m.order.Len() > m.capacity {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.entries, oldest.Value.(*lruEntry[V]).key)
+	}
+}
+
+// Len returns the number of entries
+func (m *lruMap[V]) Len() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.order.Len()
+}
+
+// KeysWith returns the keys whose value matches, most recently used first
+func (m *lruMap[V]) KeysWith(match func(V) bool) []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var keys []string
+	for element := m.order.Front(); element != nil; element = element.Next() {
+		entry := element.Value.(*lruEntry[V])
+		if match(entry.value) {
+			keys = append(keys, entry.key)
+		}
+	}
+	return keys
+}
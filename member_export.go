@@ -0,0 +1,293 @@
+package payments
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxImportSize bounds member import uploads
+const maxImportSize = 32 << 20
+
+// memberCSVHeader is the column order of CSV exports. Imports only need pubkey and
+// expires_at, in any order.
+var memberCSVHeader = []string{"pubkey", "expires_at", "tier", "source", "amount", "payment_hash", "created_at"}
+
+// ImportReport summarizes a member import
+type ImportReport struct {
+	Rows    int `json:"rows"`    // members in the import
+	Added   int `json:"added"`   // new members
+	Updated int `json:"updated"` // existing members whose access now lasts longer
+	Skipped int `json:"skipped"` // expired, or already members for at least as long
+}
+
+// ExportMembers writes all member records, expired or not, as "json" or "csv"
+func (s *System) ExportMembers(w io.Writer, format string) error {
+	members := s.paidAccessStorage.ListMembers("")
+
+	switch format {
+	case "json", "":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(map[string]interface{}{
+			"members": members,
+		})
+	case "csv":
+		writer := csv.NewWriter(w)
+		writer.Write(memberCSVHeader)
+		for _, member := range members {
+			writer.Write([]string{
+				member.Pubkey,
+				formatImportTime(member.ExpiresAt),
+				member.Tier,
+				member.Source,
+				strconv.FormatInt(member.Amount, 10),
+				member.PaymentHash,
+				formatImportTime(member.CreatedAt),
+			})
+		}
+		writer.Flush()
+		return writer.Error()
+	}
+	return fmt.Errorf("unsupported export format: %s (supported: json, csv)", format)
+}
+
+// ImportMembers adds members exported by ExportMembers or converted from other relay
+// software, as "json" or "csv". An empty expires_at means permanent access. Members
+// without a source are marked as imported. Nothing is imported if any row is invalid.
+func (s *System) ImportMembers(r io.Reader, format string) (*ImportReport, error) {
+	var members []PaidAccessMember
+	var err error
+	switch format {
+	case "json", "":
+		members, err = parseMembersJSON(r)
+	case "csv":
+		members, err = parseMembersCSV(r)
+	default:
+		return nil, fmt.Errorf("unsupported import format: %s (supported: json, csv)", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	report := &ImportReport{Rows: len(members)}
+	var current []PaidAccessMember
+	for _, member := range members {
+		if !member.ExpiresAt.IsZero() && !member.ExpiresAt.After(now) {
+			continue
+		}
+		if member.Source == "" {
+			member.Source = SourceImport
+		}
+		if member.CreatedAt.IsZero() {
+			member.CreatedAt = now
+		}
+		current = append(current, member)
+	}
+
+	report.Added, report.Updated, err = s.paidAccessStorage.ImportMembers(current)
+	if err != nil {
+		return nil, err
+	}
+	report.Skipped = report.Rows - report.Added - report.Updated
+
+	s.logInfo("📥 Imported members: %d added, %d updated, %d skipped", report.Added, report.Updated, report.Skipped)
+	return report, nil
+}
+
+// parseMembersJSON reads {"members": [...]} as written by ExportMembers, or a bare array
+func parseMembersJSON(r io.Reader) ([]PaidAccessMember, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read members: %w", err)
+	}
+
+	var members []PaidAccessMember
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "[") {
+		err = json.Unmarshal(data, &members)
+	} else {
+		var wrapped struct {
+			Members []PaidAccessMember `json:"members"`
+		}
+		err = json.Unmarshal(data, &wrapped)
+		members = wrapped.Members
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid members JSON: %w", err)
+	}
+
+	for i := range members {
+		pubkey, err := parsePubkey(members[i].Pubkey)
+		if err != nil {
+			return nil, fmt.Errorf("member %d: %w", i+1, err)
+		}
+		members[i].Pubkey = pubkey
+	}
+	return members, nil
+}
+
+// parseMembersCSV reads a CSV with a header row naming its columns
+func parseMembersCSV(r io.Reader) ([]PaidAccessMember, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"pubkey", "expires_at"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV is missing the %s column", required)
+		}
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var members []PaidAccessMember
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		pubkey, err := parsePubkey(field(record, "pubkey"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		member := PaidAccessMember{
+			Pubkey:      pubkey,
+			Tier:        field(record, "tier"),
+			Source:      field(record, "source"),
+			PaymentHash: field(record, "payment_hash"),
+		}
+		if member.ExpiresAt, err = parseImportTime(field(record, "expires_at")); err != nil {
+			return nil, fmt.Errorf("line %d: invalid expires_at: %w", line, err)
+		}
+		if member.CreatedAt, err = parseImportTime(field(record, "created_at")); err != nil {
+			return nil, fmt.Errorf("line %d: invalid created_at: %w", line, err)
+		}
+		if amount := field(record, "amount"); amount != "" {
+			if member.Amount, err = strconv.ParseInt(amount, 10, 64); err != nil {
+				return nil, fmt.Errorf("line %d: invalid amount: %w", line, err)
+			}
+		}
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+// parseImportTime accepts RFC 3339 or unix seconds, empty is the zero time
+func parseImportTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds == 0 {
+			return time.Time{}, nil
+		}
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// formatImportTime formats times for CSV, the zero time as empty
+func formatImportTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// memberFormat picks the import or export format from the query or the content type
+func memberFormat(r *http.Request) string {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+		return "csv"
+	}
+	return "json"
+}
+
+// adminExportMembersHandler downloads all member records
+func (s *System) adminExportMembersHandler(w http.ResponseWriter, r *http.Request) {
+	format := memberFormat(r)
+	switch format {
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+	default:
+		http.Error(w, "format must be json or csv", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "members."+format))
+	if err := s.ExportMembers(w, format); err != nil {
+		s.logError("❌ Failed to export members: %v", err)
+	}
+}
+
+// adminMembersCSVHandler downloads pubkey, amount, created_at and expires_at of every member
+// for bookkeeping, ?status=active or ?status=expired to filter
+func (s *System) adminMembersCSVHandler(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", "all", "active", "expired":
+	default:
+		http.Error(w, "status must be active, expired or all", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "members.csv"))
+
+	now := time.Now()
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"pubkey", "amount", "created_at", "expires_at"})
+	for _, member := range s.paidAccessStorage.ListMembers("") {
+		// Permanent members never expire
+		active := member.ExpiresAt.IsZero() || member.ExpiresAt.After(now)
+		if (status == "active" && !active) || (status == "expired" && active) {
+			continue
+		}
+		writer.Write([]string{
+			member.Pubkey,
+			strconv.FormatInt(member.Amount, 10),
+			formatImportTime(member.CreatedAt),
+			formatImportTime(member.ExpiresAt),
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		s.logError("❌ Failed to export members CSV: %v", err)
+	}
+}
+
+// adminImportMembersHandler adds members from an uploaded export
+func (s *System) adminImportMembersHandler(w http.ResponseWriter, r *http.Request) {
+	report, err := s.ImportMembers(io.LimitReader(r.Body, maxImportSize), memberFormat(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
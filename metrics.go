@@ -0,0 +1,165 @@
+package payments
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// metricsPrefix namespaces every exported metric
+const metricsPrefix = "khatru_payments_"
+
+// providerLatencyBuckets are the upper bounds, in seconds, of the provider latency histogram
+var providerLatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// metrics counts what GetStats doesn't keep, for the Prometheus endpoint
+type metrics struct {
+	invoicesCreated uint64
+	eventsAccepted  uint64
+	eventsRejected  uint64
+
+	mutex   sync.Mutex
+	latency map[latencyKey]*latencyHistogram
+}
+
+// latencyKey identifies a provider operation
+type latencyKey struct {
+	provider string
+	op       string
+}
+
+// latencyHistogram counts provider calls by how long they took
+type latencyHistogram struct {
+	buckets []uint64 // calls per bucket of providerLatencyBuckets, not cumulative
+	sum     float64  // seconds
+	count   uint64
+}
+
+// newMetrics creates empty metrics
+func newMetrics() *metrics {
+	return &metrics{latency: make(map[latencyKey]*latencyHistogram)}
+}
+
+// observeProvider records how long a provider call took
+func (m *metrics) observeProvider(provider, op string, elapsed time.Duration) {
+	seconds := elapsed.Seconds()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	key := latencyKey{provider: provider, op: op}
+	histogram, exists := m.latency[key]
+	if !exists {
+		histogram = &latencyHistogram{buckets: make([]uint64, len(providerLatencyBuckets))}
+		m.latency[key] = histogram
+	}
+	for i, bound := range providerLatencyBuckets {
+		if seconds <= bound {
+			histogram.buckets[i]++
+			break
+		}
+	}
+	histogram.sum += seconds
+	histogram.count++
+}
+
+// recordEvent counts an event passed to RejectEventHandler
+func (m *metrics) recordEvent(rejected bool) {
+	if rejected {
+		atomic.AddUint64(&m.eventsRejected, 1)
+	} else {
+		atomic.AddUint64(&m.eventsAccepted, 1)
+	}
+}
+
+// writeLatency writes the provider latency histograms, sorted by provider and operation
+func (m *metrics) writeLatency(w io.Writer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	keys := make([]latencyKey, 0, len(m.latency))
+	for key := range m.latency {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].provider != keys[j].provider {
+			return keys[i].provider < keys[j].provider
+		}
+		return keys[i].op < keys[j].op
+	})
+
+	name := metricsPrefix + "provider_request_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s Latency of payment provider API calls.\n# TYPE %s histogram\n", name, name)
+	for _, key := range keys {
+		histogram := m.latency[key]
+		labels := fmt.Sprintf(`provider="%s",op="%s"`, metricLabel(key.provider), metricLabel(key.op))
+		var cumulative uint64
+		for i, bound := range providerLatencyBuckets {
+			cumulative += histogram.buckets[i]
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%g\"} %d\n", name, labels, bound, cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, histogram.count)
+		fmt.Fprintf(w, "%s_sum{%s} %g\n", name, labels, histogram.sum)
+		fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, histogram.count)
+	}
+}
+
+// writeMetric writes a metric without labels in the Prometheus text format
+func writeMetric(w io.Writer, name, kind, help string, value interface{}) {
+	name = metricsPrefix + name
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+}
+
+// writeLabeledGauge writes a gauge with one sample per value of label, sorted by label
+func writeLabeledGauge(w io.Writer, name, help, label string, values map[string]int) {
+	name = metricsPrefix + name
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s{%s=\"%s\"} %d\n", name, label, metricLabel(key), values[key])
+	}
+}
+
+// metricLabel escapes a label value
+func metricLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// metricsHandler serves the metrics in the Prometheus text exposition format
+func (s *System) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	accessStats := s.paidAccessStorage.GetStats()
+	revenue := s.ledger.RevenueBy(func(LedgerEntry) string { return "total" })["total"]
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	writeMetric(w, "invoices_created_total", "counter", "Invoices created for membership payments.", atomic.LoadUint64(&s.metrics.invoicesCreated))
+	writeMetric(w, "payment_requests_total", "counter", "Events rejected with a payment request.", atomic.LoadUint64(&s.paymentRequests))
+	writeMetric(w, "payments_settled_total", "counter", "Payments settled and granted access.", atomic.LoadUint64(&s.successfulPayments))
+	writeMetric(w, "events_accepted_total", "counter", "Events accepted by the rejection pipeline.", atomic.LoadUint64(&s.metrics.eventsAccepted))
+	writeMetric(w, "events_rejected_total", "counter", "Events rejected by the rejection pipeline.", atomic.LoadUint64(&s.metrics.eventsRejected))
+	writeMetric(w, "revenue_msat", "gauge", "Revenue recorded in the ledger, net of refunds, in millisatoshis.", revenue.AmountMsat)
+	writeMetric(w, "active_members", "gauge", "Memberships that have not expired and are not on hold.", accessStats["active_members"])
+	writeMetric(w, "expired_members", "gauge", "Memberships that have expired.", accessStats["expired_members"])
+	writeMetric(w, "held_members", "gauge", "Memberships on hold.", accessStats["held_members"])
+	if byTier, ok := accessStats["members_by_tier"].(map[string]int); ok {
+		writeLabeledGauge(w, "active_members_by_tier", "Active memberships by tier.", "tier", byTier)
+	}
+	circuitOpen := 0
+	if !s.switcher.breaker.pausedUntil().IsZero() {
+		circuitOpen = 1
+	}
+	writeMetric(w, "provider_circuit_open", "gauge", "Whether calls to the payment provider are paused after repeated failures.", circuitOpen)
+	if s.SurgePricingEnabled() {
+		writeMetric(w, "price_multiplier", "gauge", "Multiple of the price charged for the relay load.", s.PriceMultiplier())
+	}
+	s.metrics.writeLatency(w)
+}
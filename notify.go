@@ -0,0 +1,133 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// ClientConnection is a client's relay connection, as khatru's *WebSocket
+type ClientConnection interface {
+	WriteJSON(any) error
+}
+
+// ConnectionLookup returns the connection an event arrived on, nil if unknown.
+// With khatru, wrap khatru.GetConnection so a nil *WebSocket becomes a nil interface.
+type ConnectionLookup func(ctx context.Context) ClientConnection
+
+// AccessNotice is sent as JSON in a NOTICE to the connection whose event or query
+// triggered an invoice, once that invoice was paid, so the client can resend it
+type AccessNotice struct {
+	Message     string `json:"message"`
+	PaymentHash string `json:"payment_hash"`
+	EventID     string `json:"event_id,omitempty"`   // the event rejected with the invoice, omitted for queries
+	ExpiresAt   int64  `json:"expires_at,omitempty"` // unix seconds, omitted for permanent access
+}
+
+// Messages of an AccessNotice, for invoices triggered by an event or by a query
+const (
+	accessGrantedMessage     = "payment received, access granted: resend your event"
+	readAccessGrantedMessage = "payment received, access granted: resend your subscription"
+)
+
+// waitingConnection is a connection waiting for an invoice to be paid
+type waitingConnection struct {
+	conn      ClientConnection
+	eventID   string
+	expiresAt time.Time
+}
+
+// connectionNotifier remembers which connection triggered each invoice
+type connectionNotifier struct {
+	mutex   sync.Mutex
+	lookup  ConnectionLookup
+	waiting map[string]waitingConnection // payment hash -> connection
+}
+
+// SetConnectionLookup enables notifying clients on the connection that triggered an
+// invoice when it is paid. With khatru:
+//
+//	system.SetConnectionLookup(func(ctx context.Context) payments.ClientConnection {
+//		if ws := khatru.GetConnection(ctx); ws != nil {
+//			return ws
+//		}
+//		return nil
+//	})
+func (s *System) SetConnectionLookup(lookup ConnectionLookup) {
+	s.notifier.mutex.Lock()
+	defer s.notifier.mutex.Unlock()
+
+	s.notifier.lookup = lookup
+	if s.notifier.waiting == nil {
+		s.notifier.waiting = make(map[string]waitingConnection)
+	}
+}
+
+// watchConnection remembers the connection event, or a query if nil, arrived on until
+// invoice is paid or expires
+func (s *System) watchConnection(ctx context.Context, event *nostr.Event, invoice *Invoice) {
+	s.notifier.mutex.Lock()
+	defer s.notifier.mutex.Unlock()
+
+	if s.notifier.lookup == nil {
+		return
+	}
+	conn := s.notifier.lookup(ctx)
+	if conn == nil {
+		return
+	}
+
+	expiresAt := invoice.ExpiresAt
+	if !expiresAt.After(time.Now()) {
+		expiresAt = time.Now().Add(defaultInvoiceExpiry)
+	}
+	waiting := waitingConnection{conn: conn, expiresAt: expiresAt}
+	if event != nil {
+		waiting.eventID = event.ID
+	}
+	s.notifier.waiting[invoice.PaymentHash] = waiting
+}
+
+// notifyConnection tells the connection that triggered an invoice that access was granted
+func (s *System) notifyConnection(paymentHash string, member *PaidAccessMember) {
+	s.notifier.mutex.Lock()
+	waiting, exists := s.notifier.waiting[paymentHash]
+	delete(s.notifier.waiting, paymentHash)
+	s.notifier.mutex.Unlock()
+	if !exists {
+		return
+	}
+
+	notice := AccessNotice{
+		Message:     accessGrantedMessage,
+		PaymentHash: paymentHash,
+		EventID:     waiting.eventID,
+	}
+	if waiting.eventID == "" {
+		notice.Message = readAccessGrantedMessage
+	}
+	if member != nil && !member.ExpiresAt.IsZero() {
+		notice.ExpiresAt = member.ExpiresAt.Unix()
+	}
+	noticeJSON, _ := json.Marshal(notice)
+
+	// The client may have disconnected since, it can still resend on a new connection
+	if err := waiting.conn.WriteJSON([]string{"NOTICE", string(noticeJSON)}); err != nil {
+		s.with(paymentHashAttr(paymentHash)).logWarn("⚠️ Failed to notify connection of payment %.16s...: %v", paymentHash, err)
+	}
+}
+
+// pruneConnections forgets connections waiting for invoices that expired
+func (s *System) pruneConnections(now time.Time) {
+	s.notifier.mutex.Lock()
+	defer s.notifier.mutex.Unlock()
+
+	for paymentHash, waiting := range s.notifier.waiting {
+		if now.After(waiting.expiresAt) {
+			delete(s.notifier.waiting, paymentHash)
+		}
+	}
+}
@@ -0,0 +1,446 @@
+package payments
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+)
+
+// NIP-47 event kinds
+const (
+	nwcInfoKind     = 13194
+	nwcRequestKind  = 23194
+	nwcResponseKind = 23195
+)
+
+// NIP-47 error codes
+const (
+	nwcErrNotImplemented = "NOT_IMPLEMENTED"
+	nwcErrNotFound       = "NOT_FOUND"
+	nwcErrInternal       = "INTERNAL"
+	nwcErrOther          = "OTHER"
+)
+
+// Balance top-up limits
+const (
+	topUpTier         = "topup"
+	minTopUpAmount    = 1000 // 1 sat
+	nwcRequestTimeout = 10 * time.Second
+)
+
+// nwcMethods are the NIP-47 methods the wallet service answers
+var nwcMethods = []string{"get_info", "get_balance", "make_invoice", "lookup_invoice"}
+
+// nwcRequest is a decrypted NIP-47 request
+type nwcRequest struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// nwcError is the error part of a NIP-47 response
+type nwcError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// nwcResponse is a NIP-47 response before encryption
+type nwcResponse struct {
+	ResultType string      `json:"result_type"`
+	Result     interface{} `json:"result,omitempty"`
+	Error      *nwcError   `json:"error,omitempty"`
+}
+
+// NWCEnabled reports whether members get a prepaid balance behind an NWC connection
+func (s *System) NWCEnabled() bool {
+	return s.balances != nil && s.config.NWCEnabled
+}
+
+// NWCConnectionURI returns the nostr+walletconnect:// URI spending a member's balance,
+// opening the account if needed
+func (s *System) NWCConnectionURI(pubkey string) (string, error) {
+	if !s.NWCEnabled() {
+		return "", fmt.Errorf("NWC wallet service is not enabled")
+	}
+	account, err := s.balances.Open(pubkey)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("relay", s.config.NWCRelayURL)
+	query.Set("secret", account.ConnectionSecret)
+	return "nostr+walletconnect://" + s.relayPubkey + "?" + query.Encode(), nil
+}
+
+// Balance returns a member's prepaid balance in millisatoshis
+func (s *System) Balance(pubkey string) int64 {
+	if !s.BalancesEnabled() {
+		return 0
+	}
+	if account, exists := s.balances.Get(pubkey); exists {
+		return account.Balance
+	}
+	return 0
+}
+
+// NWCInfoEvent returns the signed NIP-47 info event announcing the supported methods.
+// The embedding relay should store it so clients can discover the wallet service.
+func (s *System) NWCInfoEvent() (*nostr.Event, error) {
+	if !s.NWCEnabled() {
+		return nil, fmt.Errorf("NWC wallet service is not enabled")
+	}
+	event := &nostr.Event{
+		PubKey:    s.relayPubkey,
+		CreatedAt: nostr.Now(),
+		Kind:      nwcInfoKind,
+		Tags:      nostr.Tags{},
+		Content:   strings.Join(nwcMethods, " "),
+	}
+	if err := event.Sign(s.relayKey); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// HandleNWCRequest answers a NIP-47 request sent to the relay's wallet service. It returns
+// the signed response the relay has to broadcast, or nil if the event is not a request for
+// a known connection.
+func (s *System) HandleNWCRequest(ctx context.Context, event *nostr.Event) *nostr.Event {
+	if !s.isNWCRequest(event) {
+		return nil
+	}
+	account, exists := s.balances.ByConnection(event.PubKey)
+	if !exists {
+		return nil
+	}
+
+	sharedSecret, err := nip04.ComputeSharedSecret(event.PubKey, s.relayKey)
+	if err != nil {
+		s.logWarn("⚠️ Failed to compute NWC shared secret: %v", err)
+		return nil
+	}
+
+	var response nwcResponse
+	var request nwcRequest
+	plaintext, err := nip04.Decrypt(event.Content, sharedSecret)
+	if err == nil {
+		err = json.Unmarshal([]byte(plaintext), &request)
+	}
+	if err != nil {
+		response = nwcResponse{Error: &nwcError{Code: nwcErrOther, Message: "invalid request"}}
+	} else {
+		ctx, cancel := context.WithTimeout(ctx, nwcRequestTimeout)
+		response = s.handleNWCMethod(ctx, account, request)
+		cancel()
+	}
+	response.ResultType = request.Method
+
+	payload, _ := json.Marshal(response)
+	content, err := nip04.Encrypt(string(payload), sharedSecret)
+	if err != nil {
+		s.logWarn("⚠️ Failed to encrypt NWC response: %v", err)
+		return nil
+	}
+
+	reply := &nostr.Event{
+		PubKey:    s.relayPubkey,
+		CreatedAt: nostr.Now(),
+		Kind:      nwcResponseKind,
+		Tags:      nostr.Tags{{"p", event.PubKey}, {"e", event.ID}},
+		Content:   content,
+	}
+	if err := reply.Sign(s.relayKey); err != nil {
+		s.logWarn("⚠️ Failed to sign NWC response: %v", err)
+		return nil
+	}
+	return reply
+}
+
+// handleNWCMethod runs a single NIP-47 method for an account
+func (s *System) handleNWCMethod(ctx context.Context, account *BalanceAccount, request nwcRequest) nwcResponse {
+	switch request.Method {
+	case "get_info":
+		return nwcResponse{Result: map[string]interface{}{
+			"alias":   "relay balance",
+			"network": "mainnet",
+			"methods": nwcMethods,
+		}}
+
+	case "get_balance":
+		return nwcResponse{Result: map[string]interface{}{"balance": s.Balance(account.Pubkey)}}
+
+	case "make_invoice":
+		var params struct {
+			Amount      int64  `json:"amount"`
+			Description string `json:"description"`
+		}
+		if err := json.Unmarshal(request.Params, &params); err != nil || params.Amount < minTopUpAmount {
+			return nwcResponse{Error: &nwcError{Code: nwcErrOther, Message: fmt.Sprintf("amount of at least %d msat required", minTopUpAmount)}}
+		}
+		invoice, err := s.createTopUpInvoice(ctx, account.Pubkey, params.Amount)
+		if err != nil {
+			s.logError("❌ Failed to create top-up invoice: %v", err)
+			return nwcResponse{Error: &nwcError{Code: nwcErrInternal, Message: "could not create invoice"}}
+		}
+		return nwcResponse{Result: nwcTransaction(invoice, time.Now(), false, time.Time{})}
+
+	case "lookup_invoice":
+		var params struct {
+			PaymentHash string `json:"payment_hash"`
+		}
+		json.Unmarshal(request.Params, &params)
+		topUp, tracked := s.invoices.Get(params.PaymentHash)
+		if !tracked || topUp.Purpose != PurposeTopUp || topUp.Pubkey != account.Pubkey {
+			return nwcResponse{Error: &nwcError{Code: nwcErrNotFound, Message: "invoice not found"}}
+		}
+		verification, err := s.provider.VerifyPayment(ctx, params.PaymentHash)
+		if err != nil {
+			return nwcResponse{Error: &nwcError{Code: nwcErrInternal, Message: "could not look up invoice"}}
+		}
+		if verification.Paid {
+			s.settleTopUp(topUp.Pubkey, verification)
+		}
+		invoice := &Invoice{
+			PaymentRequest: topUp.PaymentRequest,
+			PaymentHash:    topUp.PaymentHash,
+			Amount:         topUp.Amount,
+			Description:    topUp.Description,
+			ExpiresAt:      topUp.ExpiresAt,
+		}
+		return nwcResponse{Result: nwcTransaction(invoice, topUp.CreatedAt, verification.Paid, verification.PaidAt)}
+
+	case "pay_invoice", "pay_keysend", "multi_pay_invoice", "multi_pay_keysend", "list_transactions":
+		return nwcResponse{Error: &nwcError{Code: nwcErrNotImplemented, Message: "balances can only be spent on relay access"}}
+
+	default:
+		return nwcResponse{Error: &nwcError{Code: nwcErrNotImplemented, Message: "unknown method"}}
+	}
+}
+
+// isNWCRequest reports whether an event is a NIP-47 request addressed to the relay's wallet service
+func (s *System) isNWCRequest(event *nostr.Event) bool {
+	if !s.NWCEnabled() || event.Kind != nwcRequestKind {
+		return false
+	}
+	tag := event.Tags.GetFirst([]string{"p"})
+	return tag != nil && tag.Value() == s.relayPubkey
+}
+
+// createTopUpInvoice creates an invoice crediting a member's balance once paid
+func (s *System) createTopUpInvoice(ctx context.Context, pubkey string, amount int64) (*Invoice, error) {
+	if s.IsDenylisted(pubkey) {
+		return nil, errDeniedPubkey
+	}
+
+	if err := s.allowInvoice(ctx, pubkey); err != nil {
+		return nil, err
+	}
+
+	description := fmt.Sprintf("Relay balance top-up - pubkey:%s", pubkey)
+	invoice, err := s.provider.CreateInvoice(withInvoicePurpose(ctx, PurposeTopUp), amount, description, pubkey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tracked like membership invoices, so the invoice poller credits it even after a restart
+	s.trackInvoice(invoice, pubkey, topUpTier, 0)
+	s.invoices.SetPurpose(invoice.PaymentHash, PurposeTopUp, "")
+
+	s.with(pubkeyAttr(pubkey)).logInfo("👛 Top-up invoice for %s... (%d msat)", pubkey[:16], amount)
+	return invoice, nil
+}
+
+// settleTopUp credits a paid top-up invoice to the balance of pubkey, once
+func (s *System) settleTopUp(pubkey string, verification *PaymentVerification) {
+	s.invoices.MarkPaid(verification.PaymentHash, verification.Amount, verification.PaidAt)
+	if s.creditTopUp(pubkey, verification) {
+		s.invoices.MarkGranted(verification.PaymentHash)
+	}
+}
+
+// creditTopUp adds a paid top-up to a member's balance and books it as revenue, reporting
+// whether the balance holds it, credited now or before
+func (s *System) creditTopUp(pubkey string, verification *PaymentVerification) bool {
+	// Top-ups over HTTP may come before any membership opened the account
+	if _, err := s.balances.Open(pubkey); err != nil {
+		s.with(pubkeyAttr(pubkey)).logError("❌ Failed to open balance account for %s...: %v", pubkey[:16], err)
+		return false
+	}
+	credited, err := s.balances.Credit(pubkey, verification.PaymentHash, verification.Amount)
+	if err != nil {
+		s.with(pubkeyAttr(pubkey)).logError("❌ Failed to credit top-up for %s...: %v", pubkey[:16], err)
+		return false
+	}
+	if !credited {
+		return true
+	}
+
+	err = s.ledger.Record(LedgerEntry{
+		Pubkey:      pubkey,
+		PaymentHash: verification.PaymentHash,
+		Amount:      verification.Amount,
+		Provider:    s.providerNameFor(verification.PaymentHash),
+		Tier:        topUpTier,
+		PaidAt:      verification.PaidAt,
+	})
+	if err != nil {
+		s.logWarn("⚠️ Failed to record top-up in ledger: %v", err)
+	}
+
+	s.statsHub.Publish(StatsDelta{
+		Type:       "payment",
+		Pubkey:     pubkey,
+		AmountMsat: verification.Amount,
+		Provider:   s.providerNameFor(verification.PaymentHash),
+		Tier:       topUpTier,
+		At:         time.Now(),
+	})
+	s.with(pubkeyAttr(pubkey)).logInfo("👛 Credited %d msat to the balance of %s...", verification.Amount, pubkey[:16])
+	return true
+}
+
+// AllowNWCRequests is the stage that accepts NIP-47 requests from known wallet connections,
+// whose keys are not members themselves
+func (s *System) AllowNWCRequests(next RejectFunc) RejectFunc {
+	return func(ctx context.Context, event *nostr.Event) (bool, string) {
+		if s.isNWCRequest(event) {
+			if _, exists := s.balances.ByConnection(event.PubKey); exists {
+				return false, ""
+			}
+		}
+		return next(ctx, event)
+	}
+}
+
+// PayFromBalance is the stage that lets members without active access pay from their
+// prepaid balance, per event when NWCEventCharge or BalanceMBCharge is set or by renewing
+// their membership
+func (s *System) PayFromBalance(next RejectFunc) RejectFunc {
+	return func(ctx context.Context, event *nostr.Event) (bool, string) {
+		if s.payFromBalance(ctx, event.PubKey, event) {
+			s.usage.RecordEvent(event.PubKey, event.Kind)
+			return false, ""
+		}
+		return next(ctx, event)
+	}
+}
+
+// payFromBalance charges an event or a renewal to a member's balance, reporting whether it did
+func (s *System) payFromBalance(ctx context.Context, pubkey string, event *nostr.Event) bool {
+	if !s.BalancesEnabled() {
+		return false
+	}
+	if _, exists := s.balances.Get(pubkey); !exists {
+		return false
+	}
+
+	if s.meteredBalances() {
+		if event == nil {
+			return false
+		}
+		paid, err := s.balances.Debit(pubkey, s.eventCharge(event))
+		if err != nil {
+			s.with(pubkeyAttr(pubkey)).logError("❌ Failed to charge event to balance of %s...: %v", pubkey[:16], err)
+		}
+		return paid
+	}
+
+	amount, duration, tier := s.price(ctx, pubkey, event)
+	if s.config.BalanceDayCharge > 0 {
+		amount, duration, tier = s.config.BalanceDayCharge, 24*time.Hour, balanceDayTier
+	}
+	paid, err := s.balances.Debit(pubkey, amount)
+	if err != nil {
+		s.with(pubkeyAttr(pubkey)).logError("❌ Failed to charge renewal to balance of %s...: %v", pubkey[:16], err)
+	}
+	if !paid {
+		return false
+	}
+
+	events, storage := s.quotasFor(pubkey, tier)
+	if err := s.paidAccessStorage.AddAccessFromSource(pubkey, balancePaymentHash(), tier, SourceBalance, amount, duration); err != nil {
+		s.with(pubkeyAttr(pubkey)).logError("❌ Failed to renew access from balance for %s...: %v", pubkey[:16], err)
+		// Nothing was bought, give the charge back
+		if err := s.balances.Restore(pubkey, amount); err != nil {
+			s.with(pubkeyAttr(pubkey)).logError("❌ Failed to give %d msat back to balance of %s...: %v", amount, pubkey[:16], err)
+		}
+		return false
+	}
+	if err := s.setQuotas(pubkey, events, storage); err != nil {
+		s.with(pubkeyAttr(pubkey)).logError("❌ Failed to set quotas for %s...: %v", pubkey[:16], err)
+	}
+	s.with(pubkeyAttr(pubkey)).logInfo("👛 Renewed access for %s... from balance (%d msat)", pubkey[:16], amount)
+
+	if member, exists := s.paidAccessStorage.GetMember(pubkey); exists {
+		s.fireAccessGranted(context.WithoutCancel(ctx), AccessEvent{Pubkey: pubkey, Member: *member, Reason: "balance"})
+	}
+	return true
+}
+
+// renewFromBalances renews expired memberships whose owners have enough balance left
+// and returns how many it renewed
+func (s *System) renewFromBalances(ctx context.Context) int {
+	if !s.BalancesEnabled() || s.meteredBalances() {
+		return 0
+	}
+	renewed := 0
+	for _, pubkey := range s.balances.Pubkeys() {
+		// Held memberships are left alone until the dispute is resolved
+		if member, exists := s.paidAccessStorage.GetMember(pubkey); !exists || member.Hold != nil || s.HasAccess(pubkey) {
+			continue
+		}
+		if s.payFromBalance(ctx, pubkey, nil) {
+			renewed++
+		}
+	}
+	return renewed
+}
+
+// nwcTransaction renders an invoice as a NIP-47 transaction
+func nwcTransaction(invoice *Invoice, createdAt time.Time, settled bool, settledAt time.Time) map[string]interface{} {
+	transaction := map[string]interface{}{
+		"type":         "incoming",
+		"invoice":      invoice.PaymentRequest,
+		"description":  invoice.Description,
+		"payment_hash": invoice.PaymentHash,
+		"amount":       invoice.Amount,
+		"created_at":   createdAt.Unix(),
+	}
+	if !invoice.ExpiresAt.IsZero() {
+		transaction["expires_at"] = invoice.ExpiresAt.Unix()
+	}
+	if settled {
+		if settledAt.IsZero() {
+			settledAt = time.Now()
+		}
+		transaction["settled_at"] = settledAt.Unix()
+	}
+	return transaction
+}
+
+// balancePaymentHash returns a unique reference for access paid from a balance
+func balancePaymentHash() string {
+	random := make([]byte, 16)
+	rand.Read(random)
+	return "balance:" + hex.EncodeToString(random)
+}
+
+// nwcRelayURL derives the websocket URL of the relay from its public URL
+func nwcRelayURL(publicURL string) string {
+	publicURL = strings.TrimSuffix(publicURL, "/")
+	switch {
+	case strings.HasPrefix(publicURL, "https://"):
+		return "wss://" + strings.TrimPrefix(publicURL, "https://")
+	case strings.HasPrefix(publicURL, "http://"):
+		return "ws://" + strings.TrimPrefix(publicURL, "http://")
+	}
+	return publicURL
+}
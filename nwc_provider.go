@@ -0,0 +1,315 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+)
+
+// nwcErrRateLimited is the NIP-47 error code of wallets asking to slow down
+const nwcErrRateLimited = "RATE_LIMITED"
+
+// NWCProvider implements PaymentProvider interface for any wallet reachable over Nostr
+// Wallet Connect (NIP-47), using its make_invoice and lookup_invoice methods
+type NWCProvider struct {
+	walletPubkey string
+	relayURL     string
+	secret       string
+	clientPubkey string
+	// Map payment hash to pubkey for CheckExistingPayments
+	pubkeyMap *lruMap[string]
+	mu        sync.RWMutex
+	// The wallet relay connection, reopened when it drops
+	relay      *nostr.Relay
+	relayMutex sync.Mutex
+	// Persistent storage references
+	invoiceStore *InvoiceStore
+	logTarget
+}
+
+// nwcReply is a decrypted NIP-47 response
+type nwcReply struct {
+	ResultType string          `json:"result_type"`
+	Result     json.RawMessage `json:"result"`
+	Error      *nwcError       `json:"error"`
+}
+
+// nwcTransactionResult is the transaction returned by make_invoice and lookup_invoice
+type nwcTransactionResult struct {
+	Invoice     string `json:"invoice"`
+	PaymentHash string `json:"payment_hash"`
+	Amount      int64  `json:"amount"`
+	State       string `json:"state"` // "pending", "settled", ... on newer wallets
+	ExpiresAt   int64  `json:"expires_at"`
+	SettledAt   int64  `json:"settled_at"`
+}
+
+// NewNWCProvider creates a provider receiving payments into the wallet of a
+// nostr+walletconnect:// connection URI
+func NewNWCProvider(connectionURI string, invoiceStore *InvoiceStore) (*NWCProvider, error) {
+	walletPubkey, relayURL, secret, err := parseNWCConnectionURI(connectionURI)
+	if err != nil {
+		return nil, err
+	}
+	clientPubkey, err := nostr.GetPublicKey(secret)
+	if err != nil {
+		return nil, fmt.Errorf("invalid NWC secret: %w", err)
+	}
+
+	return &NWCProvider{
+		walletPubkey: walletPubkey,
+		relayURL:     relayURL,
+		secret:       secret,
+		clientPubkey: clientPubkey,
+		pubkeyMap:    newLRUMap[string](providerMapSize),
+		invoiceStore: invoiceStore,
+	}, nil
+}
+
+// newNWCFromConfig creates the NWC provider
+func newNWCFromConfig(config *Config, invoiceStore *InvoiceStore) (PaymentProvider, error) {
+	if config.NWCWalletURI == "" {
+		return nil, fmt.Errorf("NWC_WALLET_URI required for nwc provider")
+	}
+	return NewNWCProvider(config.NWCWalletURI, invoiceStore)
+}
+
+// parseNWCConnectionURI parses nostr+walletconnect://<wallet pubkey>?relay=<url>&secret=<hex key>
+func parseNWCConnectionURI(uri string) (walletPubkey, relayURL, secret string, err error) {
+	parsed, err := url.Parse(strings.TrimSpace(uri))
+	if err != nil {
+		return "", "", "", fmt.Errorf("invalid NWC connection URI: %w", err)
+	}
+	if parsed.Scheme != "nostr+walletconnect" && parsed.Scheme != "nostrwalletconnect" {
+		return "", "", "", fmt.Errorf("invalid NWC connection URI: unexpected scheme %q", parsed.Scheme)
+	}
+
+	walletPubkey = parsed.Host
+	if walletPubkey == "" {
+		walletPubkey = parsed.Opaque
+	}
+	if !nostr.IsValidPublicKey(walletPubkey) {
+		return "", "", "", fmt.Errorf("invalid NWC connection URI: invalid wallet pubkey")
+	}
+
+	query := parsed.Query()
+	relayURL = query.Get("relay")
+	if relayURL == "" {
+		return "", "", "", fmt.Errorf("invalid NWC connection URI: missing relay")
+	}
+	secret = query.Get("secret")
+	if len(secret) != 64 {
+		return "", "", "", fmt.Errorf("invalid NWC connection URI: missing or invalid secret")
+	}
+	return walletPubkey, relayURL, secret, nil
+}
+
+// GetProviderName returns the provider name
+func (p *NWCProvider) GetProviderName() string {
+	return "nwc"
+}
+
+// CreateInvoice asks the wallet to create an invoice
+func (p *NWCProvider) CreateInvoice(ctx context.Context, amount int64, description string, pubkey string) (*Invoice, error) {
+	invoice, err := p.makeInvoice(ctx, amount, map[string]interface{}{"description": description}, pubkey)
+	if err != nil {
+		return nil, err
+	}
+	invoice.Description = description
+	return invoice, nil
+}
+
+// CreateInvoiceWithDescriptionHash asks the wallet for an invoice committing to a description hash
+func (p *NWCProvider) CreateInvoiceWithDescriptionHash(ctx context.Context, amount int64, descriptionHash string, pubkey string) (*Invoice, error) {
+	return p.makeInvoice(ctx, amount, map[string]interface{}{"description_hash": descriptionHash}, pubkey)
+}
+
+// makeInvoice calls make_invoice and remembers who the invoice is for
+func (p *NWCProvider) makeInvoice(ctx context.Context, amount int64, params map[string]interface{}, pubkey string) (*Invoice, error) {
+	params["amount"] = amount
+	params["expiry"] = int64(defaultInvoiceExpiry / time.Second)
+
+	var transaction nwcTransactionResult
+	if err := p.call(ctx, OpCreateInvoice, "make_invoice", params, &transaction); err != nil {
+		return nil, err
+	}
+	if transaction.PaymentHash == "" || transaction.Invoice == "" {
+		return nil, fmt.Errorf("NWC wallet returned an incomplete invoice")
+	}
+
+	p.mu.Lock()
+	p.pubkeyMap.Set(transaction.PaymentHash, pubkey)
+	p.mu.Unlock()
+
+	if p.invoiceStore != nil {
+		p.invoiceStore.RecordCharge(transaction.PaymentHash, transaction.PaymentHash, amount)
+	}
+
+	invoice := &Invoice{
+		PaymentRequest: transaction.Invoice,
+		PaymentHash:    transaction.PaymentHash,
+		Amount:         amount,
+		ExpiresAt:      time.Now().Add(defaultInvoiceExpiry),
+	}
+	if transaction.ExpiresAt > 0 {
+		invoice.ExpiresAt = time.Unix(transaction.ExpiresAt, 0)
+	}
+	return invoice, nil
+}
+
+// VerifyPayment looks the invoice up in the wallet
+func (p *NWCProvider) VerifyPayment(ctx context.Context, paymentHash string) (*PaymentVerification, error) {
+	var transaction nwcTransactionResult
+	err := p.call(ctx, OpVerifyPayment, "lookup_invoice", map[string]interface{}{"payment_hash": paymentHash}, &transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	verification := &PaymentVerification{
+		Paid:        transaction.SettledAt > 0 || transaction.State == "settled",
+		PaymentHash: paymentHash,
+		Amount:      transaction.Amount,
+	}
+	if verification.Paid {
+		verification.PaidAt = time.Now()
+		if transaction.SettledAt > 0 {
+			verification.PaidAt = time.Unix(transaction.SettledAt, 0)
+		}
+	}
+	return verification, nil
+}
+
+// CheckExistingPayments checks for any existing payments for a pubkey and returns verification if paid
+func (p *NWCProvider) CheckExistingPayments(ctx context.Context, pubkey string) (*PaymentVerification, error) {
+	p.mu.RLock()
+	paymentHashes := pendingHashesFor(p.pubkeyMap, p.invoiceStore, p.GetProviderName(), pubkey)
+	p.mu.RUnlock()
+
+	for _, paymentHash := range paymentHashes {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		verification, err := p.VerifyPayment(ctx, paymentHash)
+		if err == nil && verification.Paid {
+			p.logInfo("💰 Found settled NWC invoice! Payment hash: %s", paymentHash)
+			return verification, nil
+		}
+	}
+
+	return nil, nil // No paid payments found
+}
+
+// call sends a NIP-47 request to the wallet and decodes the result of its response
+func (p *NWCProvider) call(ctx context.Context, op, method string, params interface{}, result interface{}) error {
+	relay, err := p.connect(ctx)
+	if err != nil {
+		return newRequestError(p.GetProviderName(), op, err)
+	}
+
+	sharedSecret, err := nip04.ComputeSharedSecret(p.walletPubkey, p.secret)
+	if err != nil {
+		return fmt.Errorf("failed to compute NWC shared secret: %w", err)
+	}
+	paramsJSON, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+	payload, _ := json.Marshal(nwcRequest{Method: method, Params: paramsJSON})
+	content, err := nip04.Encrypt(string(payload), sharedSecret)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt request: %w", err)
+	}
+
+	request := nostr.Event{
+		PubKey:    p.clientPubkey,
+		CreatedAt: nostr.Now(),
+		Kind:      nwcRequestKind,
+		Tags:      nostr.Tags{{"p", p.walletPubkey}},
+		Content:   content,
+	}
+	if err := request.Sign(p.secret); err != nil {
+		return fmt.Errorf("failed to sign request: %w", err)
+	}
+
+	// Listen for the response before sending the request so it can't be missed
+	sub, err := relay.Subscribe(ctx, nostr.Filters{{
+		Kinds:   []int{nwcResponseKind},
+		Authors: []string{p.walletPubkey},
+		Tags:    nostr.TagMap{"e": []string{request.ID}},
+	}})
+	if err != nil {
+		return newRequestError(p.GetProviderName(), op, fmt.Errorf("failed to subscribe to responses: %w", err))
+	}
+	defer sub.Unsub()
+
+	if err := relay.Publish(ctx, request); err != nil {
+		return newRequestError(p.GetProviderName(), op, fmt.Errorf("failed to publish request: %w", err))
+	}
+
+	var response *nostr.Event
+	select {
+	case response = <-sub.Events:
+	case <-ctx.Done():
+		return newRequestError(p.GetProviderName(), op, fmt.Errorf("no response from NWC wallet: %w", ctx.Err()))
+	}
+	if response == nil {
+		return newRequestError(p.GetProviderName(), op, fmt.Errorf("NWC relay connection closed"))
+	}
+
+	plaintext, err := nip04.Decrypt(response.Content, sharedSecret)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt response: %w", err)
+	}
+	var reply nwcReply
+	if err := json.Unmarshal([]byte(plaintext), &reply); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	if reply.Error != nil {
+		return &ProviderError{
+			Provider:  p.GetProviderName(),
+			Op:        op,
+			Transient: reply.Error.Code == nwcErrRateLimited || reply.Error.Code == nwcErrInternal,
+			Err:       fmt.Errorf("wallet error: %s - %s", reply.Error.Code, reply.Error.Message),
+		}
+	}
+	if err := json.Unmarshal(reply.Result, result); err != nil {
+		return fmt.Errorf("failed to unmarshal result: %w", err)
+	}
+	return nil
+}
+
+// Close closes the wallet relay connection, it is reopened by the next call
+func (p *NWCProvider) Close() error {
+	p.relayMutex.Lock()
+	defer p.relayMutex.Unlock()
+
+	if p.relay == nil {
+		return nil
+	}
+	err := p.relay.Close()
+	p.relay = nil
+	return err
+}
+
+// connect returns the wallet relay connection, opening it if needed
+func (p *NWCProvider) connect(ctx context.Context) (*nostr.Relay, error) {
+	p.relayMutex.Lock()
+	defer p.relayMutex.Unlock()
+
+	if p.relay != nil && p.relay.IsConnected() {
+		return p.relay, nil
+	}
+	relay, err := nostr.RelayConnect(ctx, p.relayURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NWC relay: %w", err)
+	}
+	p.relay = relay
+	return relay, nil
+}
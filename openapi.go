@@ -0,0 +1,31 @@
+package payments
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// openAPISpec is the OpenAPI 3 document of the HTTP endpoints, served at GET /openapi.json
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// openAPIHandler serves the OpenAPI document, pointing it at PublicURL when configured
+func (s *System) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	spec := openAPISpec
+	if s.config.PublicURL != "" {
+		var document map[string]interface{}
+		if err := json.Unmarshal(openAPISpec, &document); err != nil {
+			s.logError("❌ Failed to parse OpenAPI document: %v", err)
+			http.Error(w, "Invalid OpenAPI document", http.StatusInternalServerError)
+			return
+		}
+		document["servers"] = []map[string]string{{"url": strings.TrimSuffix(s.config.PublicURL, "/")}}
+		spec, _ = json.Marshal(document)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(spec)
+}
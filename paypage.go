@@ -0,0 +1,157 @@
+package payments
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// payPageTemplate renders the hosted payment page
+var payPageTemplate = template.Must(template.New("pay").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Relay Access</title>
+<style>
+body { font-family: sans-serif; max-width: 32rem; margin: 2rem auto; padding: 0 1rem; }
+textarea { width: 100%; height: 8rem; font-family: monospace; font-size: 0.8rem; }
+input[type=text] { width: 100%; }
+.error { color: #b00; }
+</style>
+</head>
+<body>
+<h1>Relay Access</h1>
+<p>{{.Message}}</p>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+{{if .Invoice}}
+<p>Pay <strong>{{.AmountSats}} sats</strong> to get access for <code>{{.PubkeyShort}}</code>:</p>
+<p><a href="lightning:{{.Invoice}}">Open in wallet</a></p>
+<textarea readonly>{{.Invoice}}</textarea>
+<p><button id="check">I have paid</button> <span id="status"></span></p>
+<script>
+document.getElementById("check").onclick = async function () {
+  const status = document.getElementById("status");
+  status.textContent = "Checking...";
+  const resp = await fetch("{{.VerifyURL}}", {
+    method: "POST",
+    headers: {"Content-Type": "application/json"},
+    body: JSON.stringify({payment_hash: "{{.PaymentHash}}", pubkey: "{{.Pubkey}}"})
+  });
+  const result = resp.ok ? await resp.json() : {};
+  status.textContent = result.paid ? "Paid, access granted! You can publish to the relay now." : "Not paid yet, try again in a moment.";
+};
+if (window.EventSource) {
+  const events = new EventSource("{{.EventsURL}}");
+  const show = function (text) { document.getElementById("status").textContent = text; };
+  events.addEventListener("paid", function () { show("Paid, granting access..."); });
+  events.addEventListener("access_granted", function () { show("Paid, access granted! You can publish to the relay now."); events.close(); });
+  events.addEventListener("refused", function () { show("The payment was received but access was refused."); events.close(); });
+  events.addEventListener("expired", function () { show("This invoice expired, reload the page for a new one."); events.close(); });
+}
+</script>
+{{else}}
+<form method="get">
+<p><label>Your public key (npub or hex)<br><input type="text" name="pubkey" value="{{.Pubkey}}"></label></p>
+<p><button type="submit">Get invoice</button></p>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+// payPageData is the view model of the payment page
+type payPageData struct {
+	Message     string
+	Error       string
+	Pubkey      string
+	PubkeyShort string
+	Invoice     string
+	PaymentHash string
+	AmountSats  int64
+	VerifyURL   string
+	EventsURL   string
+}
+
+// PaymentPageURL returns the hosted payment page link for a pubkey, or "" if no public URL is configured
+func (s *System) PaymentPageURL(pubkey string) string {
+	base := s.config.PaymentPageURL
+	if base == "" {
+		if s.config.PublicURL == "" {
+			return ""
+		}
+		base = strings.TrimSuffix(s.config.PublicURL, "/") + "/pay"
+	}
+	if pubkey == "" {
+		return base
+	}
+
+	separator := "?"
+	if strings.Contains(base, "?") {
+		separator = "&"
+	}
+	return base + separator + "pubkey=" + url.QueryEscape(pubkey)
+}
+
+// payPageHandler serves the hosted payment page and creates invoices on demand
+func (s *System) payPageHandler(w http.ResponseWriter, r *http.Request) {
+	data := payPageData{
+		Message:   s.config.RejectMessage,
+		Pubkey:    r.URL.Query().Get("pubkey"),
+		VerifyURL: "verify-payment",
+	}
+
+	if data.Pubkey != "" {
+		pubkey, err := parsePubkey(data.Pubkey)
+		if err != nil {
+			data.Error = "Invalid public key"
+		} else if s.IsDenylisted(pubkey) {
+			data.Error = "This public key is banned from this relay."
+		} else if s.HasAccess(pubkey) {
+			data.Error = "This public key already has access."
+		} else {
+			invoice, err := s.CreateInvoice(r.Context(), pubkey)
+			if errors.Is(err, errRateLimited) {
+				data.Error = "Too many invoices requested, please try again later."
+			} else if err != nil {
+				s.with(pubkeyAttr(pubkey)).logError("❌ Failed to create invoice on payment page for %s: %v", pubkey[:16], err)
+				data.Error = "Could not create an invoice right now, please try again shortly."
+			} else {
+				data.Pubkey = pubkey
+				data.PubkeyShort = pubkey[:16] + "..."
+				data.Invoice = invoice.PaymentRequest
+				data.PaymentHash = invoice.PaymentHash
+				data.EventsURL = "invoice/" + invoice.PaymentHash + "/events"
+				data.AmountSats = invoice.Amount / 1000
+				s.invoices.MarkSeen(invoice.PaymentHash)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := payPageTemplate.Execute(w, data); err != nil {
+		s.logError("❌ Failed to render payment page: %v", err)
+	}
+}
+
+// parsePubkey accepts a hex pubkey or an npub and returns the hex form
+func parsePubkey(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "npub1") {
+		_, decoded, err := nip19.Decode(value)
+		if err != nil {
+			return "", err
+		}
+		value = decoded.(string)
+	}
+	value = strings.ToLower(value)
+	if !nostr.IsValid32ByteHex(value) {
+		return "", errInvalidPubkey
+	}
+	return value, nil
+}
@@ -0,0 +1,96 @@
+package payments
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// writeBehind persists a store in the background. Mutations mark it dirty and one write of
+// the latest state follows within the delay, so a burst of changes costs a single write and
+// callers don't wait for the disk.
+type writeBehind struct {
+	name       string     // store name for logs
+	logs       *logTarget // the store's
+	delay      time.Duration
+	write      func() error // snapshots the store under its own lock and writes it
+	mutex      sync.Mutex   // guards dirty and timer
+	writeMutex sync.Mutex   // one write at a time
+	dirty      bool
+	timer      *time.Timer
+}
+
+// newWriteBehind creates a write-behind persister calling write at most once per delay,
+// logging failed writes to logs
+func newWriteBehind(name string, delay time.Duration, logs *logTarget, write func() error) *writeBehind {
+	return &writeBehind{
+		name:  name,
+		logs:  logs,
+		delay: delay,
+		write: write,
+	}
+}
+
+// markDirty schedules a write. It never blocks on the disk, so stores call it while
+// holding their lock.
+func (wb *writeBehind) markDirty() {
+	wb.mutex.Lock()
+	defer wb.mutex.Unlock()
+
+	wb.dirty = true
+	if wb.timer == nil {
+		wb.timer = time.AfterFunc(wb.delay, func() {
+			if err := wb.Flush(); err != nil {
+				wb.logs.logError("❌ Failed to write %s: %v", wb.name, err)
+			}
+		})
+	}
+}
+
+// Flush writes pending changes now. A failed write stays pending and is retried.
+func (wb *writeBehind) Flush() error {
+	wb.writeMutex.Lock()
+	defer wb.writeMutex.Unlock()
+
+	wb.mutex.Lock()
+	if wb.timer != nil {
+		wb.timer.Stop()
+		wb.timer = nil
+	}
+	dirty := wb.dirty
+	wb.dirty = false
+	wb.mutex.Unlock()
+
+	if !dirty {
+		return nil
+	}
+	if err := wb.write(); err != nil {
+		wb.markDirty()
+		return err
+	}
+	return nil
+}
+
+// writeFileAtomic replaces a file through a temporary file and a rename, so a crash
+// mid-write never leaves a truncated file behind
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
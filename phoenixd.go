@@ -7,8 +7,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
+	"net/url"
 	"strings"
 	"sync"
 	"time"
@@ -19,12 +19,14 @@ type PhoenixdProvider struct {
 	baseURL              string
 	password             string
 	// Map payment hash to external ID for verification
-	paymentMap           map[string]string
+	paymentMap           *lruMap[string]
 	// Map payment hash to pubkey for verification
-	pubkeyMap            map[string]string
+	pubkeyMap            *lruMap[string]
 	mu                   sync.RWMutex
 	// Persistent storage references
-	chargeMappingStorage *ChargeMappingStorage
+	invoiceStore *InvoiceStore
+	providerHTTP
+	logTarget
 }
 
 // NewPhoenixdProvider creates a new phoenixd payment provider
@@ -39,13 +41,21 @@ func NewPhoenixdProvider(baseURL, password string) (*PhoenixdProvider, error) {
 	return &PhoenixdProvider{
 		baseURL:    baseURL,
 		password:   password,
-		paymentMap: make(map[string]string),
-		pubkeyMap:  make(map[string]string),
+		paymentMap: newLRUMap[string](providerMapSize),
+		pubkeyMap:  newLRUMap[string](providerMapSize),
 	}, nil
 }
 
 // NewPhoenixdProviderWithStorage creates a new phoenixd payment provider with persistent storage
+//
+// Deprecated: charge ids are kept in the invoice store, use NewPhoenixdProviderWithInvoiceStore.
 func NewPhoenixdProviderWithStorage(baseURL, password string, chargeMappingStorage *ChargeMappingStorage) (*PhoenixdProvider, error) {
+	return NewPhoenixdProviderWithInvoiceStore(baseURL, password, chargeMappingStorage.invoiceStore())
+}
+
+// NewPhoenixdProviderWithInvoiceStore creates a new phoenixd payment provider keeping
+// charge ids in the invoice store, so payments verify across restarts
+func NewPhoenixdProviderWithInvoiceStore(baseURL, password string, invoiceStore *InvoiceStore) (*PhoenixdProvider, error) {
 	if password == "" {
 		return nil, fmt.Errorf("phoenixd password is required")
 	}
@@ -54,11 +64,11 @@ func NewPhoenixdProviderWithStorage(baseURL, password string, chargeMappingStora
 	}
 
 	return &PhoenixdProvider{
-		baseURL:              baseURL,
-		password:             password,
-		paymentMap:           make(map[string]string),
-		pubkeyMap:            make(map[string]string),
-		chargeMappingStorage: chargeMappingStorage,
+		baseURL:      baseURL,
+		password:     password,
+		paymentMap:   newLRUMap[string](providerMapSize),
+		pubkeyMap:    newLRUMap[string](providerMapSize),
+		invoiceStore: invoiceStore,
 	}, nil
 }
 
@@ -99,21 +109,39 @@ type PhoenixdPaymentResponse struct {
 
 // CreateInvoice creates a Lightning invoice using phoenixd
 func (p *PhoenixdProvider) CreateInvoice(ctx context.Context, amount int64, description string, pubkey string) (*Invoice, error) {
-	// Convert millisatoshis to satoshis
-	amountSat := amount / 1000
-	if amountSat == 0 {
-		amountSat = 1 // minimum 1 sat
-	}
+	return p.createInvoice(ctx, amount, "description", description, pubkey)
+}
+
+// CreateInvoiceWithDescriptionHash creates a Lightning invoice committing to a hex encoded description hash
+func (p *PhoenixdProvider) CreateInvoiceWithDescriptionHash(ctx context.Context, amount int64, descriptionHash string, pubkey string) (*Invoice, error) {
+	return p.createInvoice(ctx, amount, "descriptionHash", descriptionHash, pubkey)
+}
+
+// CreateAmountlessInvoice creates an invoice the payer picks the amount of
+func (p *PhoenixdProvider) CreateAmountlessInvoice(ctx context.Context, description string, pubkey string) (*Invoice, error) {
+	return p.createInvoice(ctx, 0, "description", description, pubkey)
+}
 
+// createInvoice creates an invoice with either a description or a description hash,
+// without an amount if amount is 0
+func (p *PhoenixdProvider) createInvoice(ctx context.Context, amount int64, descriptionField, descriptionValue string, pubkey string) (*Invoice, error) {
 	// Create external ID using pubkey hash for tracking
 	hash := sha256.Sum256([]byte(pubkey + fmt.Sprintf("%d", time.Now().Unix())))
 	externalID := hex.EncodeToString(hash[:])[:16]
 
 	// phoenixd expects form data, not JSON
-	formData := fmt.Sprintf("amountSat=%d&description=%s&externalId=%s", 
-		amountSat, 
-		description, 
+	formData := fmt.Sprintf("%s=%s&externalId=%s", 
+		descriptionField,
+		descriptionValue, 
 		externalID)
+	if amount > 0 {
+		// Convert millisatoshis to satoshis
+		amountSat := amount / 1000
+		if amountSat == 0 {
+			amountSat = 1 // minimum 1 sat
+		}
+		formData = fmt.Sprintf("amountSat=%d&", amountSat) + formData
+	}
 
 	req, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+"/createinvoice", strings.NewReader(formData))
 	if err != nil {
@@ -123,20 +151,19 @@ func (p *PhoenixdProvider) CreateInvoice(ctx context.Context, amount int64, desc
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.SetBasicAuth("", p.password) // phoenixd uses HTTP basic auth with empty username
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := p.httpClient().Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to make request: %w", err)
+		return nil, newRequestError(p.GetProviderName(), OpCreateInvoice, fmt.Errorf("failed to make request: %w", err))
 	}
 	defer resp.Body.Close()
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+		return nil, newRequestError(p.GetProviderName(), OpCreateInvoice, fmt.Errorf("failed to read response: %w", err))
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("phoenixd API error: %d - %s", resp.StatusCode, string(body))
+		return nil, newStatusError(p.GetProviderName(), OpCreateInvoice, resp.StatusCode, body)
 	}
 
 	var invoiceResp PhoenixdInvoiceResponse
@@ -146,17 +173,17 @@ func (p *PhoenixdProvider) CreateInvoice(ctx context.Context, amount int64, desc
 
 	// Store payment hash and pubkey mapping for payment verification
 	p.mu.Lock()
-	p.paymentMap[invoiceResp.PaymentHash] = externalID
-	p.pubkeyMap[invoiceResp.PaymentHash] = pubkey
+	p.paymentMap.Set(invoiceResp.PaymentHash, externalID)
+	p.pubkeyMap.Set(invoiceResp.PaymentHash, pubkey)
 	p.mu.Unlock()
 	
 	// Also store in persistent storage if available
-	if p.chargeMappingStorage != nil {
-		p.chargeMappingStorage.Store(invoiceResp.PaymentHash, externalID)
+	if p.invoiceStore != nil {
+		p.invoiceStore.RecordCharge(invoiceResp.PaymentHash, externalID, amount)
 	}
 
-	// Convert timestamps
-	expiresAt := time.Unix(invoiceResp.ExpiresAt, 0)
+	// Convert timestamps, phoenixd sends milliseconds
+	expiresAt := time.UnixMilli(invoiceResp.ExpiresAt)
 
 	return &Invoice{
 		PaymentRequest: invoiceResp.Serialized,
@@ -171,17 +198,17 @@ func (p *PhoenixdProvider) CreateInvoice(ctx context.Context, amount int64, desc
 func (p *PhoenixdProvider) VerifyPayment(ctx context.Context, paymentHash string) (*PaymentVerification, error) {
 	// Get external ID from payment map or persistent storage
 	p.mu.RLock()
-	externalID, exists := p.paymentMap[paymentHash]
+	externalID, exists := p.paymentMap.Get(paymentHash)
 	p.mu.RUnlock()
 
 	// If not found in memory, try persistent storage
-	if !exists && p.chargeMappingStorage != nil {
-		if storedID, found := p.chargeMappingStorage.Get(paymentHash); found {
+	if !exists && p.invoiceStore != nil {
+		if storedID, found := p.invoiceStore.ChargeID(paymentHash); found {
 			externalID = storedID
 			exists = true
 			// Store back in memory for faster future access
 			p.mu.Lock()
-			p.paymentMap[paymentHash] = externalID
+			p.paymentMap.Set(paymentHash, externalID)
 			p.mu.Unlock()
 		}
 	}
@@ -200,16 +227,15 @@ func (p *PhoenixdProvider) VerifyPayment(ctx context.Context, paymentHash string
 
 	req.SetBasicAuth("", p.password)
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := p.httpClient().Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to make request: %w", err)
+		return nil, newRequestError(p.GetProviderName(), OpVerifyPayment, fmt.Errorf("failed to make request: %w", err))
 	}
 	defer resp.Body.Close()
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+		return nil, newRequestError(p.GetProviderName(), OpVerifyPayment, fmt.Errorf("failed to read response: %w", err))
 	}
 
 	if resp.StatusCode == http.StatusNotFound {
@@ -223,7 +249,7 @@ func (p *PhoenixdProvider) VerifyPayment(ctx context.Context, paymentHash string
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("phoenixd API error: %d - %s", resp.StatusCode, string(body))
+		return nil, newStatusError(p.GetProviderName(), OpVerifyPayment, resp.StatusCode, body)
 	}
 
 	var paymentResp PhoenixdPaymentResponse
@@ -234,8 +260,8 @@ func (p *PhoenixdProvider) VerifyPayment(ctx context.Context, paymentHash string
 	// Convert amount back to millisatoshis
 	amountMsat := paymentResp.ReceivedSat * 1000
 
-	// Convert timestamp
-	paidAt := time.Unix(paymentResp.CompletedAt, 0)
+	// Convert timestamp, phoenixd sends milliseconds
+	paidAt := time.UnixMilli(paymentResp.CompletedAt)
 
 	verification := &PaymentVerification{
 		Paid:        paymentResp.IsPaid,
@@ -249,19 +275,130 @@ func (p *PhoenixdProvider) VerifyPayment(ctx context.Context, paymentHash string
 
 // CheckExistingPayments checks for any existing payments for a pubkey and returns verification if paid
 func (p *PhoenixdProvider) CheckExistingPayments(ctx context.Context, pubkey string) (*PaymentVerification, error) {
+	// Collect candidate hashes first so no lock is held during provider API calls
 	p.mu.RLock()
-	defer p.mu.RUnlock()
-	
-	for paymentHash, storedPubkey := range p.pubkeyMap {
-		if storedPubkey == pubkey {
-			log.Printf("🔍 Found payment for this pubkey - checking hash: %s", paymentHash)
-			verification, err := p.VerifyPayment(ctx, paymentHash)
-			if err == nil && verification.Paid {
-				log.Printf("💰 Found paid invoice! Payment hash: %s", paymentHash)
-				return verification, nil
-			}
+	paymentHashes := pendingHashesFor(p.pubkeyMap, p.invoiceStore, p.GetProviderName(), pubkey)
+	p.mu.RUnlock()
+
+	for _, paymentHash := range paymentHashes {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		p.logDebug("🔍 Found payment for this pubkey - checking hash: %s", paymentHash)
+		verification, err := p.VerifyPayment(ctx, paymentHash)
+		if err == nil && verification.Paid {
+			p.logInfo("💰 Found paid invoice! Payment hash: %s", paymentHash)
+			return verification, nil
 		}
 	}
-	
+
 	return nil, nil // No paid payments found
 }
+
+type PhoenixdPayInvoiceResponse struct {
+	RecipientAmountSat int64  `json:"recipientAmountSat"`
+	RoutingFeeSat      int64  `json:"routingFeeSat"`
+	PaymentID          string `json:"paymentId"`
+	PaymentHash        string `json:"paymentHash"`
+	PaymentPreimage    string `json:"paymentPreimage"`
+	Reason             string `json:"reason"` // set instead of the preimage when the payment failed
+}
+
+// PayInvoice pays a BOLT11 invoice from the node's balance
+func (p *PhoenixdProvider) PayInvoice(ctx context.Context, paymentRequest string) (*Payout, error) {
+	formData := url.Values{"invoice": {paymentRequest}}.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+"/payinvoice", strings.NewReader(formData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("", p.password)
+
+	// Payments may take a while to route
+	resp, err := withMinTimeout(p.httpClient(), 90*time.Second).Do(req)
+	if err != nil {
+		return nil, newRequestError(p.GetProviderName(), OpPayInvoice, fmt.Errorf("failed to make request: %w", err))
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, newRequestError(p.GetProviderName(), OpPayInvoice, fmt.Errorf("failed to read response: %w", err))
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStatusError(p.GetProviderName(), OpPayInvoice, resp.StatusCode, body)
+	}
+
+	var paymentResp PhoenixdPayInvoiceResponse
+	if err := json.Unmarshal(body, &paymentResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	if paymentResp.PaymentPreimage == "" {
+		return nil, fmt.Errorf("phoenixd %w: %s", errPayoutFailed, paymentResp.Reason)
+	}
+
+	return &Payout{
+		PaymentHash: paymentResp.PaymentHash,
+		Amount:      paymentResp.RecipientAmountSat * 1000,
+		Fee:         paymentResp.RoutingFeeSat * 1000,
+		Preimage:    paymentResp.PaymentPreimage,
+	}, nil
+}
+
+// PhoenixdOutgoingPayment is a payment sent by the node
+type PhoenixdOutgoingPayment struct {
+	PaymentHash string `json:"paymentHash"`
+	Preimage    string `json:"preimage"`
+	IsPaid      bool   `json:"isPaid"`
+	Sent        int64  `json:"sent"`        // satoshis, fees included
+	Fees        int64  `json:"fees"`        // millisatoshis
+	CompletedAt int64  `json:"completedAt"` // milliseconds, zero while in flight
+}
+
+// LookupPayout looks up a payment the node sent by its payment hash
+func (p *PhoenixdProvider) LookupPayout(ctx context.Context, paymentHash string) (*Payout, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.baseURL+"/payments/outgoingbyhash/"+paymentHash, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.SetBasicAuth("", p.password)
+
+	resp, err := p.httpClient().Do(req)
+	if err != nil {
+		return nil, newRequestError(p.GetProviderName(), OpPayInvoice, fmt.Errorf("failed to make request: %w", err))
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, newRequestError(p.GetProviderName(), OpPayInvoice, fmt.Errorf("failed to read response: %w", err))
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil // never sent
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStatusError(p.GetProviderName(), OpPayInvoice, resp.StatusCode, body)
+	}
+
+	var payment PhoenixdOutgoingPayment
+	if err := json.Unmarshal(body, &payment); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	switch {
+	case payment.IsPaid:
+		return &Payout{
+			PaymentHash: paymentHash,
+			Amount:      payment.Sent*1000 - payment.Fees,
+			Fee:         payment.Fees,
+			Preimage:    payment.Preimage,
+		}, nil
+	case payment.CompletedAt != 0:
+		return nil, nil // failed
+	}
+	return nil, fmt.Errorf("phoenixd payment %s is still in flight", paymentHash)
+}
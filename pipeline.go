@@ -0,0 +1,233 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// RejectFunc has the signature of khatru's RejectEvent hooks
+type RejectFunc func(ctx context.Context, event *nostr.Event) (reject bool, msg string)
+
+// RejectMiddleware wraps a RejectFunc, deciding itself or deferring to next
+type RejectMiddleware func(next RejectFunc) RejectFunc
+
+// rejectPipeline holds the stages RejectEventHandler runs through
+type rejectPipeline struct {
+	mutex    sync.Mutex
+	wrappers []RejectMiddleware // added with Use, outermost first
+	stages   []RejectMiddleware // built-in stages or the ones set with SetRejectPipeline
+	chain    atomic.Value       // compiled RejectFunc
+}
+
+// Use wraps the payment decision with additional middleware. The first middleware
+// passed is the outermost and sees every event before the built-in stages run.
+func (s *System) Use(middleware ...RejectMiddleware) {
+	s.pipeline.mutex.Lock()
+	defer s.pipeline.mutex.Unlock()
+
+	s.pipeline.wrappers = append(s.pipeline.wrappers, middleware...)
+	s.compileRejectPipeline()
+}
+
+// SetRejectPipeline replaces the built-in stages that run before RequirePayment,
+// e.g. to insert a check between AllowMembers and ClaimPaidInvoices
+func (s *System) SetRejectPipeline(stages ...RejectMiddleware) {
+	s.pipeline.mutex.Lock()
+	defer s.pipeline.mutex.Unlock()
+
+	s.pipeline.stages = stages
+	s.compileRejectPipeline()
+}
+
+// compileRejectPipeline chains wrappers and stages around RequirePayment, caller holds the mutex
+func (s *System) compileRejectPipeline() {
+	all := append(append([]RejectMiddleware{}, s.pipeline.wrappers...), s.pipeline.stages...)
+
+	chain := RejectFunc(s.RequirePayment)
+	for i := len(all) - 1; i >= 0; i-- {
+		chain = all[i](chain)
+	}
+	s.pipeline.chain.Store(chain)
+}
+
+// RejectDenied is the stage that rejects events from denylisted pubkeys without an invoice,
+// whether or not they are members
+func (s *System) RejectDenied(next RejectFunc) RejectFunc {
+	return func(ctx context.Context, event *nostr.Event) (bool, string) {
+		if s.IsDenylisted(event.PubKey) {
+			return true, denyRejectMessage
+		}
+		return next(ctx, event)
+	}
+}
+
+// AllowFreeKinds is the stage that accepts events of Config.FreeKinds from anyone, without
+// counting them against membership quotas or trials
+func (s *System) AllowFreeKinds(next RejectFunc) RejectFunc {
+	return func(ctx context.Context, event *nostr.Event) (bool, string) {
+		if slices.Contains(s.config.FreeKinds, event.Kind) {
+			return false, ""
+		}
+		return next(ctx, event)
+	}
+}
+
+// AllowMembers is the stage that accepts events from pubkeys allowed to write: the
+// allowlist, members of a tier with CapabilityWrite or anyone if writing is free,
+// counting them against membership quotas. Members on hold are rejected without an
+// invoice, members out of storage with an invoice for a bigger tier.
+func (s *System) AllowMembers(next RejectFunc) RejectFunc {
+	return func(ctx context.Context, event *nostr.Event) (bool, string) {
+		// Allowlisted pubkeys skip quotas too
+		if s.IsAllowlisted(event.PubKey) {
+			return false, ""
+		}
+		if s.HasAccess(event.PubKey, CapabilityWrite) {
+			err := s.countEvent(event)
+			if err == nil {
+				s.logDebug("💰 Allowing event from paid user: %s...", event.PubKey[:16])
+				s.usage.RecordEvent(event.PubKey, event.Kind)
+				return false, ""
+			}
+			if errors.Is(err, errStorageQuota) {
+				return s.rejectForStorage(ctx, event)
+			}
+		}
+		if member, exists := s.paidAccessStorage.GetMember(event.PubKey); exists && member.Hold.active(time.Now()) {
+			return true, holdRejectMessage
+		}
+		return next(ctx, event)
+	}
+}
+
+// ClaimPaidInvoices is the stage that grants access when a previously issued invoice was paid
+func (s *System) ClaimPaidInvoices(next RejectFunc) RejectFunc {
+	return func(ctx context.Context, event *nostr.Event) (bool, string) {
+		if s.claimPaidInvoice(ctx, event.PubKey) {
+			s.usage.RecordEvent(event.PubKey, event.Kind)
+			return false, "" // Allow the event
+		}
+		return next(ctx, event)
+	}
+}
+
+// claimPaidInvoice grants access if the provider has a paid invoice for pubkey. The
+// check runs within the deadline of ctx, which RejectEventHandler sets.
+func (s *System) claimPaidInvoice(ctx context.Context, pubkey string) bool {
+	// Check if there are any existing payments for this pubkey that might have been paid
+	s.logDebug("🔍 Checking for existing payments for pubkey: %s...", pubkey[:16])
+
+	verification, err := s.provider.CheckExistingPayments(ctx, pubkey)
+	if err != nil || verification == nil || !verification.Paid {
+		return false
+	}
+
+	s.with(pubkeyAttr(pubkey)).logInfo("💰 Found paid invoice! Granting access for pubkey: %s...", pubkey[:16])
+	if err := s.grantPaidAccess(ctx, pubkey, verification, SourcePayment); err != nil {
+		s.logError("❌ Failed to add paid access: %v", err)
+		return false
+	}
+	s.with(pubkeyAttr(pubkey)).logInfo("✅ Successfully granted access to pubkey: %s...", pubkey[:16])
+	return true
+}
+
+// RequirePayment is the final stage: it rejects the event with a fresh invoice
+func (s *System) RequirePayment(ctx context.Context, event *nostr.Event) (bool, string) {
+	return s.requirePayment(ctx, event.PubKey, event)
+}
+
+// requirePayment rejects pubkey with a fresh invoice, for event or for a query if event is
+// nil. The invoice is created within the deadline of ctx, what is left of the time budget
+// RejectEventHandler sets.
+func (s *System) requirePayment(ctx context.Context, pubkey string, event *nostr.Event) (bool, string) {
+	atomic.AddUint64(&s.paymentRequests, 1)
+
+	// Leave invoice creation to the payment page so spam never reaches the provider
+	if s.config.RejectWithoutInvoice {
+		amount, _, _ := s.price(ctx, pubkey, event)
+		return true, s.encodePaymentRequest(PaymentRequest{
+			Message:    s.config.RejectMessage,
+			Amount:     amount,
+			PaymentURL: s.PaymentPageURL(pubkey),
+		})
+	}
+
+	invoice, err := s.createInvoiceForEvent(ctx, pubkey, event)
+	if err != nil {
+		if errors.Is(err, errDeniedPubkey) {
+			return true, denyRejectMessage
+		}
+		if errors.Is(err, errRateLimited) {
+			return true, "rate-limited: " + err.Error()
+		}
+		if errors.Is(err, errCircuitOpen) {
+			if s.config.DegradedPolicy == "allow" {
+				return false, ""
+			}
+			return true, fmt.Sprintf("payment required but the payment backend is unavailable, try again in %d seconds", s.switcher.retryAfter())
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			s.with(pubkeyAttr(pubkey)).logInfo("⏱️ Invoice creation for %s exceeded %v, applying %s policy", pubkey[:16], s.invoiceTimeout, s.config.InvoiceTimeoutPolicy)
+			if s.config.InvoiceTimeoutPolicy == "allow" {
+				return false, ""
+			}
+			return true, "payment required but invoice creation timed out, try again shortly"
+		}
+		if IsPermanent(err) {
+			s.with(pubkeyAttr(pubkey)).logError("🚨 Invoice creation for %s failed permanently, check the provider configuration: %v", pubkey[:16], err)
+		} else {
+			s.with(pubkeyAttr(pubkey)).logError("❌ Failed to create invoice for %s: %v", pubkey[:16], err)
+		}
+		if s.config.InvoiceFailurePolicy == "allow" {
+			s.with(pubkeyAttr(pubkey)).logInfo("🔓 Letting %s... through without payment, invoice failure policy is allow", pubkey[:16])
+			return false, ""
+		}
+		if IsTransient(err) {
+			return true, "payment required but the payment backend is temporarily unavailable, try again shortly"
+		}
+		return true, "payment required but invoice creation failed"
+	}
+
+	// Tell this connection once the invoice is paid
+	s.watchConnection(ctx, event, invoice)
+
+	var expiresAt int64
+	if invoice.ExpiresAt.After(time.Now()) {
+		expiresAt = invoice.ExpiresAt.Unix()
+	}
+
+	return true, s.encodePaymentRequest(PaymentRequest{
+		Message:     s.config.RejectMessage,
+		Invoice:     invoice.PaymentRequest,
+		Amount:      invoice.Amount,
+		PaymentHash: invoice.PaymentHash,
+		ExpiresAt:   expiresAt,
+		PaymentURL:  s.PaymentPageURL(pubkey),
+	})
+}
+
+// encodePaymentRequest renders a rejection payload in the configured schema version
+func (s *System) encodePaymentRequest(req PaymentRequest) string {
+	if s.config.PaymentRequestVersion < 2 {
+		// Legacy clients only know the original fields
+		req = PaymentRequest{
+			Message: req.Message,
+			Invoice: req.Invoice,
+			Amount:  req.Amount,
+		}
+	} else {
+		req.Version = s.config.PaymentRequestVersion
+		req.Tiers = s.Tiers()
+	}
+
+	paymentJSON, _ := json.Marshal(req)
+	return string(paymentJSON)
+}
@@ -0,0 +1,109 @@
+package payments
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Pricer decides what an event author has to pay to get access.
+// event is nil when an invoice is requested outside of event rejection, and member
+// is the author's current (possibly expired) membership or nil if there is none.
+// A zero duration grants permanent access.
+type Pricer interface {
+	Price(ctx context.Context, event *nostr.Event, member *PaidAccessMember) (amount int64, duration time.Duration, tier string)
+}
+
+// PricerFunc adapts an ordinary function to the Pricer interface
+type PricerFunc func(ctx context.Context, event *nostr.Event, member *PaidAccessMember) (int64, time.Duration, string)
+
+// Price calls f(ctx, event, member)
+func (f PricerFunc) Price(ctx context.Context, event *nostr.Event, member *PaidAccessMember) (int64, time.Duration, string) {
+	return f(ctx, event, member)
+}
+
+// FlatPricer charges the same amount for the same duration to everyone
+type FlatPricer struct {
+	Amount   int64
+	Duration time.Duration
+	Tier     string
+}
+
+// Price returns the flat price
+func (p FlatPricer) Price(ctx context.Context, event *nostr.Event, member *PaidAccessMember) (int64, time.Duration, string) {
+	return p.Amount, p.Duration, p.Tier
+}
+
+// SetPricer replaces the pricing logic used for new invoices
+func (s *System) SetPricer(pricer Pricer) {
+	s.pricer = pricer
+}
+
+// price asks the configured pricer what the author of event owes, letting PriceForPubkey
+// adjust the amount for the pubkey and surge pricing for the relay load
+func (s *System) price(ctx context.Context, pubkey string, event *nostr.Event) (int64, time.Duration, string) {
+	member, _ := s.paidAccessStorage.GetMember(pubkey)
+	amount, duration, tier := s.pricer.Price(ctx, event, member)
+	if s.config.PriceForPubkey != nil {
+		if adjusted := s.config.PriceForPubkey(ctx, pubkey); adjusted > 0 {
+			amount = adjusted
+		}
+	}
+	return s.surgePrice(amount), duration, tier
+}
+
+// expectedAmount is what a payment should have been: the invoice amount if the invoice is
+// tracked, else the price of the tier it grants
+func (s *System) expectedAmount(invoice TrackedInvoice, tracked bool, tier string) int64 {
+	if tracked && invoice.Amount > 0 {
+		return invoice.Amount
+	}
+	for _, t := range s.Tiers() {
+		if t.Name == tier {
+			return t.Amount
+		}
+	}
+	return s.config.PaymentAmount
+}
+
+// paidDuration checks the amount of a payment against its price. Payments short of the
+// price by more than AmountTolerance percent buy the same share of the period; as
+// permanent access can't be shared out, such payments are refused with errUnderpaid.
+// When the provider doesn't report the amount, a tracked invoice counts as paid what it
+// asked for and other payments are refused with errUnderpaid. With prorated pricing,
+// payments buy as many days as they pay for instead.
+func (s *System) paidDuration(pubkey string, verification *PaymentVerification, invoice TrackedInvoice, tracked bool, tier string, duration time.Duration) (time.Duration, error) {
+	amount := verification.Amount
+	if amount <= 0 && tracked {
+		amount = invoice.Amount
+	}
+	if amount <= 0 {
+		return 0, fmt.Errorf("%w: amount paid to %s is unknown", errUnderpaid, verification.PaymentHash)
+	}
+	if s.ProratedPricingEnabled() {
+		return s.proratedDuration(amount), nil
+	}
+
+	expected := s.expectedAmount(invoice, tracked, tier)
+	if expected <= 0 || amount*100 >= expected*int64(100-s.config.AmountTolerance) {
+		return duration, nil
+	}
+
+	if duration == 0 {
+		if !tracked || invoice.Status != InvoiceStatusRefused {
+			s.invoices.MarkPaid(verification.PaymentHash, amount, verification.PaidAt)
+			s.invoices.MarkRefused(verification.PaymentHash)
+			s.publishInvoice(verification.PaymentHash)
+			s.recordAudit(AuditEntry{Action: refusedPaymentAuditAction, Pubkey: pubkey, Actor: "system",
+				Reason: fmt.Sprintf("%d msat paid to invoice %s priced %d msat", amount, verification.PaymentHash, expected)})
+			s.with(paymentHashAttr(verification.PaymentHash)).logInfo("⛔ Refused payment %.16s... of %d msat short of the %d msat price of permanent access", verification.PaymentHash, amount, expected)
+		}
+		return 0, fmt.Errorf("%w: %d msat paid of %d msat", errUnderpaid, amount, expected)
+	}
+
+	prorated := time.Duration(float64(duration) * float64(amount) / float64(expected))
+	s.with(paymentHashAttr(verification.PaymentHash)).logInfo("⚖️ Payment %.16s... of %d msat is short of the %d msat price, granting %v instead of %v", verification.PaymentHash, amount, expected, prorated.Round(time.Second), duration)
+	return prorated, nil
+}
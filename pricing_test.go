@@ -0,0 +1,68 @@
+package payments
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPaidDuration(t *testing.T) {
+	const month = 30 * 24 * time.Hour
+	pubkey := benchPubkeys(1)[0]
+	for _, test := range []struct {
+		name         string
+		tolerance    int   // AmountTolerance
+		pricePerDay  int64 // PricePerDay
+		paid         int64 // amount the provider reported, 0 if unknown
+		invoice      int64 // amount of the tracked invoice, untracked if 0
+		duration     time.Duration
+		wantDuration time.Duration
+		wantRefused  bool // errUnderpaid
+	}{
+		{name: "paid in full", paid: 21000, invoice: 21000, duration: month, wantDuration: month},
+		{name: "overpaid", paid: 42000, invoice: 21000, duration: month, wantDuration: month},
+		{name: "short within tolerance", tolerance: 5, paid: 20000, invoice: 21000, duration: month, wantDuration: month},
+		{name: "short beyond tolerance", tolerance: 5, paid: 10500, invoice: 21000, duration: month, wantDuration: month / 2},
+		{name: "short of permanent access", paid: 10500, invoice: 21000, wantRefused: true},
+		{name: "permanent access paid in full", paid: 21000, invoice: 21000, wantDuration: 0},
+		{name: "untracked priced by tier", paid: 10500, duration: month, wantDuration: month / 2},
+		{name: "unknown amount of tracked invoice", invoice: 21000, duration: month, wantDuration: month},
+		{name: "unknown amount of untracked payment", duration: month, wantRefused: true},
+		{name: "unknown amount of untracked permanent access", wantRefused: true},
+		{name: "prorated", pricePerDay: 1000, paid: 3000, invoice: 21000, duration: month, wantDuration: 72 * time.Hour},
+		{name: "prorated unknown amount of tracked invoice", pricePerDay: 1000, invoice: 2000, duration: month, wantDuration: 48 * time.Hour},
+		{name: "prorated unknown amount of untracked payment", pricePerDay: 1000, duration: month, wantRefused: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			system := newTestSystem(t, Config{PaymentAmount: 21000, AmountTolerance: test.tolerance, PricePerDay: test.pricePerDay})
+			hash := fmt.Sprintf("%064x", 1)
+			tier := system.Tiers()[0].Name
+			if test.invoice > 0 {
+				system.invoices.Track(&Invoice{PaymentHash: hash, Amount: test.invoice}, pubkey, tier, test.duration)
+			}
+			invoice, tracked := system.invoices.Get(hash)
+
+			verification := &PaymentVerification{Paid: true, PaymentHash: hash, Amount: test.paid}
+			duration, err := system.paidDuration(pubkey, verification, invoice, tracked, tier, test.duration)
+			if test.wantRefused {
+				if !errors.Is(err, errUnderpaid) {
+					t.Fatalf("got %v (error: %v), want errUnderpaid", duration, err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := duration - test.wantDuration; diff < -time.Second || diff > time.Second {
+				t.Fatalf("granted %v, want %v", duration, test.wantDuration)
+			}
+
+			// Underpaid invoices for permanent access are refused for the amount paid
+			if tracked && test.wantRefused {
+				invoice, _ := system.invoices.Get(hash)
+				if invoice.Status != InvoiceStatusRefused || invoice.PaidAmount != test.paid {
+					t.Fatalf("invoice %s for %d msat, want refused for %d msat", invoice.Status, invoice.PaidAmount, test.paid)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package payments
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// AmountlessInvoiceProvider is implemented by providers that can create invoices without an
+// amount, leaving it to the payer, as pay-what-you-want relays hand out
+type AmountlessInvoiceProvider interface {
+	CreateAmountlessInvoice(ctx context.Context, description string, pubkey string) (*Invoice, error)
+}
+
+// ProratedPricingEnabled reports whether access lasts as long as the amount paid buys at
+// PricePerDay, instead of the priced duration
+func (s *System) ProratedPricingEnabled() bool {
+	return s.config.PricePerDay > 0
+}
+
+// proratedDuration is the access amount buys at PricePerDay, at least a second since a
+// zero duration would be permanent
+func (s *System) proratedDuration(amount int64) time.Duration {
+	return max(time.Duration(float64(amount)/float64(s.config.PricePerDay)*float64(24*time.Hour)), time.Second)
+}
+
+// amountlessInvoiceProvider returns the provider to create amountless invoices with, if
+// the provider invoices would be created with can
+func (s *System) amountlessInvoiceProvider(ctx context.Context) (AmountlessInvoiceProvider, bool) {
+	switch current := s.switcher.Current().(type) {
+	case *routingProvider:
+		return s.switcher, current.supportsAmountless(ctx)
+	case AmountlessInvoiceProvider:
+		return s.switcher, true
+	}
+	return nil, false
+}
+
+// createProratedInvoice creates an invoice for pubkey whose amount the payer picks, falling
+// back to an invoice of amount with providers that can't leave it out
+func (s *System) createProratedInvoice(ctx context.Context, amount int64, description, pubkey string) (*Invoice, error) {
+	if provider, ok := s.amountlessInvoiceProvider(ctx); ok {
+		invoice, err := provider.CreateAmountlessInvoice(ctx, description, pubkey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create amountless invoice: %w", err)
+		}
+		return invoice, nil
+	}
+	return s.provider.CreateInvoice(ctx, amount, description, pubkey)
+}
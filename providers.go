@@ -0,0 +1,154 @@
+package payments
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// providerFactory builds a payment provider from the config
+type providerFactory func(config *Config, invoiceStore *InvoiceStore) (PaymentProvider, error)
+
+// providerFactories maps Config.Provider names to their constructors.
+// Experimental providers add themselves from init() behind a build tag.
+var providerFactories = map[string]providerFactory{
+	"zbd":      newZBDFromConfig,
+	"blink":    newBlinkFromConfig,
+	"phoenixd": newPhoenixdFromConfig,
+	"fedimint": newFedimintFromConfig,
+	"lnd":      newLNDFromConfig,
+	"lndhub":   newLNDhubFromConfig,
+	"lnurl":    newLNURLFromConfig,
+	"nwc":      newNWCFromConfig,
+}
+
+// ProviderFactory creates a custom payment provider from the system config
+type ProviderFactory func(config Config) (PaymentProvider, error)
+
+// registeredProviders holds the providers added with RegisterProvider
+var (
+	registeredProviders      = make(map[string]ProviderFactory)
+	registeredProvidersMutex sync.RWMutex
+)
+
+// RegisterProvider makes a custom payment provider available under name, for
+// Config.Provider and provider routes, without changes to this package. Call it before
+// New, typically from an init function. The factory receives a copy of the config and
+// reads any settings of its own from it or the environment. RegisterProvider panics if
+// name is empty, factory is nil, or name is already taken, like database/sql.Register.
+func RegisterProvider(name string, factory func(Config) (PaymentProvider, error)) {
+	if name == "" {
+		panic("payments: RegisterProvider name is empty")
+	}
+	if factory == nil {
+		panic("payments: RegisterProvider factory is nil for " + name)
+	}
+
+	registeredProvidersMutex.Lock()
+	defer registeredProvidersMutex.Unlock()
+	if _, exists := providerFactories[name]; exists {
+		panic("payments: RegisterProvider called twice for provider " + name)
+	}
+	if _, exists := registeredProviders[name]; exists {
+		panic("payments: RegisterProvider called twice for provider " + name)
+	}
+	registeredProviders[name] = factory
+}
+
+// lookupProviderFactory returns the constructor of a built-in or registered provider
+func lookupProviderFactory(name string) (providerFactory, bool) {
+	if factory, exists := providerFactories[name]; exists {
+		return factory, true
+	}
+
+	registeredProvidersMutex.RLock()
+	factory, exists := registeredProviders[name]
+	registeredProvidersMutex.RUnlock()
+	if !exists {
+		return nil, false
+	}
+	return func(config *Config, invoiceStore *InvoiceStore) (PaymentProvider, error) {
+		provider, err := factory(*config)
+		if err == nil && provider == nil {
+			err = fmt.Errorf("provider factory returned no provider")
+		}
+		return provider, err
+	}, true
+}
+
+// newProvider creates the provider selected in the config
+func newProvider(config *Config, invoiceStore *InvoiceStore) (PaymentProvider, error) {
+	factory, exists := lookupProviderFactory(config.Provider)
+	if !exists {
+		return nil, fmt.Errorf("unsupported payment provider: %s (supported: %s)", config.Provider, strings.Join(providerNames(), ", "))
+	}
+	return factory(config, invoiceStore)
+}
+
+// providerNames returns the built-in and registered provider names in sorted order
+func providerNames() []string {
+	registeredProvidersMutex.RLock()
+	names := make([]string, 0, len(providerFactories)+len(registeredProviders))
+	for name := range registeredProviders {
+		names = append(names, name)
+	}
+	registeredProvidersMutex.RUnlock()
+
+	for name := range providerFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// pendingHashesFor returns the invoices a provider issued to pubkey, from its in-memory map
+// and from the invoice store so invoices issued before a restart or evicted are found too
+func pendingHashesFor(pubkeyMap *lruMap[string], invoiceStore *InvoiceStore, provider, pubkey string) []string {
+	seen := make(map[string]bool)
+	hashes := pubkeyMap.KeysWith(func(storedPubkey string) bool { return storedPubkey == pubkey })
+	for _, hash := range hashes {
+		seen[hash] = true
+	}
+	if invoiceStore != nil {
+		for _, hash := range invoiceStore.PaymentHashes(pubkey, provider) {
+			if !seen[hash] {
+				hashes = append(hashes, hash)
+			}
+		}
+	}
+	return hashes
+}
+
+// newZBDFromConfig creates the ZBD provider
+func newZBDFromConfig(config *Config, invoiceStore *InvoiceStore) (PaymentProvider, error) {
+	if config.ZBDAPIKey == "" {
+		return nil, fmt.Errorf("ZBD_API_KEY required for zbd provider")
+	}
+	if config.LightningAddress == "" {
+		return nil, fmt.Errorf("LIGHTNING_ADDRESS required for zbd provider")
+	}
+	provider, err := NewZBDProviderWithInvoiceStore(config.ZBDAPIKey, config.LightningAddress, invoiceStore)
+	if err != nil {
+		return nil, err
+	}
+	if config.ZBDGamertag != "" {
+		provider.SetGamertag(config.ZBDGamertag)
+	}
+	if config.ZBDWebhookSecret != "" && config.PublicURL != "" {
+		provider.SetCallbackURL(strings.TrimSuffix(config.PublicURL, "/") + "/webhook/zbd?secret=" + url.QueryEscape(config.ZBDWebhookSecret))
+	}
+	return provider, nil
+}
+
+// newPhoenixdFromConfig creates the phoenixd provider
+func newPhoenixdFromConfig(config *Config, invoiceStore *InvoiceStore) (PaymentProvider, error) {
+	if config.PhoenixdPassword == "" {
+		return nil, fmt.Errorf("PHOENIXD_PASSWORD required for phoenixd provider")
+	}
+	if config.PhoenixdURL == "" {
+		config.PhoenixdURL = "http://localhost:9740"
+	}
+	return NewPhoenixdProviderWithInvoiceStore(config.PhoenixdURL, config.PhoenixdPassword, invoiceStore)
+}
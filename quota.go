@@ -0,0 +1,134 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// quotasFor returns the event and storage quotas of a new membership on tier, 0 for
+// unlimited. Events left on the pubkey's active membership carry over.
+func (s *System) quotasFor(pubkey, tier string) (events, storage int64) {
+	events, storage = s.config.EventQuota, s.config.StorageQuota
+	for _, candidate := range s.config.Tiers {
+		if candidate.Name != tier {
+			continue
+		}
+		if candidate.Events > 0 {
+			events = candidate.Events
+		}
+		if candidate.Storage > 0 {
+			storage = candidate.Storage
+		}
+		break
+	}
+
+	if events > 0 {
+		if member, exists := s.paidAccessStorage.GetMember(pubkey); exists && member.EventQuota > 0 && s.paidAccessStorage.HasAccess(pubkey) {
+			events += member.EventQuota - member.EventsUsed
+		}
+	}
+	return events, storage
+}
+
+// setQuotas applies quotas from quotasFor to a membership that was just granted
+func (s *System) setQuotas(pubkey string, events, storage int64) error {
+	if events == 0 && storage == 0 {
+		return nil
+	}
+	return s.paidAccessStorage.SetQuotas(pubkey, events, storage)
+}
+
+// storageQuotasEnabled reports whether any membership can have a storage quota, so
+// stored bytes are only tracked when they matter
+func (s *System) storageQuotasEnabled() bool {
+	if s.config.StorageQuota > 0 {
+		return true
+	}
+	for _, tier := range s.config.Tiers {
+		if tier.Storage > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// countEvent counts an accepted event against the quotas of its author's membership
+func (s *System) countEvent(event *nostr.Event) error {
+	var size int64
+	if s.storageQuotasEnabled() {
+		size = int64(len(event.Content))
+	}
+	return s.paidAccessStorage.CountEvent(event.PubKey, size)
+}
+
+// upgradeTier returns the cheapest tier whose storage quota fits needed bytes
+func (s *System) upgradeTier(needed int64) (Tier, bool) {
+	var best Tier
+	found := false
+	for _, tier := range s.Tiers() {
+		quota := tier.Storage
+		if quota == 0 {
+			quota = s.config.StorageQuota
+		}
+		if quota > 0 && quota < needed {
+			continue
+		}
+		if !found || tier.Amount < best.Amount {
+			best, found = tier, true
+		}
+	}
+	return best, found
+}
+
+// rejectForStorage rejects an event that would exceed its author's storage quota, with an
+// invoice for the cheapest tier it fits in
+func (s *System) rejectForStorage(ctx context.Context, event *nostr.Event) (bool, string) {
+	member, _ := s.paidAccessStorage.GetMember(event.PubKey)
+	needed := int64(len(event.Content))
+	if member != nil {
+		needed += member.BytesUsed
+	}
+
+	tier, found := s.upgradeTier(needed)
+	if !found {
+		return true, "storage quota exceeded"
+	}
+	message := fmt.Sprintf("storage quota exceeded, upgrade to %s", tier.Name)
+	s.with(pubkeyAttr(event.PubKey)).logInfo("🧮 Storage quota exceeded by pubkey %s..., offering %s", event.PubKey[:16], tier.Name)
+
+	if s.config.RejectWithoutInvoice {
+		return true, s.encodePaymentRequest(PaymentRequest{
+			Message:    message,
+			Amount:     tier.Amount,
+			PaymentURL: s.PaymentPageURL(event.PubKey),
+		})
+	}
+
+	invoice, err := s.createInvoice(ctx, event.PubKey, tier.Amount, accessDurationFor(tier.Duration), tier.Name)
+	if errors.Is(err, errRateLimited) {
+		return true, "rate-limited: storage quota exceeded, " + err.Error()
+	}
+	if err != nil {
+		s.with(pubkeyAttr(event.PubKey)).logError("❌ Failed to create upgrade invoice for %s: %v", event.PubKey[:16], err)
+		return true, "storage quota exceeded, upgrade invoice unavailable"
+	}
+	s.watchConnection(ctx, event, invoice)
+
+	var expiresAt int64
+	if invoice.ExpiresAt.After(time.Now()) {
+		expiresAt = invoice.ExpiresAt.Unix()
+	}
+
+	return true, s.encodePaymentRequest(PaymentRequest{
+		Message:     message,
+		Invoice:     invoice.PaymentRequest,
+		Amount:      invoice.Amount,
+		PaymentHash: invoice.PaymentHash,
+		ExpiresAt:   expiresAt,
+		PaymentURL:  s.PaymentPageURL(event.PubKey),
+	})
+}
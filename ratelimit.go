@@ -0,0 +1,193 @@
+package payments
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimiter allows each key limit requests per window, refilling continuously like a
+// token bucket. A nil limiter allows everything.
+type rateLimiter struct {
+	limit  int
+	window time.Duration
+
+	mutex   sync.Mutex
+	buckets map[string]*rateBucket
+	pruned  time.Time
+}
+
+// rateBucket holds the requests a key has left
+type rateBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// parseRateLimit parses a limit such as "5/1h", nil for ""
+func parseRateLimit(value string) (*rateLimiter, error) {
+	if value == "" {
+		return nil, nil
+	}
+	countStr, windowStr, found := strings.Cut(value, "/")
+	if !found {
+		return nil, fmt.Errorf("invalid rate limit %q, expected requests/duration such as 5/1h", value)
+	}
+	limit, err := strconv.Atoi(strings.TrimSpace(countStr))
+	if err != nil || limit <= 0 {
+		return nil, fmt.Errorf("invalid rate limit %q: count must be a positive number", value)
+	}
+	window, err := time.ParseDuration(strings.TrimSpace(windowStr))
+	if err != nil || window <= 0 {
+		return nil, fmt.Errorf("invalid rate limit %q: invalid duration", value)
+	}
+	return &rateLimiter{
+		limit:   limit,
+		window:  window,
+		buckets: make(map[string]*rateBucket),
+	}, nil
+}
+
+// Allow takes a request from key's bucket, reporting whether there was one left
+func (l *rateLimiter) Allow(key string, now time.Time) bool {
+	if l == nil {
+		return true
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	// Full buckets are the same as none, drop them once per window so random keys don't pile up
+	if now.Sub(l.pruned) >= l.window {
+		for stale, bucket := range l.buckets {
+			if now.Sub(bucket.updated) >= l.window {
+				delete(l.buckets, stale)
+			}
+		}
+		l.pruned = now
+	}
+
+	bucket, exists := l.buckets[key]
+	if !exists {
+		bucket = &rateBucket{tokens: float64(l.limit), updated: now}
+		l.buckets[key] = bucket
+	}
+	refill := now.Sub(bucket.updated).Seconds() / l.window.Seconds() * float64(l.limit)
+	bucket.tokens = min(bucket.tokens+refill, float64(l.limit))
+	bucket.updated = now
+
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// allowInvoice applies the invoice rate limits to a new invoice for pubkey, and for the
+// client IP carried by ctx if any
+func (s *System) allowInvoice(ctx context.Context, pubkey string) error {
+	now := time.Now()
+	if pubkey != "" && !s.pubkeyInvoiceLimiter.Allow(pubkey, now) {
+		s.with(pubkeyAttr(pubkey)).logInfo("🚦 Invoice rate limit reached for pubkey %s...", pubkey[:16])
+		return errRateLimited
+	}
+	if ip := clientIP(ctx); ip != "" && !s.ipInvoiceLimiter.Allow(ip, now) {
+		s.logInfo("🚦 Invoice rate limit reached for IP %s", ip)
+		return errRateLimited
+	}
+	return nil
+}
+
+// clientIPKey is the context key of the client IP
+type clientIPKey struct{}
+
+// WithClientIP marks ctx with the IP of the client a request came from, for the per-IP
+// invoice rate limit. Attach does it for events and queries, and RegisterHandlers for the
+// HTTP endpoints.
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+// clientIP returns the client IP ctx was marked with, "" if none
+func clientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
+}
+
+// parseTrustedProxies parses the IPs and CIDR ranges of trusted reverse proxies
+func parseTrustedProxies(values []string) ([]netip.Prefix, error) {
+	var proxies []netip.Prefix
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if !strings.Contains(value, "/") {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy: %s", value)
+			}
+			proxies = append(proxies, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy: %s", value)
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+	return proxies, nil
+}
+
+// trustedProxy tells whether ip belongs to a trusted reverse proxy
+func (s *System) trustedProxy(ip netip.Addr) bool {
+	for _, prefix := range s.trustedProxies {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestIP returns the IP a request came from. X-Forwarded-For is only believed on
+// connections from a trusted proxy, and only up to the right-most address no trusted proxy
+// added: clients can put anything to the left of it.
+func (s *System) requestIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+	ip = ip.Unmap()
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0 && s.trustedProxy(ip); i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break
+		}
+		ip = hop.Unmap()
+	}
+	return ip.String()
+}
+
+// withClientIP marks the requests handler serves with their client IP
+func (s *System) withClientIP(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r.WithContext(WithClientIP(r.Context(), s.requestIP(r))))
+	}
+}
+
+// writeRateLimited answers a request refused by the invoice rate limits
+func writeRateLimited(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", "60")
+	http.Error(w, errRateLimited.Error(), http.StatusTooManyRequests)
+}
@@ -0,0 +1,278 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateFetchTimeout bounds one exchange rate request, including fallbacks
+const rateFetchTimeout = 10 * time.Second
+
+// msatPerBTC converts bitcoin amounts to millisatoshis
+const msatPerBTC = 100_000_000_000
+
+// RateProvider reports the price of bitcoin in a fiat currency
+type RateProvider interface {
+	// Rate returns the price of one bitcoin in currency, e.g. 65000.5 for "USD"
+	Rate(ctx context.Context, currency string) (float64, error)
+}
+
+// CoinbaseRates reads spot prices from the Coinbase API
+type CoinbaseRates struct {
+	client *http.Client
+}
+
+// NewCoinbaseRates creates a Coinbase rate provider
+func NewCoinbaseRates() *CoinbaseRates {
+	return &CoinbaseRates{client: &http.Client{Timeout: rateFetchTimeout}}
+}
+
+// Rate implements RateProvider
+func (c *CoinbaseRates) Rate(ctx context.Context, currency string) (float64, error) {
+	var response struct {
+		Data struct {
+			Amount string `json:"amount"`
+		} `json:"data"`
+	}
+	url := "https://api.coinbase.com/v2/prices/BTC-" + strings.ToUpper(currency) + "/spot"
+	if err := fetchRateJSON(ctx, c.client, "Coinbase", url, &response); err != nil {
+		return 0, err
+	}
+	return parseRate(response.Data.Amount)
+}
+
+// KrakenRates reads last trade prices from the Kraken API
+type KrakenRates struct {
+	client *http.Client
+}
+
+// NewKrakenRates creates a Kraken rate provider
+func NewKrakenRates() *KrakenRates {
+	return &KrakenRates{client: &http.Client{Timeout: rateFetchTimeout}}
+}
+
+// Rate implements RateProvider
+func (k *KrakenRates) Rate(ctx context.Context, currency string) (float64, error) {
+	var response struct {
+		Error  []string `json:"error"`
+		Result map[string]struct {
+			Last []string `json:"c"` // price and volume of the last trade
+		} `json:"result"`
+	}
+	url := "https://api.kraken.com/0/public/Ticker?pair=XBT" + strings.ToUpper(currency)
+	if err := fetchRateJSON(ctx, k.client, "Kraken", url, &response); err != nil {
+		return 0, err
+	}
+	if len(response.Error) > 0 {
+		return 0, fmt.Errorf("Kraken error: %s", strings.Join(response.Error, ", "))
+	}
+	// The result is keyed by Kraken's own pair name, e.g. "XXBTZUSD"
+	for _, ticker := range response.Result {
+		if len(ticker.Last) > 0 {
+			return parseRate(ticker.Last[0])
+		}
+	}
+	return 0, fmt.Errorf("Kraken has no BTC price in %s", currency)
+}
+
+// MempoolRates reads prices from the mempool.space API, or a self-hosted instance
+type MempoolRates struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewMempoolRates creates a mempool.space rate provider, baseURL empty for mempool.space
+func NewMempoolRates(baseURL string) *MempoolRates {
+	if baseURL == "" {
+		baseURL = "https://mempool.space"
+	}
+	return &MempoolRates{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  &http.Client{Timeout: rateFetchTimeout},
+	}
+}
+
+// Rate implements RateProvider
+func (m *MempoolRates) Rate(ctx context.Context, currency string) (float64, error) {
+	var prices map[string]json.Number
+	if err := fetchRateJSON(ctx, m.client, "mempool.space", m.baseURL+"/api/v1/prices", &prices); err != nil {
+		return 0, err
+	}
+	price, ok := prices[strings.ToUpper(currency)]
+	if !ok {
+		return 0, fmt.Errorf("mempool.space has no BTC price in %s", currency)
+	}
+	return parseRate(price.String())
+}
+
+// FallbackRates asks each provider in turn until one has a rate
+type FallbackRates []RateProvider
+
+// Rate implements RateProvider
+func (f FallbackRates) Rate(ctx context.Context, currency string) (float64, error) {
+	var errs []string
+	for _, provider := range f {
+		rate, err := provider.Rate(ctx, currency)
+		if err == nil {
+			return rate, nil
+		}
+		errs = append(errs, err.Error())
+	}
+	return 0, fmt.Errorf("no exchange rate available: %s", strings.Join(errs, "; "))
+}
+
+// parseRateProviders builds the rate providers named in a comma-separated list, asked in
+// that order: "coinbase", "kraken", "mempool" or a mempool instance URL
+func parseRateProviders(value string) (RateProvider, error) {
+	var providers FallbackRates
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		switch {
+		case name == "":
+			continue
+		case name == "coinbase":
+			providers = append(providers, NewCoinbaseRates())
+		case name == "kraken":
+			providers = append(providers, NewKrakenRates())
+		case name == "mempool":
+			providers = append(providers, NewMempoolRates(""))
+		case strings.HasPrefix(name, "https://") || strings.HasPrefix(name, "http://"):
+			providers = append(providers, NewMempoolRates(name))
+		default:
+			return nil, fmt.Errorf("unknown rate provider %q (supported: coinbase, kraken, mempool or a mempool URL)", name)
+		}
+	}
+	if len(providers) == 0 {
+		return nil, fmt.Errorf("no rate provider given")
+	}
+	return providers, nil
+}
+
+// fetchRateJSON gets url and decodes the JSON response into v
+func fetchRateJSON(ctx context.Context, client *http.Client, provider, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return newRequestError(provider, OpFetchRate, fmt.Errorf("failed to fetch rate: %w", err))
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return newRequestError(provider, OpFetchRate, fmt.Errorf("failed to read response: %w", err))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return newStatusError(provider, OpFetchRate, resp.StatusCode, body)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("invalid %s response: %w", provider, err)
+	}
+	return nil
+}
+
+// parseRate parses a price, rejecting values that can't be a bitcoin price
+func parseRate(value string) (float64, error) {
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil || rate <= 0 || math.IsInf(rate, 0) {
+		return 0, fmt.Errorf("invalid rate %q", value)
+	}
+	return rate, nil
+}
+
+// rateCache keeps the last exchange rate fetched. When fetching fails it keeps using the
+// last rate, or the configured fallback until a rate was fetched at all.
+type rateCache struct {
+	provider RateProvider
+	currency string
+	fallback float64
+	mutex    sync.RWMutex
+	rate     float64
+}
+
+// current returns the rate to price invoices with, false if there is none
+func (c *rateCache) current() (float64, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if c.rate > 0 {
+		return c.rate, true
+	}
+	return c.fallback, c.fallback > 0
+}
+
+// refresh fetches the rate again
+func (c *rateCache) refresh(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, rateFetchTimeout)
+	defer cancel()
+
+	rate, err := c.provider.Rate(ctx, c.currency)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	c.rate = rate
+	c.mutex.Unlock()
+	return nil
+}
+
+// FiatPricingEnabled reports whether some price is set in fiat
+func (s *System) FiatPricingEnabled() bool {
+	return s.rates != nil
+}
+
+// ExchangeRate returns the price of one bitcoin in FiatCurrency that invoices are priced
+// with, false if no rate was fetched and there is no fallback
+func (s *System) ExchangeRate() (float64, bool) {
+	if s.rates == nil {
+		return 0, false
+	}
+	return s.rates.current()
+}
+
+// fiatToMsat converts a fiat price to millisatoshis at the current rate, rounded to whole
+// sats, false if the price is not in fiat or there is no rate
+func (s *System) fiatToMsat(fiat float64) (int64, bool) {
+	if fiat <= 0 {
+		return 0, false
+	}
+	rate, ok := s.ExchangeRate()
+	if !ok {
+		return 0, false
+	}
+	sats := math.Round(fiat / rate * msatPerBTC / 1000)
+	if sats < 1 {
+		sats = 1
+	}
+	return int64(sats) * 1000, true
+}
+
+// runRateRefresh fetches the exchange rate again on every tick
+func (s *System) runRateRefresh(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := s.rates.refresh(ctx); err != nil {
+			s.logWarn("⚠️ Failed to refresh exchange rate, keeping the last one: %v", err)
+		}
+	}
+}
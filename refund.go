@@ -0,0 +1,329 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// refundAuditAction is the audit log action of refunds
+const refundAuditAction = "refund"
+
+// PayoutProvider is implemented by providers that can pay invoices out of their wallet,
+// as refunds need
+type PayoutProvider interface {
+	// PayInvoice pays a BOLT11 invoice and returns what was sent
+	PayInvoice(ctx context.Context, paymentRequest string) (*Payout, error)
+}
+
+// Payout is a payment sent by a provider
+type Payout struct {
+	PaymentHash string `json:"payment_hash"`       // hash of the invoice paid
+	Amount      int64  `json:"amount"`             // millisatoshis received by the destination
+	Fee         int64  `json:"fee"`                // routing fee in millisatoshis
+	Preimage    string `json:"preimage,omitempty"` // proof of payment, if the provider returned it
+}
+
+// Refund is a payment sent back to a payer
+type Refund struct {
+	PaymentHash string    `json:"payment_hash"` // the payment refunded
+	Pubkey      string    `json:"pubkey"`
+	Destination string    `json:"destination"`
+	Payout      Payout    `json:"payout"`
+	RefundedAt  time.Time `json:"refunded_at"`
+}
+
+// PayoutLookup is implemented by payout providers that can look up a payment they sent,
+// so that a refund whose outcome is unknown isn't paid twice
+type PayoutLookup interface {
+	// LookupPayout returns the payment of the invoice with paymentHash, nil if it was never
+	// sent or failed, and an error if it is still in flight
+	LookupPayout(ctx context.Context, paymentHash string) (*Payout, error)
+}
+
+// Refund pays a settled payment back to destination, a BOLT11 invoice or a Lightning
+// address, from the wallet of the provider that received it. Lightning addresses are sent
+// the full amount paid; invoices may ask for less, e.g. to keep a fee, but not for more.
+// Access granted by the payment is not touched, call RevokeAccess for that.
+//
+// The invoice paid is recorded before paying it. If a refund fails without knowing
+// whether it was sent, the next call looks the payment up instead of paying again, or
+// retries the same invoice when the provider can't look payments up.
+func (s *System) Refund(ctx context.Context, paymentHash, destination string) (*Refund, error) {
+	// One refund at a time, so that a payment can't be refunded twice
+	s.refundMutex.Lock()
+	defer s.refundMutex.Unlock()
+
+	pubkey, amount, err := s.refundable(paymentHash)
+	if err != nil {
+		return nil, err
+	}
+	provider, ok := s.payoutProvider(paymentHash)
+	if !ok {
+		return nil, fmt.Errorf("%s provider cannot send refunds", s.providerNameFor(paymentHash))
+	}
+
+	var paymentRequest string
+	if invoice, exists := s.invoices.Get(paymentHash); exists {
+		paymentRequest = invoice.RefundRequest
+	}
+	if paymentRequest != "" {
+		if lookup, ok := provider.(PayoutLookup); ok {
+			refundHash, _, err := decodeBolt11(paymentRequest)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pending refund invoice: %w", err)
+			}
+			payout, err := lookup.LookupPayout(ctx, refundHash)
+			if err != nil {
+				return nil, fmt.Errorf("failed to look up pending refund: %w", err)
+			}
+			if payout != nil {
+				s.with(paymentHashAttr(paymentHash)).logInfo("🔍 Found pending refund of payment %.16s... already paid", paymentHash)
+				return s.recordRefund(paymentHash, pubkey, destination, payout), nil
+			}
+			// Never sent, a new invoice can be paid
+			paymentRequest = ""
+		}
+	}
+
+	retry := paymentRequest != ""
+	if !retry {
+		paymentRequest, err = s.refundInvoice(ctx, destination, amount)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.invoices.SetRefundRequest(paymentHash, paymentRequest); err != nil {
+			return nil, fmt.Errorf("failed to record pending refund: %w", err)
+		}
+	}
+	payout, err := provider.PayInvoice(ctx, paymentRequest)
+	if err != nil {
+		// The invoice is kept unless it surely wasn't paid, a retry must not pay another.
+		// Retried invoices stay too, an earlier attempt may still be in flight.
+		if !retry && errors.Is(err, errPayoutFailed) {
+			if err := s.invoices.SetRefundRequest(paymentHash, ""); err != nil {
+				s.logWarn("⚠️ Failed to clear pending refund: %v", err)
+			}
+		}
+		return nil, err
+	}
+	return s.recordRefund(paymentHash, pubkey, destination, payout), nil
+}
+
+// recordRefund records a refund of paymentHash paid with payout
+func (s *System) recordRefund(paymentHash, pubkey, destination string, payout *Payout) *Refund {
+	s.invoices.MarkRefunded(paymentHash, payout.Amount)
+	if err := s.ledger.MarkRefunded(paymentHash, payout.Amount); err != nil {
+		s.logWarn("⚠️ Failed to record refund in ledger: %v", err)
+	}
+	s.recordAudit(AuditEntry{Action: refundAuditAction, Pubkey: pubkey, Actor: "admin",
+		Reason: fmt.Sprintf("%d msat of payment %s refunded with invoice %s", payout.Amount, paymentHash, payout.PaymentHash)})
+	s.with(pubkeyAttr(pubkey), paymentHashAttr(paymentHash)).logInfo("↩️ Refunded %d msat of payment %.16s... to %s...", payout.Amount, paymentHash, pubkey[:16])
+
+	return &Refund{
+		PaymentHash: paymentHash,
+		Pubkey:      pubkey,
+		Destination: destination,
+		Payout:      *payout,
+		RefundedAt:  time.Now(),
+	}
+}
+
+// refundable returns who paid a payment hash and how much was received, if it can be
+// refunded. Payments whose amount the provider didn't report can't be, the price asked
+// may be more than was paid.
+func (s *System) refundable(paymentHash string) (string, int64, error) {
+	if entry, exists := s.ledger.Get(paymentHash); exists {
+		if !entry.RefundedAt.IsZero() {
+			return "", 0, errAlreadyRefunded
+		}
+		if entry.Amount <= 0 {
+			return "", 0, fmt.Errorf("%w for payment %s", errUnknownPaidAmount, paymentHash)
+		}
+		return entry.Pubkey, entry.Amount, nil
+	}
+
+	// Refused payments are only tracked with their invoice
+	invoice, exists := s.invoices.Get(paymentHash)
+	if !exists {
+		return "", 0, fmt.Errorf("no settled payment with hash %s", paymentHash)
+	}
+	if !invoice.RefundedAt.IsZero() {
+		return "", 0, errAlreadyRefunded
+	}
+	switch invoice.Status {
+	case InvoiceStatusPaid, InvoiceStatusGranted, InvoiceStatusRefused:
+		if invoice.PaidAmount <= 0 {
+			return "", 0, fmt.Errorf("%w for payment %s", errUnknownPaidAmount, paymentHash)
+		}
+		return invoice.Pubkey, invoice.PaidAmount, nil
+	}
+	return "", 0, fmt.Errorf("payment %s has not settled", paymentHash)
+}
+
+// refundInvoice returns the invoice to pay a refund of up to amount to destination
+func (s *System) refundInvoice(ctx context.Context, destination string, amount int64) (string, error) {
+	destination = strings.TrimPrefix(strings.TrimSpace(destination), "lightning:")
+	if destination == "" {
+		return "", fmt.Errorf("%w: a BOLT11 invoice or Lightning address is required", errInvalidRefundDestination)
+	}
+	lower := strings.ToLower(destination)
+	if strings.Contains(lower, "@") || !strings.HasPrefix(lower, "ln") || strings.HasPrefix(lower, "lnurl") {
+		return s.lnurlRefundInvoice(ctx, destination, amount)
+	}
+
+	_, invoiceAmount, err := decodeBolt11(destination)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errInvalidRefundDestination, err)
+	}
+	if invoiceAmount == 0 {
+		return "", fmt.Errorf("%w: invoice has no amount", errInvalidRefundDestination)
+	}
+	if invoiceAmount > amount {
+		return "", fmt.Errorf("%w: invoice is for %d msat but only %d msat were paid", errInvalidRefundDestination, invoiceAmount, amount)
+	}
+	return destination, nil
+}
+
+// lnurlRefundInvoice asks an LNURL-pay endpoint for an invoice of amount
+func (s *System) lnurlRefundInvoice(ctx context.Context, address string, amount int64) (string, error) {
+	payURL, err := resolveLNURL(address)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errInvalidRefundDestination, err)
+	}
+
+	var params LNURLPayParams
+	if err := s.getRefundJSON(ctx, payURL, &params); err != nil {
+		return "", err
+	}
+	if params.Status == "ERROR" {
+		return "", fmt.Errorf("LNURL-pay endpoint error: %s", params.Reason)
+	}
+	if params.Tag != "payRequest" || params.Callback == "" {
+		return "", fmt.Errorf("%w: %s is not an LNURL-pay endpoint", errInvalidRefundDestination, payURL)
+	}
+	if amount < params.MinSendable || amount > params.MaxSendable {
+		return "", fmt.Errorf("%w: refund of %d msat outside the endpoint's range of %d-%d msat", errInvalidRefundDestination, amount, params.MinSendable, params.MaxSendable)
+	}
+
+	callback, err := url.Parse(params.Callback)
+	if err != nil {
+		return "", fmt.Errorf("invalid LNURL-pay callback: %w", err)
+	}
+	query := callback.Query()
+	query.Set("amount", strconv.FormatInt(amount, 10))
+	callback.RawQuery = query.Encode()
+
+	var payment LNURLPayCallbackResponse
+	if err := s.getRefundJSON(ctx, callback.String(), &payment); err != nil {
+		return "", err
+	}
+	if payment.Status == "ERROR" {
+		return "", fmt.Errorf("LNURL-pay callback error: %s", payment.Reason)
+	}
+
+	// The invoice comes from a third party, check it is for what was asked
+	_, invoiceAmount, err := decodeBolt11(payment.PR)
+	if err != nil {
+		return "", err
+	}
+	if invoiceAmount != amount {
+		return "", fmt.Errorf("LNURL-pay invoice is for %d msat instead of %d msat", invoiceAmount, amount)
+	}
+	return payment.PR, nil
+}
+
+// getRefundJSON fetches a JSON document from a refund destination's LNURL-pay endpoint
+// with the shared HTTP client
+func (s *System) getRefundJSON(ctx context.Context, endpoint string, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return newRequestError("LNURL", OpPayInvoice, fmt.Errorf("failed to make request: %w", err))
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return newRequestError("LNURL", OpPayInvoice, fmt.Errorf("failed to read response: %w", err))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return newStatusError("LNURL", OpPayInvoice, resp.StatusCode, body)
+	}
+	if err := json.Unmarshal(body, result); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	return nil
+}
+
+// adminRefundHandler refunds a payment, optionally revoking the access it granted
+func (s *System) adminRefundHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		PaymentHash string `json:"payment_hash"`
+		Destination string `json:"destination"` // BOLT11 invoice or Lightning address
+		Revoke      bool   `json:"revoke"`
+		Reason      string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.PaymentHash == "" {
+		http.Error(w, "payment_hash is required", http.StatusBadRequest)
+		return
+	}
+	if _, _, err := s.refundable(req.PaymentHash); err != nil {
+		status := http.StatusNotFound
+		if errors.Is(err, errAlreadyRefunded) {
+			status = http.StatusConflict
+		} else if errors.Is(err, errUnknownPaidAmount) {
+			status = http.StatusUnprocessableEntity
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	refund, err := s.Refund(r.Context(), req.PaymentHash, req.Destination)
+	if err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, errInvalidRefundDestination) {
+			status = http.StatusBadRequest
+		} else if errors.Is(err, errAlreadyRefunded) {
+			status = http.StatusConflict
+		}
+		s.with(paymentHashAttr(req.PaymentHash)).logError("❌ Failed to refund payment %.16s...: %v", req.PaymentHash, err)
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	revoked := false
+	if req.Revoke {
+		reason := "refund"
+		if req.Reason != "" {
+			reason += ": " + req.Reason
+		}
+		if err := s.RevokeAccess(r.Context(), refund.Pubkey, reason); err != nil {
+			s.with(paymentHashAttr(req.PaymentHash)).logWarn("⚠️ Refunded payment %.16s... but did not revoke access: %v", req.PaymentHash, err)
+		} else {
+			revoked = true
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"refunded": true,
+		"refund":   refund,
+		"revoked":  revoked,
+	})
+}
@@ -0,0 +1,103 @@
+package payments
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRefundable(t *testing.T) {
+	pubkey := benchPubkeys(1)[0]
+	for _, test := range []struct {
+		name       string
+		setup      func(s *System, hash string)
+		wantAmount int64
+		wantError  error // nil for any error when wantAmount is 0
+	}{
+		{
+			name: "ledger amount",
+			setup: func(s *System, hash string) {
+				s.ledger.Record(LedgerEntry{Pubkey: pubkey, PaymentHash: hash, Amount: 20000})
+			},
+			wantAmount: 20000,
+		},
+		{
+			name: "ledger amount unknown",
+			setup: func(s *System, hash string) {
+				s.ledger.Record(LedgerEntry{Pubkey: pubkey, PaymentHash: hash})
+			},
+			wantError: errUnknownPaidAmount,
+		},
+		{
+			name: "ledger entry refunded",
+			setup: func(s *System, hash string) {
+				s.ledger.Record(LedgerEntry{Pubkey: pubkey, PaymentHash: hash, Amount: 20000})
+				s.ledger.MarkRefunded(hash, 20000)
+			},
+			wantError: errAlreadyRefunded,
+		},
+		{
+			// The amount paid, not the one asked, is paid back
+			name: "refused invoice",
+			setup: func(s *System, hash string) {
+				s.invoices.Track(&Invoice{PaymentHash: hash, Amount: 21000}, pubkey, "", 0)
+				s.invoices.MarkPaid(hash, 10000, time.Now())
+				s.invoices.MarkRefused(hash)
+			},
+			wantAmount: 10000,
+		},
+		{
+			name: "refused invoice amount unknown",
+			setup: func(s *System, hash string) {
+				s.invoices.Track(&Invoice{PaymentHash: hash, Amount: 21000}, pubkey, "", 0)
+				s.invoices.MarkPaid(hash, 0, time.Now())
+				s.invoices.MarkRefused(hash)
+			},
+			wantError: errUnknownPaidAmount,
+		},
+		{
+			name: "refused invoice refunded",
+			setup: func(s *System, hash string) {
+				s.invoices.Track(&Invoice{PaymentHash: hash, Amount: 21000}, pubkey, "", 0)
+				s.invoices.MarkPaid(hash, 10000, time.Now())
+				s.invoices.MarkRefused(hash)
+				s.invoices.MarkRefunded(hash, 10000)
+			},
+			wantError: errAlreadyRefunded,
+		},
+		{
+			name: "unpaid invoice",
+			setup: func(s *System, hash string) {
+				s.invoices.Track(&Invoice{PaymentHash: hash, Amount: 21000}, pubkey, "", 0)
+			},
+		},
+		{
+			name:  "unknown payment",
+			setup: func(s *System, hash string) {},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			system := newTestSystem(t, Config{})
+			hash := fmt.Sprintf("%064x", 1)
+			test.setup(system, hash)
+
+			payer, amount, err := system.refundable(hash)
+			if test.wantAmount == 0 {
+				if err == nil {
+					t.Fatalf("refundable for %d msat, want an error", amount)
+				}
+				if test.wantError != nil && !errors.Is(err, test.wantError) {
+					t.Fatalf("got error %v, want %v", err, test.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if payer != pubkey || amount != test.wantAmount {
+				t.Fatalf("refundable for %d msat paid by %s..., want %d msat paid by %s...", amount, payer[:16], test.wantAmount, pubkey[:16])
+			}
+		})
+	}
+}
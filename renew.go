@@ -0,0 +1,139 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Renew creates an invoice extending pubkey's membership by a tier's duration: the named
+// tier, else the tier of the current membership, else the first tier. The time left on an
+// active membership is kept once the invoice is paid, so members can pay ahead of expiry.
+func (s *System) Renew(ctx context.Context, pubkey, tier string) (*TierInvoice, error) {
+	selected, err := s.renewalTier(pubkey, tier)
+	if err != nil {
+		return nil, err
+	}
+
+	invoice, err := s.createInvoice(ctx, pubkey, selected.Amount, accessDurationFor(selected.Duration), selected.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	renewal := &TierInvoice{
+		Tier:           selected.Name,
+		Duration:       selected.Duration,
+		Amount:         selected.Amount,
+		PaymentRequest: invoice.PaymentRequest,
+		PaymentHash:    invoice.PaymentHash,
+	}
+	if !invoice.ExpiresAt.IsZero() {
+		renewal.ExpiresAt = invoice.ExpiresAt.Unix()
+	}
+	s.with(pubkeyAttr(pubkey)).logInfo("🔁 Renewal invoice for %s... on the %s tier", pubkey[:16], selected.Name)
+	return renewal, nil
+}
+
+// renewalTier picks the tier Renew charges for
+func (s *System) renewalTier(pubkey, name string) (Tier, error) {
+	if name == "" {
+		if member, exists := s.paidAccessStorage.GetMember(pubkey); exists {
+			for _, tier := range s.Tiers() {
+				if tier.Name == member.Tier {
+					return tier, nil
+				}
+			}
+		}
+		return s.Tiers()[0], nil
+	}
+
+	for _, tier := range s.Tiers() {
+		if tier.Name == name {
+			return tier, nil
+		}
+	}
+	return Tier{}, fmt.Errorf("unknown tier %q", name)
+}
+
+// renewHandler creates a renewal invoice, reporting the current expiry and the one paying
+// it now would give
+func (s *System) renewHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Pubkey string `json:"pubkey"`
+		Tier   string `json:"tier"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	pubkey, err := parsePubkey(req.Pubkey)
+	if err != nil {
+		http.Error(w, "valid pubkey is required", http.StatusBadRequest)
+		return
+	}
+
+	if s.IsDenylisted(pubkey) {
+		http.Error(w, "Pubkey is banned from this relay", http.StatusForbidden)
+		return
+	}
+	if _, err := s.renewalTier(pubkey, req.Tier); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.invoiceTimeout)
+	defer cancel()
+
+	renewal, err := s.Renew(ctx, pubkey, req.Tier)
+	if errors.Is(err, errRateLimited) {
+		writeRateLimited(w)
+		return
+	}
+	if err != nil {
+		s.with(pubkeyAttr(pubkey)).logError("❌ Failed to create renewal invoice for %s: %v", pubkey[:16], err)
+		http.Error(w, "Failed to create invoice", http.StatusBadGateway)
+		return
+	}
+	s.invoices.MarkSeen(renewal.PaymentHash)
+
+	response := map[string]interface{}{
+		"pubkey":     pubkey,
+		"has_access": s.HasAccess(pubkey),
+		"invoice":    renewal,
+	}
+	if expiresAt, renewedUntil := s.renewalExpiry(pubkey, renewal.Duration); !renewedUntil.IsZero() {
+		response["renewed_until"] = renewedUntil.Unix()
+		if !expiresAt.IsZero() {
+			response["expires_at"] = expiresAt.Unix()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// renewalExpiry returns when pubkey's active membership expires and when it would after
+// a renewal for duration, zero times for permanent access
+func (s *System) renewalExpiry(pubkey, duration string) (expiresAt, renewedUntil time.Time) {
+	period := accessDurationFor(duration)
+	if period == 0 {
+		return time.Time{}, time.Time{}
+	}
+
+	now := time.Now()
+	renewedUntil = now.Add(period)
+	if member, exists := s.paidAccessStorage.GetMember(pubkey); exists && s.paidAccessStorage.HasAccess(pubkey) {
+		if member.ExpiresAt.IsZero() {
+			if member.EventQuota == 0 {
+				return time.Time{}, time.Time{}
+			}
+		} else {
+			expiresAt = member.ExpiresAt
+			renewedUntil = member.ExpiresAt.Add(period)
+		}
+	}
+	return expiresAt, renewedUntil
+}
@@ -0,0 +1,121 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultProviderRetries retries transient provider failures twice, after about 250ms
+// and 500ms
+const defaultProviderRetries = "3/250ms"
+
+// maxRetryDelay caps the wait between two attempts of a provider call
+const maxRetryDelay = 5 * time.Second
+
+// retryPolicy is how often a provider call is attempted and how long the first retry waits
+type retryPolicy struct {
+	attempts int
+	backoff  time.Duration
+}
+
+// retryPolicies holds the retry policy of every provider, with overrides by provider name
+type retryPolicies struct {
+	fallback  retryPolicy
+	providers map[string]retryPolicy
+	logs      *logTarget // the System's
+}
+
+// parseRetryPolicies parses comma-separated "attempts/backoff" policies, each optionally
+// prefixed with "provider=", e.g. "3/250ms,zbd=5/500ms". The backoff may be left out.
+func parseRetryPolicies(value string) (*retryPolicies, error) {
+	policies := &retryPolicies{
+		fallback:  retryPolicy{attempts: 1},
+		providers: make(map[string]retryPolicy),
+	}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, spec, named := strings.Cut(entry, "=")
+		if !named {
+			spec, name = name, ""
+		}
+
+		attemptsStr, backoffStr, _ := strings.Cut(spec, "/")
+		attempts, err := strconv.Atoi(strings.TrimSpace(attemptsStr))
+		if err != nil || attempts < 1 {
+			return nil, fmt.Errorf("invalid provider retries %q: attempts must be a positive number", entry)
+		}
+		policy := retryPolicy{attempts: attempts, backoff: 250 * time.Millisecond}
+		if backoffStr != "" {
+			if policy.backoff, err = time.ParseDuration(strings.TrimSpace(backoffStr)); err != nil || policy.backoff <= 0 {
+				return nil, fmt.Errorf("invalid provider retries %q: invalid backoff", entry)
+			}
+		}
+
+		if name = strings.ToLower(strings.TrimSpace(name)); name == "" {
+			policies.fallback = policy
+		} else {
+			policies.providers[name] = policy
+		}
+	}
+	return policies, nil
+}
+
+// forProvider returns the policy of the named provider
+func (rp *retryPolicies) forProvider(name string) retryPolicy {
+	if policy, exists := rp.providers[strings.ToLower(name)]; exists {
+		return policy
+	}
+	return rp.fallback
+}
+
+// delay is the wait before retry number retry: the backoff doubled for each earlier retry,
+// with jitter so callers that failed together don't retry together
+func (p retryPolicy) delay(retry int) time.Duration {
+	delay := p.backoff
+	for i := 1; i < retry && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxRetryDelay)
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// do runs call, retrying transient provider failures by the policy of the provider that
+// failed for as long as ctx allows. Permanent failures are returned right away.
+func (rp *retryPolicies) do(ctx context.Context, call func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := call()
+		if err == nil || rp == nil || !IsTransient(err) || ctx.Err() != nil {
+			return err
+		}
+
+		var providerErr *ProviderError
+		errors.As(err, &providerErr)
+		policy := rp.forProvider(providerErr.Provider)
+		if attempt >= policy.attempts {
+			return err
+		}
+
+		// Give up early rather than sleep past the caller's deadline
+		delay := policy.delay(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return err
+		}
+		rp.logs.logWarn("🔁 %v, retrying in %v (attempt %d of %d)", err, delay.Round(time.Millisecond), attempt+1, policy.attempts)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,377 @@
+package payments
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Invoice purposes routes can be restricted to
+const (
+	PurposeAccess    = "access" // membership invoices from rejections, the payment page and GET /invoices
+	PurposeTopUp     = "topup"  // NWC balance top-ups
+	PurposeLNAddress = "lnurl"  // payments and zaps to the relay's Lightning address
+)
+
+// ProviderRoute sends invoices matching a purpose and amount range to another provider
+type ProviderRoute struct {
+	Provider  string `json:"provider"`             // name of the provider handling matching invoices
+	Purpose   string `json:"purpose,omitempty"`    // "access", "topup" or "lnurl", empty matches any purpose
+	MinAmount int64  `json:"min_amount,omitempty"` // in millisatoshis, inclusive
+	MaxAmount int64  `json:"max_amount,omitempty"` // in millisatoshis, exclusive, 0 for no upper bound
+}
+
+// matches reports whether an invoice falls into the route
+func (r ProviderRoute) matches(purpose string, amount int64) bool {
+	if r.Purpose != "" && r.Purpose != purpose {
+		return false
+	}
+	return amount >= r.MinAmount && (r.MaxAmount == 0 || amount < r.MaxAmount)
+}
+
+// String formats the route as in PROVIDER_ROUTES
+func (r ProviderRoute) String() string {
+	route := r.Provider
+	if r.Purpose != "" {
+		route += "@" + r.Purpose
+	}
+	if r.MinAmount != 0 || r.MaxAmount != 0 {
+		route += ":" + strconv.FormatInt(r.MinAmount, 10) + "-"
+		if r.MaxAmount != 0 {
+			route += strconv.FormatInt(r.MaxAmount, 10)
+		}
+	}
+	return route
+}
+
+// invoicePurposeKey carries the invoice purpose through provider calls
+type invoicePurposeKey struct{}
+
+// withInvoicePurpose marks the invoices created with ctx as serving purpose
+func withInvoicePurpose(ctx context.Context, purpose string) context.Context {
+	return context.WithValue(ctx, invoicePurposeKey{}, purpose)
+}
+
+// invoicePurpose returns the purpose set with withInvoicePurpose, defaulting to access
+func invoicePurpose(ctx context.Context) string {
+	if purpose, ok := ctx.Value(invoicePurposeKey{}).(string); ok {
+		return purpose
+	}
+	return PurposeAccess
+}
+
+// routingProvider spreads invoices over several providers and verifies every payment
+// hash against the provider that issued it
+type routingProvider struct {
+	primary      string
+	providers    map[string]PaymentProvider
+	routes       []ProviderRoute
+	invoiceStore *InvoiceStore
+
+	mu       sync.RWMutex
+	issuedBy map[string]string // payment hash -> provider name
+}
+
+// newRoutingProvider creates the primary provider and every provider named in routes
+func newRoutingProvider(config *Config, routes []ProviderRoute, invoiceStore *InvoiceStore) (*routingProvider, error) {
+	router := &routingProvider{
+		primary:      config.Provider,
+		providers:    make(map[string]PaymentProvider),
+		routes:       routes,
+		invoiceStore: invoiceStore,
+		issuedBy:     make(map[string]string),
+	}
+
+	names := []string{config.Provider}
+	for _, route := range routes {
+		names = append(names, route.Provider)
+	}
+	for _, name := range names {
+		if _, exists := router.providers[name]; exists {
+			continue
+		}
+		factory, exists := lookupProviderFactory(name)
+		if !exists {
+			return nil, fmt.Errorf("unsupported payment provider: %s (supported: %s)", name, strings.Join(providerNames(), ", "))
+		}
+		provider, err := factory(config, invoiceStore)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize %s provider: %w", name, err)
+		}
+		router.providers[name] = provider
+	}
+	return router, nil
+}
+
+// setHTTPClient hands the HTTP client to every routed provider
+func (r *routingProvider) setHTTPClient(client *http.Client) {
+	for _, provider := range r.providers {
+		useHTTPClient(provider, client)
+	}
+}
+
+// setLogger hands the logger to every routed provider
+func (r *routingProvider) setLogger(logger *slog.Logger) {
+	for _, provider := range r.providers {
+		useLogger(provider, logger)
+	}
+}
+
+// route picks the provider for an invoice, the first matching route wins
+func (r *routingProvider) route(ctx context.Context, amount int64) string {
+	purpose := invoicePurpose(ctx)
+	for _, route := range r.routes {
+		if route.matches(purpose, amount) {
+			return route.Provider
+		}
+	}
+	return r.primary
+}
+
+// CreateInvoice creates the invoice with the provider routed to
+func (r *routingProvider) CreateInvoice(ctx context.Context, amount int64, description string, pubkey string) (*Invoice, error) {
+	name := r.route(ctx, amount)
+	invoice, err := r.providers[name].CreateInvoice(ctx, amount, description, pubkey)
+	if err != nil {
+		return nil, err
+	}
+	r.recordIssuer(invoice.PaymentHash, name)
+	return invoice, nil
+}
+
+// CreateInvoiceWithDescriptionHash creates a description hash invoice with the provider routed to
+func (r *routingProvider) CreateInvoiceWithDescriptionHash(ctx context.Context, amount int64, descriptionHash string, pubkey string) (*Invoice, error) {
+	name := r.route(ctx, amount)
+	provider, ok := r.providers[name].(DescriptionHashProvider)
+	if !ok {
+		return nil, fmt.Errorf("%s provider cannot create description hash invoices", name)
+	}
+	invoice, err := provider.CreateInvoiceWithDescriptionHash(ctx, amount, descriptionHash, pubkey)
+	if err != nil {
+		return nil, err
+	}
+	r.recordIssuer(invoice.PaymentHash, name)
+	return invoice, nil
+}
+
+// supportsDescriptionHash reports whether the provider routed to can commit to a description hash
+func (r *routingProvider) supportsDescriptionHash(ctx context.Context, amount int64) bool {
+	_, ok := r.providers[r.route(ctx, amount)].(DescriptionHashProvider)
+	return ok
+}
+
+// CreateAmountlessInvoice creates an amountless invoice with the provider routed to
+func (r *routingProvider) CreateAmountlessInvoice(ctx context.Context, description string, pubkey string) (*Invoice, error) {
+	name := r.route(ctx, 0)
+	provider, ok := r.providers[name].(AmountlessInvoiceProvider)
+	if !ok {
+		return nil, fmt.Errorf("%s provider cannot create amountless invoices", name)
+	}
+	invoice, err := provider.CreateAmountlessInvoice(ctx, description, pubkey)
+	if err != nil {
+		return nil, err
+	}
+	r.recordIssuer(invoice.PaymentHash, name)
+	return invoice, nil
+}
+
+// supportsAmountless reports whether the provider routed to can leave out the amount
+func (r *routingProvider) supportsAmountless(ctx context.Context) bool {
+	_, ok := r.providers[r.route(ctx, 0)].(AmountlessInvoiceProvider)
+	return ok
+}
+
+// VerifyPayment asks the provider that issued the payment hash
+func (r *routingProvider) VerifyPayment(ctx context.Context, paymentHash string) (*PaymentVerification, error) {
+	return r.providers[r.issuer(paymentHash)].VerifyPayment(ctx, paymentHash)
+}
+
+// CheckExistingPayments asks every provider, primary first, for a paid invoice of pubkey
+func (r *routingProvider) CheckExistingPayments(ctx context.Context, pubkey string) (*PaymentVerification, error) {
+	var lastErr error
+	failed := 0
+	for _, name := range r.names() {
+		verification, err := r.providers[name].CheckExistingPayments(ctx, pubkey)
+		if err != nil {
+			lastErr = err
+			failed++
+			continue
+		}
+		if verification != nil && verification.Paid {
+			return verification, nil
+		}
+	}
+	if failed == len(r.providers) {
+		return nil, lastErr
+	}
+	return nil, nil
+}
+
+// GetProviderName returns the name of the primary provider
+func (r *routingProvider) GetProviderName() string {
+	return r.primary
+}
+
+// names returns the provider names, primary first
+func (r *routingProvider) names() []string {
+	names := []string{r.primary}
+	for name := range r.providers {
+		if name != r.primary {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// recordIssuer remembers which provider issued a payment hash
+func (r *routingProvider) recordIssuer(paymentHash, name string) {
+	r.mu.Lock()
+	r.issuedBy[paymentHash] = name
+	r.mu.Unlock()
+
+	if r.invoiceStore != nil {
+		r.invoiceStore.SetProvider(paymentHash, name)
+	}
+}
+
+// issuer returns the provider that issued a payment hash, the primary one if unknown
+func (r *routingProvider) issuer(paymentHash string) string {
+	r.mu.RLock()
+	name, exists := r.issuedBy[paymentHash]
+	r.mu.RUnlock()
+
+	if !exists && r.invoiceStore != nil {
+		name, exists = r.invoiceStore.Provider(paymentHash)
+	}
+	if _, known := r.providers[name]; !exists || !known {
+		return r.primary
+	}
+	return name
+}
+
+// providers returns the concrete providers in use, unwrapping the switch and router
+func (s *System) providers() []PaymentProvider {
+	current := s.switcher.Current()
+	router, ok := current.(*routingProvider)
+	if !ok {
+		return []PaymentProvider{current}
+	}
+	providers := make([]PaymentProvider, 0, len(router.providers))
+	for _, name := range router.names() {
+		providers = append(providers, router.providers[name])
+	}
+	return providers
+}
+
+// zbdProvider returns the ZBD provider if one is in use
+func (s *System) zbdProvider() (*ZBDProvider, bool) {
+	for _, provider := range s.providers() {
+		if zbd, ok := provider.(*ZBDProvider); ok {
+			return zbd, true
+		}
+	}
+	return nil, false
+}
+
+// blinkProvider returns the Blink provider if one is configured, directly or as a route
+func (s *System) blinkProvider() (*BlinkProvider, bool) {
+	for _, provider := range s.providers() {
+		if blink, ok := provider.(*BlinkProvider); ok {
+			return blink, true
+		}
+	}
+	return nil, false
+}
+
+// providerNameFor returns the name of the provider that issued a payment hash
+func (s *System) providerNameFor(paymentHash string) string {
+	if strings.HasPrefix(paymentHash, fedimintEcashPrefix) {
+		return "fedimint"
+	}
+	provider := s.switcher.issuerOf(paymentHash)
+	if router, ok := provider.(*routingProvider); ok {
+		return router.providers[router.issuer(paymentHash)].GetProviderName()
+	}
+	return provider.GetProviderName()
+}
+
+// payoutProvider returns the provider that received a payment hash, if it can pay out of
+// its wallet
+func (s *System) payoutProvider(paymentHash string) (PayoutProvider, bool) {
+	provider := s.switcher.issuerOf(paymentHash)
+	if router, ok := provider.(*routingProvider); ok {
+		provider = router.providers[router.issuer(paymentHash)]
+	}
+	payer, ok := provider.(PayoutProvider)
+	return payer, ok
+}
+
+// descriptionHashProvider returns the provider to use for a description hash invoice of
+// amount, if the provider it would be routed to supports them
+func (s *System) descriptionHashProvider(ctx context.Context, amount int64) (DescriptionHashProvider, bool) {
+	switch current := s.switcher.Current().(type) {
+	case *routingProvider:
+		return s.switcher, current.supportsDescriptionHash(ctx, amount)
+	case DescriptionHashProvider:
+		return s.switcher, true
+	}
+	return nil, false
+}
+
+// parseProviderRoutes parses "provider[@purpose][:min-max],..." with amounts in millisatoshis,
+// e.g. "zbd:0-100000,phoenixd:100000-,fedimint@topup"
+func parseProviderRoutes(value string) ([]ProviderRoute, error) {
+	var routes []ProviderRoute
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		target, amounts, hasAmounts := strings.Cut(entry, ":")
+		name, purpose, _ := strings.Cut(target, "@")
+		route := ProviderRoute{Provider: name, Purpose: purpose}
+
+		if hasAmounts {
+			minStr, maxStr, ok := strings.Cut(amounts, "-")
+			if !ok {
+				return nil, fmt.Errorf("invalid amount range %q, expected min-max", amounts)
+			}
+			var err error
+			if minStr != "" {
+				if route.MinAmount, err = strconv.ParseInt(minStr, 10, 64); err != nil {
+					return nil, fmt.Errorf("invalid minimum amount %q", minStr)
+				}
+			}
+			if maxStr != "" {
+				if route.MaxAmount, err = strconv.ParseInt(maxStr, 10, 64); err != nil {
+					return nil, fmt.Errorf("invalid maximum amount %q", maxStr)
+				}
+			}
+		}
+		routes = append(routes, route)
+	}
+	return routes, validateProviderRoutes(routes)
+}
+
+// validateProviderRoutes checks that every route names a provider, purpose and a valid range
+func validateProviderRoutes(routes []ProviderRoute) error {
+	for _, route := range routes {
+		if route.Provider == "" {
+			return fmt.Errorf("provider route without provider")
+		}
+		switch route.Purpose {
+		case "", PurposeAccess, PurposeTopUp, PurposeLNAddress:
+		default:
+			return fmt.Errorf("unknown invoice purpose %q (supported: %s, %s, %s)", route.Purpose, PurposeAccess, PurposeTopUp, PurposeLNAddress)
+		}
+		if route.MinAmount < 0 || (route.MaxAmount != 0 && route.MaxAmount <= route.MinAmount) {
+			return fmt.Errorf("invalid amount range for %s provider route", route.Provider)
+		}
+	}
+	return nil
+}
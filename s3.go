@@ -0,0 +1,224 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3LatestBackup is the key suffix of the copy of the newest backup, read by RestoreFromS3
+const s3LatestBackup = "latest.json"
+
+// s3Client uploads to and downloads from S3-compatible object storage with path-style URLs
+// and AWS Signature Version 4, which Amazon S3, Backblaze B2, Cloudflare R2, MinIO and
+// others accept
+type s3Client struct {
+	endpoint  string // scheme and host, e.g. "https://s3.us-east-1.amazonaws.com"
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+	prefix    string
+	client    *http.Client
+}
+
+// newS3ClientFromConfig validates the backup bucket settings
+func newS3ClientFromConfig(config *Config) (*s3Client, error) {
+	if config.BackupS3Endpoint == "" || config.BackupS3AccessKey == "" || config.BackupS3SecretKey == "" {
+		return nil, fmt.Errorf("S3 backups require an endpoint, access key and secret key")
+	}
+	endpoint, err := url.Parse(config.BackupS3Endpoint)
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "https" && endpoint.Scheme != "http") {
+		return nil, fmt.Errorf("invalid S3 endpoint: %s", config.BackupS3Endpoint)
+	}
+	region := config.BackupS3Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &s3Client{
+		endpoint:  endpoint.Scheme + "://" + endpoint.Host,
+		bucket:    config.BackupS3Bucket,
+		region:    region,
+		accessKey: config.BackupS3AccessKey,
+		secretKey: config.BackupS3SecretKey,
+		prefix:    config.BackupS3Prefix,
+		client:    &http.Client{Timeout: 60 * time.Second},
+	}, nil
+}
+
+// sink opens a backup object, uploaded along with a latest.json copy when closed
+func (c *s3Client) sink(name string) (io.WriteCloser, error) {
+	return &s3Upload{client: c, name: name}, nil
+}
+
+// s3Upload buffers a backup until it is closed, S3 needs the length and hash up front
+type s3Upload struct {
+	client *s3Client
+	name   string
+	buffer bytes.Buffer
+}
+
+func (u *s3Upload) Write(p []byte) (int, error) {
+	return u.buffer.Write(p)
+}
+
+// Close uploads the backup, then replaces latest.json with it
+func (u *s3Upload) Close() error {
+	ctx := context.Background()
+	if err := u.client.put(ctx, u.name, u.buffer.Bytes()); err != nil {
+		return err
+	}
+	return u.client.put(ctx, s3LatestBackup, u.buffer.Bytes())
+}
+
+// put stores an object under the configured prefix
+func (c *s3Client) put(ctx context.Context, name string, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "PUT", c.objectURL(name), bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	c.sign(req, data, time.Now())
+
+	_, err = c.do(req)
+	return err
+}
+
+// get reads an object under the configured prefix
+func (c *s3Client) get(ctx context.Context, name string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.objectURL(name), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	c.sign(req, nil, time.Now())
+	return c.do(req)
+}
+
+// do sends a signed request and returns the response body
+func (c *s3Client) do(req *http.Request) ([]byte, error) {
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, newRequestError("s3", OpBackup, fmt.Errorf("failed to reach object storage: %w", err))
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, newRequestError("s3", OpBackup, fmt.Errorf("failed to read response: %w", err))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStatusError("s3", OpBackup, resp.StatusCode, body)
+	}
+	return body, nil
+}
+
+// objectURL returns the path-style URL of an object
+func (c *s3Client) objectURL(name string) string {
+	return c.endpoint + "/" + s3Escape(c.bucket) + "/" + s3Escape(c.prefix+name)
+}
+
+// sign adds AWS Signature Version 4 headers to req
+func (c *s3Client) sign(req *http.Request, payload []byte, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(payload)
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// Sign every header set so far, lower-cased and sorted
+	var names []string
+	headers := make(map[string]string)
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		names = append(names, lower)
+		headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + c.region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+c.secretKey), date)
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Del("Host") // net/http sends req.Host
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.accessKey, scope, signedHeaders, signature))
+}
+
+// s3Escape percent-encodes an object key as SigV4 expects: everything but unreserved
+// characters, keeping slashes
+func s3Escape(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if c == '/' || c == '-' || c == '.' || c == '_' || c == '~' ||
+			('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// RestoreFromS3 restores memberships and invoices from a backup in the configured bucket,
+// the newest one when name is empty
+func (s *System) RestoreFromS3(ctx context.Context, name string) error {
+	if s.s3 == nil {
+		return fmt.Errorf("S3 backups are not configured")
+	}
+	if name == "" {
+		name = s3LatestBackup
+	}
+	data, err := s.s3.get(ctx, name)
+	if err != nil {
+		return fmt.Errorf("failed to download backup %s: %w", name, err)
+	}
+	return s.RestoreFromBackup(bytes.NewReader(data))
+}
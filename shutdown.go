@@ -0,0 +1,71 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// providerCloser is implemented by providers holding connections open between calls, such
+// as NWC's wallet relay connection, so Close can end them
+type providerCloser interface {
+	Close() error
+}
+
+// background runs routine in a goroutine that Close waits for. Routines return once ctx
+// is done, calls they make with it are cancelled.
+func (s *System) background(routine func(ctx context.Context)) {
+	s.routines.Add(1)
+	go func() {
+		defer s.routines.Done()
+		routine(s.ctx)
+	}()
+}
+
+// Close shuts the system down: it stops the background routines and waits for them until
+// ctx is done, writes out membership and invoice changes still pending in the background,
+// and closes provider connections. Call it once the relay stopped serving requests.
+func (s *System) Close(ctx context.Context) error {
+	s.stop()
+
+	done := make(chan struct{})
+	go func() {
+		s.routines.Wait()
+		close(done)
+	}()
+
+	var waitErr error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		waitErr = fmt.Errorf("background routines still running: %w", ctx.Err())
+	}
+
+	err := errors.Join(waitErr, s.flush(), s.closeProviders())
+	if err == nil {
+		s.logInfo("👋 Payment system closed")
+	}
+	return err
+}
+
+// flush writes membership, invoice and balance changes still pending in the background
+func (s *System) flush() error {
+	err := errors.Join(s.paidAccessStorage.Flush(), s.invoices.Flush())
+	if s.balances != nil {
+		err = errors.Join(err, s.balances.Flush())
+	}
+	return err
+}
+
+// closeProviders closes the connections the providers hold open
+func (s *System) closeProviders() error {
+	var errs []error
+	for _, provider := range s.providers() {
+		if closer, ok := provider.(providerCloser); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("failed to close %s provider: %w", provider.GetProviderName(), err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,142 @@
+package payments
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// StatsSnapshot is a typed copy of GetStats, served as JSON by GET /stats
+type StatsSnapshot struct {
+	Timestamp time.Time `json:"timestamp"`
+
+	Provider          string `json:"provider"`
+	LightningAddress  string `json:"lightning_address,omitempty"`
+	PaymentAmountMsat int64  `json:"payment_amount_msat"`
+	AccessDuration    string `json:"access_duration"`
+
+	PaymentRequests    uint64 `json:"payment_requests"`
+	SuccessfulPayments uint64 `json:"successful_payments"`
+
+	Members      MemberStats          `json:"members"`
+	Revenue      RevenueStats         `json:"revenue"`
+	Invoices     InvoiceStats         `json:"invoices"`
+	EventsByKind map[string]KindUsage `json:"events_by_kind"`
+
+	RelayLoad       *RelayLoad    `json:"relay_load,omitempty"`       // with surge pricing
+	PriceMultiplier float64       `json:"price_multiplier,omitempty"` // with surge pricing
+	Balances        *BalanceStats `json:"balances,omitempty"`         // with prepaid balances
+}
+
+// MemberStats counts memberships
+type MemberStats struct {
+	Total     int                   `json:"total"`
+	Active    int                   `json:"active"`
+	Expired   int                   `json:"expired"`
+	Held      int                   `json:"held"`
+	ByTier    map[string]int        `json:"by_tier"`   // active members
+	BySource  map[string]int        `json:"by_source"` // active members
+	Remaining []RemainingTimeBucket `json:"remaining_time_histogram"`
+}
+
+// RevenueStats sums the payment ledger, net of refunds
+type RevenueStats struct {
+	TotalMsat  int64                    `json:"total_msat"`
+	Payments   int                      `json:"payments"`
+	ByProvider map[string]RevenueTotals `json:"by_provider"`
+	ByTier     map[string]RevenueTotals `json:"by_tier"`
+}
+
+// InvoiceStats describes how issued invoices convert into payments
+type InvoiceStats struct {
+	Created                   uint64  `json:"created"`
+	Seen                      uint64  `json:"seen"`
+	Paid                      uint64  `json:"paid"`
+	Abandoned                 uint64  `json:"abandoned"`
+	Pending                   int     `json:"pending"`
+	AbandonmentRate           float64 `json:"abandonment_rate"` // 0 to 1
+	AvgLifetimeSeconds        float64 `json:"avg_lifetime_seconds"`
+	AbandoningPubkeys         int     `json:"abandoning_pubkeys"`
+	AbandoningPubkeysPaidLate int     `json:"abandoning_pubkeys_later_paid"`
+	TimeToPayP50Seconds       float64 `json:"time_to_pay_p50_seconds"`
+	TimeToPayP90Seconds       float64 `json:"time_to_pay_p90_seconds"`
+	TimeToPayP99Seconds       float64 `json:"time_to_pay_p99_seconds"`
+	TimeToPaySamples          int     `json:"time_to_pay_samples"`
+}
+
+// BalanceStats sums the prepaid balances
+type BalanceStats struct {
+	Accounts  int   `json:"accounts"`
+	TotalMsat int64 `json:"total_msat"`
+}
+
+// Snapshot returns the current stats as a StatsSnapshot
+func (s *System) Snapshot() StatsSnapshot {
+	stats := s.GetStats()
+
+	snapshot := StatsSnapshot{
+		Timestamp:          time.Now(),
+		Provider:           s.provider.GetProviderName(),
+		LightningAddress:   s.config.LightningAddress,
+		PaymentAmountMsat:  s.config.PaymentAmount,
+		AccessDuration:     s.config.AccessDuration,
+		PaymentRequests:    statValue[uint64](stats, "payment_requests"),
+		SuccessfulPayments: statValue[uint64](stats, "successful_payments"),
+		Members: MemberStats{
+			Total:     statValue[int](stats, "total_members"),
+			Active:    statValue[int](stats, "active_members"),
+			Expired:   statValue[int](stats, "expired_members"),
+			Held:      statValue[int](stats, "held_members"),
+			ByTier:    statValue[map[string]int](stats, "members_by_tier"),
+			BySource:  statValue[map[string]int](stats, "members_by_source"),
+			Remaining: statValue[[]RemainingTimeBucket](stats, "remaining_time_histogram"),
+		},
+		Revenue: RevenueStats{
+			TotalMsat:  statValue[int64](stats, "total_revenue_msat"),
+			Payments:   statValue[int](stats, "ledger_payments"),
+			ByProvider: statValue[map[string]RevenueTotals](stats, "revenue_by_provider"),
+			ByTier:     statValue[map[string]RevenueTotals](stats, "revenue_by_tier"),
+		},
+		Invoices: InvoiceStats{
+			Created:                   statValue[uint64](stats, "invoices_created"),
+			Seen:                      statValue[uint64](stats, "invoices_seen"),
+			Paid:                      statValue[uint64](stats, "invoices_paid"),
+			Abandoned:                 statValue[uint64](stats, "invoices_abandoned"),
+			Pending:                   statValue[int](stats, "invoices_pending"),
+			AbandonmentRate:           statValue[float64](stats, "abandonment_rate"),
+			AvgLifetimeSeconds:        statValue[float64](stats, "avg_invoice_lifetime_seconds"),
+			AbandoningPubkeys:         statValue[int](stats, "abandoning_pubkeys"),
+			AbandoningPubkeysPaidLate: statValue[int](stats, "abandoning_pubkeys_later_paid"),
+			TimeToPayP50Seconds:       statValue[float64](stats, "time_to_pay_p50_seconds"),
+			TimeToPayP90Seconds:       statValue[float64](stats, "time_to_pay_p90_seconds"),
+			TimeToPayP99Seconds:       statValue[float64](stats, "time_to_pay_p99_seconds"),
+			TimeToPaySamples:          statValue[int](stats, "time_to_pay_samples"),
+		},
+		EventsByKind: statValue[map[string]KindUsage](stats, "events_by_kind"),
+	}
+
+	if s.SurgePricingEnabled() {
+		load := s.Load()
+		snapshot.RelayLoad = &load
+		snapshot.PriceMultiplier = s.PriceMultiplier()
+	}
+	if s.BalancesEnabled() {
+		snapshot.Balances = &BalanceStats{
+			Accounts:  statValue[int](stats, "balance_accounts"),
+			TotalMsat: statValue[int64](stats, "total_balance_msat"),
+		}
+	}
+	return snapshot
+}
+
+// statValue reads a stat of GetStats, the zero value if it is missing
+func statValue[T any](stats map[string]interface{}, key string) T {
+	value, _ := stats[key].(T)
+	return value
+}
+
+// statsHandler serves the stats as a StatsSnapshot
+func (s *System) statsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Snapshot())
+}
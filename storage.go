@@ -1,270 +1,1065 @@
 package payments
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
-	"os"
-	"path/filepath"
+	"log/slog"
+	"sort"
 	"sync"
 	"time"
 )
 
 // PaidAccessMember represents a user who has paid for access
 type PaidAccessMember struct {
-	Pubkey      string    `json:"pubkey"`
+	Pubkey      string          `json:"pubkey"`
+	PaymentHash string          `json:"payment_hash"`
+	ExpiresAt   time.Time       `json:"expires_at"`
+	CreatedAt   time.Time       `json:"created_at"`
+	Amount      int64           `json:"amount"`
+	Tier        string          `json:"tier,omitempty"`
+	Source      string          `json:"source,omitempty"` // how the membership came to exist, one of the Source constants
+	Hold        *MembershipHold `json:"hold,omitempty"`
+	EventQuota  int64           `json:"event_quota,omitempty"` // events the membership may publish, 0 for unlimited
+	EventsUsed  int64           `json:"events_used,omitempty"` // events published against the quota
+
+	StorageQuota int64 `json:"storage_quota,omitempty"` // bytes of event content the membership may store, 0 for unlimited
+	BytesUsed    int64 `json:"bytes_used,omitempty"`    // bytes of event content accepted, kept across renewals
+}
+
+// Membership sources, telling paid memberships apart from complimentary ones
+const (
+	SourcePayment = "payment" // Lightning payment verified or claimed by the relay
+	SourceWebhook = "webhook" // payment reported by a provider webhook
+	SourceBalance = "balance" // paid from a prepaid NWC balance
+	SourceStream  = "stream"  // kept alive by streaming payments
+	SourceVoucher = "voucher" // redeemed voucher
+	SourceCashu   = "cashu"   // Cashu token melted to pay a relay invoice
+	SourceEcash   = "ecash"   // Fedimint ecash notes redeemed by the relay
+	SourceAdmin   = "admin"   // granted by hand by an operator
+	SourceImport  = "import"  // imported from another system
+	SourceTrial   = "trial"   // free trial
+)
+
+// IsPaidSource reports whether memberships from source were paid for, as opposed to comps
+func IsPaidSource(source string) bool {
+	switch source {
+	case SourcePayment, SourceWebhook, SourceBalance, SourceStream, SourceCashu, SourceEcash:
+		return true
+	}
+	return false
+}
+
+// MembershipHold suspends a membership without deleting it, e.g. while a payment is disputed
+type MembershipHold struct {
+	Reason    string    `json:"reason"`
+	PlacedAt  time.Time `json:"placed_at"`
+	ReleaseAt time.Time `json:"release_at,omitempty"` // zero keeps the hold until released by hand
+}
+
+// active reports whether the hold still suspends access at now
+func (h *MembershipHold) active(now time.Time) bool {
+	return h != nil && (h.ReleaseAt.IsZero() || now.Before(h.ReleaseAt))
+}
+
+// RemainingTimeBucket counts active members whose access ends within a time range
+type RemainingTimeBucket struct {
+	Label      string `json:"label"`
+	Members    int    `json:"members"`
+	AmountMsat int64  `json:"amount_msat"` // what these members paid last time, i.e. renewal revenue at stake
+}
+
+// remainingTimeBuckets are the upper bounds of the remaining-time histogram
+var remainingTimeBuckets = []struct {
+	label string
+	max   time.Duration
+}{
+	{"<1w", 7 * 24 * time.Hour},
+	{"1w-1m", 30 * 24 * time.Hour},
+	{"1m-3m", 90 * 24 * time.Hour},
+	{"3m-6m", 180 * 24 * time.Hour},
+	{"6m-1y", 365 * 24 * time.Hour},
+	{">1y", 0},
+}
+
+// StreamTier is the tier of memberships kept alive by streaming payments
+const StreamTier = "stream"
+
+// StreamDrip is a single streaming payment
+type StreamDrip struct {
 	PaymentHash string    `json:"payment_hash"`
-	ExpiresAt   time.Time `json:"expires_at"`
-	CreatedAt   time.Time `json:"created_at"`
 	Amount      int64     `json:"amount"`
+	ReceivedAt  time.Time `json:"received_at"`
 }
 
 // PaidAccessStorage manages paid access members
 type PaidAccessStorage struct {
 	Members  map[string]*PaidAccessMember `json:"members"`
+	// Recent streaming payments per pubkey, oldest first
+	Streams  map[string][]StreamDrip      `json:"streams,omitempty"`
+	// When each pubkey started its free trial, so nobody gets two
+	Trials   map[string]time.Time         `json:"trials,omitempty"`
 	mutex    sync.RWMutex
-	filePath string
+	store    Store
+	// Rolling window for Streams, see SetStreamWindow
+	streamWindow time.Duration
+	// Background writer, nil when every change is written synchronously
+	persister *writeBehind
+	// Pubkeys changed since the last write, so stores able to write single pubkeys write
+	// only those; allDirty when the whole state must be written
+	dirty    map[string]bool
+	allDirty bool
+
+	// Cached GetStats result so frequent scrapes don't contend with HasAccess
+	statsMutex    sync.Mutex
+	statsCache    map[string]interface{}
+	statsCachedAt time.Time
+	statsTTL      time.Duration
+
+	// Recent HasAccess results, so events of busy pubkeys don't contend on the lock
+	access *accessCache
+
+	logTarget
 }
 
-// NewPaidAccessStorage creates a new paid access storage
+// NewPaidAccessStorage creates a new paid access storage backed by a JSON file
 func NewPaidAccessStorage(filePath string) *PaidAccessStorage {
+	return NewPaidAccessStorageWithStore(NewJSONFileStore(filePath))
+}
+
+// setLogger makes the storage and its store log to logger
+func (pas *PaidAccessStorage) setLogger(logger *slog.Logger) {
+	pas.logTarget.setLogger(logger)
+	useLogger(pas.store, logger)
+}
+
+// NewPaidAccessStorageWithStore creates a new paid access storage backed by store
+func NewPaidAccessStorageWithStore(store Store) *PaidAccessStorage {
 	storage := &PaidAccessStorage{
 		Members:  make(map[string]*PaidAccessMember),
-		filePath: filePath,
+		Streams:  make(map[string][]StreamDrip),
+		Trials:   make(map[string]time.Time),
+		store:    store,
+		statsTTL: 5 * time.Second,
+		access:   newAccessCache(time.Second),
 	}
-	
-	// Ensure directory exists
-	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
-		log.Printf("⚠️ Failed to create directory for paid access file: %v", err)
+
+	if err := storage.Load(); err != nil {
+		logWarn("⚠️ Failed to load paid access data: %v", err)
 	}
-	
-	storage.Load()
 	return storage
 }
 
-// Load reads paid access data from file
+// Load reads paid access data from the store
 func (pas *PaidAccessStorage) Load() error {
 	pas.mutex.Lock()
 	defer pas.mutex.Unlock()
 
-	if _, err := os.Stat(pas.filePath); os.IsNotExist(err) {
-		// File doesn't exist, start with empty storage
-		return nil
+	data, err := pas.store.Load()
+	if err != nil {
+		return err
 	}
+	pas.Members = data.Members
+	pas.Streams = data.Streams
+	pas.Trials = data.Trials
+	if pas.Streams == nil {
+		pas.Streams = make(map[string][]StreamDrip)
+	}
+	if pas.Trials == nil {
+		pas.Trials = make(map[string]time.Time)
+	}
+	pas.dirty, pas.allDirty = nil, false
+	pas.access.clear()
+	return nil
+}
 
-	data, err := ioutil.ReadFile(pas.filePath)
-	if err != nil {
-		return fmt.Errorf("failed to read paid access file: %w", err)
+// Save persists paid access data, callers hold the lock. Passing the pubkeys whose member,
+// stream or trial changed lets the store write only those, without any the whole state is
+// written. With a write delay set the write happens in the background, see SetWriteDelay.
+func (pas *PaidAccessStorage) Save(pubkeys ...string) error {
+	// Every mutation ends up here, so drop cached stats and access results
+	pas.invalidateStats()
+	pas.access.clear()
+	if len(pubkeys) == 0 {
+		pubkeys = nil
 	}
+	pas.markDirty(pubkeys)
+	return pas.persist()
+}
+
+// markDirty records pubkeys as changed since the last write, everything when nil, callers
+// hold the lock
+func (pas *PaidAccessStorage) markDirty(pubkeys []string) {
+	if pubkeys == nil {
+		pas.allDirty = true
+		return
+	}
+	if pas.dirty == nil {
+		pas.dirty = make(map[string]bool)
+	}
+	for _, pubkey := range pubkeys {
+		pas.dirty[pubkey] = true
+	}
+}
 
-	if len(data) == 0 {
+// persist writes the changes or schedules the write, callers hold the lock
+func (pas *PaidAccessStorage) persist() error {
+	if pas.persister != nil {
+		pas.persister.markDirty()
 		return nil
 	}
+	data, pubkeys := pas.takeChanges()
+	if err := pas.write(data, pubkeys, pas.snapshot); err != nil {
+		pas.markDirty(pubkeys)
+		return err
+	}
+	return nil
+}
 
-	return json.Unmarshal(data, pas)
+// takeChanges copies what changed since the last write for the store and starts tracking
+// anew, callers hold the lock. pubkeys is nil when data is the whole state, because the
+// store only writes whole states or changes weren't tracked per pubkey.
+func (pas *PaidAccessStorage) takeChanges() (data *StoreData, pubkeys []string) {
+	if _, ok := pas.store.(changeSaver); !ok || pas.allDirty {
+		data = pas.snapshot()
+	} else {
+		data = newStoreData()
+		pubkeys = make([]string, 0, len(pas.dirty))
+		for pubkey := range pas.dirty {
+			pubkeys = append(pubkeys, pubkey)
+			if member, exists := pas.Members[pubkey]; exists {
+				copied := *member
+				data.Members[pubkey] = &copied
+			}
+			if drips, exists := pas.Streams[pubkey]; exists {
+				data.Streams[pubkey] = append([]StreamDrip(nil), drips...)
+			}
+			if startedAt, exists := pas.Trials[pubkey]; exists {
+				data.Trials[pubkey] = startedAt
+			}
+		}
+	}
+	pas.dirty, pas.allDirty = nil, false
+	return data, pubkeys
 }
 
-// Save writes paid access data to file
-func (pas *PaidAccessStorage) Save() error {
-	// Don't use RLock here since AddPaidAccess already has Lock
-	data, err := json.MarshalIndent(pas, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal paid access data: %w", err)
+// write hands changes from takeChanges to the store. A store writing single pubkeys may
+// ask for the whole state instead, which whole copies.
+func (pas *PaidAccessStorage) write(data *StoreData, pubkeys []string, whole func() *StoreData) error {
+	if pubkeys != nil {
+		err := pas.store.(changeSaver).saveChanges(data, pubkeys)
+		if !errors.Is(err, errSnapshotNeeded) {
+			return err
+		}
+		data = whole()
 	}
+	return pas.store.Save(data)
+}
 
-	log.Printf("💾 Saving paid access data to: %s", pas.filePath)
-	err = ioutil.WriteFile(pas.filePath, data, 0644)
-	if err != nil {
-		log.Printf("❌ Failed to write paid access file: %v", err)
-		return err
+// snapshot copies the state for the store, callers hold the lock
+func (pas *PaidAccessStorage) snapshot() *StoreData {
+	data := &StoreData{
+		Members: make(map[string]*PaidAccessMember, len(pas.Members)),
+		Streams: make(map[string][]StreamDrip, len(pas.Streams)),
+		Trials:  make(map[string]time.Time, len(pas.Trials)),
 	}
-	log.Printf("✅ Successfully saved paid access data")
-	return nil
+	for pubkey, member := range pas.Members {
+		copied := *member
+		data.Members[pubkey] = &copied
+	}
+	for pubkey, drips := range pas.Streams {
+		data.Streams[pubkey] = append([]StreamDrip(nil), drips...)
+	}
+	for pubkey, startedAt := range pas.Trials {
+		data.Trials[pubkey] = startedAt
+	}
+	return data
+}
+
+// export copies the state, e.g. for backups
+func (pas *PaidAccessStorage) export() *StoreData {
+	pas.mutex.RLock()
+	defer pas.mutex.RUnlock()
+	return pas.snapshot()
+}
+
+// replace swaps in restored state and saves it
+func (pas *PaidAccessStorage) replace(data *StoreData) error {
+	pas.mutex.Lock()
+	defer pas.mutex.Unlock()
+
+	pas.Members = data.Members
+	pas.Streams = data.Streams
+	pas.Trials = data.Trials
+	if pas.Streams == nil {
+		pas.Streams = make(map[string][]StreamDrip)
+	}
+	if pas.Trials == nil {
+		pas.Trials = make(map[string]time.Time)
+	}
+	return pas.Save()
+}
+
+// SetWriteDelay batches writes: changes are written in the background at most delay after
+// they happen instead of rewriting the store on every change. Zero writes synchronously.
+// Call Flush before exiting so the last changes are not lost.
+func (pas *PaidAccessStorage) SetWriteDelay(delay time.Duration) {
+	pas.Flush()
+
+	pas.mutex.Lock()
+	defer pas.mutex.Unlock()
+	if delay <= 0 {
+		pas.persister = nil
+		return
+	}
+	pas.persister = newWriteBehind("paid access data", delay, &pas.logTarget, func() error {
+		// Copy under the lock, the store may be slow
+		pas.mutex.Lock()
+		data, pubkeys := pas.takeChanges()
+		pas.mutex.Unlock()
+
+		if err := pas.write(data, pubkeys, pas.export); err != nil {
+			pas.mutex.Lock()
+			pas.markDirty(pubkeys)
+			pas.mutex.Unlock()
+			return err
+		}
+		return nil
+	})
+}
+
+// Flush writes changes still waiting for a background write
+func (pas *PaidAccessStorage) Flush() error {
+	pas.mutex.RLock()
+	persister := pas.persister
+	pas.mutex.RUnlock()
+
+	if persister == nil {
+		return nil
+	}
+	return persister.Flush()
 }
 
 // AddPaidAccess adds a new paid access member
 func (pas *PaidAccessStorage) AddPaidAccess(pubkey, paymentHash string, amount int64, duration time.Duration) error {
+	return pas.AddPaidAccessForTier(pubkey, paymentHash, "", amount, duration)
+}
+
+// AddPaidAccessForTier adds a new paid access member on the given tier
+func (pas *PaidAccessStorage) AddPaidAccessForTier(pubkey, paymentHash, tier string, amount int64, duration time.Duration) error {
+	return pas.AddAccessFromSource(pubkey, paymentHash, tier, SourcePayment, amount, duration)
+}
+
+// AddAccessFromSource adds a new member on the given tier, recording how the membership was granted.
+// An active membership is renewed: the duration is added to the time it has left.
+func (pas *PaidAccessStorage) AddAccessFromSource(pubkey, paymentHash, tier, source string, amount int64, duration time.Duration) error {
 	pas.mutex.Lock()
 	defer pas.mutex.Unlock()
 
-	expiresAt := time.Now().Add(duration)
+	now := time.Now()
+	expiresAt := now.Add(duration)
 	if duration == 0 {
 		expiresAt = time.Time{} // Never expires
 	}
 
+	existing, exists := pas.Members[pubkey]
+	if exists && !expiresAt.IsZero() && !existing.quotaExhausted() {
+		// Renewing early doesn't lose the time left, and unlimited permanent access stays permanent
+		switch {
+		case existing.ExpiresAt.IsZero() && existing.EventQuota == 0:
+			expiresAt = time.Time{}
+		case existing.ExpiresAt.After(now):
+			expiresAt = existing.ExpiresAt.Add(duration)
+		}
+	}
+
 	member := &PaidAccessMember{
 		Pubkey:      pubkey,
 		PaymentHash: paymentHash,
 		ExpiresAt:   expiresAt,
-		CreatedAt:   time.Now(),
+		CreatedAt:   now,
 		Amount:      amount,
+		Tier:        tier,
+		Source:      source,
+	}
+	if exists {
+		// Paying again doesn't lift a hold, and stored events still count
+		member.Hold = existing.Hold
+		member.BytesUsed = existing.BytesUsed
 	}
 
 	pas.Members[pubkey] = member
 
-	if err := pas.Save(); err != nil {
+	if err := pas.Save(pubkey); err != nil {
 		return fmt.Errorf("failed to save paid access: %w", err)
 	}
 
 	if expiresAt.IsZero() {
-		log.Printf("💰 Added permanent paid access for pubkey %s...", pubkey[:16])
+		pas.with(pubkeyAttr(pubkey)).logInfo("💰 Added permanent paid access for pubkey %s...", pubkey[:16])
 	} else {
-		log.Printf("💰 Added paid access for pubkey %s... (expires: %v)", pubkey[:16], expiresAt)
+		pas.with(pubkeyAttr(pubkey)).logInfo("💰 Added paid access for pubkey %s... (expires: %v)", pubkey[:16], expiresAt)
 	}
 	return nil
 }
 
+// RecordDrip adds a streaming payment to a pubkey's rolling window and keeps its stream
+// membership alive until the amount received within the window drops below minPerWindow.
+// Longer memberships bought some other way are left untouched. It returns the member and
+// whether access is active after the drip.
+func (pas *PaidAccessStorage) RecordDrip(pubkey string, drip StreamDrip, minPerWindow int64) (*PaidAccessMember, bool, error) {
+	pas.mutex.Lock()
+	defer pas.mutex.Unlock()
+
+	window := pas.streamWindow
+	now := time.Now()
+	drips := pas.Streams[pubkey]
+	for _, existing := range drips {
+		if existing.PaymentHash == drip.PaymentHash {
+			member := pas.Members[pubkey]
+			return member, member != nil && (member.ExpiresAt.IsZero() || now.Before(member.ExpiresAt)) && !member.Hold.active(now), nil
+		}
+	}
+
+	// Keep only drips still inside the window, in arrival order
+	var kept []StreamDrip
+	for _, existing := range append(drips, drip) {
+		if now.Sub(existing.ReceivedAt) < window {
+			kept = append(kept, existing)
+		}
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i].ReceivedAt.Before(kept[j].ReceivedAt) })
+	pas.Streams[pubkey] = kept
+
+	expiresAt := streamExpiry(kept, window, minPerWindow)
+	member, exists := pas.Members[pubkey]
+
+	// Don't shorten a membership bought with a regular payment
+	paidLonger := exists && member.Tier != StreamTier && (member.ExpiresAt.IsZero() || member.ExpiresAt.After(expiresAt))
+	if !paidLonger && expiresAt.After(now) {
+		var total int64
+		for _, d := range kept {
+			total += d.Amount
+		}
+		createdAt := now
+		if exists && member.Tier == StreamTier && now.Before(member.ExpiresAt) {
+			createdAt = member.CreatedAt
+		}
+		member = &PaidAccessMember{
+			Pubkey:      pubkey,
+			PaymentHash: drip.PaymentHash,
+			ExpiresAt:   expiresAt,
+			CreatedAt:   createdAt,
+			Amount:      total,
+			Tier:        StreamTier,
+			Source:      SourceStream,
+		}
+		if exists {
+			member.Hold = pas.Members[pubkey].Hold
+		}
+		pas.Members[pubkey] = member
+	}
+
+	if err := pas.Save(pubkey); err != nil {
+		return nil, false, fmt.Errorf("failed to save paid access: %w", err)
+	}
+
+	member = pas.Members[pubkey]
+	return member, member != nil && (member.ExpiresAt.IsZero() || now.Before(member.ExpiresAt)) && !member.Hold.active(now), nil
+}
+
+// pruneStreams drops drips that left the streaming window, returning the pubkeys whose
+// stream changed
+func (pas *PaidAccessStorage) pruneStreams(now time.Time) []string {
+	if pas.streamWindow == 0 {
+		return nil
+	}
+
+	var changed []string
+	for pubkey, drips := range pas.Streams {
+		var kept []StreamDrip
+		for _, drip := range drips {
+			if now.Sub(drip.ReceivedAt) < pas.streamWindow {
+				kept = append(kept, drip)
+			}
+		}
+		if len(kept) == len(drips) {
+			continue
+		}
+		changed = append(changed, pubkey)
+		if len(kept) == 0 {
+			delete(pas.Streams, pubkey)
+		} else {
+			pas.Streams[pubkey] = kept
+		}
+	}
+	return changed
+}
+
+// SetStreamWindow sets the rolling window streaming payments are counted over
+func (pas *PaidAccessStorage) SetStreamWindow(window time.Duration) {
+	pas.mutex.Lock()
+	defer pas.mutex.Unlock()
+	pas.streamWindow = window
+}
+
+// streamExpiry returns when the amount received within window falls below minPerWindow
+// if no further drips arrive, drips sorted oldest first
+func streamExpiry(drips []StreamDrip, window time.Duration, minPerWindow int64) time.Time {
+	var remaining int64
+	for _, drip := range drips {
+		remaining += drip.Amount
+	}
+	if remaining < minPerWindow {
+		return time.Time{}
+	}
+
+	// Drips leave the window oldest first, the rate drops below the threshold once enough have left
+	for _, drip := range drips {
+		remaining -= drip.Amount
+		if remaining < minPerWindow {
+			return drip.ReceivedAt.Add(window)
+		}
+	}
+	return time.Time{}
+}
+
 // HasAccess checks if a pubkey has valid paid access
 func (pas *PaidAccessStorage) HasAccess(pubkey string) bool {
+	now := time.Now()
+	if allowed, cached := pas.access.get(pubkey, now); cached {
+		return allowed
+	}
+
 	pas.mutex.RLock()
 	defer pas.mutex.RUnlock()
 
+	// Cache under the lock, so a change can't slip in between and leave a stale result
+	allowed, changesAt := pas.accessAt(pubkey, now)
+	pas.access.put(pubkey, allowed, now, changesAt)
+	return allowed
+}
+
+// accessAt checks if a pubkey has valid paid access at now, and when that changes unless
+// the member does, zero if never. Callers hold the lock.
+func (pas *PaidAccessStorage) accessAt(pubkey string, now time.Time) (bool, time.Time) {
 	member, exists := pas.Members[pubkey]
 	if !exists {
-		return false
+		return false, time.Time{}
 	}
 
 	// Check if access has expired (unless it's forever)
-	if !member.ExpiresAt.IsZero() && time.Now().After(member.ExpiresAt) {
-		return false
+	if !member.ExpiresAt.IsZero() && now.After(member.ExpiresAt) {
+		return false, time.Time{}
 	}
 
-	return true
+	// Quota memberships end early once every event is used
+	if member.quotaExhausted() {
+		return false, time.Time{}
+	}
+
+	// Held memberships are kept but don't grant access
+	if member.Hold.active(now) {
+		return false, member.Hold.ReleaseAt
+	}
+	return true, member.ExpiresAt
 }
 
-// CleanupExpired removes expired access entries
-func (pas *PaidAccessStorage) CleanupExpired() error {
+// SetAccessCacheTTL sets how long HasAccess results are reused, zero disables caching.
+// Results are dropped whenever members change, the TTL bounds how long changes made by
+// other processes sharing the store go unnoticed.
+func (pas *PaidAccessStorage) SetAccessCacheTTL(ttl time.Duration) {
+	pas.access.setTTL(ttl)
+}
+
+// StartTrial gives a pubkey that never had a trial or membership free access on tier: for
+// duration, or without time limit if zero, and for events events, or without event limit
+// if zero. It reports whether the trial started.
+func (pas *PaidAccessStorage) StartTrial(pubkey, tier string, duration time.Duration, events int64) (bool, error) {
 	pas.mutex.Lock()
 	defer pas.mutex.Unlock()
 
+	if _, exists := pas.Members[pubkey]; exists {
+		return false, nil
+	}
+	if _, trialed := pas.Trials[pubkey]; trialed {
+		return false, nil
+	}
+
 	now := time.Now()
-	cleanedCount := 0
+	member := &PaidAccessMember{
+		Pubkey:     pubkey,
+		CreatedAt:  now,
+		Tier:       tier,
+		Source:     SourceTrial,
+		EventQuota: events,
+	}
+	if duration > 0 {
+		member.ExpiresAt = now.Add(duration)
+	}
+	pas.Members[pubkey] = member
+	pas.Trials[pubkey] = now
 
-	for pubkey, member := range pas.Members {
-		if !member.ExpiresAt.IsZero() && now.After(member.ExpiresAt) {
-			delete(pas.Members, pubkey)
-			cleanedCount++
-		}
+	if err := pas.Save(pubkey); err != nil {
+		delete(pas.Members, pubkey)
+		delete(pas.Trials, pubkey)
+		return false, fmt.Errorf("failed to save trial: %w", err)
 	}
+	pas.with(pubkeyAttr(pubkey)).logInfo("🎁 Started free trial for pubkey %s...", pubkey[:16])
+	return true, nil
+}
 
-	if cleanedCount > 0 {
-		log.Printf("🧹 Cleaned up %d expired access entries", cleanedCount)
-		return pas.Save()
+// SetQuotas limits a member to events more events and storage bytes of event content in
+// total, 0 lifts a limit. Bytes already stored still count.
+func (pas *PaidAccessStorage) SetQuotas(pubkey string, events, storage int64) error {
+	pas.mutex.Lock()
+	defer pas.mutex.Unlock()
+
+	member, exists := pas.Members[pubkey]
+	if !exists {
+		return fmt.Errorf("no membership for pubkey: %s", pubkey)
 	}
+	member.EventQuota, member.EventsUsed = events, 0
+	member.StorageQuota = storage
 
+	if err := pas.Save(pubkey); err != nil {
+		return fmt.Errorf("failed to save quotas: %w", err)
+	}
 	return nil
 }
 
-// GetStats returns statistics about paid access
-func (pas *PaidAccessStorage) GetStats() map[string]interface{} {
+// CountEvent counts an event with size bytes of content against a member's quotas. It
+// returns errEventQuota or errStorageQuota without counting when the event doesn't fit.
+// Pubkeys without quotas always pass, and a zero size leaves storage untracked.
+func (pas *PaidAccessStorage) CountEvent(pubkey string, size int64) error {
+	pas.mutex.Lock()
+	defer pas.mutex.Unlock()
+
+	member, exists := pas.Members[pubkey]
+	if !exists || (member.EventQuota == 0 && size == 0) {
+		return nil
+	}
+	if member.quotaExhausted() {
+		return errEventQuota
+	}
+	if member.StorageQuota > 0 && member.BytesUsed+size > member.StorageQuota {
+		return errStorageQuota
+	}
+	if member.EventQuota > 0 {
+		member.EventsUsed++
+	}
+	member.BytesUsed += size
+
+	// Only this member changed, keep the access results of the others
+	pas.invalidateStats()
+	pas.access.forget(pubkey)
+	pas.markDirty([]string{pubkey})
+	if err := pas.persist(); err != nil {
+		pas.with(pubkeyAttr(pubkey)).logWarn("⚠️ Failed to save event count for pubkey %s...: %v", pubkey[:16], err)
+	}
+	if member.quotaExhausted() {
+		pas.with(pubkeyAttr(pubkey)).logInfo("🧮 Event quota of %d used up by pubkey %s...", member.EventQuota, pubkey[:16])
+	}
+	return nil
+}
+
+// quotaExhausted reports whether a membership has published every event its quota allows
+func (m *PaidAccessMember) quotaExhausted() bool {
+	return m.EventQuota > 0 && m.EventsUsed >= m.EventQuota
+}
+
+// GetMember returns a copy of the member record for a pubkey, expired or not
+func (pas *PaidAccessStorage) GetMember(pubkey string) (*PaidAccessMember, bool) {
 	pas.mutex.RLock()
 	defer pas.mutex.RUnlock()
 
-	stats := map[string]interface{}{
-		"total_members":   len(pas.Members),
-		"active_members":  0,
-		"expired_members": 0,
+	member, exists := pas.Members[pubkey]
+	if !exists {
+		return nil, false
+	}
+
+	copied := *member
+	return &copied, true
+}
+
+// ExtendAccess adds duration to a member's expiry, counted from now if the membership has
+// expired, and returns the extended record. Permanent memberships can't be extended.
+func (pas *PaidAccessStorage) ExtendAccess(pubkey string, duration time.Duration) (*PaidAccessMember, error) {
+	pas.mutex.Lock()
+	defer pas.mutex.Unlock()
+
+	member, exists := pas.Members[pubkey]
+	if !exists {
+		return nil, fmt.Errorf("%w for pubkey: %s", errNoMembership, pubkey)
+	}
+	if member.ExpiresAt.IsZero() {
+		return nil, fmt.Errorf("membership of %s is permanent", pubkey)
 	}
 
 	now := time.Now()
-	for _, member := range pas.Members {
-		if member.ExpiresAt.IsZero() || now.Before(member.ExpiresAt) {
-			stats["active_members"] = stats["active_members"].(int) + 1
-		} else {
-			stats["expired_members"] = stats["expired_members"].(int) + 1
+	if member.ExpiresAt.Before(now) {
+		member.ExpiresAt = now
+	}
+	member.ExpiresAt = member.ExpiresAt.Add(duration)
+
+	if err := pas.Save(pubkey); err != nil {
+		return nil, fmt.Errorf("failed to save paid access: %w", err)
+	}
+	copied := *member
+	return &copied, nil
+}
+
+// RemovePaidAccess deletes a member and returns the removed record
+func (pas *PaidAccessStorage) RemovePaidAccess(pubkey string) (*PaidAccessMember, error) {
+	pas.mutex.Lock()
+	defer pas.mutex.Unlock()
+
+	member, exists := pas.Members[pubkey]
+	if !exists {
+		return nil, nil
+	}
+
+	delete(pas.Members, pubkey)
+	delete(pas.Streams, pubkey)
+	if err := pas.Save(pubkey); err != nil {
+		return nil, fmt.Errorf("failed to save paid access: %w", err)
+	}
+	return member, nil
+}
+
+// TransferMembership moves the remaining time of from's membership to to. An active
+// membership of to is extended by that time, otherwise to takes over from's record.
+// It returns the resulting membership of to.
+func (pas *PaidAccessStorage) TransferMembership(from, to string) (*PaidAccessMember, error) {
+	pas.mutex.Lock()
+	defer pas.mutex.Unlock()
+
+	now := time.Now()
+	source, exists := pas.Members[from]
+	if !exists || (!source.ExpiresAt.IsZero() && now.After(source.ExpiresAt)) {
+		return nil, fmt.Errorf("no active membership for pubkey: %s", from)
+	}
+	if source.Hold != nil {
+		return nil, fmt.Errorf("membership of pubkey %s is on hold", from)
+	}
+
+	transferred := *source
+	transferred.Pubkey = to
+	if target, exists := pas.Members[to]; exists && (target.ExpiresAt.IsZero() || now.Before(target.ExpiresAt)) {
+		transferred = *target
+		switch {
+		case transferred.ExpiresAt.IsZero():
+			// Already permanent
+		case source.ExpiresAt.IsZero():
+			transferred.ExpiresAt = time.Time{}
+		default:
+			transferred.ExpiresAt = transferred.ExpiresAt.Add(source.ExpiresAt.Sub(now))
 		}
 	}
 
-	return stats
+	pas.Members[to] = &transferred
+	delete(pas.Members, from)
+	if drips, exists := pas.Streams[from]; exists {
+		merged := append(pas.Streams[to], drips...)
+		sort.Slice(merged, func(i, j int) bool { return merged[i].ReceivedAt.Before(merged[j].ReceivedAt) })
+		pas.Streams[to] = merged
+		delete(pas.Streams, from)
+	}
+
+	if err := pas.Save(from, to); err != nil {
+		return nil, fmt.Errorf("failed to save paid access: %w", err)
+	}
+
+	copied := transferred
+	return &copied, nil
 }
 
-// ChargeMappingStorage manages persistent storage of payment hash to charge ID mappings
-type ChargeMappingStorage struct {
-	Mappings map[string]string `json:"mappings"`
-	mutex    sync.RWMutex
-	filePath string
+// PlaceHold suspends a membership until releaseAt, or until released by hand if releaseAt is zero.
+// It returns the held membership.
+func (pas *PaidAccessStorage) PlaceHold(pubkey, reason string, releaseAt time.Time) (*PaidAccessMember, error) {
+	pas.mutex.Lock()
+	defer pas.mutex.Unlock()
+
+	member, exists := pas.Members[pubkey]
+	if !exists {
+		return nil, fmt.Errorf("no membership found for pubkey: %s", pubkey)
+	}
+	if member.Hold != nil {
+		return nil, fmt.Errorf("membership of pubkey %s is already on hold", pubkey)
+	}
+
+	member.Hold = &MembershipHold{
+		Reason:    reason,
+		PlacedAt:  time.Now(),
+		ReleaseAt: releaseAt,
+	}
+	if err := pas.Save(pubkey); err != nil {
+		return nil, fmt.Errorf("failed to save paid access: %w", err)
+	}
+
+	copied := *member
+	return &copied, nil
 }
 
-// NewChargeMappingStorage creates a new charge mapping storage
-func NewChargeMappingStorage(filePath string) *ChargeMappingStorage {
-	storage := &ChargeMappingStorage{
-		Mappings: make(map[string]string),
-		filePath: filePath,
+// ReleaseHold lifts the hold on a membership and extends it by the time it spent on hold,
+// so the member doesn't lose paid time to the dispute. It returns the released membership.
+func (pas *PaidAccessStorage) ReleaseHold(pubkey string) (*PaidAccessMember, error) {
+	pas.mutex.Lock()
+	defer pas.mutex.Unlock()
+
+	member, exists := pas.Members[pubkey]
+	if !exists || member.Hold == nil {
+		return nil, fmt.Errorf("no membership on hold for pubkey: %s", pubkey)
 	}
-	
-	// Ensure directory exists
-	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
-		log.Printf("⚠️ Failed to create directory for charge mapping file: %v", err)
+
+	pas.releaseHold(member, time.Now())
+	if err := pas.Save(pubkey); err != nil {
+		return nil, fmt.Errorf("failed to save paid access: %w", err)
 	}
-	
-	storage.load()
-	return storage
+
+	copied := *member
+	return &copied, nil
 }
 
-// load reads charge mappings from file
-func (cms *ChargeMappingStorage) load() error {
-	cms.mutex.Lock()
-	defer cms.mutex.Unlock()
+// ReleaseDueHolds lifts holds whose release date has passed and returns the released members
+func (pas *PaidAccessStorage) ReleaseDueHolds() ([]PaidAccessMember, error) {
+	pas.mutex.Lock()
+	defer pas.mutex.Unlock()
 
-	if _, err := os.Stat(cms.filePath); os.IsNotExist(err) {
-		return nil // File doesn't exist, start with empty mappings
+	now := time.Now()
+	var released []PaidAccessMember
+	var pubkeys []string
+	for pubkey, member := range pas.Members {
+		if member.Hold == nil || member.Hold.active(now) {
+			continue
+		}
+		pas.releaseHold(member, member.Hold.ReleaseAt)
+		released = append(released, *member)
+		pubkeys = append(pubkeys, pubkey)
 	}
 
-	data, err := ioutil.ReadFile(cms.filePath)
-	if err != nil {
-		log.Printf("⚠️ Failed to read charge mappings file: %v", err)
-		return err
+	if len(released) > 0 {
+		pas.logInfo("⏸️ Released %d memberships from hold", len(released))
+		return released, pas.Save(pubkeys...)
 	}
+	return nil, nil
+}
 
-	if len(data) == 0 {
-		return nil
+// ImportMembers adds memberships carried over from elsewhere in one save. Existing members
+// are only replaced by memberships lasting longer, keeping their hold. It returns how many
+// were added and how many replaced existing members.
+func (pas *PaidAccessStorage) ImportMembers(members []PaidAccessMember) (added, updated int, err error) {
+	pas.mutex.Lock()
+	defer pas.mutex.Unlock()
+
+	var pubkeys []string
+	for i := range members {
+		member := members[i]
+		existing, exists := pas.Members[member.Pubkey]
+		if exists {
+			if !outlasts(&member, existing) {
+				continue
+			}
+			member.Hold = existing.Hold
+			updated++
+		} else {
+			added++
+		}
+		pas.Members[member.Pubkey] = &member
+		pubkeys = append(pubkeys, member.Pubkey)
 	}
 
-	return json.Unmarshal(data, cms)
+	if added+updated == 0 {
+		return 0, 0, nil
+	}
+	if err := pas.Save(pubkeys...); err != nil {
+		return 0, 0, fmt.Errorf("failed to save paid access: %w", err)
+	}
+	return added, updated, nil
 }
 
-// save writes charge mappings to file
-func (cms *ChargeMappingStorage) save() error {
-	data, err := json.MarshalIndent(cms, "", "  ")
-	if err != nil {
-		return err
+// ListMembers returns copies of all member records, expired or not, optionally only those from source
+func (pas *PaidAccessStorage) ListMembers(source string) []PaidAccessMember {
+	pas.mutex.RLock()
+	defer pas.mutex.RUnlock()
+
+	members := make([]PaidAccessMember, 0, len(pas.Members))
+	for _, member := range pas.Members {
+		if source == "" || member.Source == source {
+			members = append(members, *member)
+		}
 	}
+	sort.Slice(members, func(i, j int) bool { return members[i].CreatedAt.Before(members[j].CreatedAt) })
+	return members
+}
 
-	return ioutil.WriteFile(cms.filePath, data, 0644)
+// Holds returns copies of all memberships currently on hold
+func (pas *PaidAccessStorage) Holds() []PaidAccessMember {
+	pas.mutex.RLock()
+	defer pas.mutex.RUnlock()
+
+	held := make([]PaidAccessMember, 0)
+	for _, member := range pas.Members {
+		if member.Hold != nil {
+			held = append(held, *member)
+		}
+	}
+	sort.Slice(held, func(i, j int) bool { return held[i].Hold.PlacedAt.Before(held[j].Hold.PlacedAt) })
+	return held
 }
 
-// Store saves a payment hash to charge ID mapping
-func (cms *ChargeMappingStorage) Store(paymentHash, chargeID string) error {
-	cms.mutex.Lock()
-	defer cms.mutex.Unlock()
+// releaseHold clears a member's hold as of releasedAt, caller holds the mutex
+func (pas *PaidAccessStorage) releaseHold(member *PaidAccessMember, releasedAt time.Time) {
+	if !member.ExpiresAt.IsZero() && releasedAt.After(member.Hold.PlacedAt) {
+		member.ExpiresAt = member.ExpiresAt.Add(releasedAt.Sub(member.Hold.PlacedAt))
+	}
+	member.Hold = nil
+}
 
-	cms.Mappings[paymentHash] = chargeID
-	
-	if err := cms.save(); err != nil {
-		log.Printf("⚠️ Failed to save charge mapping: %v", err)
-		return err
+// CleanupExpired removes expired access entries
+func (pas *PaidAccessStorage) CleanupExpired() error {
+	_, err := pas.RemoveExpired()
+	return err
+}
+
+// RemoveExpired removes expired access entries and returns the removed members
+func (pas *PaidAccessStorage) RemoveExpired() ([]PaidAccessMember, error) {
+	pas.mutex.Lock()
+	defer pas.mutex.Unlock()
+
+	now := time.Now()
+	var removed []PaidAccessMember
+	var pubkeys []string
+
+	for pubkey, member := range pas.Members {
+		// Held memberships stay until the dispute is resolved
+		if member.Hold != nil {
+			continue
+		}
+		if !member.ExpiresAt.IsZero() && now.After(member.ExpiresAt) {
+			delete(pas.Members, pubkey)
+			removed = append(removed, *member)
+			pubkeys = append(pubkeys, pubkey)
+		}
 	}
+	pubkeys = append(pubkeys, pas.pruneStreams(now)...)
 
-	log.Printf("💾 Stored charge mapping: %s... → %s", paymentHash[:16], chargeID)
-	return nil
+	if len(pubkeys) > 0 {
+		pas.logInfo("🧹 Cleaned up %d expired access entries", len(removed))
+		return removed, pas.Save(pubkeys...)
+	}
+
+	return nil, nil
+}
+
+// SetStatsCacheTTL sets how long GetStats results are reused, zero disables caching
+func (pas *PaidAccessStorage) SetStatsCacheTTL(ttl time.Duration) {
+	pas.statsMutex.Lock()
+	defer pas.statsMutex.Unlock()
+
+	pas.statsTTL = ttl
+	pas.statsCache = nil
+}
+
+// invalidateStats drops cached stats after members changed
+func (pas *PaidAccessStorage) invalidateStats() {
+	pas.statsMutex.Lock()
+	pas.statsCache = nil
+	pas.statsMutex.Unlock()
 }
 
-// Get retrieves a charge ID by payment hash
-func (cms *ChargeMappingStorage) Get(paymentHash string) (string, bool) {
-	cms.mutex.RLock()
-	defer cms.mutex.RUnlock()
+// GetStats returns statistics about paid access, cached for a short TTL
+func (pas *PaidAccessStorage) GetStats() map[string]interface{} {
+	pas.statsMutex.Lock()
+	if pas.statsCache != nil && time.Since(pas.statsCachedAt) < pas.statsTTL {
+		stats := copyStats(pas.statsCache)
+		pas.statsMutex.Unlock()
+		return stats
+	}
+	pas.statsMutex.Unlock()
+
+	stats := pas.computeStats()
+
+	pas.statsMutex.Lock()
+	if pas.statsTTL > 0 {
+		pas.statsCache = stats
+		pas.statsCachedAt = time.Now()
+	}
+	pas.statsMutex.Unlock()
 
-	chargeID, exists := cms.Mappings[paymentHash]
-	return chargeID, exists
+	return copyStats(stats)
 }
 
-// Cleanup removes old mappings (older than 24 hours)
-func (cms *ChargeMappingStorage) Cleanup() {
-	cms.mutex.Lock()
-	defer cms.mutex.Unlock()
+// copyStats returns a shallow copy so callers can't modify the cached map
+func copyStats(stats map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(stats))
+	for key, value := range stats {
+		copied[key] = value
+	}
+	return copied
+}
+
+// computeStats walks all members to build access statistics
+func (pas *PaidAccessStorage) computeStats() map[string]interface{} {
+	pas.mutex.RLock()
+	defer pas.mutex.RUnlock()
+
+	stats := map[string]interface{}{
+		"total_members":   len(pas.Members),
+		"active_members":  0,
+		"expired_members": 0,
+		"held_members":    0,
+	}
+
+	byTier := make(map[string]int)
+	bySource := make(map[string]int)
+	histogram := make([]RemainingTimeBucket, len(remainingTimeBuckets)+1)
+	for i, bucket := range remainingTimeBuckets {
+		histogram[i].Label = bucket.label
+	}
+	forever := &histogram[len(remainingTimeBuckets)]
+	forever.Label = "forever"
 
-	// In a production system, you'd want to track creation timestamps
-	// For now, we'll keep all mappings as they're needed for verification
-	log.Printf("💾 Charge mapping cleanup completed (%d mappings)", len(cms.Mappings))
+	now := time.Now()
+	for _, member := range pas.Members {
+		if member.Hold != nil {
+			stats["held_members"] = stats["held_members"].(int) + 1
+			continue
+		}
+		if member.ExpiresAt.IsZero() || now.Before(member.ExpiresAt) {
+			stats["active_members"] = stats["active_members"].(int) + 1
+		} else {
+			stats["expired_members"] = stats["expired_members"].(int) + 1
+			continue
+		}
+
+		tier := member.Tier
+		if tier == "" {
+			tier = "unknown"
+		}
+		byTier[tier]++
+
+		source := member.Source
+		if source == "" {
+			source = "unknown"
+		}
+		bySource[source]++
+
+		bucket := forever
+		if !member.ExpiresAt.IsZero() {
+			remaining := member.ExpiresAt.Sub(now)
+			for i, candidate := range remainingTimeBuckets {
+				if candidate.max == 0 || remaining < candidate.max {
+					bucket = &histogram[i]
+					break
+				}
+			}
+		}
+		bucket.Members++
+		bucket.AmountMsat += member.Amount
+	}
+
+	stats["members_by_tier"] = byTier
+	stats["members_by_source"] = bySource
+	stats["remaining_time_histogram"] = histogram
+
+	return stats
 }
@@ -0,0 +1,475 @@
+package payments
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StoreData is the membership state a Store persists
+type StoreData struct {
+	Members map[string]*PaidAccessMember `json:"members"`
+	Streams map[string][]StreamDrip      `json:"streams,omitempty"` // recent streaming payments per pubkey, oldest first
+	Trials  map[string]time.Time         `json:"trials,omitempty"`  // when each pubkey started its free trial
+}
+
+// Store persists memberships. PaidAccessStorage serves members from memory and writes the
+// whole state through its Store after changes.
+type Store interface {
+	// Load returns the stored state, empty when nothing was stored yet
+	Load() (*StoreData, error)
+	// Save replaces the stored state
+	Save(data *StoreData) error
+}
+
+// changeSaver is implemented by stores able to write the state of single pubkeys, so a
+// change doesn't cost a pass over every member
+type changeSaver interface {
+	// saveChanges writes the state of pubkeys held in data, pubkeys missing from it were
+	// deleted. It returns errSnapshotNeeded when Save must be called with the whole state.
+	saveChanges(data *StoreData, pubkeys []string) error
+}
+
+// newStoreData returns empty membership state
+func newStoreData() *StoreData {
+	return &StoreData{
+		Members: make(map[string]*PaidAccessMember),
+		Streams: make(map[string][]StreamDrip),
+		Trials:  make(map[string]time.Time),
+	}
+}
+
+// JSONFileStore keeps memberships in a JSON file, the default store. Changes are appended
+// to a journal next to it, so a grant writes one line and only looks at the pubkeys that
+// changed; the file is rewritten as a snapshot once the journal outgrows it.
+type JSONFileStore struct {
+	path        string
+	journalPath string
+
+	mutex      sync.Mutex
+	generation int      // snapshot generation the journal applies to
+	journaled  int      // entries in the journal
+	written    *written // state on disk, nil until loaded or snapshotted
+
+	logTarget
+}
+
+// NewJSONFileStore creates a store for the JSON file at path, creating its directory. The
+// journal is kept at path + ".journal".
+func NewJSONFileStore(path string) *JSONFileStore {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		logWarn("⚠️ Failed to create directory for paid access file: %v", err)
+	}
+	return &JSONFileStore{path: path, journalPath: path + ".journal"}
+}
+
+// Load reads the snapshot and replays the journal, a missing or empty file holds no members
+func (fs *JSONFileStore) Load() (*StoreData, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	snapshot := journalSnapshot{StoreData: newStoreData()}
+	raw, err := os.ReadFile(fs.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read paid access file: %w", err)
+	}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &snapshot); err != nil {
+			return nil, fmt.Errorf("failed to parse paid access file: %w", err)
+		}
+	}
+	data := snapshot.StoreData
+	if data.Members == nil {
+		data.Members = make(map[string]*PaidAccessMember)
+	}
+	if data.Streams == nil {
+		data.Streams = make(map[string][]StreamDrip)
+	}
+	if data.Trials == nil {
+		data.Trials = make(map[string]time.Time)
+	}
+
+	applied, torn, err := replayJournal(&fs.logTarget, fs.journalPath, snapshot.Generation, data)
+	if err != nil {
+		return nil, err
+	}
+	fs.generation = snapshot.Generation
+	fs.journaled = applied
+	fs.written = &written{}
+	if err := fs.written.remember(data); err != nil {
+		return nil, err
+	}
+	if torn {
+		// Appending after a torn line would corrupt the next entry, snapshot first
+		fs.written = nil
+	}
+	return data, nil
+}
+
+// Save appends what changed since the last save to the journal, or writes a snapshot
+func (fs *JSONFileStore) Save(data *StoreData) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	if fs.written == nil {
+		return fs.snapshot(data)
+	}
+	entries, err := fs.written.changes(data, fs.generation)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	if fs.journaled+len(entries) > max(minJournalCompaction, len(data.Members)) {
+		return fs.snapshot(data)
+	}
+	return fs.journal(entries)
+}
+
+// saveChanges appends the changes of pubkeys to the journal, asking for the whole state
+// when a snapshot is due
+func (fs *JSONFileStore) saveChanges(data *StoreData, pubkeys []string) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	if fs.written == nil {
+		return errSnapshotNeeded
+	}
+	entries, err := fs.written.changesOf(data, pubkeys, fs.generation)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	if fs.journaled+len(entries) > max(minJournalCompaction, len(fs.written.members)) {
+		return errSnapshotNeeded
+	}
+	return fs.journal(entries)
+}
+
+// journal appends entries to the journal and records them as written, callers hold the lock
+func (fs *JSONFileStore) journal(entries []journalEntry) error {
+	if err := appendJournal(fs.journalPath, entries); err != nil {
+		fs.logError("❌ Failed to write paid access journal: %v", err)
+		fs.written = nil // the journal may hold part of the entries, snapshot next time
+		return err
+	}
+	for _, entry := range entries {
+		fs.written.apply(entry)
+	}
+	fs.journaled += len(entries)
+	return nil
+}
+
+// snapshot rewrites the file with data under a new generation and empties the journal.
+// Entries of older generations left by a crash in between are skipped on load.
+func (fs *JSONFileStore) snapshot(data *StoreData) error {
+	generation := fs.generation + 1
+	raw, err := json.MarshalIndent(journalSnapshot{StoreData: data, Generation: generation}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal paid access data: %w", err)
+	}
+
+	fs.logDebug("💾 Saving paid access data to: %s", fs.path)
+	if err := writeFileAtomic(fs.path, raw, 0644); err != nil {
+		fs.logError("❌ Failed to write paid access file: %v", err)
+		return err
+	}
+	fs.generation = generation
+	if err := os.Remove(fs.journalPath); err != nil && !os.IsNotExist(err) {
+		fs.logWarn("⚠️ Failed to remove compacted paid access journal: %v", err)
+	}
+	fs.journaled = 0
+	fs.written = &written{}
+	if err := fs.written.remember(data); err != nil {
+		fs.written = nil
+		return err
+	}
+	fs.logDebug("✅ Successfully saved paid access data")
+	return nil
+}
+
+// SQL dialects supported by SQLStore
+const (
+	DialectSQLite   = "sqlite"
+	DialectPostgres = "postgres"
+)
+
+// SQLStore keeps memberships in SQLite or Postgres through database/sql. Open the database
+// with the driver of your choice, e.g. modernc.org/sqlite or github.com/lib/pq.
+type SQLStore struct {
+	db      *sql.DB
+	dialect string
+}
+
+// NewSQLStore creates the membership tables if needed and returns a store using them
+func NewSQLStore(db *sql.DB, dialect string) (*SQLStore, error) {
+	if dialect != DialectSQLite && dialect != DialectPostgres {
+		return nil, fmt.Errorf("unsupported SQL dialect: %s (supported: %s, %s)", dialect, DialectSQLite, DialectPostgres)
+	}
+
+	// Members are stored as JSON next to the columns worth querying, so new member
+	// fields don't need schema changes
+	schema := []string{
+		`CREATE TABLE IF NOT EXISTS paid_access_members (
+			pubkey TEXT PRIMARY KEY,
+			tier TEXT NOT NULL,
+			source TEXT NOT NULL,
+			expires_at BIGINT NOT NULL,
+			data TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS paid_access_streams (
+			pubkey TEXT PRIMARY KEY,
+			data TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS paid_access_trials (
+			pubkey TEXT PRIMARY KEY,
+			started_at BIGINT NOT NULL
+		)`,
+	}
+	for _, statement := range schema {
+		if _, err := db.Exec(statement); err != nil {
+			return nil, fmt.Errorf("failed to create membership tables: %w", err)
+		}
+	}
+	return &SQLStore{db: db, dialect: dialect}, nil
+}
+
+// Load reads all members and streams
+func (ss *SQLStore) Load() (*StoreData, error) {
+	data := newStoreData()
+
+	rows, err := ss.db.Query("SELECT data FROM paid_access_members")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query members: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var raw string
+		if err := rows.Scan(&raw); err != nil {
+			return nil, fmt.Errorf("failed to read member: %w", err)
+		}
+		var member PaidAccessMember
+		if err := json.Unmarshal([]byte(raw), &member); err != nil {
+			return nil, fmt.Errorf("failed to parse member: %w", err)
+		}
+		data.Members[member.Pubkey] = &member
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read members: %w", err)
+	}
+
+	streamRows, err := ss.db.Query("SELECT pubkey, data FROM paid_access_streams")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query streams: %w", err)
+	}
+	defer streamRows.Close()
+	for streamRows.Next() {
+		var pubkey, raw string
+		if err := streamRows.Scan(&pubkey, &raw); err != nil {
+			return nil, fmt.Errorf("failed to read stream: %w", err)
+		}
+		var drips []StreamDrip
+		if err := json.Unmarshal([]byte(raw), &drips); err != nil {
+			return nil, fmt.Errorf("failed to parse stream: %w", err)
+		}
+		data.Streams[pubkey] = drips
+	}
+	if err := streamRows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read streams: %w", err)
+	}
+
+	trialRows, err := ss.db.Query("SELECT pubkey, started_at FROM paid_access_trials")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query trials: %w", err)
+	}
+	defer trialRows.Close()
+	for trialRows.Next() {
+		var pubkey string
+		var startedAt int64
+		if err := trialRows.Scan(&pubkey, &startedAt); err != nil {
+			return nil, fmt.Errorf("failed to read trial: %w", err)
+		}
+		data.Trials[pubkey] = time.Unix(startedAt, 0)
+	}
+	if err := trialRows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read trials: %w", err)
+	}
+	return data, nil
+}
+
+// Save replaces all members and streams in one transaction
+func (ss *SQLStore) Save(data *StoreData) error {
+	tx, err := ss.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // no-op after commit
+
+	for _, table := range []string{"paid_access_members", "paid_access_streams", "paid_access_trials"} {
+		if _, err := tx.Exec("DELETE FROM " + table); err != nil {
+			return fmt.Errorf("failed to clear %s: %w", table, err)
+		}
+	}
+
+	insertMember, err := tx.Prepare(ss.bind("INSERT INTO paid_access_members (pubkey, tier, source, expires_at, data) VALUES (?, ?, ?, ?, ?)"))
+	if err != nil {
+		return fmt.Errorf("failed to prepare member insert: %w", err)
+	}
+	defer insertMember.Close()
+	for pubkey, member := range data.Members {
+		raw, err := json.Marshal(member)
+		if err != nil {
+			return fmt.Errorf("failed to marshal member: %w", err)
+		}
+		if _, err := insertMember.Exec(pubkey, member.Tier, member.Source, unixOrZero(member.ExpiresAt), string(raw)); err != nil {
+			return fmt.Errorf("failed to insert member %s: %w", pubkey, err)
+		}
+	}
+
+	insertStream, err := tx.Prepare(ss.bind("INSERT INTO paid_access_streams (pubkey, data) VALUES (?, ?)"))
+	if err != nil {
+		return fmt.Errorf("failed to prepare stream insert: %w", err)
+	}
+	defer insertStream.Close()
+	for pubkey, drips := range data.Streams {
+		raw, err := json.Marshal(drips)
+		if err != nil {
+			return fmt.Errorf("failed to marshal stream: %w", err)
+		}
+		if _, err := insertStream.Exec(pubkey, string(raw)); err != nil {
+			return fmt.Errorf("failed to insert stream %s: %w", pubkey, err)
+		}
+	}
+
+	insertTrial, err := tx.Prepare(ss.bind("INSERT INTO paid_access_trials (pubkey, started_at) VALUES (?, ?)"))
+	if err != nil {
+		return fmt.Errorf("failed to prepare trial insert: %w", err)
+	}
+	defer insertTrial.Close()
+	for pubkey, startedAt := range data.Trials {
+		if _, err := insertTrial.Exec(pubkey, startedAt.Unix()); err != nil {
+			return fmt.Errorf("failed to insert trial %s: %w", pubkey, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit memberships: %w", err)
+	}
+	return nil
+}
+
+// bind rewrites ? placeholders into the dialect's style
+func (ss *SQLStore) bind(query string) string {
+	if ss.dialect != DialectPostgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// unixOrZero returns unix seconds, zero for the zero time used by permanent access
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// MigrationReport summarizes a Migrate run
+type MigrationReport struct {
+	Members int `json:"members"` // memberships read from the source
+	Active  int `json:"active"`  // of those, memberships still granting access
+	Copied  int `json:"copied"`  // memberships written to the target
+	Kept    int `json:"kept"`    // target memberships left alone because they last at least as long
+	Streams int `json:"streams"` // streaming payment histories copied
+	Trials  int `json:"trials"`  // free trials copied
+}
+
+// Migrate copies memberships from one store to another, e.g. from the JSON file to SQL.
+// Members already in the target are only replaced when the source's membership lasts
+// longer, so running it twice or into a store in use loses nothing. The target is read
+// back afterwards to check every active membership arrived. Stop the relay first, or
+// changes made during the migration are missed.
+func Migrate(from, to Store) (*MigrationReport, error) {
+	source, err := from.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load source: %w", err)
+	}
+	target, err := to.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load target: %w", err)
+	}
+
+	now := time.Now()
+	report := &MigrationReport{Members: len(source.Members)}
+	for pubkey, member := range source.Members {
+		if member.ExpiresAt.IsZero() || member.ExpiresAt.After(now) {
+			report.Active++
+		}
+		if existing, ok := target.Members[pubkey]; ok && !outlasts(member, existing) {
+			report.Kept++
+			continue
+		}
+		target.Members[pubkey] = member
+		report.Copied++
+	}
+	for pubkey, drips := range source.Streams {
+		if _, ok := target.Streams[pubkey]; !ok {
+			target.Streams[pubkey] = drips
+			report.Streams++
+		}
+	}
+	for pubkey, startedAt := range source.Trials {
+		if _, ok := target.Trials[pubkey]; !ok {
+			target.Trials[pubkey] = startedAt
+			report.Trials++
+		}
+	}
+
+	if err := to.Save(target); err != nil {
+		return nil, fmt.Errorf("failed to save target: %w", err)
+	}
+
+	// Read back rather than trusting the write
+	saved, err := to.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify target: %w", err)
+	}
+	for pubkey, member := range source.Members {
+		if !member.ExpiresAt.IsZero() && !member.ExpiresAt.After(now) {
+			continue
+		}
+		if migrated, ok := saved.Members[pubkey]; !ok || outlasts(member, migrated) {
+			return nil, fmt.Errorf("verification failed: active membership of %s is missing from the target", pubkey)
+		}
+	}
+
+	logInfo("📦 Migrated %d memberships (%d active), %d already up to date in the target", report.Copied, report.Active, report.Kept)
+	return report, nil
+}
+
+// outlasts reports whether a grants access longer than b, zero expiry being permanent
+func outlasts(a, b *PaidAccessMember) bool {
+	if b.ExpiresAt.IsZero() {
+		return false
+	}
+	return a.ExpiresAt.IsZero() || a.ExpiresAt.After(b.ExpiresAt)
+}
@@ -0,0 +1,108 @@
+package payments
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// StatsDelta describes a change to payment stats pushed to stream subscribers
+type StatsDelta struct {
+	Type       string    `json:"type"` // "payment" or "invoice"
+	Pubkey     string    `json:"pubkey"`
+	AmountMsat int64     `json:"amount_msat"`
+	Provider   string    `json:"provider"`
+	Tier       string    `json:"tier,omitempty"`
+	At         time.Time `json:"at"`
+}
+
+// statsHub fans out stats deltas to connected stream clients
+type statsHub struct {
+	subscribers map[chan StatsDelta]struct{}
+	mutex       sync.Mutex
+}
+
+// newStatsHub creates a hub without subscribers
+func newStatsHub() *statsHub {
+	return &statsHub{
+		subscribers: make(map[chan StatsDelta]struct{}),
+	}
+}
+
+// Subscribe registers a new subscriber channel
+func (h *statsHub) Subscribe() chan StatsDelta {
+	ch := make(chan StatsDelta, 16)
+
+	h.mutex.Lock()
+	h.subscribers[ch] = struct{}{}
+	h.mutex.Unlock()
+
+	return ch
+}
+
+// Unsubscribe removes a subscriber channel
+func (h *statsHub) Unsubscribe(ch chan StatsDelta) {
+	h.mutex.Lock()
+	delete(h.subscribers, ch)
+	h.mutex.Unlock()
+}
+
+// Publish sends a delta to every subscriber, dropping it for clients that fall behind
+func (h *statsHub) Publish(delta StatsDelta) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for ch := range h.subscribers {
+		select {
+		case ch <- delta:
+		default:
+		}
+	}
+}
+
+// statsStreamHandler streams stats deltas as server-sent events
+func (s *System) statsStreamHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	ch := s.statsHub.Subscribe()
+	defer s.statsHub.Unsubscribe(ch)
+
+	// Start with a full snapshot so dashboards can apply deltas on top of it
+	writeSSE(w, "snapshot", s.GetStats())
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(30 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case delta := <-ch:
+			writeSSE(w, delta.Type, delta)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// writeSSE writes a single named server-sent event with a JSON payload
+func writeSSE(w http.ResponseWriter, event string, payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+}
@@ -0,0 +1,142 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// streamPollInterval is how often streaming providers are asked for new keysends
+const streamPollInterval = time.Minute
+
+// Keysend is a spontaneous payment received from a pubkey, e.g. a podcast-style streaming drip
+type Keysend struct {
+	PaymentHash string    `json:"payment_hash"`
+	Pubkey      string    `json:"pubkey"` // nostr pubkey of the sender, carried in a custom TLV record
+	Amount      int64     `json:"amount"` // in millisatoshis
+	ReceivedAt  time.Time `json:"received_at"`
+}
+
+// StreamingProvider is implemented by providers that can list received keysend payments
+type StreamingProvider interface {
+	// ListKeysends returns keysends received after since, oldest first
+	ListKeysends(ctx context.Context, since time.Time) ([]Keysend, error)
+}
+
+// StreamingEnabled reports whether streaming payments keep memberships alive
+func (s *System) StreamingEnabled() bool {
+	return s.config.StreamSatsPerDay > 0
+}
+
+// RecordKeysend credits a streaming payment to its sender, keeping their access active for
+// as long as the received rate meets StreamSatsPerDay
+func (s *System) RecordKeysend(ctx context.Context, keysend Keysend) error {
+	if !s.StreamingEnabled() {
+		return fmt.Errorf("streaming payments are not enabled")
+	}
+	pubkey, err := parsePubkey(keysend.Pubkey)
+	if err != nil {
+		return err
+	}
+	if keysend.PaymentHash == "" || keysend.Amount <= 0 {
+		return fmt.Errorf("payment hash and a positive amount are required")
+	}
+	if keysend.ReceivedAt.IsZero() {
+		keysend.ReceivedAt = time.Now()
+	}
+
+	hadAccess := s.HasAccess(pubkey)
+	member, active, err := s.paidAccessStorage.RecordDrip(pubkey, StreamDrip{
+		PaymentHash: keysend.PaymentHash,
+		Amount:      keysend.Amount,
+		ReceivedAt:  keysend.ReceivedAt,
+	}, s.streamMinPerWindow)
+	if err != nil {
+		return err
+	}
+
+	err = s.ledger.Record(LedgerEntry{
+		Pubkey:      pubkey,
+		PaymentHash: keysend.PaymentHash,
+		Amount:      keysend.Amount,
+		Provider:    s.provider.GetProviderName(),
+		Tier:        StreamTier,
+		PaidAt:      keysend.ReceivedAt,
+	})
+	if err != nil {
+		s.logWarn("⚠️ Failed to record keysend in ledger: %v", err)
+	}
+
+	s.statsHub.Publish(StatsDelta{
+		Type:       "payment",
+		Pubkey:     pubkey,
+		AmountMsat: keysend.Amount,
+		Provider:   s.provider.GetProviderName(),
+		Tier:       StreamTier,
+		At:         time.Now(),
+	})
+
+	if active && !hadAccess {
+		s.with(pubkeyAttr(pubkey)).logInfo("🌊 Streaming payments granted access for pubkey %s...", pubkey[:16])
+		s.fireAccessGranted(context.WithoutCancel(ctx), AccessEvent{Pubkey: pubkey, Member: *member, Reason: "stream"})
+	}
+	return nil
+}
+
+// runStreamingPoller feeds keysends from a streaming provider into the accumulator
+func (s *System) runStreamingPoller(ctx context.Context, provider StreamingProvider) {
+	since := time.Now().Add(-s.streamWindow)
+
+	ticker := time.NewTicker(streamPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		listCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		keysends, err := provider.ListKeysends(listCtx, since)
+		cancel()
+		if err != nil {
+			s.logError("❌ Failed to list keysends: %v", err)
+			continue
+		}
+
+		for _, keysend := range keysends {
+			if keysend.ReceivedAt.After(since) {
+				since = keysend.ReceivedAt
+			}
+			if keysend.Pubkey == "" {
+				continue // not attributable to a nostr pubkey
+			}
+			if err := s.RecordKeysend(ctx, keysend); err != nil {
+				s.with(paymentHashAttr(keysend.PaymentHash)).logWarn("⚠️ Ignoring keysend %.16s...: %v", keysend.PaymentHash, err)
+			}
+		}
+	}
+}
+
+// keysendWebhookHandler accepts keysends pushed by a node sidecar
+func (s *System) keysendWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var keysend Keysend
+	if err := json.NewDecoder(r.Body).Decode(&keysend); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.RecordKeysend(r.Context(), keysend); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	pubkey, _ := parsePubkey(keysend.Pubkey)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"pubkey":     pubkey,
+		"has_access": s.HasAccess(pubkey),
+	})
+}
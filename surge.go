@@ -0,0 +1,127 @@
+package payments
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// loadSampleInterval is how often the relay load is measured
+const loadSampleInterval = 10 * time.Second
+
+// loadSmoothing is the weight of the newest sample in the relay load, so a burst raises
+// prices within a few samples and they fall back over about a minute once it is quiet
+const loadSmoothing = 0.3
+
+// RelayLoad is how busy the relay is, smoothed over the last minute or so
+type RelayLoad struct {
+	EventsPerSecond float64 `json:"events_per_second"` // events submitted, accepted or not
+	BytesPerSecond  float64 `json:"bytes_per_second"`  // event content accepted, i.e. storage growth
+}
+
+// loadMeter counts events between samples and keeps the smoothed load
+type loadMeter struct {
+	events  int64
+	bytes   int64
+	mutex   sync.RWMutex
+	load    RelayLoad
+	sampled time.Time
+}
+
+// record counts a submitted event, with its content size if it was accepted
+func (m *loadMeter) record(size int64) {
+	atomic.AddInt64(&m.events, 1)
+	atomic.AddInt64(&m.bytes, size)
+}
+
+// sample turns the counts since the last sample into rates and blends them into the load
+func (m *loadMeter) sample(now time.Time) {
+	events := atomic.SwapInt64(&m.events, 0)
+	bytes := atomic.SwapInt64(&m.bytes, 0)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	elapsed := now.Sub(m.sampled).Seconds()
+	m.sampled = now
+	if elapsed <= 0 {
+		return
+	}
+	m.load.EventsPerSecond += loadSmoothing * (float64(events)/elapsed - m.load.EventsPerSecond)
+	m.load.BytesPerSecond += loadSmoothing * (float64(bytes)/elapsed - m.load.BytesPerSecond)
+}
+
+// current returns the smoothed load
+func (m *loadMeter) current() RelayLoad {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.load
+}
+
+// SurgePricingEnabled reports whether prices follow the relay load
+func (s *System) SurgePricingEnabled() bool {
+	return s.load != nil
+}
+
+// Load returns the current relay load, zero without surge pricing
+func (s *System) Load() RelayLoad {
+	if s.load == nil {
+		return RelayLoad{}
+	}
+	return s.load.current()
+}
+
+// PriceMultiplier returns what prices are currently multiplied with for the relay load:
+// SurgeMultiplier's answer if set, else the load over the surge thresholds, between 1
+// and SurgeMaxMultiplier
+func (s *System) PriceMultiplier() float64 {
+	if s.load == nil {
+		return 1
+	}
+	load := s.load.current()
+	if s.config.SurgeMultiplier != nil {
+		if multiplier := s.config.SurgeMultiplier(load); multiplier > 0 {
+			return multiplier
+		}
+		return 1
+	}
+
+	multiplier := 1.0
+	if threshold := s.config.SurgeEventsPerSecond; threshold > 0 {
+		multiplier = math.Max(multiplier, load.EventsPerSecond/threshold)
+	}
+	if threshold := s.config.SurgeBytesPerSecond; threshold > 0 {
+		multiplier = math.Max(multiplier, load.BytesPerSecond/float64(threshold))
+	}
+	return math.Min(multiplier, s.config.SurgeMaxMultiplier)
+}
+
+// surgePrice applies the price multiplier to amount, rounded to whole sats
+func (s *System) surgePrice(amount int64) int64 {
+	multiplier := s.PriceMultiplier()
+	if multiplier == 1 || amount <= 0 {
+		return amount
+	}
+	sats := math.Round(float64(amount) * multiplier / 1000)
+	if sats < 1 {
+		sats = 1
+	}
+	return int64(sats) * 1000
+}
+
+// runLoadSampler measures the relay load on every tick
+func (s *System) runLoadSampler(ctx context.Context) {
+	ticker := time.NewTicker(loadSampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.load.sample(now)
+		}
+	}
+}
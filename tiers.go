@@ -0,0 +1,375 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr/nip11"
+)
+
+// Tier is a purchasable access option, e.g. one month or lifetime
+type Tier struct {
+	Name     string `json:"name"`     // shown to users and recorded on memberships
+	Amount   int64  `json:"amount"`   // in millisatoshis
+	Duration string `json:"duration"` // "1week", "1month", "1year", "forever" or a Go duration
+
+	Fiat     float64 `json:"fiat,omitempty"` // price in FiatCurrency, converted to Amount when invoices are created
+	currency string  // currency of Fiat given in PAYMENT_TIERS, checked against FiatCurrency
+
+	Capabilities []Capability `json:"capabilities,omitempty"` // what members of this tier may do (default: everything)
+	Events       int64        `json:"events,omitempty"`       // events a membership may publish, e.g. 1000 notes (default: EventQuota)
+	Storage      int64        `json:"storage,omitempty"`      // bytes of event content a membership may store (default: StorageQuota)
+}
+
+// TierInvoice is an invoice for one tier of a bundle
+type TierInvoice struct {
+	Tier           string `json:"tier"`
+	Duration       string `json:"duration"`
+	Amount         int64  `json:"amount"`
+	Coupon         string `json:"coupon,omitempty"`   // coupon code taken off the price
+	Discount       int64  `json:"discount,omitempty"` // millisatoshis the coupon took off
+	PaymentRequest string `json:"payment_request,omitempty"`
+	PaymentHash    string `json:"payment_hash,omitempty"`
+	ExpiresAt      int64  `json:"expires_at,omitempty"` // unix seconds
+	Error          string `json:"error,omitempty"`
+}
+
+// parseTiers parses "name:amount_msat:duration" entries separated by commas, optionally
+// followed by ":capability+capability" to limit what the tier grants. Amounts followed by a
+// currency code, e.g. "1.50USD", are fiat prices.
+func parseTiers(value string) ([]Tier, error) {
+	var tiers []Tier
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) != 3 && len(parts) != 4 {
+			return nil, fmt.Errorf("invalid tier %q (expected name:amount_msat:duration[:capabilities])", entry)
+		}
+		tier := Tier{Name: parts[0], Duration: parts[2]}
+		if number := strings.TrimRight(parts[1], "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"); number != parts[1] {
+			fiat, err := strconv.ParseFloat(number, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid fiat price for tier %q: %w", parts[0], err)
+			}
+			tier.Fiat, tier.currency = fiat, strings.ToUpper(parts[1][len(number):])
+		} else {
+			amount, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid amount for tier %q: %w", parts[0], err)
+			}
+			tier.Amount = amount
+		}
+		if len(parts) == 4 {
+			tier.Capabilities = parseCapabilities(parts[3], "+")
+		}
+		tiers = append(tiers, tier)
+	}
+	return tiers, nil
+}
+
+// validateTiers checks that tier names are unique, amounts positive and capabilities known
+func validateTiers(tiers []Tier) error {
+	seen := make(map[string]bool)
+	for _, tier := range tiers {
+		if tier.Name == "" {
+			return fmt.Errorf("tier name required")
+		}
+		if seen[tier.Name] {
+			return fmt.Errorf("duplicate tier: %s", tier.Name)
+		}
+		if tier.Amount < 0 || tier.Fiat < 0 || (tier.Amount == 0 && tier.Fiat == 0) {
+			return fmt.Errorf("invalid amount for tier %s: %d", tier.Name, tier.Amount)
+		}
+		if tier.Events < 0 {
+			return fmt.Errorf("invalid event quota for tier %s: %d", tier.Name, tier.Events)
+		}
+		if tier.Storage < 0 {
+			return fmt.Errorf("invalid storage quota for tier %s: %d", tier.Name, tier.Storage)
+		}
+		if err := validateCapabilities(tier.Capabilities); err != nil {
+			return fmt.Errorf("invalid tier %s: %w", tier.Name, err)
+		}
+		seen[tier.Name] = true
+	}
+	return nil
+}
+
+// accessDurationFor converts an access duration setting, zero means access never expires
+func accessDurationFor(duration string) time.Duration {
+	if duration == "forever" {
+		return 0
+	}
+	return time.Until(calculateExpirationTime(duration))
+}
+
+// Tiers returns the configured access tiers
+func (s *System) Tiers() []Tier {
+	tiers := append([]Tier(nil), s.config.Tiers...)
+	for i := range tiers {
+		if amount, ok := s.fiatToMsat(tiers[i].Fiat); ok {
+			tiers[i].Amount = amount
+		}
+	}
+	return tiers
+}
+
+// tierNamed returns the configured tier called name
+func (s *System) tierNamed(name string) (Tier, bool) {
+	for _, tier := range s.Tiers() {
+		if tier.Name == name {
+			return tier, true
+		}
+	}
+	return Tier{}, false
+}
+
+// tierForPayment returns the tier a payment of value msat buys: the named tier, or the most
+// valuable tier value covers when name is empty
+func (s *System) tierForPayment(name string, value int64) (Tier, error) {
+	tier, found := s.tierForAmount(value)
+	if name != "" {
+		tier, found = s.tierNamed(name)
+		if !found {
+			return Tier{}, fmt.Errorf("unknown tier %q", name)
+		}
+	}
+	if !found {
+		return Tier{}, fmt.Errorf("%d sats does not cover the price of any tier", value/1000)
+	}
+	if value < tier.Amount {
+		return Tier{}, fmt.Errorf("%d sats does not cover the %s price of %d sats", value/1000, tier.Name, tier.Amount/1000)
+	}
+	return tier, nil
+}
+
+// RelayFees describes the tiers as NIP-11 fees: lifetime tiers as admission fees,
+// the others as subscriptions with their period in seconds
+func (s *System) RelayFees() *nip11.RelayFeesDocument {
+	fees := &nip11.RelayFeesDocument{}
+	for _, tier := range s.Tiers() {
+		period := accessDurationFor(tier.Duration).Round(time.Second)
+		if period == 0 {
+			fees.Admission = append(fees.Admission, struct {
+				Amount int    `json:"amount"`
+				Unit   string `json:"unit"`
+			}{Amount: int(tier.Amount), Unit: "msats"})
+			continue
+		}
+		fees.Subscription = append(fees.Subscription, struct {
+			Amount int    `json:"amount"`
+			Unit   string `json:"unit"`
+			Period int    `json:"period"`
+		}{Amount: int(tier.Amount), Unit: "msats", Period: int(period.Seconds())})
+	}
+	return fees
+}
+
+// ApplyToRelayInfo advertises the payment requirement in a relay's NIP-11 document: the
+// payment page as payments_url, the tiers as fees, and limitation.payment_required.
+// Attach calls it unless WithoutRelayInfo is given, relays wired by hand can call it
+// themselves:
+//
+//	system.ApplyToRelayInfo(relay.Info)
+func (s *System) ApplyToRelayInfo(info *nip11.RelayInformationDocument) {
+	if pageURL := s.PaymentPageURL(""); pageURL != "" {
+		info.PaymentsURL = pageURL
+	}
+	info.Fees = s.RelayFees()
+	if info.Limitation == nil {
+		info.Limitation = &nip11.RelayLimitationDocument{}
+	}
+	info.Limitation.PaymentRequired = true
+	info.Limitation.RestrictedWrites = !s.IsFree(CapabilityWrite)
+}
+
+// CreateTierInvoices creates one invoice per configured tier so a pubkey can pick an option.
+// Tiers whose invoice could not be created carry an error instead of an invoice.
+func (s *System) CreateTierInvoices(ctx context.Context, pubkey string) []TierInvoice {
+	return s.createTierInvoices(ctx, pubkey, nil)
+}
+
+// CreateDiscountedTierInvoices is CreateTierInvoices with a coupon taken off every tier's price
+func (s *System) CreateDiscountedTierInvoices(ctx context.Context, pubkey, code string) ([]TierInvoice, error) {
+	coupon, err := s.coupon(code)
+	if err != nil {
+		return nil, err
+	}
+	return s.createTierInvoices(ctx, pubkey, &coupon), nil
+}
+
+// createTierInvoices creates the invoices of CreateTierInvoices, discounted by coupon if not nil
+func (s *System) createTierInvoices(ctx context.Context, pubkey string, coupon *Coupon) []TierInvoice {
+	tiers := s.Tiers()
+	results := make([]TierInvoice, len(tiers))
+
+	for i, tier := range tiers {
+		results[i] = TierInvoice{
+			Tier:     tier.Name,
+			Duration: tier.Duration,
+			Amount:   tier.Amount,
+		}
+		if coupon != nil {
+			results[i].Coupon = normalizeCouponCode(coupon.Code)
+			results[i].Discount = coupon.discount(tier.Amount)
+			results[i].Amount -= results[i].Discount
+		}
+
+		invoice, err := s.createInvoice(ctx, pubkey, results[i].Amount, accessDurationFor(tier.Duration), tier.Name)
+		if errors.Is(err, errRateLimited) {
+			results[i].Error = err.Error()
+			continue
+		}
+		if err != nil {
+			s.with(pubkeyAttr(pubkey)).logError("❌ Failed to create %s invoice for %s: %v", tier.Name, pubkey[:16], err)
+			results[i].Error = "invoice unavailable"
+			continue
+		}
+		if results[i].Coupon != "" {
+			s.invoices.SetCoupon(invoice.PaymentHash, results[i].Coupon)
+		}
+
+		results[i].PaymentRequest = invoice.PaymentRequest
+		results[i].PaymentHash = invoice.PaymentHash
+		if !invoice.ExpiresAt.IsZero() {
+			results[i].ExpiresAt = invoice.ExpiresAt.Unix()
+		}
+	}
+
+	return results
+}
+
+// tierInvoicesHandler returns invoices for every tier for one pubkey
+func (s *System) tierInvoicesHandler(w http.ResponseWriter, r *http.Request) {
+	pubkey, err := parsePubkey(r.URL.Query().Get("pubkey"))
+	if err != nil {
+		http.Error(w, "valid pubkey is required", http.StatusBadRequest)
+		return
+	}
+	if s.IsDenylisted(pubkey) {
+		http.Error(w, "Pubkey is banned from this relay", http.StatusForbidden)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.invoiceTimeout)
+	defer cancel()
+
+	var invoices []TierInvoice
+	if code := r.URL.Query().Get("coupon"); code != "" {
+		invoices, err = s.CreateDiscountedTierInvoices(ctx, pubkey, code)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		invoices = s.CreateTierInvoices(ctx, pubkey)
+	}
+	for _, invoice := range invoices {
+		s.invoices.MarkSeen(invoice.PaymentHash)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"pubkey":       pubkey,
+		"has_access":   s.HasAccess(pubkey),
+		"capabilities": s.Capabilities(pubkey),
+		"invoices":     invoices,
+	})
+}
+
+// CreateTierInvoice creates an invoice for pubkey on the named tier, or priced by the
+// Pricer like the invoices of rejected events when tier is empty, so clients can get an
+// invoice before publishing anything
+func (s *System) CreateTierInvoice(ctx context.Context, pubkey, tier string) (*TierInvoice, error) {
+	var amount int64
+	var duration time.Duration
+	result := &TierInvoice{Tier: tier}
+	if tier == "" {
+		amount, duration, result.Tier = s.price(ctx, pubkey, nil)
+	} else {
+		selected, ok := s.tierNamed(tier)
+		if !ok {
+			return nil, fmt.Errorf("unknown tier %q", tier)
+		}
+		amount, duration = selected.Amount, accessDurationFor(selected.Duration)
+	}
+	if selected, ok := s.tierNamed(result.Tier); ok {
+		result.Duration = selected.Duration
+	}
+
+	invoice, err := s.createInvoice(ctx, pubkey, amount, duration, result.Tier)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Amount = invoice.Amount
+	result.PaymentRequest = invoice.PaymentRequest
+	result.PaymentHash = invoice.PaymentHash
+	if !invoice.ExpiresAt.IsZero() {
+		result.ExpiresAt = invoice.ExpiresAt.Unix()
+	}
+	return result, nil
+}
+
+// createInvoiceHandler creates an invoice for a pubkey and tier on request, for onboarding
+// pages and clients that want to pay before publishing
+func (s *System) createInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Pubkey string `json:"pubkey"`
+		Tier   string `json:"tier"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	pubkey, err := parsePubkey(req.Pubkey)
+	if err != nil {
+		http.Error(w, "valid pubkey is required", http.StatusBadRequest)
+		return
+	}
+
+	if s.IsDenylisted(pubkey) {
+		http.Error(w, "Pubkey is banned from this relay", http.StatusForbidden)
+		return
+	}
+	if _, ok := s.tierNamed(req.Tier); req.Tier != "" && !ok {
+		http.Error(w, fmt.Sprintf("unknown tier %q", req.Tier), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.invoiceTimeout)
+	defer cancel()
+
+	invoice, err := s.CreateTierInvoice(ctx, pubkey, req.Tier)
+	if errors.Is(err, errRateLimited) {
+		writeRateLimited(w)
+		return
+	}
+	if errors.Is(err, errCircuitOpen) {
+		w.Header().Set("Retry-After", strconv.Itoa(s.switcher.retryAfter()))
+		http.Error(w, "Payment provider temporarily unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		s.with(pubkeyAttr(pubkey)).logError("❌ Failed to create invoice for %s: %v", pubkey[:16], err)
+		http.Error(w, "Failed to create invoice", http.StatusBadGateway)
+		return
+	}
+	s.invoices.MarkSeen(invoice.PaymentHash)
+	s.with(pubkeyAttr(pubkey)).logInfo("🧾 Invoice requested by %s... on the %s tier", pubkey[:16], invoice.Tier)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"pubkey":     pubkey,
+		"has_access": s.HasAccess(pubkey),
+		"invoice":    invoice,
+	})
+}
@@ -0,0 +1,166 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// TransferAuthorizationKind is the kind of the event in which an old key authorizes moving
+// its membership to a new key. It is in the ephemeral range so relays don't keep it around.
+const TransferAuthorizationKind = 21776
+
+// Transfer authorization limits
+const (
+	selfTransferMaxAge  = 10 * time.Minute // how old an authorization posted by the user may be
+	transferClockSkew   = 5 * time.Minute
+	transferAuditAction = "transfer"
+)
+
+// TransferMembership moves the remaining membership time of the authorization's author
+// to the pubkey named in its p tag. actor is recorded in the audit log, "admin" or "self".
+// Each authorization can be used once.
+func (s *System) TransferMembership(ctx context.Context, authorization *nostr.Event, actor, reason string) (*PaidAccessMember, error) {
+	maxAge := time.Duration(0)
+	if actor != "admin" {
+		maxAge = selfTransferMaxAge
+	}
+	newPubkey, err := parseTransferAuthorization(authorization, maxAge)
+	if err != nil {
+		return nil, err
+	}
+
+	// Checked and recorded under one lock, so concurrent requests can't both use it
+	reference := authorization.GetID()
+	s.transferMutex.Lock()
+	if s.audit.HasReference(reference) {
+		s.transferMutex.Unlock()
+		return nil, fmt.Errorf("transfer authorization was already used")
+	}
+
+	oldPubkey := authorization.PubKey
+	previous, _ := s.paidAccessStorage.GetMember(oldPubkey)
+	member, err := s.paidAccessStorage.TransferMembership(oldPubkey, newPubkey)
+	if err != nil {
+		s.transferMutex.Unlock()
+		return nil, err
+	}
+
+	if reason == "" {
+		reason = authorization.Content
+	}
+	s.recordAudit(AuditEntry{
+		Action:    transferAuditAction,
+		Pubkey:    oldPubkey,
+		Target:    newPubkey,
+		Actor:     actor,
+		Reason:    reason,
+		Reference: reference,
+	})
+	s.transferMutex.Unlock()
+
+	s.with(pubkeyAttr(oldPubkey), slog.String("to_pubkey", newPubkey)).logInfo("🔑 Transferred membership from %s... to %s... (%s)", oldPubkey[:16], newPubkey[:16], actor)
+
+	ctx = context.WithoutCancel(ctx)
+	if previous != nil {
+		s.fireAccessRevoked(ctx, AccessEvent{Pubkey: oldPubkey, Member: *previous, Reason: "transferred"})
+	}
+	s.fireAccessGranted(ctx, AccessEvent{Pubkey: newPubkey, Member: *member, Reason: "transfer"})
+	return member, nil
+}
+
+// parseTransferAuthorization validates a transfer authorization and returns the new pubkey.
+// A zero maxAge accepts authorizations of any age.
+func parseTransferAuthorization(event *nostr.Event, maxAge time.Duration) (string, error) {
+	if event == nil {
+		return "", fmt.Errorf("transfer authorization required")
+	}
+	if event.Kind != TransferAuthorizationKind {
+		return "", fmt.Errorf("transfer authorization must be kind %d", TransferAuthorizationKind)
+	}
+	if event.ID != event.GetID() {
+		return "", fmt.Errorf("transfer authorization id doesn't match its contents")
+	}
+	if ok, err := event.CheckSignature(); err != nil || !ok {
+		return "", fmt.Errorf("invalid transfer authorization signature")
+	}
+
+	createdAt := event.CreatedAt.Time()
+	if createdAt.After(time.Now().Add(transferClockSkew)) {
+		return "", fmt.Errorf("transfer authorization is from the future")
+	}
+	if maxAge > 0 && time.Since(createdAt) > maxAge {
+		return "", fmt.Errorf("transfer authorization expired, sign a new one")
+	}
+
+	tags := event.Tags.GetAll([]string{"p"})
+	if len(tags) != 1 {
+		return "", fmt.Errorf("transfer authorization must have exactly one p tag")
+	}
+	newPubkey, err := parsePubkey(tags[0].Value())
+	if err != nil {
+		return "", fmt.Errorf("invalid new pubkey: %w", err)
+	}
+	if newPubkey == event.PubKey {
+		return "", fmt.Errorf("cannot transfer a membership to the same pubkey")
+	}
+	return newPubkey, nil
+}
+
+// transferHandler moves a membership on behalf of the old key, given a fresh authorization
+func (s *System) transferHandler(w http.ResponseWriter, r *http.Request) {
+	s.handleTransfer(w, r, "self")
+}
+
+// adminTransferHandler moves a membership for an operator, accepting authorizations of any age
+func (s *System) adminTransferHandler(w http.ResponseWriter, r *http.Request) {
+	s.handleTransfer(w, r, "admin")
+}
+
+// handleTransfer decodes a transfer request and runs it for actor
+func (s *System) handleTransfer(w http.ResponseWriter, r *http.Request, actor string) {
+	var req struct {
+		Authorization *nostr.Event `json:"authorization"`
+		Reason        string       `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	member, err := s.TransferMembership(r.Context(), req.Authorization, actor, req.Reason)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"transferred": true,
+		"from":        req.Authorization.PubKey,
+		"member":      member,
+	})
+}
+
+// adminAuditHandler lists audit log entries, optionally for a single pubkey
+func (s *System) adminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	pubkey := r.URL.Query().Get("pubkey")
+	if pubkey != "" {
+		parsed, err := parsePubkey(pubkey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		pubkey = parsed
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"entries": s.audit.List(pubkey),
+	})
+}
@@ -0,0 +1,74 @@
+package payments
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// transferAuthorization returns an authorization signed with secretKey to transfer its
+// membership to newPubkey
+func transferAuthorization(t *testing.T, secretKey, newPubkey string) *nostr.Event {
+	t.Helper()
+	event := &nostr.Event{
+		Kind:      TransferAuthorizationKind,
+		CreatedAt: nostr.Now(),
+		Tags:      nostr.Tags{{"p", newPubkey}},
+		Content:   "key rotation",
+	}
+	if err := event.Sign(secretKey); err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+func TestTransferMembershipReplay(t *testing.T) {
+	ctx := context.Background()
+	system := newTestSystem(t, Config{})
+	oldKey := nostr.GeneratePrivateKey()
+	oldPubkey, _ := nostr.GetPublicKey(oldKey)
+	newPubkey := benchPubkeys(1)[0]
+
+	if _, err := system.GrantAccess(ctx, oldPubkey, time.Hour, "test"); err != nil {
+		t.Fatal(err)
+	}
+	authorization := transferAuthorization(t, oldKey, newPubkey)
+	if _, err := system.TransferMembership(ctx, authorization, "self", ""); err != nil {
+		t.Fatalf("first transfer: %v", err)
+	}
+	if system.HasAccess(oldPubkey) || !system.HasAccess(newPubkey) {
+		t.Fatal("membership not moved to the new key")
+	}
+
+	// The old key pays again, the used authorization must not move the new membership
+	if _, err := system.GrantAccess(ctx, oldPubkey, time.Hour, "test"); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name      string
+		event     func() *nostr.Event
+		actor     string
+		wantError string
+	}{
+		{"same authorization", func() *nostr.Event { return authorization }, "self", "already used"},
+		{"same authorization by an admin", func() *nostr.Event { return authorization }, "admin", "already used"},
+		{"other id", func() *nostr.Event {
+			forged := *authorization
+			forged.ID = strings.Repeat("0", 64)
+			return &forged
+		}, "self", "doesn't match"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := system.TransferMembership(ctx, test.event(), test.actor, "")
+			if err == nil || !strings.Contains(err.Error(), test.wantError) {
+				t.Fatalf("got error %v, want %q", err, test.wantError)
+			}
+			if !system.HasAccess(oldPubkey) {
+				t.Fatal("replayed authorization moved the membership")
+			}
+		})
+	}
+}
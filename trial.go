@@ -0,0 +1,45 @@
+package payments
+
+import (
+	"context"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// trialTier is the tier recorded on free trial memberships
+const trialTier = "trial"
+
+// TrialsEnabled reports whether pubkeys never seen before get a free trial
+func (s *System) TrialsEnabled() bool {
+	return s.config.TrialEvents > 0 || s.trialDuration > 0
+}
+
+// StartTrials is the stage that gives pubkeys never seen before a free trial of
+// TrialEvents events and/or TrialDuration, accepting the event that starts it. Trials are
+// remembered after they end, so each pubkey gets one.
+func (s *System) StartTrials(next RejectFunc) RejectFunc {
+	return func(ctx context.Context, event *nostr.Event) (bool, string) {
+		if s.startTrial(ctx, event.PubKey) && s.countEvent(event) == nil {
+			s.usage.RecordEvent(event.PubKey, event.Kind)
+			return false, ""
+		}
+		return next(ctx, event)
+	}
+}
+
+// startTrial starts a free trial for a new pubkey, reporting whether it did
+func (s *System) startTrial(ctx context.Context, pubkey string) bool {
+	started, err := s.paidAccessStorage.StartTrial(pubkey, trialTier, s.trialDuration, s.config.TrialEvents)
+	if err != nil {
+		s.with(pubkeyAttr(pubkey)).logError("❌ Failed to start trial for %s...: %v", pubkey[:16], err)
+		return false
+	}
+	if !started {
+		return false
+	}
+
+	if member, exists := s.paidAccessStorage.GetMember(pubkey); exists {
+		s.fireAccessGranted(context.WithoutCancel(ctx), AccessEvent{Pubkey: pubkey, Member: *member, Reason: "trial"})
+	}
+	return true
+}
@@ -0,0 +1,73 @@
+package payments
+
+import (
+	"strconv"
+	"sync"
+)
+
+// KindUsage aggregates accepted events of a single kind from paying members
+type KindUsage struct {
+	Kind    int    `json:"kind"`
+	Name    string `json:"name,omitempty"`
+	Events  uint64 `json:"events"`
+	Members int    `json:"members"`
+}
+
+// usageTracker counts accepted events per kind for paying members
+type usageTracker struct {
+	events  map[int]uint64
+	members map[int]map[string]struct{}
+	mutex   sync.Mutex
+}
+
+// newUsageTracker creates an empty usage tracker
+func newUsageTracker() *usageTracker {
+	return &usageTracker{
+		events:  make(map[int]uint64),
+		members: make(map[int]map[string]struct{}),
+	}
+}
+
+// RecordEvent counts an accepted event from a paying member
+func (ut *usageTracker) RecordEvent(pubkey string, kind int) {
+	ut.mutex.Lock()
+	defer ut.mutex.Unlock()
+
+	ut.events[kind]++
+	if ut.members[kind] == nil {
+		ut.members[kind] = make(map[string]struct{})
+	}
+	ut.members[kind][pubkey] = struct{}{}
+}
+
+// ByKind returns usage aggregates keyed by kind number
+func (ut *usageTracker) ByKind() map[string]KindUsage {
+	ut.mutex.Lock()
+	defer ut.mutex.Unlock()
+
+	usage := make(map[string]KindUsage, len(ut.events))
+	for kind, count := range ut.events {
+		usage[strconv.Itoa(kind)] = KindUsage{
+			Kind:    kind,
+			Name:    kindNames[kind],
+			Events:  count,
+			Members: len(ut.members[kind]),
+		}
+	}
+	return usage
+}
+
+// kindNames labels commonly hosted event kinds for reporting
+var kindNames = map[int]string{
+	0:     "metadata",
+	1:     "note",
+	3:     "contacts",
+	4:     "encrypted_dm",
+	5:     "deletion",
+	6:     "repost",
+	7:     "reaction",
+	1063:  "file_metadata",
+	9735:  "zap_receipt",
+	10002: "relay_list",
+	30023: "long_form",
+}
@@ -0,0 +1,261 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Webhook retry timing: the first retry comes after webhookRetryBase, each further one
+// waits twice as long up to webhookRetryMax
+const (
+	webhookRetryInterval = 30 * time.Second
+	webhookRetryBase     = 30 * time.Second
+	webhookRetryMax      = time.Hour
+)
+
+// WebhookRetry is a payment reported by a webhook that could not be granted yet
+type WebhookRetry struct {
+	Pubkey       string              `json:"pubkey"`
+	Verification PaymentVerification `json:"verification"`
+	Source       string              `json:"source"`
+	Attempts     int                 `json:"attempts"`
+	LastError    string              `json:"last_error"`
+	QueuedAt     time.Time           `json:"queued_at"`
+	NextAttempt  time.Time           `json:"next_attempt"`
+}
+
+// WebhookRetryQueue persists webhook payments whose grant failed, e.g. on a disk error,
+// so they are granted later instead of lost once the provider stops redelivering
+type WebhookRetryQueue struct {
+	Retries  map[string]*WebhookRetry `json:"retries"` // payment hash -> retry
+	mutex    sync.Mutex
+	filePath string
+}
+
+// NewWebhookRetryQueue creates a retry queue stored at filePath
+func NewWebhookRetryQueue(filePath string) *WebhookRetryQueue {
+	queue := &WebhookRetryQueue{
+		Retries:  make(map[string]*WebhookRetry),
+		filePath: filePath,
+	}
+
+	// Ensure directory exists
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		logWarn("⚠️ Failed to create directory for webhook retry file: %v", err)
+	}
+
+	if err := queue.load(); err != nil {
+		logWarn("⚠️ Failed to load webhook retries: %v", err)
+	}
+	return queue
+}
+
+// load reads the queue from file
+func (q *WebhookRetryQueue) load() error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	data, err := os.ReadFile(q.filePath)
+	if os.IsNotExist(err) {
+		return nil // File doesn't exist, start with an empty queue
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read webhook retry file: %w", err)
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(data, q); err != nil {
+		return err
+	}
+	if q.Retries == nil {
+		q.Retries = make(map[string]*WebhookRetry)
+	}
+	return nil
+}
+
+// save writes the queue to file, caller holds the mutex
+func (q *WebhookRetryQueue) save() error {
+	data, err := json.MarshalIndent(q, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook retries: %w", err)
+	}
+
+	return writeFileAtomic(q.filePath, data, 0644)
+}
+
+// Add queues a payment whose grant failed with cause, keeping the attempts of a payment
+// queued already
+func (q *WebhookRetryQueue) Add(pubkey string, verification *PaymentVerification, source string, cause error) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	now := time.Now()
+	retry, exists := q.Retries[verification.PaymentHash]
+	if !exists {
+		retry = &WebhookRetry{
+			Pubkey:       pubkey,
+			Verification: *verification,
+			Source:       source,
+			QueuedAt:     now,
+		}
+		q.Retries[verification.PaymentHash] = retry
+	}
+	retry.LastError = cause.Error()
+	retry.NextAttempt = now.Add(webhookRetryDelay(retry.Attempts))
+
+	if err := q.save(); err != nil {
+		if !exists {
+			delete(q.Retries, verification.PaymentHash)
+		}
+		return err
+	}
+	return nil
+}
+
+// Due returns the retries whose next attempt has come, oldest first
+func (q *WebhookRetryQueue) Due(now time.Time) []WebhookRetry {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	var due []WebhookRetry
+	for _, retry := range q.Retries {
+		if !now.Before(retry.NextAttempt) {
+			due = append(due, *retry)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].QueuedAt.Before(due[j].QueuedAt) })
+	return due
+}
+
+// List returns every queued retry, oldest first
+func (q *WebhookRetryQueue) List() []WebhookRetry {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	retries := make([]WebhookRetry, 0, len(q.Retries))
+	for _, retry := range q.Retries {
+		retries = append(retries, *retry)
+	}
+	sort.Slice(retries, func(i, j int) bool { return retries[i].QueuedAt.Before(retries[j].QueuedAt) })
+	return retries
+}
+
+// Failed records another failed attempt for a payment and schedules the next one
+func (q *WebhookRetryQueue) Failed(paymentHash string, cause error) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	retry, exists := q.Retries[paymentHash]
+	if !exists {
+		return nil
+	}
+	retry.Attempts++
+	retry.LastError = cause.Error()
+	retry.NextAttempt = time.Now().Add(webhookRetryDelay(retry.Attempts))
+	return q.save()
+}
+
+// Remove drops a payment that was granted or refused for good
+func (q *WebhookRetryQueue) Remove(paymentHash string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if _, exists := q.Retries[paymentHash]; !exists {
+		return nil
+	}
+	delete(q.Retries, paymentHash)
+	return q.save()
+}
+
+// Len returns the number of queued retries
+func (q *WebhookRetryQueue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.Retries)
+}
+
+// webhookRetryDelay is the wait before the next attempt after attempts failed retries
+func webhookRetryDelay(attempts int) time.Duration {
+	delay := webhookRetryBase
+	for i := 0; i < attempts && delay < webhookRetryMax; i++ {
+		delay *= 2
+	}
+	return min(delay, webhookRetryMax)
+}
+
+// grantWebhookPayment grants access for a payment reported by a webhook, queueing it for
+// retries when granting fails for a reason that may pass. It only fails when the payment
+// could be neither granted nor queued, so the provider should deliver it again.
+func (s *System) grantWebhookPayment(ctx context.Context, pubkey string, verification *PaymentVerification) error {
+	err := s.grantPaidAccess(ctx, pubkey, verification, SourceWebhook)
+	if err == nil || errors.Is(err, errDeniedPubkey) || errors.Is(err, errUnderpaid) {
+		// Granted, or refused for good where retrying won't change that
+		return nil
+	}
+
+	s.with(paymentHashAttr(verification.PaymentHash)).logError("❌ Failed to add paid access for payment %.16s..., queueing a retry: %v", verification.PaymentHash, err)
+	if queueErr := s.webhookRetries.Add(pubkey, verification, SourceWebhook, err); queueErr != nil {
+		s.with(paymentHashAttr(verification.PaymentHash)).logError("❌ Failed to queue webhook retry for payment %.16s...: %v", verification.PaymentHash, queueErr)
+		return err
+	}
+	return nil
+}
+
+// retryWebhookPayments grants the queued webhook payments that are due, returning how many
+// were granted
+func (s *System) retryWebhookPayments(ctx context.Context) int {
+	granted := 0
+	for _, retry := range s.webhookRetries.Due(time.Now()) {
+		err := s.grantPaidAccess(ctx, retry.Pubkey, &retry.Verification, retry.Source)
+		if err != nil && !errors.Is(err, errDeniedPubkey) && !errors.Is(err, errUnderpaid) {
+			s.with(paymentHashAttr(retry.Verification.PaymentHash)).logWarn("⚠️ Webhook payment %.16s... still failing after %d retries: %v", retry.Verification.PaymentHash, retry.Attempts+1, err)
+			if saveErr := s.webhookRetries.Failed(retry.Verification.PaymentHash, err); saveErr != nil {
+				s.logError("❌ Failed to save webhook retry: %v", saveErr)
+			}
+			continue
+		}
+
+		if saveErr := s.webhookRetries.Remove(retry.Verification.PaymentHash); saveErr != nil {
+			s.logError("❌ Failed to save webhook retry: %v", saveErr)
+		}
+		if err == nil {
+			s.with(pubkeyAttr(retry.Pubkey), paymentHashAttr(retry.Verification.PaymentHash)).logInfo("🔁 Webhook payment %.16s... granted for pubkey %s... on retry", retry.Verification.PaymentHash, retry.Pubkey[:16])
+			granted++
+		}
+	}
+	return granted
+}
+
+// runWebhookRetries reprocesses queued webhook payments in the background
+func (s *System) runWebhookRetries(ctx context.Context) {
+	ticker := time.NewTicker(webhookRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.retryWebhookPayments(ctx)
+	}
+}
+
+// adminWebhookRetriesHandler lists webhook payments waiting to be granted
+func (s *System) adminWebhookRetriesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"retries": s.webhookRetries.List(),
+	})
+}
@@ -0,0 +1,151 @@
+package payments
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// NIP-57 event kinds
+const (
+	zapRequestKind = 9734
+	zapReceiptKind = 9735
+)
+
+// Zap receipt publishing limits
+const (
+	zapReceiptTimeout   = 5 * time.Second
+	maxZapReceiptRelays = 10
+)
+
+// ZapsEnabled reports whether the relay's Lightning address accepts zaps
+func (s *System) ZapsEnabled() bool {
+	return s.relayPubkey != ""
+}
+
+// createZapInvoice creates an invoice committing to the zap request when the provider supports it
+func (s *System) createZapInvoice(ctx context.Context, pubkey string, amount int64, rawRequest string) (*Invoice, error) {
+	ctx = withInvoicePurpose(ctx, PurposeLNAddress)
+	if provider, ok := s.descriptionHashProvider(ctx, amount); ok {
+		hash := sha256.Sum256([]byte(rawRequest))
+		return provider.CreateInvoiceWithDescriptionHash(ctx, amount, hex.EncodeToString(hash[:]), pubkey)
+	}
+	return s.provider.CreateInvoice(ctx, amount, rawRequest, pubkey)
+}
+
+// publishZapReceipt signs a NIP-57 zap receipt and sends it to the relays named in the zap request
+func (s *System) publishZapReceipt(ctx context.Context, request *nostr.Event, rawRequest string, invoice *Invoice, paidAt time.Time) {
+	if paidAt.IsZero() {
+		paidAt = time.Now()
+	}
+
+	tags := nostr.Tags{}
+	for _, name := range []string{"p", "e", "a"} {
+		if tag := request.Tags.GetFirst([]string{name}); tag != nil {
+			tags = append(tags, *tag)
+		}
+	}
+	tags = append(tags,
+		nostr.Tag{"P", request.PubKey},
+		nostr.Tag{"bolt11", invoice.PaymentRequest},
+		nostr.Tag{"description", rawRequest},
+	)
+
+	receipt := &nostr.Event{
+		PubKey:    s.relayPubkey,
+		CreatedAt: nostr.Timestamp(paidAt.Unix()),
+		Kind:      zapReceiptKind,
+		Tags:      tags,
+	}
+	if err := receipt.Sign(s.relayKey); err != nil {
+		s.logError("❌ Failed to sign zap receipt: %v", err)
+		return
+	}
+
+	s.fireZapReceipt(ctx, receipt)
+
+	relays := zapRequestRelays(request)
+	if len(relays) > maxZapReceiptRelays {
+		relays = relays[:maxZapReceiptRelays]
+	}
+	for _, relayURL := range relays {
+		s.publishToRelay(ctx, relayURL, *receipt)
+	}
+	s.with(slog.String("event_id", receipt.ID)).logInfo("⚡ Published zap receipt %s to %d relays", receipt.ID[:16], len(relays))
+}
+
+// publishToRelay sends an event to a single relay, giving up after a short timeout
+func (s *System) publishToRelay(ctx context.Context, relayURL string, event nostr.Event) {
+	ctx, cancel := context.WithTimeout(ctx, zapReceiptTimeout)
+	defer cancel()
+
+	relay, err := nostr.RelayConnect(ctx, relayURL)
+	if err != nil {
+		s.logWarn("⚠️ Failed to connect to %s for zap receipt: %v", relayURL, err)
+		return
+	}
+	defer relay.Close()
+
+	if err := relay.Publish(ctx, event); err != nil {
+		s.logWarn("⚠️ Failed to publish zap receipt to %s: %v", relayURL, err)
+	}
+}
+
+// parseZapRequest validates a NIP-57 zap request for the given amount
+func parseZapRequest(raw string, amount int64) (*nostr.Event, error) {
+	var event nostr.Event
+	if err := json.Unmarshal([]byte(raw), &event); err != nil {
+		return nil, fmt.Errorf("invalid zap request")
+	}
+	if event.Kind != zapRequestKind {
+		return nil, fmt.Errorf("zap request must be kind %d", zapRequestKind)
+	}
+	if ok, err := event.CheckSignature(); err != nil || !ok {
+		return nil, fmt.Errorf("invalid zap request signature")
+	}
+	if len(event.Tags.GetAll([]string{"p"})) != 1 {
+		return nil, fmt.Errorf("zap request must have exactly one p tag")
+	}
+	if len(event.Tags.GetAll([]string{"e"})) > 1 {
+		return nil, fmt.Errorf("zap request must have at most one e tag")
+	}
+	if tag := event.Tags.GetFirst([]string{"amount"}); tag != nil && tag.Value() != strconv.FormatInt(amount, 10) {
+		return nil, fmt.Errorf("zap request amount does not match")
+	}
+	return &event, nil
+}
+
+// zapRequestRelays returns the relays a zap receipt should be published to
+func zapRequestRelays(request *nostr.Event) []string {
+	tag := request.Tags.GetFirst([]string{"relays"})
+	if tag == nil {
+		return nil
+	}
+	return (*tag)[1:]
+}
+
+// parseSecretKey accepts a hex secret key or an nsec and returns the hex form
+func parseSecretKey(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "nsec1") {
+		_, decoded, err := nip19.Decode(value)
+		if err != nil {
+			return "", err
+		}
+		value = decoded.(string)
+	}
+	value = strings.ToLower(value)
+	if !nostr.IsValid32ByteHex(value) {
+		return "", fmt.Errorf("invalid secret key")
+	}
+	return value, nil
+}
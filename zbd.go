@@ -8,9 +8,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -20,13 +20,19 @@ type ZBDProvider struct {
 	apiKey               string
 	baseURL              string
 	lightning            string
+	// Optional gamertag that receives payments instead of the project wallet
+	gamertag             string
+	// Optional webhook URL ZBD reports charge updates to
+	callbackURL          string
 	// Map payment hash to charge ID for verification
-	chargeMap            map[string]string
+	chargeMap            *lruMap[string]
 	// Map payment hash to pubkey for verification
-	pubkeyMap            map[string]string
+	pubkeyMap            *lruMap[string]
 	mu                   sync.RWMutex
 	// Persistent storage references
-	chargeMappingStorage *ChargeMappingStorage
+	invoiceStore *InvoiceStore
+	providerHTTP
+	logTarget
 }
 
 // NewZBDProvider creates a new ZBD payment provider
@@ -42,13 +48,21 @@ func NewZBDProvider(apiKey, lightningAddress string) (*ZBDProvider, error) {
 		apiKey:    apiKey,
 		baseURL:   "https://api.zebedee.io",
 		lightning: lightningAddress,
-		chargeMap: make(map[string]string),
-		pubkeyMap: make(map[string]string),
+		chargeMap: newLRUMap[string](providerMapSize),
+		pubkeyMap: newLRUMap[string](providerMapSize),
 	}, nil
 }
 
 // NewZBDProviderWithStorage creates a new ZBD payment provider with persistent storage
+//
+// Deprecated: charge ids are kept in the invoice store, use NewZBDProviderWithInvoiceStore.
 func NewZBDProviderWithStorage(apiKey, lightningAddress string, chargeMappingStorage *ChargeMappingStorage) (*ZBDProvider, error) {
+	return NewZBDProviderWithInvoiceStore(apiKey, lightningAddress, chargeMappingStorage.invoiceStore())
+}
+
+// NewZBDProviderWithInvoiceStore creates a new ZBD payment provider keeping charge ids in
+// the invoice store, so payments verify across restarts
+func NewZBDProviderWithInvoiceStore(apiKey, lightningAddress string, invoiceStore *InvoiceStore) (*ZBDProvider, error) {
 	if apiKey == "" {
 		return nil, fmt.Errorf("zBD API key is required")
 	}
@@ -57,12 +71,12 @@ func NewZBDProviderWithStorage(apiKey, lightningAddress string, chargeMappingSto
 	}
 
 	return &ZBDProvider{
-		apiKey:               apiKey,
-		baseURL:              "https://api.zebedee.io",
-		lightning:            lightningAddress,
-		chargeMap:            make(map[string]string),
-		pubkeyMap:            make(map[string]string),
-		chargeMappingStorage: chargeMappingStorage,
+		apiKey:       apiKey,
+		baseURL:      "https://api.zebedee.io",
+		lightning:    lightningAddress,
+		chargeMap:    newLRUMap[string](providerMapSize),
+		pubkeyMap:    newLRUMap[string](providerMapSize),
+		invoiceStore: invoiceStore,
 	}, nil
 }
 
@@ -77,6 +91,7 @@ type ZBDChargeRequest struct {
 	Description string `json:"description"`
 	InternalID  string `json:"internalId,omitempty"`
 	ExpiresIn   int    `json:"expiresIn,omitempty"`
+	CallbackURL string `json:"callbackUrl,omitempty"`
 }
 
 type ZBDInvoice struct {
@@ -106,7 +121,15 @@ type ZBDChargeResponse struct {
 
 // CreateInvoice creates a Lightning invoice using ZBD Charges API
 func (z *ZBDProvider) CreateInvoice(ctx context.Context, amount int64, description string, pubkey string) (*Invoice, error) {
-	log.Printf("🐛 DEBUG ZBD: Creating invoice for pubkey=%s, amount=%d", pubkey[:16]+"...", amount)
+	z.logDebug("🐛 ZBD: Creating invoice for pubkey=%s, amount=%d", pubkey[:16]+"...", amount)
+
+	z.mu.RLock()
+	gamertag := z.gamertag
+	callbackURL := z.callbackURL
+	z.mu.RUnlock()
+	if gamertag != "" {
+		return z.createGamertagCharge(ctx, gamertag, amount, description, pubkey)
+	}
 
 	// Create internal ID using pubkey hash for tracking
 	hash := sha256.Sum256([]byte(pubkey + fmt.Sprintf("%d", time.Now().Unix())))
@@ -117,63 +140,59 @@ func (z *ZBDProvider) CreateInvoice(ctx context.Context, amount int64, descripti
 		Description: description,
 		InternalID:  internalID,
 		ExpiresIn:   3600, // 1 hour expiry
+		CallbackURL: callbackURL,
 	}
 
-	log.Printf("🐛 DEBUG ZBD: Charge request: %+v", chargeReq)
+	// The callback URL is left out, it carries the webhook secret
+	z.logDebug("🐛 ZBD: Charge request: amount=%s, description=%q, internalId=%s", chargeReq.Amount, chargeReq.Description, chargeReq.InternalID)
 
 	reqBody, err := json.Marshal(chargeReq)
 	if err != nil {
-		log.Printf("🐛 DEBUG ZBD: Failed to marshal request: %v", err)
+		z.logDebug("🐛 ZBD: Failed to marshal request: %v", err)
 		return nil, fmt.Errorf("failed to marshal charge request: %w", err)
 	}
 
-	log.Printf("🐛 DEBUG ZBD: Making request to %s", z.baseURL+"/v0/charges")
+	z.logDebug("🐛 ZBD: Making request to %s", z.baseURL+"/v0/charges")
 	req, err := http.NewRequestWithContext(ctx, "POST", z.baseURL+"/v0/charges", bytes.NewBuffer(reqBody))
 	if err != nil {
-		log.Printf("🐛 DEBUG ZBD: Failed to create request: %v", err)
+		z.logDebug("🐛 ZBD: Failed to create request: %v", err)
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("apikey", z.apiKey)
 	
-	log.Printf("🐛 DEBUG ZBD: API Key length: %d", len(z.apiKey))
-	log.Printf("🐛 DEBUG ZBD: Request headers: %+v", req.Header)
+	z.logDebug("🐛 ZBD: API Key length: %d", len(z.apiKey))
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := z.httpClient().Do(req)
 	if err != nil {
-		log.Printf("🐛 DEBUG ZBD: Request failed: %v", err)
-		return nil, fmt.Errorf("failed to make request: %w", err)
+		z.logDebug("🐛 ZBD: Request failed: %v", err)
+		return nil, newRequestError(z.GetProviderName(), OpCreateInvoice, fmt.Errorf("failed to make request: %w", err))
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Printf("🐛 DEBUG ZBD: Failed to read response: %v", err)
-		return nil, fmt.Errorf("failed to read response: %w", err)
+		z.logDebug("🐛 ZBD: Failed to read response: %v", err)
+		return nil, newRequestError(z.GetProviderName(), OpCreateInvoice, fmt.Errorf("failed to read response: %w", err))
 	}
 
-	log.Printf("🐛 DEBUG ZBD: Response status: %d", resp.StatusCode)
-	log.Printf("🐛 DEBUG ZBD: Response body: %s", string(body))
+	z.logDebug("🐛 ZBD: Response status: %d", resp.StatusCode)
 
 	if resp.StatusCode != http.StatusOK {
-		log.Printf("🐛 DEBUG ZBD: API error: %d - %s", resp.StatusCode, string(body))
-		return nil, fmt.Errorf("ZBD API error: %d - %s", resp.StatusCode, string(body))
+		return nil, newStatusError(z.GetProviderName(), OpCreateInvoice, resp.StatusCode, body)
 	}
 
 	var chargeResp ZBDChargeResponse
 	if err := json.Unmarshal(body, &chargeResp); err != nil {
-		log.Printf("🐛 DEBUG ZBD: Failed to unmarshal response: %v", err)
+		z.logDebug("🐛 ZBD: Failed to unmarshal response: %v", err)
 		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
 	}
 
-	log.Printf("🐛 DEBUG ZBD: Parsed response: %+v", chargeResp)
-
 	// Parse amount back to int64
 	amountMsat, err := strconv.ParseInt(chargeResp.Data.Amount, 10, 64)
 	if err != nil {
-		log.Printf("🐛 DEBUG ZBD: Failed to parse amount, using fallback: %v", err)
+		z.logDebug("🐛 ZBD: Failed to parse amount, using fallback: %v", err)
 		amountMsat = amount // fallback to requested amount
 	}
 
@@ -185,21 +204,21 @@ func (z *ZBDProvider) CreateInvoice(ctx context.Context, amount int64, descripti
 	
 	// Store charge ID and pubkey mapping for payment verification
 	z.mu.Lock()
-	z.chargeMap[paymentHash] = chargeResp.Data.ID
-	z.pubkeyMap[paymentHash] = pubkey
+	z.chargeMap.Set(paymentHash, chargeResp.Data.ID)
+	z.pubkeyMap.Set(paymentHash, pubkey)
 	z.mu.Unlock()
 	
 	// Also store in persistent storage if available
-	if z.chargeMappingStorage != nil {
-		z.chargeMappingStorage.Store(paymentHash, chargeResp.Data.ID)
+	if z.invoiceStore != nil {
+		z.invoiceStore.RecordCharge(paymentHash, chargeResp.Data.ID, amountMsat)
 	}
 	
-	log.Printf("🐛 DEBUG ZBD: Stored mapping - PaymentHash: %s -> ChargeID: %s, Pubkey: %s...", paymentHash, chargeResp.Data.ID, pubkey[:16])
+	z.logDebug("🐛 ZBD: Stored mapping - PaymentHash: %s -> ChargeID: %s, Pubkey: %s...", paymentHash, chargeResp.Data.ID, pubkey[:16])
 
 	if len(chargeResp.Data.Invoice.Request) > 50 {
-		log.Printf("🐛 DEBUG ZBD: Created invoice successfully - PaymentRequest: %s...", chargeResp.Data.Invoice.Request[:50])
+		z.logDebug("🐛 ZBD: Created invoice successfully - PaymentRequest: %s...", chargeResp.Data.Invoice.Request[:50])
 	} else {
-		log.Printf("🐛 DEBUG ZBD: Created invoice successfully - PaymentRequest: %s", chargeResp.Data.Invoice.Request)
+		z.logDebug("🐛 ZBD: Created invoice successfully - PaymentRequest: %s", chargeResp.Data.Invoice.Request)
 	}
 
 	return &Invoice{
@@ -215,16 +234,16 @@ func (z *ZBDProvider) CreateInvoice(ctx context.Context, amount int64, descripti
 func (z *ZBDProvider) VerifyPayment(ctx context.Context, paymentHash string) (*PaymentVerification, error) {
 	// Check in-memory mapping first
 	z.mu.RLock()
-	chargeID, exists := z.chargeMap[paymentHash]
+	chargeID, exists := z.chargeMap.Get(paymentHash)
 	z.mu.RUnlock()
 	
 	// If not found in memory, check persistent storage
-	if !exists && z.chargeMappingStorage != nil {
-		chargeID, exists = z.chargeMappingStorage.Get(paymentHash)
+	if !exists && z.invoiceStore != nil {
+		chargeID, exists = z.invoiceStore.ChargeID(paymentHash)
 		if exists {
 			// Load back into memory for faster future access
 			z.mu.Lock()
-			z.chargeMap[paymentHash] = chargeID
+			z.chargeMap.Set(paymentHash, chargeID)
 			z.mu.Unlock()
 		}
 	}
@@ -238,8 +257,16 @@ func (z *ZBDProvider) VerifyPayment(ctx context.Context, paymentHash string) (*P
 		}, fmt.Errorf("charge ID not found for payment hash: %s", paymentHash)
 	}
 	
-	log.Printf("🐛 DEBUG ZBD: Verifying payment - PaymentHash: %s -> ChargeID: %s", paymentHash, chargeID)
-	
+	if strings.HasPrefix(chargeID, zbdGamertagPrefix) {
+		return z.verifyGamertagTransaction(ctx, paymentHash, strings.TrimPrefix(chargeID, zbdGamertagPrefix))
+	}
+
+	z.logDebug("🐛 ZBD: Verifying payment - PaymentHash: %s -> ChargeID: %s", paymentHash, chargeID)
+	return z.fetchCharge(ctx, paymentHash, chargeID)
+}
+
+// fetchCharge asks the ZBD API whether the charge with chargeID was paid
+func (z *ZBDProvider) fetchCharge(ctx context.Context, paymentHash, chargeID string) (*PaymentVerification, error) {
 	// Query ZBD API to get charge status
 	req, err := http.NewRequestWithContext(ctx, "GET", z.baseURL+"/v0/charges/"+chargeID, nil)
 	if err != nil {
@@ -249,20 +276,18 @@ func (z *ZBDProvider) VerifyPayment(ctx context.Context, paymentHash string) (*P
 	req.Header.Set("apikey", z.apiKey)
 	req.Header.Set("Content-Type", "application/json")
 	
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := z.httpClient().Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to make request: %w", err)
+		return nil, newRequestError(z.GetProviderName(), OpVerifyPayment, fmt.Errorf("failed to make request: %w", err))
 	}
 	defer resp.Body.Close()
 	
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+		return nil, newRequestError(z.GetProviderName(), OpVerifyPayment, fmt.Errorf("failed to read response: %w", err))
 	}
 	
-	log.Printf("🐛 DEBUG ZBD: Verify response status: %d", resp.StatusCode)
-	log.Printf("🐛 DEBUG ZBD: Verify response body: %s", string(body))
+	z.logDebug("🐛 ZBD: Verify response status: %d", resp.StatusCode)
 	
 	if resp.StatusCode != 200 {
 		return &PaymentVerification{
@@ -270,7 +295,7 @@ func (z *ZBDProvider) VerifyPayment(ctx context.Context, paymentHash string) (*P
 			PaymentHash: paymentHash,
 			Amount:      0,
 			PaidAt:      time.Time{},
-		}, fmt.Errorf("ZBD API error: %d - %s", resp.StatusCode, string(body))
+		}, newStatusError(z.GetProviderName(), OpVerifyPayment, resp.StatusCode, body)
 	}
 	
 	var chargeResp ZBDChargeResponse
@@ -291,7 +316,7 @@ func (z *ZBDProvider) VerifyPayment(ctx context.Context, paymentHash string) (*P
 		amount, _ = strconv.ParseInt(chargeResp.Data.Amount, 10, 64)
 	}
 	
-	log.Printf("🐛 DEBUG ZBD: Payment verification result - Paid: %v, Status: %s, Amount: %d", isPaid, chargeResp.Data.Status, amount)
+	z.logDebug("🐛 ZBD: Payment verification result - Paid: %v, Status: %s, Amount: %d", isPaid, chargeResp.Data.Status, amount)
 	
 	return &PaymentVerification{
 		Paid:        isPaid,
@@ -303,20 +328,23 @@ func (z *ZBDProvider) VerifyPayment(ctx context.Context, paymentHash string) (*P
 
 // CheckExistingPayments checks for any existing payments for a pubkey and returns verification if paid
 func (z *ZBDProvider) CheckExistingPayments(ctx context.Context, pubkey string) (*PaymentVerification, error) {
+	// Collect candidate hashes first so no lock is held during provider API calls
 	z.mu.RLock()
-	defer z.mu.RUnlock()
-	
-	for paymentHash, storedPubkey := range z.pubkeyMap {
-		if storedPubkey == pubkey {
-			log.Printf("🔍 Found payment for this pubkey - checking hash: %s", paymentHash)
-			verification, err := z.VerifyPayment(ctx, paymentHash)
-			if err == nil && verification.Paid {
-				log.Printf("💰 Found paid invoice! Payment hash: %s", paymentHash)
-				return verification, nil
-			}
+	paymentHashes := pendingHashesFor(z.pubkeyMap, z.invoiceStore, z.GetProviderName(), pubkey)
+	z.mu.RUnlock()
+
+	for _, paymentHash := range paymentHashes {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		z.logDebug("🔍 Found payment for this pubkey - checking hash: %s", paymentHash)
+		verification, err := z.VerifyPayment(ctx, paymentHash)
+		if err == nil && verification.Paid {
+			z.logInfo("💰 Found paid invoice! Payment hash: %s", paymentHash)
+			return verification, nil
 		}
 	}
-	
+
 	return nil, nil // No paid payments found
 }
 
@@ -331,37 +359,50 @@ type ZBDWebhookPayload struct {
 	ExpiresAt   string `json:"expiresAt"`
 }
 
-// HandleWebhook processes ZBD webhook notifications
-func (z *ZBDProvider) HandleWebhook(payload []byte) (*PaymentVerification, string, error) {
+// HandleWebhook processes ZBD webhook notifications. The payload only says which charge to
+// look at, whether and how much it was paid is asked from the ZBD API.
+func (z *ZBDProvider) HandleWebhook(ctx context.Context, payload []byte) (*PaymentVerification, string, error) {
 	var webhookPayload ZBDWebhookPayload
 	if err := json.Unmarshal(payload, &webhookPayload); err != nil {
 		return nil, "", fmt.Errorf("failed to unmarshal webhook payload: %w", err)
 	}
 
-	log.Printf("💰 Received ZBD webhook: ID=%s, Status=%s", webhookPayload.ID, webhookPayload.Status)
+	z.logInfo("💰 Received ZBD webhook: ID=%s, Status=%s", webhookPayload.ID, webhookPayload.Status)
 
 	if webhookPayload.Status != "completed" && webhookPayload.Status != "settled" {
-		log.Printf("💰 Payment not completed yet: %s", webhookPayload.Status)
+		z.logInfo("💰 Payment not completed yet: %s", webhookPayload.Status)
 		return nil, "", nil
 	}
 
-	// Extract pubkey from description
-	pubkey := extractPubkeyFromDescription(webhookPayload.Description)
+	// The invoice store knows who the charge was for, also across restarts. The charge
+	// description is only a fallback for charges it never saw.
+	paymentHash := webhookPayload.ID // Use ZBD charge ID as payment hash
+	var pubkey string
+	if z.invoiceStore != nil {
+		if tracked, found := z.invoiceStore.ByChargeID(webhookPayload.ID); found {
+			paymentHash = tracked.PaymentHash
+			pubkey = tracked.Pubkey
+		}
+	}
 	if pubkey == "" {
-		return nil, "", fmt.Errorf("could not extract pubkey from webhook payload")
+		if parsed, err := parsePubkey(extractPubkeyFromDescription(webhookPayload.Description)); err == nil {
+			pubkey = parsed
+		}
+	}
+	if pubkey == "" {
+		return nil, "", fmt.Errorf("could not resolve pubkey for charge %s", webhookPayload.ID)
 	}
 
-	// Parse amount
-	amount, err := strconv.ParseInt(webhookPayload.Amount, 10, 64)
+	verification, err := z.fetchCharge(ctx, paymentHash, webhookPayload.ID)
 	if err != nil {
-		return nil, "", fmt.Errorf("invalid amount in webhook: %w", err)
+		return nil, "", fmt.Errorf("failed to verify charge %s: %w", webhookPayload.ID, err)
 	}
-
-	verification := &PaymentVerification{
-		Paid:        true,
-		PaymentHash: webhookPayload.ID, // Use ZBD charge ID as payment hash
-		Amount:      amount,
-		PaidAt:      time.Now(),
+	if !verification.Paid {
+		z.logWarn("⚠️ ZBD webhook says charge %s is %s, the API doesn't", webhookPayload.ID, webhookPayload.Status)
+		return nil, "", nil
+	}
+	if verification.PaidAt.IsZero() {
+		verification.PaidAt = time.Now()
 	}
 
 	return verification, pubkey, nil
@@ -376,13 +417,73 @@ func generatePaymentHash(paymentRequest, pubkey string) string {
 
 // extractPubkeyFromDescription extracts pubkey from payment description
 func extractPubkeyFromDescription(description string) string {
-	// Look for "pubkey:" prefix in description
-	prefix := "pubkey:"
-	if len(description) > len(prefix) {
-		if description[:len(prefix)] == prefix {
-			return description[len(prefix):]
-		}
+	// Look for "pubkey:" in the description, e.g. "Trusted Relay Access - pubkey:<hex>"
+	_, pubkey, found := strings.Cut(description, "pubkey:")
+	if !found {
+		return ""
+	}
+	return strings.TrimSpace(pubkey)
+}
+
+// ZBD payment API structures
+type ZBDPaymentRequest struct {
+	Invoice     string `json:"invoice"`
+	Amount      string `json:"amount,omitempty"`
+	Description string `json:"description"`
+}
+
+type ZBDPaymentData struct {
+	ID       string `json:"id"`
+	Fee      string `json:"fee"`
+	Amount   string `json:"amount"`
+	Preimage string `json:"preimage"`
+	Status   string `json:"status"`
+}
+
+type ZBDPaymentResponse struct {
+	Success bool           `json:"success"`
+	Data    ZBDPaymentData `json:"data"`
+	Message string         `json:"message"`
+}
+
+// PayInvoice pays a BOLT11 invoice from the project wallet
+func (z *ZBDProvider) PayInvoice(ctx context.Context, paymentRequest string) (*Payout, error) {
+	paymentHash, amount, err := decodeBolt11(paymentRequest)
+	if err != nil {
+		return nil, err
 	}
 
-	return ""
+	reqBody, err := json.Marshal(ZBDPaymentRequest{
+		Invoice:     paymentRequest,
+		Amount:      strconv.FormatInt(amount, 10), // amount in millisatoshis
+		Description: "Refund",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payment request: %w", err)
+	}
+
+	body, err := z.doGamertagRequest(ctx, OpPayInvoice, "POST", "/v0/payments", reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	var paymentResp ZBDPaymentResponse
+	if err := json.Unmarshal(body, &paymentResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	// Payments are "completed" or still "pending" while being routed
+	if !paymentResp.Success || paymentResp.Data.Status == "error" || paymentResp.Data.Status == "failed" {
+		return nil, fmt.Errorf("ZBD %w: %s", errPayoutFailed, paymentResp.Message)
+	}
+
+	payout := &Payout{
+		PaymentHash: paymentHash,
+		Amount:      amount,
+		Preimage:    paymentResp.Data.Preimage,
+	}
+	if paymentResp.Data.Amount != "" {
+		payout.Amount, _ = strconv.ParseInt(paymentResp.Data.Amount, 10, 64)
+	}
+	payout.Fee, _ = strconv.ParseInt(paymentResp.Data.Fee, 10, 64)
+	return payout, nil
 }
@@ -0,0 +1,186 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// zbdGamertagPrefix marks charge mappings that point at a gamertag transaction instead of a charge
+const zbdGamertagPrefix = "gamertag:"
+
+// ZBD gamertag API structures
+type ZBDGamertagChargeRequest struct {
+	Amount      string `json:"amount"`
+	Gamertag    string `json:"gamertag"`
+	Description string `json:"description"`
+	InternalID  string `json:"internalId,omitempty"`
+}
+
+type ZBDGamertagChargeData struct {
+	Unit             string `json:"unit"`
+	Status           string `json:"status"`
+	Amount           string `json:"amount"`
+	Description      string `json:"description"`
+	InternalID       string `json:"internalId"`
+	TransactionID    string `json:"transactionId"`
+	InvoiceRequest   string `json:"invoiceRequest"`
+	InvoiceExpiresAt string `json:"invoiceExpiresAt"`
+}
+
+type ZBDGamertagChargeResponse struct {
+	Success bool                  `json:"success"`
+	Data    ZBDGamertagChargeData `json:"data"`
+	Message string                `json:"message"`
+}
+
+type ZBDGamertagTransactionData struct {
+	ID          string `json:"id"`
+	Status      string `json:"status"`
+	Amount      string `json:"amount"`
+	ConfirmedAt string `json:"confirmedAt,omitempty"`
+}
+
+type ZBDGamertagTransactionResponse struct {
+	Success bool                       `json:"success"`
+	Data    ZBDGamertagTransactionData `json:"data"`
+	Message string                     `json:"message"`
+}
+
+// SetGamertag makes new invoices pay the given ZBD gamertag instead of the project wallet.
+// Charges created before the switch stay verifiable.
+func (z *ZBDProvider) SetGamertag(gamertag string) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.gamertag = strings.TrimPrefix(gamertag, "@")
+}
+
+// SetCallbackURL makes new charges report their updates to callbackURL, the relay's
+// /webhook/zbd endpoint
+func (z *ZBDProvider) SetCallbackURL(callbackURL string) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.callbackURL = callbackURL
+}
+
+// createGamertagCharge requests a payment to the configured gamertag
+func (z *ZBDProvider) createGamertagCharge(ctx context.Context, gamertag string, amount int64, description string, pubkey string) (*Invoice, error) {
+	reqBody, err := json.Marshal(ZBDGamertagChargeRequest{
+		Amount:      strconv.FormatInt(amount, 10), // amount in millisatoshis
+		Gamertag:    gamertag,
+		Description: description,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal gamertag charge request: %w", err)
+	}
+
+	body, err := z.doGamertagRequest(ctx, OpCreateInvoice, "POST", "/v0/gamertag/charges", reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	var chargeResp ZBDGamertagChargeResponse
+	if err := json.Unmarshal(body, &chargeResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	if chargeResp.Data.TransactionID == "" || chargeResp.Data.InvoiceRequest == "" {
+		return nil, fmt.Errorf("ZBD gamertag charge returned no invoice: %s", chargeResp.Message)
+	}
+
+	expiresAt, _ := time.Parse(time.RFC3339, chargeResp.Data.InvoiceExpiresAt)
+
+	// Track the transaction under a payment hash like regular charges
+	paymentHash := generatePaymentHash(chargeResp.Data.InvoiceRequest, pubkey)
+	mapping := zbdGamertagPrefix + chargeResp.Data.TransactionID
+
+	z.mu.Lock()
+	z.chargeMap.Set(paymentHash, mapping)
+	z.pubkeyMap.Set(paymentHash, pubkey)
+	z.mu.Unlock()
+
+	if z.invoiceStore != nil {
+		z.invoiceStore.RecordCharge(paymentHash, mapping, amount)
+	}
+
+	z.logInfo("🎮 Created ZBD gamertag charge for @%s - transaction %s", gamertag, chargeResp.Data.TransactionID)
+
+	return &Invoice{
+		PaymentRequest: chargeResp.Data.InvoiceRequest,
+		PaymentHash:    paymentHash,
+		Amount:         amount,
+		Description:    description,
+		ExpiresAt:      expiresAt,
+	}, nil
+}
+
+// verifyGamertagTransaction polls the status of a gamertag transaction
+func (z *ZBDProvider) verifyGamertagTransaction(ctx context.Context, paymentHash, transactionID string) (*PaymentVerification, error) {
+	body, err := z.doGamertagRequest(ctx, OpVerifyPayment, "GET", "/v0/gamertag/transaction/"+transactionID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var txResp ZBDGamertagTransactionResponse
+	if err := json.Unmarshal(body, &txResp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	// ZBD reports e.g. "completed" or "TRANSACTION_STATUS_COMPLETED"
+	isPaid := strings.Contains(strings.ToLower(txResp.Data.Status), "completed")
+
+	verification := &PaymentVerification{
+		Paid:        isPaid,
+		PaymentHash: paymentHash,
+	}
+	if txResp.Data.Amount != "" {
+		verification.Amount, _ = strconv.ParseInt(txResp.Data.Amount, 10, 64)
+	}
+	if isPaid {
+		verification.PaidAt = time.Now()
+		if txResp.Data.ConfirmedAt != "" {
+			if confirmedAt, err := time.Parse(time.RFC3339, txResp.Data.ConfirmedAt); err == nil {
+				verification.PaidAt = confirmedAt
+			}
+		}
+	}
+
+	return verification, nil
+}
+
+// doGamertagRequest sends an authenticated request to the ZBD API and returns the response body
+func (z *ZBDProvider) doGamertagRequest(ctx context.Context, op, method, path string, payload []byte) ([]byte, error) {
+	var reqBody io.Reader
+	if payload != nil {
+		reqBody = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, z.baseURL+path, reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("apikey", z.apiKey)
+
+	resp, err := z.httpClient().Do(req)
+	if err != nil {
+		return nil, newRequestError(z.GetProviderName(), op, fmt.Errorf("failed to make request: %w", err))
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, newRequestError(z.GetProviderName(), op, fmt.Errorf("failed to read response: %w", err))
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStatusError(z.GetProviderName(), op, resp.StatusCode, body)
+	}
+	return body, nil
+}